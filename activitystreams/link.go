@@ -3,6 +3,7 @@ package activitystreams
 const (
 	LinkTypeLink    = "Link"
 	LinkTypeMention = "Mention"
+	LinkTypeHashtag = "Hashtag"
 )
 
 type LinkIface interface {
@@ -24,6 +25,10 @@ func (l *Link) link() *Link {
 	return l
 }
 
+func ToLink(l LinkIface) *Link {
+	return l.link()
+}
+
 func (l *Link) Type() (string, error) {
 	return LinkTypeLink, nil
 }
@@ -43,3 +48,18 @@ func (m *Mention) Type() (string, error) {
 func (l *Mention) MarshalJSON() ([]byte, error) {
 	return MarshalEntity(l)
 }
+
+// Hashtag is a link to the collection of objects tagged with the hashtag given
+// by its name.  It is not part of the ActivityStreams vocabulary, but is
+// widely used to tag objects.
+type Hashtag struct {
+	Link
+}
+
+func (h *Hashtag) Type() (string, error) {
+	return LinkTypeHashtag, nil
+}
+
+func (h *Hashtag) MarshalJSON() ([]byte, error) {
+	return MarshalEntity(h)
+}
@@ -78,4 +78,19 @@ var _ = Describe("Link", func() {
 
 		testutil.CheckActivityStreamsEntity("Mention", &actualMention, expectedLinkMap)
 	})
+	Describe("Hashtag", func() {
+		actualHashtag := activitystreams.Hashtag{
+			Link: actualLink,
+		}
+
+		BeforeEach(func() {
+			expectedLinkMap["type"] = "Hashtag"
+		})
+
+		AfterEach(func() {
+			delete(expectedLinkMap, "type")
+		})
+
+		testutil.CheckActivityStreamsEntity("Hashtag", &actualHashtag, expectedLinkMap)
+	})
 })
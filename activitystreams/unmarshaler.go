@@ -82,4 +82,5 @@ func init() {
 	DefaultEntityUnmarshaler.RegisterType("OrderedCollectionPage", &CollectionPage{})
 	DefaultEntityUnmarshaler.RegisterType("Link", &Link{})
 	DefaultEntityUnmarshaler.RegisterType("Mention", &Mention{})
+	DefaultEntityUnmarshaler.RegisterType("Hashtag", &Hashtag{})
 }
@@ -1,12 +1,11 @@
 package database
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/url"
-	"path"
-	"strconv"
-	"strings"
+	"time"
 
 	"github.com/brandonsides/pubblr/activitystreams"
 )
@@ -14,341 +13,1007 @@ import (
 type PubblrDatabaseConfig struct {
 }
 
+var (
+	ErrUserNotFound = errors.New("user does not exist")
+	ErrUserExists   = errors.New("user already exists")
+	ErrNotFound     = errors.New("not found")
+	ErrTxDone       = errors.New("transaction has already been committed or rolled back")
+	ErrInvalidId    = errors.New("invalid id")
+	ErrEmailExists  = errors.New("email address already in use")
+	ErrNotGroup     = errors.New("not a group")
+)
+
+// Queries is the set of operations supported both by a database directly and
+// by a transaction opened against it.  Every operation takes a context, and
+// fails with the context's error if it is cancelled or its deadline passes
+// before the operation can run.
+type Queries interface {
+	CreateObject(ctx context.Context, obj activitystreams.ObjectIface, user string, baseIdUrl url.URL) (activitystreams.ObjectIface, error)
+	CreateInboxItem(ctx context.Context, item activitystreams.ActivityIface, user string) (activitystreams.ActivityIface, error)
+	CreateOutboxItem(ctx context.Context, act activitystreams.ActivityIface, user string, baseIdUrl url.URL) (activitystreams.ActivityIface, error)
+	CreateDeliveryJob(ctx context.Context, act activitystreams.ActivityIface, recipient string) (*DeliveryJob, error)
+	GetDeliveryJobs(ctx context.Context, due time.Time, limit int) ([]*DeliveryJob, error)
+	UpdateDeliveryJob(ctx context.Context, job DeliveryJob) error
+	DeleteDeliveryJob(ctx context.Context, id string) error
+	GetOutboxItem(ctx context.Context, user, id string) (activitystreams.ActivityIface, error)
+	GetInboxItems(ctx context.Context, user string, query PageQuery) ([]*BoxItem, error)
+	GetInboxCount(ctx context.Context, user string) (int, error)
+	GetInboxItem(ctx context.Context, user, id string) (activitystreams.ActivityIface, error)
+	GetOutboxItems(ctx context.Context, user string, query PageQuery) ([]*BoxItem, error)
+	GetOutboxCount(ctx context.Context, user string) (int, error)
+	GetObject(ctx context.Context, user, typ, id string) (activitystreams.ObjectIface, error)
+	GetObjects(ctx context.Context, user string) ([]activitystreams.ObjectIface, error)
+	AddFollower(ctx context.Context, user, actorId string) error
+	GetFollowers(ctx context.Context, user string) ([]string, error)
+	AddFollowing(ctx context.Context, user, actorId string) error
+	GetFollowing(ctx context.Context, user string) ([]string, error)
+	AddBlock(ctx context.Context, user, actorId string) error
+	GetBlocks(ctx context.Context, user string) ([]string, error)
+	CreateUser(ctx context.Context, user activitystreams.ActorIface, username, password string, baseIdUrl url.URL) (activitystreams.ActorIface, error)
+	GetUser(ctx context.Context, username string) (activitystreams.ActorIface, error)
+	UpdateUser(ctx context.Context, user activitystreams.ActorIface, username string) (activitystreams.ActorIface, error)
+	CreateBlog(ctx context.Context, blog activitystreams.ActorIface, name, account string, baseIdUrl url.URL) (activitystreams.ActorIface, error)
+	GetAccount(ctx context.Context, name string) (string, error)
+	GetBlogs(ctx context.Context, account string) ([]string, error)
+	CreateGroup(ctx context.Context, group activitystreams.ActorIface, name, owner string, baseIdUrl url.URL) (activitystreams.ActorIface, error)
+	GetGroupMembers(ctx context.Context, group string) ([]*GroupMember, error)
+	GetGroupMember(ctx context.Context, group, username string) (*GroupMember, error)
+	SetGroupMember(ctx context.Context, member GroupMember) error
+	RemoveGroupMember(ctx context.Context, group, username string) error
+	GetGroups(ctx context.Context, username string) ([]*GroupMember, error)
+	GetAppearance(ctx context.Context, user string) (*Appearance, error)
+	SetAppearance(ctx context.Context, user string, appearance Appearance) error
+	CheckPassword(ctx context.Context, username, password string) error
+	GetRoles(ctx context.Context, username string) ([]string, error)
+	SetRoles(ctx context.Context, username string, roles []string) error
+	CreateRefreshToken(ctx context.Context, token RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash string) (*RefreshToken, error)
+	UseRefreshToken(ctx context.Context, hash string) error
+	CreateSession(ctx context.Context, session Session) error
+	GetSession(ctx context.Context, id string) (*Session, error)
+	GetSessions(ctx context.Context, username string) ([]*Session, error)
+	UpdateSession(ctx context.Context, session Session) error
+	DeleteSession(ctx context.Context, id string) error
+	CreateOAuthClient(ctx context.Context, client OAuthClient) error
+	GetOAuthClient(ctx context.Context, id string) (*OAuthClient, error)
+	CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) error
+	GetAuthorizationCode(ctx context.Context, hash string) (*AuthorizationCode, error)
+	DeleteAuthorizationCode(ctx context.Context, hash string) error
+	GetTwoFactor(ctx context.Context, username string) (*TwoFactor, error)
+	SetTwoFactor(ctx context.Context, twoFactor TwoFactor) error
+	DeleteTwoFactor(ctx context.Context, username string) error
+	CreateLoginChallenge(ctx context.Context, challenge LoginChallenge) error
+	GetLoginChallenge(ctx context.Context, hash string) (*LoginChallenge, error)
+	UpdateLoginChallenge(ctx context.Context, challenge LoginChallenge) error
+	DeleteLoginChallenge(ctx context.Context, hash string) error
+	SetPassword(ctx context.Context, username, password string) error
+	GetEmail(ctx context.Context, username string) (*Email, error)
+	SetEmail(ctx context.Context, username string, email Email) error
+	GetUsernameByEmail(ctx context.Context, address string) (string, error)
+	CreateAccountToken(ctx context.Context, token AccountToken) error
+	GetAccountToken(ctx context.Context, hash string) (*AccountToken, error)
+	DeleteAccountToken(ctx context.Context, hash string) error
+	DeleteAccountTokens(ctx context.Context, username string, purpose AccountTokenPurpose) error
+	GetRegistration(ctx context.Context, username string) (*Registration, error)
+	SetRegistration(ctx context.Context, registration Registration) error
+	GetRegistrations(ctx context.Context, status AccountStatus) ([]*Registration, error)
+	CreateInvite(ctx context.Context, invite Invite) error
+	GetInvite(ctx context.Context, hash string) (*Invite, error)
+	GetInvites(ctx context.Context) ([]*Invite, error)
+	UpdateInvite(ctx context.Context, invite Invite) error
+	DeleteInvite(ctx context.Context, hash string) error
+	CreatePersonalAccessToken(ctx context.Context, token PersonalAccessToken) error
+	GetPersonalAccessToken(ctx context.Context, hash string) (*PersonalAccessToken, error)
+	GetPersonalAccessTokens(ctx context.Context, username string) ([]*PersonalAccessToken, error)
+	UpdatePersonalAccessToken(ctx context.Context, token PersonalAccessToken) error
+	DeletePersonalAccessToken(ctx context.Context, username, id string) error
+}
+
+// Tx is a unit of work against a database.  None of the writes made through a
+// Tx are visible outside of it until Commit succeeds; Rollback discards them.
+// Calling Rollback after Commit is a no-op, so it is safe to defer.
+type Tx interface {
+	Queries
+	Commit() error
+	Rollback() error
+}
+
+// PageQuery selects a page of items from an inbox or outbox.  Items are ordered
+// newest first, and are selected relative to the ids of other items, so that
+// the contents of a page do not shift as new items arrive.  Empty ids are
+// ignored.
+type PageQuery struct {
+	// Only select items older than this one
+	MaxId string
+	// Only select items newer than this one, starting from the newest
+	SinceId string
+	// Only select items newer than this one, starting from those immediately
+	// newer; takes precedence over SinceId
+	MinId string
+	// The maximum number of items to select
+	Limit int
+}
+
+// BoxItem is an activity in an inbox or outbox, along with the id by which it
+// is known within that box.
+type BoxItem struct {
+	Id       string
+	Activity activitystreams.ActivityIface
+}
+
+// DeliveryJob is a pending delivery of an activity to a single recipient.
+// Failed deliveries are retried until they succeed or are given up on.
+type DeliveryJob struct {
+	Id        string
+	Recipient string
+	Activity  activitystreams.ActivityIface
+	// the number of times delivery has failed
+	Attempts int
+	// when delivery is next due to be attempted; zero for new jobs, which are
+	// due at once
+	NextAttempt time.Time
+	// the error with which the last attempt failed
+	LastError string
+	// whether delivery has been given up on.  Dead jobs are kept, so that
+	// what was never delivered can be found, but are never attempted again.
+	Dead bool
+}
+
+// RefreshToken is a long-lived credential which may be exchanged, once, for a
+// new access token and a new refresh token in the same login session.
+type RefreshToken struct {
+	// the hash of the token; tokens themselves are never stored
+	Hash     string
+	Username string
+	Session  string
+	Expires  time.Time
+	// whether the token has already been exchanged
+	Used bool
+}
+
+// Session is a login of a user on some device.  Every token issued to a user
+// belongs to a session, and is only accepted while the session exists.
+type Session struct {
+	Id        string
+	Username  string
+	UserAgent string
+	// the OAuth client to which the session was granted, if any
+	ClientId string
+	// the space-separated scopes of the tokens issued in the session; empty
+	// for sessions begun by logging in directly
+	Scope   string
+	Created time.Time
+	// when tokens were last issued in the session
+	LastUsed time.Time
+}
+
+// OAuthClient is a third-party application registered to request access to
+// users' accounts
+type OAuthClient struct {
+	Id string
+	// the hash of the client's secret; empty for public clients, which
+	// cannot keep a secret
+	SecretHash   string
+	Name         string
+	RedirectUris []string
+	// the space-separated scopes the client may request
+	Scope   string
+	Created time.Time
+}
+
+// AuthorizationCode is a user's approval of an OAuth client's request, which
+// the client may exchange once for tokens
+type AuthorizationCode struct {
+	// the hash of the code; codes themselves are never stored
+	Hash        string
+	ClientId    string
+	Username    string
+	RedirectUri string
+	Scope       string
+	// the PKCE challenge which the client must answer to redeem the code
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Expires             time.Time
+}
+
+// TwoFactor is a user's enrollment in two-factor authentication
+type TwoFactor struct {
+	Username string
+	// the base32-encoded TOTP secret
+	Secret string
+	// whether the user has confirmed enrollment with a valid code; until they
+	// do, logging in does not require a second factor
+	Enabled bool
+	// the time step of the last code accepted, so that codes cannot be
+	// replayed
+	LastStep int64
+	// the hashes of the unused recovery codes
+	RecoveryCodes []string
+}
+
+// LoginChallenge is a login which awaits its second factor
+type LoginChallenge struct {
+	// the hash of the challenge token; tokens themselves are never stored
+	Hash      string
+	Username  string
+	UserAgent string
+	Expires   time.Time
+	// the number of wrong codes given in answer so far
+	Attempts int
+}
+
+// Email is the email address of a user
+type Email struct {
+	Address string
+	// whether the user has shown that they receive mail sent to the address
+	Verified bool
+}
+
+// AccountTokenPurpose is what an AccountToken may be used for
+type AccountTokenPurpose string
+
+const (
+	PurposePasswordReset     AccountTokenPurpose = "password-reset"
+	PurposeEmailVerification AccountTokenPurpose = "email-verification"
+)
+
+// AccountToken is a single-use token mailed to a user, by which they may
+// reset their password or verify their email address
+type AccountToken struct {
+	// the hash of the token; tokens themselves are never stored
+	Hash     string
+	Username string
+	Purpose  AccountTokenPurpose
+	// the address to which the token was sent
+	Email   string
+	Expires time.Time
+}
+
+// AccountStatus is the standing of an account in the registration process
+type AccountStatus string
+
+const (
+	AccountActive AccountStatus = "active"
+	// awaiting approval by an administrator
+	AccountPending  AccountStatus = "pending"
+	AccountRejected AccountStatus = "rejected"
+)
+
+// Registration records how a user registered, and whether their account may
+// be used.  Users created without one are taken to be active.
+type Registration struct {
+	Username string
+	Status   AccountStatus
+	// the reason the user gave for wanting an account, for administrators
+	// deciding whether to approve it
+	Reason string
+	// the hash of the invite with which the user registered, if any
+	Invite  string
+	Created time.Time
+}
+
+// Invite is a code with which users may register on servers whose
+// registration is by invitation
+type Invite struct {
+	// the hash of the code; codes themselves are never stored
+	Hash      string
+	CreatedBy string
+	Created   time.Time
+	// the invite is refused after this time; never, if zero
+	Expires time.Time
+	// the number of times the invite may be used; unlimited, if zero
+	MaxUses int
+	Uses    int
+}
+
+// PersonalAccessToken is a long-lived credential which a user mints for a
+// script or other tool of their own, so that it need not hold their password
+type PersonalAccessToken struct {
+	Id string
+	// the hash of the token; tokens themselves are never stored
+	Hash     string
+	Username string
+	Name     string
+	// the space-separated scopes the token carries
+	Scope   string
+	Created time.Time
+	// the token is refused after this time; never, if zero
+	Expires time.Time
+	// when the token was last presented, or zero if never
+	LastUsed time.Time
+}
+
+// Appearance is how an actor's blog is shown to readers in browsers
+type Appearance struct {
+	// the name of one of the server's themes; its default theme, if empty
+	Theme string
+	// a stylesheet applied over the theme's
+	CSS string
+}
+
+// GroupRole is what a member of a group may do in it
+type GroupRole string
+
+const (
+	// owners may do anything, including managing the other owners
+	GroupOwner GroupRole = "owner"
+	// admins may post and manage posters
+	GroupAdmin GroupRole = "admin"
+	// posters may only post
+	GroupPoster GroupRole = "poster"
+)
+
+// GroupMember is an account's membership of a group
+type GroupMember struct {
+	Group    string
+	Username string
+	Role     GroupRole
+	Added    time.Time
+}
+
+// UserData is the data of an actor.  Each account is created with an actor of
+// the same name, with which the account logs in; the account may then create
+// further actors, its side blogs, which have no password of their own.
 type UserData struct {
-	Actor    json.RawMessage              `json:"actor"`
-	Password string                       `json:"password"`
-	Inbox    []json.RawMessage            `json:"-"`
-	Outbox   []json.RawMessage            `json:"-"`
-	Objects  map[string][]json.RawMessage `json:"-"`
-	// TODO: make this EntityIface
-	Followers []activitystreams.Actor       `json:"-"`
-	Following []activitystreams.EntityIface `json:"-"`
-	Streams   []activitystreams.EntityIface `json:"-"`
+	Actor    json.RawMessage `json:"actor"`
+	Password string          `json:"password"`
+	// the account owning the actor, if it is a side blog; empty for the
+	// actor created with an account
+	Account string `json:"account,omitempty"`
+	// the members of the actor, by username, if it is a group; nil
+	// otherwise
+	Members map[string]GroupMember       `json:"-"`
+	Inbox   []json.RawMessage            `json:"-"`
+	Outbox  []json.RawMessage            `json:"-"`
+	Objects map[string][]json.RawMessage `json:"-"`
+	// ids of the actors following, followed by and blocked by the user
+	Followers []string `json:"-"`
+	Following []string `json:"-"`
+	Blocks    []string `json:"-"`
+	// the roles granted to the user, such as "admin"
+	Roles   []string                      `json:"-"`
+	Streams []activitystreams.EntityIface `json:"-"`
+	Email   Email                         `json:"-"`
+	// nil for users created without one
+	Registration *Registration `json:"-"`
+	Appearance   Appearance    `json:"-"`
 }
 
+// clone returns a copy of the UserData that shares no mutable state with the
+// original, so that one may be modified without affecting the other.
+func (u UserData) clone() UserData {
+	ret := u
+	ret.Inbox = append([]json.RawMessage(nil), u.Inbox...)
+	ret.Outbox = append([]json.RawMessage(nil), u.Outbox...)
+	if u.Objects != nil {
+		ret.Objects = make(map[string][]json.RawMessage, len(u.Objects))
+		for typ, objects := range u.Objects {
+			ret.Objects[typ] = append([]json.RawMessage(nil), objects...)
+		}
+	}
+	ret.Followers = append([]string(nil), u.Followers...)
+	ret.Following = append([]string(nil), u.Following...)
+	ret.Blocks = append([]string(nil), u.Blocks...)
+	ret.Roles = append([]string(nil), u.Roles...)
+	if u.Members != nil {
+		ret.Members = make(map[string]GroupMember, len(u.Members))
+		for username, member := range u.Members {
+			ret.Members[username] = member
+		}
+	}
+	ret.Streams = append([]activitystreams.EntityIface(nil), u.Streams...)
+	if u.Registration != nil {
+		registration := *u.Registration
+		ret.Registration = &registration
+	}
+	return ret
+}
+
+type deliveryJobData struct {
+	recipient   string
+	activity    json.RawMessage
+	attempts    int
+	nextAttempt time.Time
+	lastError   string
+	dead        bool
+}
+
+// PubblrDatabase is an in-memory implementation of the Pubblr database.
+// Transactions are serialized: at most one is open at any time, and
+// operations made directly against the database run in a transaction of
+// their own.
 type PubblrDatabase struct {
-	users map[string]UserData
+	lock              chan struct{}
+	users             map[string]UserData
+	deliveryJobs      map[int]deliveryJobData
+	nextDeliveryJobId int
+	// by hash
+	refreshTokens map[string]RefreshToken
+	// by id
+	sessions     map[string]Session
+	oauthClients map[string]OAuthClient
+	// by hash
+	authorizationCodes map[string]AuthorizationCode
+	// by username
+	twoFactors map[string]TwoFactor
+	// by hash
+	loginChallenges map[string]LoginChallenge
+	accountTokens   map[string]AccountToken
+	invites         map[string]Invite
+	// by hash
+	personalAccessTokens map[string]PersonalAccessToken
 }
 
 func NewPubblrDatabase(config PubblrDatabaseConfig) *PubblrDatabase {
 	return &PubblrDatabase{
-		users: make(map[string]UserData),
+		lock:                 make(chan struct{}, 1),
+		users:                make(map[string]UserData),
+		deliveryJobs:         make(map[int]deliveryJobData),
+		refreshTokens:        make(map[string]RefreshToken),
+		sessions:             make(map[string]Session),
+		oauthClients:         make(map[string]OAuthClient),
+		authorizationCodes:   make(map[string]AuthorizationCode),
+		twoFactors:           make(map[string]TwoFactor),
+		loginChallenges:      make(map[string]LoginChallenge),
+		accountTokens:        make(map[string]AccountToken),
+		invites:              make(map[string]Invite),
+		personalAccessTokens: make(map[string]PersonalAccessToken),
 	}
 }
 
-func (d *PubblrDatabase) CreateObject(post activitystreams.ObjectIface, user string, baseUrl url.URL) (activitystreams.ObjectIface, error) {
-	userData, ok := d.users[user]
-	if !ok {
-		return nil, fmt.Errorf("user %s does not exist")
+// Begin opens a transaction, waiting until any other open transaction has
+// finished or ctx is done.
+func (d *PubblrDatabase) Begin(ctx context.Context) (Tx, error) {
+	return d.begin(ctx)
+}
+
+func (d *PubblrDatabase) begin(ctx context.Context) (*PubblrTx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	objects := userData.Objects
-	if objects == nil {
-		objects = make(map[string][]json.RawMessage)
+	select {
+	case d.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 
-	postType, err := post.Type()
+	return &PubblrTx{
+		ctx:                   ctx,
+		db:                    d,
+		undoUsers:             make(map[string]*UserData),
+		undoNextDeliveryJobId: d.nextDeliveryJobId,
+		undoDeliveryJobs:      make(map[int]*deliveryJobData),
+	}, nil
+}
+
+// withTx runs fn in a transaction of its own, committing it if fn succeeds
+func withTx[T any](ctx context.Context, d *PubblrDatabase, fn func(tx *PubblrTx) (T, error)) (T, error) {
+	var zero T
+
+	tx, err := d.begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("Could not get post type: %w", err)
+		return zero, err
 	}
-	postType = strings.ToLower(postType)
+	defer tx.Rollback()
 
-	baseUrl.Path = path.Join(baseUrl.Path, user, postType, strconv.Itoa(len(objects[postType])))
-	id := baseUrl.String()
-	activitystreams.ToObject(post).Id = id
+	ret, err := fn(tx)
+	if err != nil {
+		return zero, err
+	}
 
-	postJson, err := json.Marshal(post)
+	err = tx.Commit()
 	if err != nil {
-		return nil, fmt.Errorf("Failed to marshal retrieved post: %w", err)
+		return zero, err
 	}
+	return ret, nil
+}
 
-	objects[postType] = append(objects[postType], postJson)
+func (d *PubblrDatabase) CreateObject(ctx context.Context, post activitystreams.ObjectIface, user string, baseUrl url.URL) (activitystreams.ObjectIface, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (activitystreams.ObjectIface, error) {
+		return tx.CreateObject(ctx, post, user, baseUrl)
+	})
+}
 
-	return post, nil
+func (d *PubblrDatabase) CreateInboxItem(ctx context.Context, a activitystreams.ActivityIface, user string) (activitystreams.ActivityIface, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (activitystreams.ActivityIface, error) {
+		return tx.CreateInboxItem(ctx, a, user)
+	})
 }
 
-func (d *PubblrDatabase) CreateInboxItem(a activitystreams.ActivityIface, user string) (activitystreams.ActivityIface, error) {
-	if d.users == nil {
-		d.users = make(map[string]UserData)
-	}
+func (d *PubblrDatabase) CreateOutboxItem(ctx context.Context, activity activitystreams.ActivityIface, user string, baseUrl url.URL) (activitystreams.ActivityIface, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (activitystreams.ActivityIface, error) {
+		return tx.CreateOutboxItem(ctx, activity, user, baseUrl)
+	})
+}
 
-	userData, ok := d.users[user]
-	if !ok {
-		return nil, fmt.Errorf("User %s does not exist", user)
-	}
+func (d *PubblrDatabase) CreateDeliveryJob(ctx context.Context, activity activitystreams.ActivityIface, recipient string) (*DeliveryJob, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*DeliveryJob, error) {
+		return tx.CreateDeliveryJob(ctx, activity, recipient)
+	})
+}
 
-	marshalledActivity, err := json.Marshal(a)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to marshal activity: %w", err)
-	}
+func (d *PubblrDatabase) GetDeliveryJobs(ctx context.Context, due time.Time, limit int) ([]*DeliveryJob, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]*DeliveryJob, error) {
+		return tx.GetDeliveryJobs(ctx, due, limit)
+	})
+}
 
-	userData.Inbox = append(userData.Inbox, marshalledActivity)
-	d.users[user] = userData
+func (d *PubblrDatabase) UpdateDeliveryJob(ctx context.Context, job DeliveryJob) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.UpdateDeliveryJob(ctx, job)
+	})
+	return err
+}
 
-	return a, nil
+func (d *PubblrDatabase) DeleteDeliveryJob(ctx context.Context, id string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.DeleteDeliveryJob(ctx, id)
+	})
+	return err
 }
 
-func (d *PubblrDatabase) CreateOutboxItem(activity activitystreams.ActivityIface, user string, baseUrl url.URL) (activitystreams.ActivityIface, error) {
-	if d.users == nil {
-		d.users = make(map[string]UserData)
-	}
+func (d *PubblrDatabase) GetInboxItems(ctx context.Context, user string, query PageQuery) ([]*BoxItem, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]*BoxItem, error) {
+		return tx.GetInboxItems(ctx, user, query)
+	})
+}
 
-	userData, ok := d.users[user]
-	if !ok {
-		return nil, fmt.Errorf("User %s does not exist", user)
-	}
+func (d *PubblrDatabase) GetOutboxItems(ctx context.Context, user string, query PageQuery) ([]*BoxItem, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]*BoxItem, error) {
+		return tx.GetOutboxItems(ctx, user, query)
+	})
+}
 
-	baseUrl.Path = path.Join(baseUrl.Path, user, "outbox", strconv.Itoa(len(userData.Outbox)))
-	id := baseUrl.String()
-	activitystreams.ToObject(activity).Id = id
+func (d *PubblrDatabase) GetInboxCount(ctx context.Context, user string) (int, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (int, error) {
+		return tx.GetInboxCount(ctx, user)
+	})
+}
 
-	activityJson, err := json.Marshal(activity)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to marshal activity: %w", err)
-	}
+func (d *PubblrDatabase) GetOutboxCount(ctx context.Context, user string) (int, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (int, error) {
+		return tx.GetOutboxCount(ctx, user)
+	})
+}
 
-	userData.Outbox = append(userData.Outbox, activityJson)
-	d.users[user] = userData
+func (d *PubblrDatabase) GetInboxItem(ctx context.Context, user, id string) (activitystreams.ActivityIface, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (activitystreams.ActivityIface, error) {
+		return tx.GetInboxItem(ctx, user, id)
+	})
+}
 
-	return activity, nil
+func (d *PubblrDatabase) GetOutboxItem(ctx context.Context, user, id string) (activitystreams.ActivityIface, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (activitystreams.ActivityIface, error) {
+		return tx.GetOutboxItem(ctx, user, id)
+	})
 }
 
-func (d *PubblrDatabase) GetInboxPage(user string, page int, pageSize int) ([]activitystreams.ActivityIface, error) {
-	if d.users == nil {
-		d.users = make(map[string]UserData)
-	}
+func (d *PubblrDatabase) GetObject(ctx context.Context, user, typ, id string) (activitystreams.ObjectIface, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (activitystreams.ObjectIface, error) {
+		return tx.GetObject(ctx, user, typ, id)
+	})
+}
 
-	userData, ok := d.users[user]
-	if !ok {
-		return nil, fmt.Errorf("User %s does not exist", user)
-	}
+func (d *PubblrDatabase) GetObjects(ctx context.Context, user string) ([]activitystreams.ObjectIface, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]activitystreams.ObjectIface, error) {
+		return tx.GetObjects(ctx, user)
+	})
+}
 
-	rawInbox := userData.Inbox
-	if !ok {
-		rawInbox = make([]json.RawMessage, 0)
-		userData.Inbox = rawInbox
-	}
+func (d *PubblrDatabase) AddFollower(ctx context.Context, user, actorId string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.AddFollower(ctx, user, actorId)
+	})
+	return err
+}
 
-	start := page * pageSize
-	end := start + pageSize
-	if end > len(rawInbox) {
-		end = len(rawInbox)
+func (d *PubblrDatabase) GetFollowers(ctx context.Context, user string) ([]string, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]string, error) {
+		return tx.GetFollowers(ctx, user)
+	})
+}
 
-	}
-	rawInbox = rawInbox[start:end]
-
-	inbox := make([]activitystreams.ActivityIface, len(rawInbox))
-	for i, activityJson := range rawInbox {
-		var activity activitystreams.ActivityIface
-		err := activitystreams.DefaultEntityUnmarshaler.Unmarshal(activityJson, &activity)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to unmarshal activity: %w", err)
-		}
+func (d *PubblrDatabase) AddFollowing(ctx context.Context, user, actorId string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.AddFollowing(ctx, user, actorId)
+	})
+	return err
+}
 
-		inbox[i] = activity
-	}
+func (d *PubblrDatabase) GetFollowing(ctx context.Context, user string) ([]string, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]string, error) {
+		return tx.GetFollowing(ctx, user)
+	})
+}
 
-	return inbox, nil
+func (d *PubblrDatabase) AddBlock(ctx context.Context, user, actorId string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.AddBlock(ctx, user, actorId)
+	})
+	return err
 }
 
-func (d *PubblrDatabase) GetOutboxPage(user string, page int, pageSize int) ([]activitystreams.ActivityIface, error) {
-	if d.users == nil {
-		d.users = make(map[string]UserData)
-	}
+func (d *PubblrDatabase) GetBlocks(ctx context.Context, user string) ([]string, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]string, error) {
+		return tx.GetBlocks(ctx, user)
+	})
+}
 
-	userData, ok := d.users[user]
-	if !ok {
-		return nil, fmt.Errorf("User %s does not exist", user)
-	}
+func (d *PubblrDatabase) CreateUser(ctx context.Context, user activitystreams.ActorIface, username, password string, baseUrl url.URL) (activitystreams.ActorIface, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (activitystreams.ActorIface, error) {
+		return tx.CreateUser(ctx, user, username, password, baseUrl)
+	})
+}
 
-	rawOutbox := userData.Outbox
-	if !ok {
-		rawOutbox = make([]json.RawMessage, 0)
-		userData.Outbox = rawOutbox
-	}
+func (d *PubblrDatabase) GetUser(ctx context.Context, username string) (activitystreams.ActorIface, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (activitystreams.ActorIface, error) {
+		return tx.GetUser(ctx, username)
+	})
+}
 
-	start := page * pageSize
-	end := start + pageSize
-	if end > len(rawOutbox) {
-		end = len(rawOutbox)
+func (d *PubblrDatabase) UpdateUser(ctx context.Context, user activitystreams.ActorIface, username string) (activitystreams.ActorIface, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (activitystreams.ActorIface, error) {
+		return tx.UpdateUser(ctx, user, username)
+	})
+}
 
-	}
-	rawOutbox = rawOutbox[start:end]
-
-	outbox := make([]activitystreams.ActivityIface, len(rawOutbox))
-	for i, activityJson := range rawOutbox {
-		var activity activitystreams.ActivityIface
-		err := activitystreams.DefaultEntityUnmarshaler.Unmarshal(activityJson, &activity)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to unmarshal activity: %w", err)
-		}
+func (d *PubblrDatabase) CreateBlog(ctx context.Context, blog activitystreams.ActorIface, name, account string, baseUrl url.URL) (activitystreams.ActorIface, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (activitystreams.ActorIface, error) {
+		return tx.CreateBlog(ctx, blog, name, account, baseUrl)
+	})
+}
 
-		outbox[i] = activity
-	}
+func (d *PubblrDatabase) GetAccount(ctx context.Context, name string) (string, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (string, error) {
+		return tx.GetAccount(ctx, name)
+	})
+}
 
-	return outbox, nil
+func (d *PubblrDatabase) GetBlogs(ctx context.Context, account string) ([]string, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]string, error) {
+		return tx.GetBlogs(ctx, account)
+	})
 }
 
-func (d *PubblrDatabase) GetInboxCount(user string) (int, error) {
-	if d.users == nil {
-		d.users = make(map[string]UserData)
-	}
+func (d *PubblrDatabase) CreateGroup(ctx context.Context, group activitystreams.ActorIface, name, owner string, baseUrl url.URL) (activitystreams.ActorIface, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (activitystreams.ActorIface, error) {
+		return tx.CreateGroup(ctx, group, name, owner, baseUrl)
+	})
+}
 
-	userData, ok := d.users[user]
-	if !ok {
-		return 0, fmt.Errorf("user %s does not exist", user)
-	}
+func (d *PubblrDatabase) GetGroupMembers(ctx context.Context, group string) ([]*GroupMember, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]*GroupMember, error) {
+		return tx.GetGroupMembers(ctx, group)
+	})
+}
 
-	return len(userData.Inbox), nil
+func (d *PubblrDatabase) GetGroupMember(ctx context.Context, group, username string) (*GroupMember, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*GroupMember, error) {
+		return tx.GetGroupMember(ctx, group, username)
+	})
 }
 
-func (d *PubblrDatabase) GetOutboxCount(user string) (int, error) {
-	if d.users == nil {
-		d.users = make(map[string]UserData)
-	}
+func (d *PubblrDatabase) SetGroupMember(ctx context.Context, member GroupMember) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.SetGroupMember(ctx, member)
+	})
+	return err
+}
 
-	userData, ok := d.users[user]
-	if !ok {
-		return 0, fmt.Errorf("user %s does not exist", user)
-	}
+func (d *PubblrDatabase) RemoveGroupMember(ctx context.Context, group, username string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.RemoveGroupMember(ctx, group, username)
+	})
+	return err
+}
 
-	return len(userData.Outbox), nil
+func (d *PubblrDatabase) GetGroups(ctx context.Context, username string) ([]*GroupMember, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]*GroupMember, error) {
+		return tx.GetGroups(ctx, username)
+	})
 }
 
-func (d *PubblrDatabase) GetInboxItem(user, id string) (activitystreams.ActivityIface, error) {
-	if d.users == nil {
-		d.users = make(map[string]UserData)
-	}
+func (d *PubblrDatabase) GetAppearance(ctx context.Context, user string) (*Appearance, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*Appearance, error) {
+		return tx.GetAppearance(ctx, user)
+	})
+}
 
-	userData, ok := d.users[user]
-	if !ok {
-		return nil, fmt.Errorf("user %s does not exist", user)
-	}
+func (d *PubblrDatabase) SetAppearance(ctx context.Context, user string, appearance Appearance) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.SetAppearance(ctx, user, appearance)
+	})
+	return err
+}
 
-	parsedId, err := strconv.Atoi(id)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to parse id: %w", err)
-	}
+func (d *PubblrDatabase) CheckPassword(ctx context.Context, username, password string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.CheckPassword(ctx, username, password)
+	})
+	return err
+}
 
-	if len(userData.Inbox) <= parsedId {
-		return nil, fmt.Errorf("No activity with id %s", id)
-	}
+func (d *PubblrDatabase) GetRoles(ctx context.Context, username string) ([]string, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]string, error) {
+		return tx.GetRoles(ctx, username)
+	})
+}
 
-	activityJson := userData.Inbox[parsedId]
-	var activity activitystreams.ActivityIface
-	err = activitystreams.DefaultEntityUnmarshaler.Unmarshal(activityJson, &activity)
+func (d *PubblrDatabase) SetRoles(ctx context.Context, username string, roles []string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.SetRoles(ctx, username, roles)
+	})
+	return err
+}
 
-	return activity, err
+func (d *PubblrDatabase) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.CreateRefreshToken(ctx, token)
+	})
+	return err
 }
 
-func (d *PubblrDatabase) GetOutboxItem(user, id string) (activitystreams.ActivityIface, error) {
-	if d.users == nil {
-		d.users = make(map[string]UserData)
-	}
+func (d *PubblrDatabase) GetRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*RefreshToken, error) {
+		return tx.GetRefreshToken(ctx, hash)
+	})
+}
 
-	userData, ok := d.users[user]
-	if !ok {
-		return nil, fmt.Errorf("user %s does not exist", user)
-	}
+func (d *PubblrDatabase) UseRefreshToken(ctx context.Context, hash string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.UseRefreshToken(ctx, hash)
+	})
+	return err
+}
 
-	parsedId, err := strconv.Atoi(id)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to parse id: %w", err)
-	}
+func (d *PubblrDatabase) CreateSession(ctx context.Context, session Session) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.CreateSession(ctx, session)
+	})
+	return err
+}
 
-	if len(userData.Outbox) <= parsedId {
-		return nil, fmt.Errorf("No activity with id %s", id)
-	}
+func (d *PubblrDatabase) GetSession(ctx context.Context, id string) (*Session, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*Session, error) {
+		return tx.GetSession(ctx, id)
+	})
+}
 
-	activityJson := userData.Outbox[parsedId]
-	var activity activitystreams.ActivityIface
-	err = activitystreams.DefaultEntityUnmarshaler.Unmarshal(activityJson, &activity)
+func (d *PubblrDatabase) GetSessions(ctx context.Context, username string) ([]*Session, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]*Session, error) {
+		return tx.GetSessions(ctx, username)
+	})
+}
 
-	return activity, err
+func (d *PubblrDatabase) UpdateSession(ctx context.Context, session Session) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.UpdateSession(ctx, session)
+	})
+	return err
 }
 
-func (d *PubblrDatabase) GetObject(user, typ, id string) (activitystreams.ObjectIface, error) {
-	userData, ok := d.users[user]
-	if !ok {
-		return nil, fmt.Errorf("user %s does not exist", user)
-	}
+func (d *PubblrDatabase) DeleteSession(ctx context.Context, id string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.DeleteSession(ctx, id)
+	})
+	return err
+}
 
-	parsedId, err := strconv.Atoi(id)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to parse id: %w", err)
-	}
+func (d *PubblrDatabase) CreateOAuthClient(ctx context.Context, client OAuthClient) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.CreateOAuthClient(ctx, client)
+	})
+	return err
+}
 
-	objects := userData.Objects[typ]
-	if !ok {
-		return nil, fmt.Errorf("Object not found")
-	}
+func (d *PubblrDatabase) GetOAuthClient(ctx context.Context, id string) (*OAuthClient, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*OAuthClient, error) {
+		return tx.GetOAuthClient(ctx, id)
+	})
+}
 
-	if len(objects) <= parsedId {
-		return nil, fmt.Errorf("Object not found")
-	}
+func (d *PubblrDatabase) CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.CreateAuthorizationCode(ctx, code)
+	})
+	return err
+}
 
-	postJson := objects[parsedId]
-	var post activitystreams.ObjectIface
-	err = activitystreams.DefaultEntityUnmarshaler.Unmarshal(postJson, &post)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to unmarshal retrieved post: %w", err)
-	}
+func (d *PubblrDatabase) GetAuthorizationCode(ctx context.Context, hash string) (*AuthorizationCode, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*AuthorizationCode, error) {
+		return tx.GetAuthorizationCode(ctx, hash)
+	})
+}
 
-	return post, nil
+func (d *PubblrDatabase) DeleteAuthorizationCode(ctx context.Context, hash string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.DeleteAuthorizationCode(ctx, hash)
+	})
+	return err
 }
 
-func (d *PubblrDatabase) CreateUser(user activitystreams.ActorIface, username, password string, baseUrl url.URL) (activitystreams.ActorIface, error) {
-	if d.users == nil {
-		d.users = make(map[string]UserData)
-	}
+func (d *PubblrDatabase) GetTwoFactor(ctx context.Context, username string) (*TwoFactor, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*TwoFactor, error) {
+		return tx.GetTwoFactor(ctx, username)
+	})
+}
 
-	_, ok := d.users[username]
-	if ok {
-		return nil, fmt.Errorf("User %s already exists", username)
-	}
+func (d *PubblrDatabase) SetTwoFactor(ctx context.Context, twoFactor TwoFactor) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.SetTwoFactor(ctx, twoFactor)
+	})
+	return err
+}
 
-	baseUrl.Path = path.Join(baseUrl.Path, username)
-	id := baseUrl.String()
+func (d *PubblrDatabase) DeleteTwoFactor(ctx context.Context, username string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.DeleteTwoFactor(ctx, username)
+	})
+	return err
+}
 
-	activitystreams.ToObject(user).Id = id
+func (d *PubblrDatabase) CreateLoginChallenge(ctx context.Context, challenge LoginChallenge) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.CreateLoginChallenge(ctx, challenge)
+	})
+	return err
+}
 
-	bytes, err := json.Marshal(user)
-	if err != nil {
-		return nil, err
-	}
+func (d *PubblrDatabase) GetLoginChallenge(ctx context.Context, hash string) (*LoginChallenge, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*LoginChallenge, error) {
+		return tx.GetLoginChallenge(ctx, hash)
+	})
+}
 
-	userdata := d.users[username]
-	userdata.Actor = bytes
-	userdata.Password = password
-	d.users[username] = userdata
-	return user, nil
+func (d *PubblrDatabase) UpdateLoginChallenge(ctx context.Context, challenge LoginChallenge) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.UpdateLoginChallenge(ctx, challenge)
+	})
+	return err
 }
 
-func (d *PubblrDatabase) GetUser(username string) (activitystreams.ActorIface, error) {
-	userData, ok := d.users[username]
-	if !ok {
-		return nil, fmt.Errorf("User %s does not exist", username)
-	}
-	userJson := userData.Actor
+func (d *PubblrDatabase) DeleteLoginChallenge(ctx context.Context, hash string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.DeleteLoginChallenge(ctx, hash)
+	})
+	return err
+}
 
-	var user activitystreams.ActorIface
-	err := activitystreams.DefaultEntityUnmarshaler.Unmarshal(userJson, &user)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to unmarshal retrieved user: %w", err)
-	}
+func (d *PubblrDatabase) SetPassword(ctx context.Context, username, password string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.SetPassword(ctx, username, password)
+	})
+	return err
+}
 
-	return user, nil
+func (d *PubblrDatabase) GetEmail(ctx context.Context, username string) (*Email, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*Email, error) {
+		return tx.GetEmail(ctx, username)
+	})
 }
 
-func (d *PubblrDatabase) CheckPassword(username, password string) error {
-	userData, ok := d.users[username]
-	if !ok {
-		return fmt.Errorf("User %s does not exist", username)
-	}
+func (d *PubblrDatabase) SetEmail(ctx context.Context, username string, email Email) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.SetEmail(ctx, username, email)
+	})
+	return err
+}
 
-	if userData.Password != password {
-		return fmt.Errorf("Wrong password")
-	}
+func (d *PubblrDatabase) GetUsernameByEmail(ctx context.Context, address string) (string, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (string, error) {
+		return tx.GetUsernameByEmail(ctx, address)
+	})
+}
+
+func (d *PubblrDatabase) CreateAccountToken(ctx context.Context, token AccountToken) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.CreateAccountToken(ctx, token)
+	})
+	return err
+}
+
+func (d *PubblrDatabase) GetAccountToken(ctx context.Context, hash string) (*AccountToken, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*AccountToken, error) {
+		return tx.GetAccountToken(ctx, hash)
+	})
+}
+
+func (d *PubblrDatabase) DeleteAccountToken(ctx context.Context, hash string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.DeleteAccountToken(ctx, hash)
+	})
+	return err
+}
+
+func (d *PubblrDatabase) DeleteAccountTokens(ctx context.Context, username string, purpose AccountTokenPurpose) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.DeleteAccountTokens(ctx, username, purpose)
+	})
+	return err
+}
+
+func (d *PubblrDatabase) GetRegistration(ctx context.Context, username string) (*Registration, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*Registration, error) {
+		return tx.GetRegistration(ctx, username)
+	})
+}
+
+func (d *PubblrDatabase) SetRegistration(ctx context.Context, registration Registration) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.SetRegistration(ctx, registration)
+	})
+	return err
+}
+
+func (d *PubblrDatabase) GetRegistrations(ctx context.Context, status AccountStatus) ([]*Registration, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]*Registration, error) {
+		return tx.GetRegistrations(ctx, status)
+	})
+}
+
+func (d *PubblrDatabase) CreateInvite(ctx context.Context, invite Invite) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.CreateInvite(ctx, invite)
+	})
+	return err
+}
+
+func (d *PubblrDatabase) GetInvite(ctx context.Context, hash string) (*Invite, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*Invite, error) {
+		return tx.GetInvite(ctx, hash)
+	})
+}
+
+func (d *PubblrDatabase) GetInvites(ctx context.Context) ([]*Invite, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]*Invite, error) {
+		return tx.GetInvites(ctx)
+	})
+}
+
+func (d *PubblrDatabase) UpdateInvite(ctx context.Context, invite Invite) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.UpdateInvite(ctx, invite)
+	})
+	return err
+}
+
+func (d *PubblrDatabase) DeleteInvite(ctx context.Context, hash string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.DeleteInvite(ctx, hash)
+	})
+	return err
+}
+
+func (d *PubblrDatabase) CreatePersonalAccessToken(ctx context.Context, token PersonalAccessToken) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.CreatePersonalAccessToken(ctx, token)
+	})
+	return err
+}
+
+func (d *PubblrDatabase) GetPersonalAccessToken(ctx context.Context, hash string) (*PersonalAccessToken, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) (*PersonalAccessToken, error) {
+		return tx.GetPersonalAccessToken(ctx, hash)
+	})
+}
+
+func (d *PubblrDatabase) GetPersonalAccessTokens(ctx context.Context, username string) ([]*PersonalAccessToken, error) {
+	return withTx(ctx, d, func(tx *PubblrTx) ([]*PersonalAccessToken, error) {
+		return tx.GetPersonalAccessTokens(ctx, username)
+	})
+}
+
+func (d *PubblrDatabase) UpdatePersonalAccessToken(ctx context.Context, token PersonalAccessToken) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.UpdatePersonalAccessToken(ctx, token)
+	})
+	return err
+}
 
-	return nil
+func (d *PubblrDatabase) DeletePersonalAccessToken(ctx context.Context, username, id string) error {
+	_, err := withTx(ctx, d, func(tx *PubblrTx) (struct{}, error) {
+		return struct{}{}, tx.DeletePersonalAccessToken(ctx, username, id)
+	})
+	return err
 }
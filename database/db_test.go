@@ -0,0 +1,15 @@
+package database_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/database/testutil"
+	"github.com/brandonsides/pubblr/server"
+)
+
+var _ = Describe("PubblrDatabase", func() {
+	testutil.CheckDB(func() server.DB {
+		return database.NewPubblrDatabase(database.PubblrDatabaseConfig{})
+	})
+})
@@ -0,0 +1,1390 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var baseUrl = url.URL{
+	Scheme: "http",
+	Host:   "example.org",
+	Path:   "/pubblr",
+}
+
+func newActor(name string) activitystreams.ActorIface {
+	return &activitystreams.Person{
+		Actor: activitystreams.Actor{
+			Object: activitystreams.Object{
+				Entity: activitystreams.Entity{
+					Name: name,
+				},
+			},
+		},
+	}
+}
+
+func newNote(content string) *activitystreams.Note {
+	return &activitystreams.Note{
+		Object: activitystreams.Object{
+			Content: content,
+		},
+	}
+}
+
+func newCreate(content string) *activitystreams.Create {
+	return &activitystreams.Create{
+		TransitiveActivity: activitystreams.TransitiveActivity{
+			Object: newNote(content),
+		},
+	}
+}
+
+func contentOf(a activitystreams.ActivityIface) string {
+	create, ok := a.(*activitystreams.Create)
+	Expect(ok).To(BeTrue(), "expected a Create, got %T", a)
+	object, ok := create.Object.(activitystreams.ObjectIface)
+	Expect(ok).To(BeTrue(), "expected Create object to be an Object, got %T", create.Object)
+	return activitystreams.ToObject(object).Content
+}
+
+// CheckDB describes the behaviour required of every server.DB implementation.
+// newDB is called before each spec, and must return an empty database.
+func CheckDB(newDB func() server.DB) {
+	var db server.DB
+	var ctx context.Context
+
+	BeforeEach(func() {
+		db = newDB()
+		ctx = context.Background()
+	})
+
+	Describe("CreateUser", func() {
+		It("should assign the actor an id under the base url", func() {
+			actor, err := db.CreateUser(ctx, newActor("Alice"), "alice", "password", baseUrl)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(activitystreams.ToObject(actor).Id).To(Equal("http://example.org/pubblr/alice"))
+		})
+
+		It("should make the actor retrievable", func() {
+			_, err := db.CreateUser(ctx, newActor("Alice"), "alice", "password", baseUrl)
+			Expect(err).ToNot(HaveOccurred())
+
+			actor, err := db.GetUser(ctx, "alice")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(actor.Type()).To(Equal("Person"))
+			Expect(activitystreams.ToObject(actor).Name).To(Equal("Alice"))
+			Expect(activitystreams.ToObject(actor).Id).To(Equal("http://example.org/pubblr/alice"))
+		})
+
+		It("should refuse to create the same user twice", func() {
+			_, err := db.CreateUser(ctx, newActor("Alice"), "alice", "password", baseUrl)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = db.CreateUser(ctx, newActor("Alice"), "alice", "password", baseUrl)
+			Expect(err).To(MatchError(database.ErrUserExists))
+		})
+
+		It("should let the actor be replaced, keeping its id", func() {
+			_, err := db.CreateUser(ctx, newActor("Alice"), "alice", "password", baseUrl)
+			Expect(err).ToNot(HaveOccurred())
+
+			replacement := newActor("Alice Liddell")
+			activitystreams.ToObject(replacement).Id = "http://example.com/alice"
+			actor, err := db.UpdateUser(ctx, replacement, "alice")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(activitystreams.ToObject(actor).Id).To(Equal("http://example.org/pubblr/alice"))
+
+			actor, err = db.GetUser(ctx, "alice")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(activitystreams.ToObject(actor).Name).To(Equal("Alice Liddell"))
+			Expect(activitystreams.ToObject(actor).Id).To(Equal("http://example.org/pubblr/alice"))
+			Expect(db.CheckPassword(ctx, "alice", "password")).To(Succeed())
+		})
+
+		It("should store the password", func() {
+			_, err := db.CreateUser(ctx, newActor("Alice"), "alice", "password", baseUrl)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(db.CheckPassword(ctx, "alice", "password")).To(Succeed())
+			Expect(db.CheckPassword(ctx, "alice", "wrong")).ToNot(Succeed())
+		})
+	})
+
+	Describe("missing users", func() {
+		It("should be reported by every per-user operation", func() {
+			_, err := db.GetUser(ctx, "nobody")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			Expect(db.CheckPassword(ctx, "nobody", "password")).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.UpdateUser(ctx, newActor("Nobody"), "nobody")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.CreateObject(ctx, newNote("hi"), "nobody", baseUrl)
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.CreateInboxItem(ctx, newCreate("hi"), "nobody")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.CreateOutboxItem(ctx, newCreate("hi"), "nobody", baseUrl)
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetInboxItems(ctx, "nobody", database.PageQuery{Limit: 10})
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetOutboxItems(ctx, "nobody", database.PageQuery{Limit: 10})
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetInboxCount(ctx, "nobody")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetOutboxCount(ctx, "nobody")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetInboxItem(ctx, "nobody", "0")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetOutboxItem(ctx, "nobody", "0")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetObject(ctx, "nobody", "note", "0")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetObjects(ctx, "nobody")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			Expect(db.AddFollower(ctx, "nobody", "http://example.org/bob")).To(MatchError(database.ErrUserNotFound))
+			Expect(db.AddFollowing(ctx, "nobody", "http://example.org/bob")).To(MatchError(database.ErrUserNotFound))
+			Expect(db.AddBlock(ctx, "nobody", "http://example.org/bob")).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetFollowers(ctx, "nobody")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetFollowing(ctx, "nobody")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetBlocks(ctx, "nobody")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			Expect(db.CreateSession(ctx, database.Session{Id: "s1", Username: "nobody"})).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetSessions(ctx, "nobody")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetRoles(ctx, "nobody")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+
+			_, err = db.GetTwoFactor(ctx, "nobody")
+			Expect(err).To(MatchError(database.ErrUserNotFound))
+			Expect(db.SetTwoFactor(ctx, database.TwoFactor{Username: "nobody"})).To(MatchError(database.ErrUserNotFound))
+			Expect(db.CreateLoginChallenge(ctx, database.LoginChallenge{Hash: "c", Username: "nobody"})).To(MatchError(database.ErrUserNotFound))
+			Expect(db.SetRoles(ctx, "nobody", []string{"admin"})).To(MatchError(database.ErrUserNotFound))
+		})
+	})
+
+	Context("with a user", func() {
+		BeforeEach(func() {
+			_, err := db.CreateUser(ctx, newActor("Alice"), "alice", "password", baseUrl)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Describe("CreateObject", func() {
+			It("should assign distinct ids per type", func() {
+				first, err := db.CreateObject(ctx, newNote("first"), "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				second, err := db.CreateObject(ctx, newNote("second"), "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+
+				firstId := activitystreams.ToObject(first).Id
+				secondId := activitystreams.ToObject(second).Id
+				Expect(firstId).To(HavePrefix("http://example.org/pubblr/alice/note/"))
+				Expect(secondId).To(HavePrefix("http://example.org/pubblr/alice/note/"))
+				Expect(firstId).ToNot(Equal(secondId))
+			})
+
+			It("should make objects retrievable by the last segment of their id", func() {
+				created, err := db.CreateObject(ctx, newNote("hello"), "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				id := activitystreams.ToObject(created).Id
+
+				retrieved, err := db.GetObject(ctx, "alice", "note", id[strings.LastIndex(id, "/")+1:])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(retrieved.Type()).To(Equal("Note"))
+				Expect(activitystreams.ToObject(retrieved).Content).To(Equal("hello"))
+				Expect(activitystreams.ToObject(retrieved).Id).To(Equal(id))
+			})
+
+			It("should report missing objects", func() {
+				_, err := db.GetObject(ctx, "alice", "note", "0")
+				Expect(err).To(MatchError(database.ErrNotFound))
+			})
+		})
+
+		Describe("GetObjects", func() {
+			It("should return nothing for a new user", func() {
+				Expect(db.GetObjects(ctx, "alice")).To(BeEmpty())
+			})
+
+			It("should return every object in order of creation within each type", func() {
+				for _, content := range []string{"first", "second"} {
+					_, err := db.CreateObject(ctx, newNote(content), "alice", baseUrl)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				_, err := db.CreateObject(ctx, &activitystreams.Article{}, "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+
+				objects, err := db.GetObjects(ctx, "alice")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(objects).To(HaveLen(3))
+
+				var notes []string
+				for _, object := range objects {
+					if typ, _ := object.Type(); typ == "Note" {
+						notes = append(notes, activitystreams.ToObject(object).Content)
+					}
+				}
+				Expect(notes).To(Equal([]string{"first", "second"}))
+			})
+		})
+
+		for _, relation := range []struct {
+			name string
+			add  func(db server.DB, actorId string) error
+			get  func(db server.DB) ([]string, error)
+		}{
+			{"followers", func(db server.DB, actorId string) error {
+				return db.AddFollower(context.Background(), "alice", actorId)
+			}, func(db server.DB) ([]string, error) {
+				return db.GetFollowers(context.Background(), "alice")
+			}},
+			{"following", func(db server.DB, actorId string) error {
+				return db.AddFollowing(context.Background(), "alice", actorId)
+			}, func(db server.DB) ([]string, error) {
+				return db.GetFollowing(context.Background(), "alice")
+			}},
+			{"blocks", func(db server.DB, actorId string) error {
+				return db.AddBlock(context.Background(), "alice", actorId)
+			}, func(db server.DB) ([]string, error) {
+				return db.GetBlocks(context.Background(), "alice")
+			}},
+		} {
+			relation := relation
+			Describe(relation.name, func() {
+				It("should start empty", func() {
+					Expect(relation.get(db)).To(BeEmpty())
+				})
+
+				It("should list added actors once each, in order", func() {
+					Expect(relation.add(db, "http://example.org/bob")).To(Succeed())
+					Expect(relation.add(db, "http://example.org/carol")).To(Succeed())
+					Expect(relation.add(db, "http://example.org/bob")).To(Succeed())
+
+					Expect(relation.get(db)).To(Equal([]string{"http://example.org/bob", "http://example.org/carol"}))
+				})
+			})
+		}
+
+		Describe("CreateOutboxItem", func() {
+			It("should assign distinct ids under the outbox", func() {
+				first, err := db.CreateOutboxItem(ctx, newCreate("first"), "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				second, err := db.CreateOutboxItem(ctx, newCreate("second"), "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+
+				firstId := activitystreams.ToObject(first).Id
+				secondId := activitystreams.ToObject(second).Id
+				Expect(firstId).To(HavePrefix("http://example.org/pubblr/alice/outbox/"))
+				Expect(secondId).To(HavePrefix("http://example.org/pubblr/alice/outbox/"))
+				Expect(firstId).ToNot(Equal(secondId))
+
+				retrieved, err := db.GetOutboxItem(ctx, "alice", secondId[strings.LastIndex(secondId, "/")+1:])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(contentOf(retrieved)).To(Equal("second"))
+			})
+
+			It("should report missing items", func() {
+				_, err := db.GetOutboxItem(ctx, "alice", "0")
+				Expect(err).To(MatchError(database.ErrNotFound))
+			})
+		})
+
+		Describe("CreateInboxItem", func() {
+			It("should make items retrievable in order of arrival", func() {
+				for i := 0; i < 3; i++ {
+					_, err := db.CreateInboxItem(ctx, newCreate(strconv.Itoa(i)), "alice")
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				for i := 0; i < 3; i++ {
+					item, err := db.GetInboxItem(ctx, "alice", strconv.Itoa(i))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(contentOf(item)).To(Equal(strconv.Itoa(i)))
+				}
+			})
+
+			It("should report missing items", func() {
+				_, err := db.GetInboxItem(ctx, "alice", "0")
+				Expect(err).To(MatchError(database.ErrNotFound))
+			})
+		})
+
+		Describe("counts", func() {
+			It("should start at zero", func() {
+				Expect(db.GetInboxCount(ctx, "alice")).To(Equal(0))
+				Expect(db.GetOutboxCount(ctx, "alice")).To(Equal(0))
+			})
+
+			It("should count inbox and outbox items separately", func() {
+				for i := 0; i < 3; i++ {
+					_, err := db.CreateInboxItem(ctx, newCreate("in"), "alice")
+					Expect(err).ToNot(HaveOccurred())
+				}
+				for i := 0; i < 2; i++ {
+					_, err := db.CreateOutboxItem(ctx, newCreate("out"), "alice", baseUrl)
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				Expect(db.GetInboxCount(ctx, "alice")).To(Equal(3))
+				Expect(db.GetOutboxCount(ctx, "alice")).To(Equal(2))
+			})
+		})
+
+		Describe("paging", func() {
+			BeforeEach(func() {
+				for i := 0; i < 5; i++ {
+					_, err := db.CreateInboxItem(ctx, newCreate(strconv.Itoa(i)), "alice")
+					Expect(err).ToNot(HaveOccurred())
+					_, err = db.CreateOutboxItem(ctx, newCreate(strconv.Itoa(i)), "alice", baseUrl)
+					Expect(err).ToNot(HaveOccurred())
+				}
+			})
+
+			for _, box := range []struct {
+				name     string
+				getItems func(db server.DB, query database.PageQuery) ([]*database.BoxItem, error)
+			}{
+				{"inbox", func(db server.DB, query database.PageQuery) ([]*database.BoxItem, error) {
+					return db.GetInboxItems(context.Background(), "alice", query)
+				}},
+				{"outbox", func(db server.DB, query database.PageQuery) ([]*database.BoxItem, error) {
+					return db.GetOutboxItems(context.Background(), "alice", query)
+				}},
+			} {
+				box := box
+				Describe(box.name, func() {
+					contents := func(items []*database.BoxItem) []string {
+						var ret []string
+						for _, item := range items {
+							ret = append(ret, contentOf(item.Activity))
+						}
+						return ret
+					}
+
+					getItems := func(query database.PageQuery) []*database.BoxItem {
+						items, err := box.getItems(db, query)
+						Expect(err).ToNot(HaveOccurred())
+						return items
+					}
+
+					It("should return the newest items first", func() {
+						items := getItems(database.PageQuery{Limit: 2})
+						Expect(contents(items)).To(Equal([]string{"4", "3"}))
+					})
+
+					It("should return everything when the limit exceeds the box", func() {
+						items := getItems(database.PageQuery{Limit: 100})
+						Expect(contents(items)).To(Equal([]string{"4", "3", "2", "1", "0"}))
+					})
+
+					It("should walk to older items with MaxId", func() {
+						first := getItems(database.PageQuery{Limit: 2})
+						second := getItems(database.PageQuery{MaxId: first[1].Id, Limit: 2})
+						Expect(contents(second)).To(Equal([]string{"2", "1"}))
+						third := getItems(database.PageQuery{MaxId: second[1].Id, Limit: 2})
+						Expect(contents(third)).To(Equal([]string{"0"}))
+						Expect(getItems(database.PageQuery{MaxId: third[0].Id, Limit: 2})).To(BeEmpty())
+					})
+
+					It("should walk to newer items with MinId", func() {
+						oldest := getItems(database.PageQuery{Limit: 100})[4]
+						newer := getItems(database.PageQuery{MinId: oldest.Id, Limit: 2})
+						Expect(contents(newer)).To(Equal([]string{"2", "1"}))
+						newer = getItems(database.PageQuery{MinId: newer[0].Id, Limit: 2})
+						Expect(contents(newer)).To(Equal([]string{"4", "3"}))
+						Expect(getItems(database.PageQuery{MinId: newer[0].Id, Limit: 2})).To(BeEmpty())
+					})
+
+					It("should return the newest items after SinceId", func() {
+						oldest := getItems(database.PageQuery{Limit: 100})[4]
+						items := getItems(database.PageQuery{SinceId: oldest.Id, Limit: 2})
+						Expect(contents(items)).To(Equal([]string{"4", "3"}))
+					})
+
+					It("should combine MaxId with SinceId", func() {
+						all := getItems(database.PageQuery{Limit: 100})
+						items := getItems(database.PageQuery{MaxId: all[0].Id, SinceId: all[3].Id, Limit: 100})
+						Expect(contents(items)).To(Equal([]string{"3", "2"}))
+					})
+
+					It("should not shift pages when new items arrive", func() {
+						first := getItems(database.PageQuery{Limit: 2})
+
+						_, err := db.CreateInboxItem(ctx, newCreate("5"), "alice")
+						Expect(err).ToNot(HaveOccurred())
+						_, err = db.CreateOutboxItem(ctx, newCreate("5"), "alice", baseUrl)
+						Expect(err).ToNot(HaveOccurred())
+
+						second := getItems(database.PageQuery{MaxId: first[1].Id, Limit: 2})
+						Expect(contents(second)).To(Equal([]string{"2", "1"}))
+						newer := getItems(database.PageQuery{MinId: first[0].Id, Limit: 2})
+						Expect(contents(newer)).To(Equal([]string{"5"}))
+					})
+
+					It("should reject malformed ids", func() {
+						_, err := box.getItems(db, database.PageQuery{MaxId: "not an id", Limit: 2})
+						Expect(err).To(MatchError(database.ErrInvalidId))
+					})
+				})
+			}
+		})
+
+		Describe("roles", func() {
+			It("should start empty and be replaced when set", func() {
+				Expect(db.GetRoles(ctx, "alice")).To(BeEmpty())
+
+				Expect(db.SetRoles(ctx, "alice", []string{"admin"})).To(Succeed())
+				Expect(db.GetRoles(ctx, "alice")).To(Equal([]string{"admin"}))
+
+				Expect(db.SetRoles(ctx, "alice", nil)).To(Succeed())
+				Expect(db.GetRoles(ctx, "alice")).To(BeEmpty())
+			})
+
+			It("should be restored on rollback", func() {
+				tx, err := db.Begin(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.SetRoles(ctx, "alice", []string{"admin"})).To(Succeed())
+				Expect(tx.Rollback()).To(Succeed())
+
+				Expect(db.GetRoles(ctx, "alice")).To(BeEmpty())
+			})
+		})
+
+		Describe("two-factor authentication", func() {
+			twoFactor := database.TwoFactor{
+				Username:      "alice",
+				Secret:        "SECRET",
+				Enabled:       true,
+				LastStep:      42,
+				RecoveryCodes: []string{"first", "second"},
+			}
+
+			It("should be reported missing until the user enrolls", func() {
+				_, err := db.GetTwoFactor(ctx, "alice")
+				Expect(err).To(MatchError(database.ErrNotFound))
+				Expect(db.DeleteTwoFactor(ctx, "alice")).To(MatchError(database.ErrNotFound))
+			})
+
+			It("should be replaced when set, until deleted", func() {
+				Expect(db.SetTwoFactor(ctx, database.TwoFactor{Username: "alice", Secret: "OLD"})).To(Succeed())
+				Expect(db.SetTwoFactor(ctx, twoFactor)).To(Succeed())
+
+				retrieved, err := db.GetTwoFactor(ctx, "alice")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(*retrieved).To(Equal(twoFactor))
+
+				Expect(db.DeleteTwoFactor(ctx, "alice")).To(Succeed())
+				_, err = db.GetTwoFactor(ctx, "alice")
+				Expect(err).To(MatchError(database.ErrNotFound))
+			})
+
+			It("should not share recovery codes with callers", func() {
+				Expect(db.SetTwoFactor(ctx, twoFactor)).To(Succeed())
+
+				retrieved, err := db.GetTwoFactor(ctx, "alice")
+				Expect(err).ToNot(HaveOccurred())
+				retrieved.RecoveryCodes[0] = "changed"
+
+				retrieved, err = db.GetTwoFactor(ctx, "alice")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(retrieved.RecoveryCodes).To(Equal([]string{"first", "second"}))
+			})
+
+			Describe("login challenges", func() {
+				challenge := database.LoginChallenge{
+					Hash:      "challenge",
+					Username:  "alice",
+					UserAgent: "test",
+					Expires:   time.Now().Add(time.Minute).Truncate(time.Second),
+				}
+
+				It("should be retrievable by hash, updatable and deletable", func() {
+					Expect(db.CreateLoginChallenge(ctx, challenge)).To(Succeed())
+					Expect(db.CreateLoginChallenge(ctx, challenge)).ToNot(Succeed())
+
+					updated := challenge
+					updated.Attempts = 1
+					Expect(db.UpdateLoginChallenge(ctx, updated)).To(Succeed())
+
+					retrieved, err := db.GetLoginChallenge(ctx, "challenge")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(*retrieved).To(Equal(updated))
+
+					Expect(db.DeleteLoginChallenge(ctx, "challenge")).To(Succeed())
+					_, err = db.GetLoginChallenge(ctx, "challenge")
+					Expect(err).To(MatchError(database.ErrNotFound))
+					Expect(db.UpdateLoginChallenge(ctx, updated)).To(MatchError(database.ErrNotFound))
+					Expect(db.DeleteLoginChallenge(ctx, "challenge")).To(MatchError(database.ErrNotFound))
+				})
+			})
+		})
+
+		Describe("passwords", func() {
+			It("should be replaced when set", func() {
+				Expect(db.SetPassword(ctx, "alice", "new password")).To(Succeed())
+				Expect(db.CheckPassword(ctx, "alice", "password")).ToNot(Succeed())
+				Expect(db.CheckPassword(ctx, "alice", "new password")).To(Succeed())
+				Expect(db.SetPassword(ctx, "nobody", "password")).To(MatchError(database.ErrUserNotFound))
+			})
+		})
+
+		Describe("email addresses", func() {
+			It("should be reported missing until set", func() {
+				_, err := db.GetEmail(ctx, "alice")
+				Expect(err).To(MatchError(database.ErrNotFound))
+				_, err = db.GetEmail(ctx, "nobody")
+				Expect(err).To(MatchError(database.ErrUserNotFound))
+			})
+
+			It("should be replaced when set, and find their user", func() {
+				Expect(db.SetEmail(ctx, "alice", database.Email{Address: "alice@example.org"})).To(Succeed())
+				Expect(db.SetEmail(ctx, "alice", database.Email{Address: "alice@example.com", Verified: true})).To(Succeed())
+
+				Expect(db.GetEmail(ctx, "alice")).To(Equal(&database.Email{Address: "alice@example.com", Verified: true}))
+				Expect(db.GetUsernameByEmail(ctx, "ALICE@example.com")).To(Equal("alice"))
+				_, err := db.GetUsernameByEmail(ctx, "alice@example.org")
+				Expect(err).To(MatchError(database.ErrNotFound))
+			})
+
+			It("should not be shared between users", func() {
+				_, err := db.CreateUser(ctx, newActor("Bob"), "bob", "password", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(db.SetEmail(ctx, "alice", database.Email{Address: "alice@example.org"})).To(Succeed())
+				Expect(db.SetEmail(ctx, "bob", database.Email{Address: "Alice@example.org"})).To(MatchError(database.ErrEmailExists))
+				Expect(db.SetEmail(ctx, "alice", database.Email{Address: "alice@example.org", Verified: true})).To(Succeed())
+			})
+
+			Describe("account tokens", func() {
+				token := database.AccountToken{
+					Hash:     "token",
+					Username: "alice",
+					Purpose:  database.PurposePasswordReset,
+					Email:    "alice@example.org",
+					Expires:  time.Now().Add(time.Hour).Truncate(time.Second),
+				}
+
+				It("should be retrievable by hash until deleted", func() {
+					Expect(db.CreateAccountToken(ctx, token)).To(Succeed())
+					Expect(db.CreateAccountToken(ctx, token)).ToNot(Succeed())
+
+					Expect(db.GetAccountToken(ctx, "token")).To(Equal(&token))
+
+					Expect(db.DeleteAccountToken(ctx, "token")).To(Succeed())
+					_, err := db.GetAccountToken(ctx, "token")
+					Expect(err).To(MatchError(database.ErrNotFound))
+					Expect(db.DeleteAccountToken(ctx, "token")).To(MatchError(database.ErrNotFound))
+				})
+
+				It("should be deleted per user and purpose", func() {
+					verification := token
+					verification.Hash = "verification"
+					verification.Purpose = database.PurposeEmailVerification
+					other := token
+					other.Hash = "other"
+					Expect(db.CreateAccountToken(ctx, token)).To(Succeed())
+					Expect(db.CreateAccountToken(ctx, other)).To(Succeed())
+					Expect(db.CreateAccountToken(ctx, verification)).To(Succeed())
+
+					Expect(db.DeleteAccountTokens(ctx, "alice", database.PurposePasswordReset)).To(Succeed())
+					_, err := db.GetAccountToken(ctx, "token")
+					Expect(err).To(MatchError(database.ErrNotFound))
+					_, err = db.GetAccountToken(ctx, "other")
+					Expect(err).To(MatchError(database.ErrNotFound))
+					Expect(db.GetAccountToken(ctx, "verification")).To(Equal(&verification))
+				})
+
+				It("should refuse tokens for missing users", func() {
+					missing := token
+					missing.Username = "nobody"
+					Expect(db.CreateAccountToken(ctx, missing)).To(MatchError(database.ErrUserNotFound))
+				})
+
+				It("should be restored on rollback", func() {
+					Expect(db.CreateAccountToken(ctx, token)).To(Succeed())
+
+					tx, err := db.Begin(ctx)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(tx.DeleteAccountTokens(ctx, "alice", database.PurposePasswordReset)).To(Succeed())
+					Expect(tx.Rollback()).To(Succeed())
+
+					Expect(db.GetAccountToken(ctx, "token")).To(Equal(&token))
+				})
+			})
+		})
+
+		Describe("side blogs", func() {
+			It("should be created for an account, listed and traced back to it", func() {
+				blog, err := db.CreateBlog(ctx, newActor("Alice's Art"), "alice-art", "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(activitystreams.ToObject(blog).Id).To(Equal(baseUrl.String() + "/alice-art"))
+				_, err = db.CreateBlog(ctx, newActor("Alice's Photos"), "alice-photos", "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(db.GetUser(ctx, "alice-art")).To(Equal(blog))
+				Expect(db.GetBlogs(ctx, "alice")).To(Equal([]string{"alice-art", "alice-photos"}))
+				Expect(db.GetBlogs(ctx, "alice-art")).To(BeEmpty())
+				Expect(db.GetAccount(ctx, "alice-art")).To(Equal("alice"))
+				Expect(db.GetAccount(ctx, "alice")).To(Equal("alice"))
+				_, err = db.GetAccount(ctx, "nobody")
+				Expect(err).To(MatchError(database.ErrUserNotFound))
+			})
+
+			It("should refuse names already taken and owners which are not accounts", func() {
+				_, err := db.CreateBlog(ctx, newActor("Alice"), "alice", "alice", baseUrl)
+				Expect(err).To(MatchError(database.ErrUserExists))
+				_, err = db.CreateBlog(ctx, newActor("Art"), "art", "nobody", baseUrl)
+				Expect(err).To(MatchError(database.ErrUserNotFound))
+
+				_, err = db.CreateBlog(ctx, newActor("Art"), "art", "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = db.CreateBlog(ctx, newActor("More Art"), "more-art", "art", baseUrl)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should not be logged into or listed as registrations", func() {
+				_, err := db.CreateBlog(ctx, newActor("Art"), "art", "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(db.CheckPassword(ctx, "art", "")).ToNot(Succeed())
+				registrations, err := db.GetRegistrations(ctx, database.AccountActive)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(registrations).To(HaveLen(1))
+			})
+
+			It("should be removed on rollback", func() {
+				tx, err := db.Begin(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = tx.CreateBlog(ctx, newActor("Art"), "art", "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.Rollback()).To(Succeed())
+
+				Expect(db.GetBlogs(ctx, "alice")).To(BeEmpty())
+			})
+		})
+
+		Describe("groups", func() {
+			BeforeEach(func() {
+				_, err := db.CreateUser(ctx, newActor("Bob"), "bob", "password", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = db.CreateGroup(ctx, newActor("Book Club"), "book-club", "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should be created with their creator as owner", func() {
+				Expect(db.GetUser(ctx, "book-club")).ToNot(BeNil())
+				members, err := db.GetGroupMembers(ctx, "book-club")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(members).To(HaveLen(1))
+				Expect(members[0].Username).To(Equal("alice"))
+				Expect(members[0].Role).To(Equal(database.GroupOwner))
+
+				_, err = db.GetAccount(ctx, "book-club")
+				Expect(err).To(MatchError(database.ErrNotFound))
+			})
+
+			It("should add, update and remove members", func() {
+				Expect(db.SetGroupMember(ctx, database.GroupMember{Group: "book-club", Username: "bob", Role: database.GroupPoster})).To(Succeed())
+				Expect(db.SetGroupMember(ctx, database.GroupMember{Group: "book-club", Username: "bob", Role: database.GroupAdmin})).To(Succeed())
+
+				member, err := db.GetGroupMember(ctx, "book-club", "bob")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(member.Role).To(Equal(database.GroupAdmin))
+				members, err := db.GetGroupMembers(ctx, "book-club")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(members).To(HaveLen(2))
+				Expect(members[1].Username).To(Equal("bob"))
+
+				groups, err := db.GetGroups(ctx, "bob")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(groups).To(HaveLen(1))
+				Expect(groups[0].Group).To(Equal("book-club"))
+
+				Expect(db.RemoveGroupMember(ctx, "book-club", "bob")).To(Succeed())
+				_, err = db.GetGroupMember(ctx, "book-club", "bob")
+				Expect(err).To(MatchError(database.ErrNotFound))
+				Expect(db.RemoveGroupMember(ctx, "book-club", "bob")).To(MatchError(database.ErrNotFound))
+				Expect(db.GetGroups(ctx, "bob")).To(BeEmpty())
+			})
+
+			It("should admit only accounts as members", func() {
+				Expect(db.SetGroupMember(ctx, database.GroupMember{Group: "book-club", Username: "nobody", Role: database.GroupPoster})).To(MatchError(database.ErrUserNotFound))
+				_, err := db.CreateBlog(ctx, newActor("Art"), "art", "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(db.SetGroupMember(ctx, database.GroupMember{Group: "book-club", Username: "art", Role: database.GroupPoster})).ToNot(Succeed())
+				Expect(db.SetGroupMember(ctx, database.GroupMember{Group: "book-club", Username: "book-club", Role: database.GroupPoster})).ToNot(Succeed())
+			})
+
+			It("should refuse actors which are not groups", func() {
+				_, err := db.GetGroupMembers(ctx, "alice")
+				Expect(err).To(MatchError(database.ErrNotGroup))
+				_, err = db.GetGroupMember(ctx, "nobody", "alice")
+				Expect(err).To(MatchError(database.ErrUserNotFound))
+				Expect(db.SetGroupMember(ctx, database.GroupMember{Group: "bob", Username: "alice", Role: database.GroupPoster})).To(MatchError(database.ErrNotGroup))
+			})
+
+			It("should not be logged into or listed as registrations", func() {
+				Expect(db.CheckPassword(ctx, "book-club", "")).ToNot(Succeed())
+				registrations, err := db.GetRegistrations(ctx, database.AccountActive)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(registrations).To(HaveLen(2))
+			})
+
+			It("should restore members on rollback", func() {
+				tx, err := db.Begin(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.SetGroupMember(ctx, database.GroupMember{Group: "book-club", Username: "bob", Role: database.GroupPoster})).To(Succeed())
+				Expect(tx.RemoveGroupMember(ctx, "book-club", "alice")).To(Succeed())
+				Expect(tx.Rollback()).To(Succeed())
+
+				members, err := db.GetGroupMembers(ctx, "book-club")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(members).To(HaveLen(1))
+				Expect(members[0].Username).To(Equal("alice"))
+			})
+		})
+
+		Describe("appearance", func() {
+			It("should default to the zero appearance", func() {
+				Expect(db.GetAppearance(ctx, "alice")).To(Equal(&database.Appearance{}))
+			})
+
+			It("should be set per actor", func() {
+				appearance := database.Appearance{Theme: "dark", CSS: "body { color: red; }"}
+				Expect(db.SetAppearance(ctx, "alice", appearance)).To(Succeed())
+				Expect(db.GetAppearance(ctx, "alice")).To(Equal(&appearance))
+
+				_, err := db.GetAppearance(ctx, "nobody")
+				Expect(err).To(MatchError(database.ErrUserNotFound))
+				Expect(db.SetAppearance(ctx, "nobody", appearance)).To(MatchError(database.ErrUserNotFound))
+			})
+
+			It("should be restored on rollback", func() {
+				tx, err := db.Begin(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.SetAppearance(ctx, "alice", database.Appearance{Theme: "dark"})).To(Succeed())
+				Expect(tx.Rollback()).To(Succeed())
+
+				Expect(db.GetAppearance(ctx, "alice")).To(Equal(&database.Appearance{}))
+			})
+		})
+
+		Describe("registrations", func() {
+			It("should report users created without one as active", func() {
+				Expect(db.GetRegistration(ctx, "alice")).To(Equal(&database.Registration{
+					Username: "alice",
+					Status:   database.AccountActive,
+				}))
+				_, err := db.GetRegistration(ctx, "nobody")
+				Expect(err).To(MatchError(database.ErrUserNotFound))
+			})
+
+			It("should be replaced when set, and listed by status oldest first", func() {
+				_, err := db.CreateUser(ctx, newActor("Bob"), "bob", "password", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = db.CreateUser(ctx, newActor("Carol"), "carol", "password", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+
+				now := time.Now().Truncate(time.Second)
+				carol := database.Registration{Username: "carol", Status: database.AccountPending, Reason: "hi", Created: now}
+				bob := database.Registration{Username: "bob", Status: database.AccountPending, Invite: "invite", Created: now.Add(time.Second)}
+				Expect(db.SetRegistration(ctx, bob)).To(Succeed())
+				Expect(db.SetRegistration(ctx, carol)).To(Succeed())
+				Expect(db.GetRegistration(ctx, "bob")).To(Equal(&bob))
+
+				Expect(db.GetRegistrations(ctx, database.AccountPending)).To(Equal([]*database.Registration{&carol, &bob}))
+				Expect(db.GetRegistrations(ctx, database.AccountActive)).To(HaveLen(1))
+
+				carol.Status = database.AccountRejected
+				Expect(db.SetRegistration(ctx, carol)).To(Succeed())
+				Expect(db.GetRegistrations(ctx, database.AccountPending)).To(Equal([]*database.Registration{&bob}))
+			})
+
+			It("should be restored on rollback", func() {
+				tx, err := db.Begin(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.SetRegistration(ctx, database.Registration{Username: "alice", Status: database.AccountPending})).To(Succeed())
+				Expect(tx.Rollback()).To(Succeed())
+
+				registration, err := db.GetRegistration(ctx, "alice")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(registration.Status).To(Equal(database.AccountActive))
+			})
+		})
+
+		Describe("invites", func() {
+			invite := database.Invite{
+				Hash:      "invite",
+				CreatedBy: "alice",
+				Created:   time.Now().Truncate(time.Second),
+				Expires:   time.Now().Add(time.Hour).Truncate(time.Second),
+				MaxUses:   2,
+			}
+
+			It("should be retrievable by hash, updatable and deletable", func() {
+				Expect(db.CreateInvite(ctx, invite)).To(Succeed())
+				Expect(db.CreateInvite(ctx, invite)).ToNot(Succeed())
+
+				used := invite
+				used.Uses = 1
+				Expect(db.UpdateInvite(ctx, used)).To(Succeed())
+				Expect(db.GetInvite(ctx, "invite")).To(Equal(&used))
+
+				Expect(db.DeleteInvite(ctx, "invite")).To(Succeed())
+				_, err := db.GetInvite(ctx, "invite")
+				Expect(err).To(MatchError(database.ErrNotFound))
+				Expect(db.UpdateInvite(ctx, used)).To(MatchError(database.ErrNotFound))
+				Expect(db.DeleteInvite(ctx, "invite")).To(MatchError(database.ErrNotFound))
+			})
+
+			It("should be listed oldest first", func() {
+				newer := invite
+				newer.Hash = "newer"
+				newer.Created = invite.Created.Add(time.Second)
+				Expect(db.CreateInvite(ctx, newer)).To(Succeed())
+				Expect(db.CreateInvite(ctx, invite)).To(Succeed())
+
+				Expect(db.GetInvites(ctx)).To(Equal([]*database.Invite{&invite, &newer}))
+			})
+		})
+
+		Describe("personal access tokens", func() {
+			token := database.PersonalAccessToken{
+				Id:       "id",
+				Hash:     "token",
+				Username: "alice",
+				Name:     "backup script",
+				Scope:    "read",
+				Created:  time.Now().Truncate(time.Second),
+			}
+
+			It("should be retrievable by hash, updatable and deletable by id", func() {
+				Expect(db.CreatePersonalAccessToken(ctx, token)).To(Succeed())
+				Expect(db.CreatePersonalAccessToken(ctx, token)).ToNot(Succeed())
+
+				used := token
+				used.LastUsed = token.Created.Add(time.Minute)
+				Expect(db.UpdatePersonalAccessToken(ctx, used)).To(Succeed())
+				Expect(db.GetPersonalAccessToken(ctx, "token")).To(Equal(&used))
+
+				Expect(db.DeletePersonalAccessToken(ctx, "bob", "id")).To(MatchError(database.ErrNotFound))
+				Expect(db.DeletePersonalAccessToken(ctx, "alice", "id")).To(Succeed())
+				_, err := db.GetPersonalAccessToken(ctx, "token")
+				Expect(err).To(MatchError(database.ErrNotFound))
+				Expect(db.UpdatePersonalAccessToken(ctx, used)).To(MatchError(database.ErrNotFound))
+				Expect(db.DeletePersonalAccessToken(ctx, "alice", "id")).To(MatchError(database.ErrNotFound))
+			})
+
+			It("should be listed per user, oldest first", func() {
+				_, err := db.CreateUser(ctx, newActor("Bob"), "bob", "password", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+
+				newer := token
+				newer.Id = "newer"
+				newer.Hash = "newer"
+				newer.Created = token.Created.Add(time.Second)
+				bobs := token
+				bobs.Id = "bobs"
+				bobs.Hash = "bobs"
+				bobs.Username = "bob"
+				Expect(db.CreatePersonalAccessToken(ctx, newer)).To(Succeed())
+				Expect(db.CreatePersonalAccessToken(ctx, token)).To(Succeed())
+				Expect(db.CreatePersonalAccessToken(ctx, bobs)).To(Succeed())
+
+				Expect(db.GetPersonalAccessTokens(ctx, "alice")).To(Equal([]*database.PersonalAccessToken{&token, &newer}))
+				_, err = db.GetPersonalAccessTokens(ctx, "nobody")
+				Expect(err).To(MatchError(database.ErrUserNotFound))
+			})
+
+			It("should refuse tokens for missing users", func() {
+				missing := token
+				missing.Username = "nobody"
+				Expect(db.CreatePersonalAccessToken(ctx, missing)).To(MatchError(database.ErrUserNotFound))
+			})
+
+			It("should be restored on rollback", func() {
+				Expect(db.CreatePersonalAccessToken(ctx, token)).To(Succeed())
+
+				tx, err := db.Begin(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.DeletePersonalAccessToken(ctx, "alice", "id")).To(Succeed())
+				Expect(tx.Rollback()).To(Succeed())
+
+				Expect(db.GetPersonalAccessToken(ctx, "token")).To(Equal(&token))
+			})
+		})
+
+		Describe("transactions", func() {
+			It("should apply writes on commit", func() {
+				tx, err := db.Begin(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = tx.CreateObject(ctx, newNote("hi"), "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = tx.CreateOutboxItem(ctx, newCreate("hi"), "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.Commit()).To(Succeed())
+
+				Expect(db.GetOutboxCount(ctx, "alice")).To(Equal(1))
+				_, err = db.GetObject(ctx, "alice", "note", "0")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should discard writes on rollback", func() {
+				tx, err := db.Begin(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = tx.CreateUser(ctx, newActor("Bob"), "bob", "password", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = tx.CreateObject(ctx, newNote("hi"), "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = tx.CreateOutboxItem(ctx, newCreate("hi"), "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = tx.CreateDeliveryJob(ctx, newCreate("hi"), "http://example.org/pubblr/bob")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.Rollback()).To(Succeed())
+
+				_, err = db.GetUser(ctx, "bob")
+				Expect(err).To(MatchError(database.ErrUserNotFound))
+				Expect(db.GetOutboxCount(ctx, "alice")).To(Equal(0))
+				_, err = db.GetObject(ctx, "alice", "note", "0")
+				Expect(err).To(MatchError(database.ErrNotFound))
+				Expect(db.GetDeliveryJobs(ctx, time.Now(), 10)).To(BeEmpty())
+			})
+
+			It("should make writes visible within the transaction", func() {
+				tx, err := db.Begin(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				defer tx.Rollback()
+
+				_, err = tx.CreateOutboxItem(ctx, newCreate("hi"), "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.GetOutboxCount(ctx, "alice")).To(Equal(1))
+			})
+
+			It("should not be usable once finished", func() {
+				tx, err := db.Begin(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.Commit()).To(Succeed())
+				Expect(tx.Rollback()).To(Succeed())
+
+				_, err = tx.GetUser(ctx, "alice")
+				Expect(err).To(MatchError(database.ErrTxDone))
+				Expect(tx.Commit()).To(MatchError(database.ErrTxDone))
+			})
+
+			It("should roll back if its context is cancelled before commit", func() {
+				txCtx, cancel := context.WithCancel(ctx)
+				tx, err := db.Begin(txCtx)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = tx.CreateOutboxItem(txCtx, newCreate("hi"), "alice", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+
+				cancel()
+				Expect(tx.Commit()).To(MatchError(context.Canceled))
+				Expect(db.GetOutboxCount(ctx, "alice")).To(Equal(0))
+			})
+		})
+
+		Describe("delivery jobs", func() {
+			It("should be returned oldest first until deleted", func() {
+				for i := 0; i < 3; i++ {
+					_, err := db.CreateDeliveryJob(ctx, newCreate(strconv.Itoa(i)), fmt.Sprintf("http://example.org/%d", i))
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				jobs, err := db.GetDeliveryJobs(ctx, time.Now(), 2)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(jobs).To(HaveLen(2))
+				Expect(jobs[0].Recipient).To(Equal("http://example.org/0"))
+				Expect(contentOf(jobs[0].Activity)).To(Equal("0"))
+				Expect(jobs[1].Recipient).To(Equal("http://example.org/1"))
+
+				Expect(db.DeleteDeliveryJob(ctx, jobs[0].Id)).To(Succeed())
+				Expect(db.DeleteDeliveryJob(ctx, jobs[0].Id)).To(MatchError(database.ErrNotFound))
+
+				jobs, err = db.GetDeliveryJobs(ctx, time.Now(), 10)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(jobs).To(HaveLen(2))
+				Expect(jobs[0].Recipient).To(Equal("http://example.org/1"))
+			})
+
+			It("should hold back jobs until they are due, and dead jobs for good", func() {
+				now := time.Now()
+				for i := 0; i < 3; i++ {
+					_, err := db.CreateDeliveryJob(ctx, newCreate(strconv.Itoa(i)), fmt.Sprintf("http://example.org/%d", i))
+					Expect(err).ToNot(HaveOccurred())
+				}
+				jobs, err := db.GetDeliveryJobs(ctx, now, 10)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(jobs).To(HaveLen(3))
+
+				retry := *jobs[0]
+				retry.Attempts = 1
+				retry.NextAttempt = now.Add(time.Minute)
+				retry.LastError = "connection refused"
+				Expect(db.UpdateDeliveryJob(ctx, retry)).To(Succeed())
+				dead := *jobs[1]
+				dead.Attempts = 10
+				dead.Dead = true
+				Expect(db.UpdateDeliveryJob(ctx, dead)).To(Succeed())
+
+				jobs, err = db.GetDeliveryJobs(ctx, now, 10)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(jobs).To(HaveLen(1))
+				Expect(jobs[0].Recipient).To(Equal("http://example.org/2"))
+
+				jobs, err = db.GetDeliveryJobs(ctx, now.Add(time.Minute), 10)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(jobs).To(HaveLen(2))
+				Expect(jobs[0].Recipient).To(Equal("http://example.org/0"))
+				Expect(jobs[0].Attempts).To(Equal(1))
+				Expect(jobs[0].NextAttempt).To(BeTemporally("==", retry.NextAttempt))
+				Expect(jobs[0].LastError).To(Equal("connection refused"))
+				Expect(contentOf(jobs[0].Activity)).To(Equal("0"))
+			})
+
+			It("should not update jobs which do not exist", func() {
+				Expect(db.UpdateDeliveryJob(ctx, database.DeliveryJob{Id: "0"})).To(MatchError(database.ErrNotFound))
+			})
+		})
+
+		Describe("sessions", func() {
+			newSession := func(id string, created time.Time) database.Session {
+				return database.Session{
+					Id:        id,
+					Username:  "alice",
+					UserAgent: "test",
+					Created:   created,
+					LastUsed:  created,
+				}
+			}
+			now := time.Now().Truncate(time.Second)
+
+			It("should be retrievable by id", func() {
+				Expect(db.CreateSession(ctx, newSession("s1", now))).To(Succeed())
+
+				session, err := db.GetSession(ctx, "s1")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(*session).To(Equal(newSession("s1", now)))
+
+				_, err = db.GetSession(ctx, "s2")
+				Expect(err).To(MatchError(database.ErrNotFound))
+			})
+
+			It("should refuse duplicate ids", func() {
+				Expect(db.CreateSession(ctx, newSession("s1", now))).To(Succeed())
+				Expect(db.CreateSession(ctx, newSession("s1", now))).ToNot(Succeed())
+			})
+
+			It("should be listed per user, oldest first", func() {
+				_, err := db.CreateUser(ctx, newActor("Bob"), "bob", "password", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(db.CreateSession(ctx, newSession("s1", now.Add(time.Minute)))).To(Succeed())
+				Expect(db.CreateSession(ctx, newSession("s2", now))).To(Succeed())
+				bobs := newSession("s3", now)
+				bobs.Username = "bob"
+				Expect(db.CreateSession(ctx, bobs)).To(Succeed())
+
+				sessions, err := db.GetSessions(ctx, "alice")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sessions).To(HaveLen(2))
+				Expect(sessions[0].Id).To(Equal("s2"))
+				Expect(sessions[1].Id).To(Equal("s1"))
+			})
+
+			It("should be updated", func() {
+				Expect(db.CreateSession(ctx, newSession("s1", now))).To(Succeed())
+
+				session := newSession("s1", now)
+				session.LastUsed = now.Add(time.Hour)
+				Expect(db.UpdateSession(ctx, session)).To(Succeed())
+
+				updated, err := db.GetSession(ctx, "s1")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(updated.LastUsed).To(Equal(now.Add(time.Hour)))
+
+				Expect(db.UpdateSession(ctx, newSession("s2", now))).To(MatchError(database.ErrNotFound))
+			})
+
+			It("should be deleted", func() {
+				Expect(db.CreateSession(ctx, newSession("s1", now))).To(Succeed())
+				Expect(db.DeleteSession(ctx, "s1")).To(Succeed())
+
+				_, err := db.GetSession(ctx, "s1")
+				Expect(err).To(MatchError(database.ErrNotFound))
+				Expect(db.DeleteSession(ctx, "s1")).To(MatchError(database.ErrNotFound))
+			})
+		})
+
+		Describe("OAuth clients", func() {
+			client := database.OAuthClient{
+				Id:           "client",
+				Name:         "App",
+				RedirectUris: []string{"https://app.example.com/callback"},
+				Scope:        "read",
+				Created:      time.Now().Truncate(time.Second),
+			}
+
+			It("should be retrievable by id", func() {
+				Expect(db.CreateOAuthClient(ctx, client)).To(Succeed())
+
+				retrieved, err := db.GetOAuthClient(ctx, "client")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(*retrieved).To(Equal(client))
+
+				_, err = db.GetOAuthClient(ctx, "other")
+				Expect(err).To(MatchError(database.ErrNotFound))
+			})
+
+			It("should refuse duplicate ids", func() {
+				Expect(db.CreateOAuthClient(ctx, client)).To(Succeed())
+				Expect(db.CreateOAuthClient(ctx, client)).ToNot(Succeed())
+			})
+
+			Describe("authorization codes", func() {
+				code := database.AuthorizationCode{
+					Hash:                "code",
+					ClientId:            "client",
+					Username:            "alice",
+					RedirectUri:         "https://app.example.com/callback",
+					Scope:               "read",
+					CodeChallenge:       "challenge",
+					CodeChallengeMethod: "S256",
+					Expires:             time.Now().Add(time.Minute).Truncate(time.Second),
+				}
+
+				BeforeEach(func() {
+					Expect(db.CreateOAuthClient(ctx, client)).To(Succeed())
+				})
+
+				It("should be retrievable by hash until deleted", func() {
+					Expect(db.CreateAuthorizationCode(ctx, code)).To(Succeed())
+
+					retrieved, err := db.GetAuthorizationCode(ctx, "code")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(*retrieved).To(Equal(code))
+
+					Expect(db.DeleteAuthorizationCode(ctx, "code")).To(Succeed())
+					_, err = db.GetAuthorizationCode(ctx, "code")
+					Expect(err).To(MatchError(database.ErrNotFound))
+					Expect(db.DeleteAuthorizationCode(ctx, "code")).To(MatchError(database.ErrNotFound))
+				})
+
+				It("should refuse codes for missing users or clients", func() {
+					missingUser := code
+					missingUser.Username = "nobody"
+					Expect(db.CreateAuthorizationCode(ctx, missingUser)).To(MatchError(database.ErrUserNotFound))
+
+					missingClient := code
+					missingClient.ClientId = "other"
+					Expect(db.CreateAuthorizationCode(ctx, missingClient)).To(MatchError(database.ErrNotFound))
+				})
+			})
+		})
+
+		Describe("refresh tokens", func() {
+			newToken := func(hash, session string) database.RefreshToken {
+				return database.RefreshToken{
+					Hash:     hash,
+					Username: "alice",
+					Session:  session,
+					Expires:  time.Now().Add(time.Hour).Truncate(time.Second),
+				}
+			}
+
+			BeforeEach(func() {
+				for _, id := range []string{"s1", "s2"} {
+					Expect(db.CreateSession(ctx, database.Session{Id: id, Username: "alice"})).To(Succeed())
+				}
+			})
+
+			It("should be retrievable by hash", func() {
+				Expect(db.CreateRefreshToken(ctx, newToken("a", "s1"))).To(Succeed())
+
+				token, err := db.GetRefreshToken(ctx, "a")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(*token).To(Equal(newToken("a", "s1")))
+
+				_, err = db.GetRefreshToken(ctx, "b")
+				Expect(err).To(MatchError(database.ErrNotFound))
+			})
+
+			It("should refuse tokens outside the user's sessions or with duplicate hashes", func() {
+				Expect(db.CreateRefreshToken(ctx, newToken("a", "s3"))).To(MatchError(database.ErrNotFound))
+
+				_, err := db.CreateUser(ctx, newActor("Bob"), "bob", "password", baseUrl)
+				Expect(err).ToNot(HaveOccurred())
+				token := newToken("a", "s1")
+				token.Username = "bob"
+				Expect(db.CreateRefreshToken(ctx, token)).To(MatchError(database.ErrNotFound))
+
+				Expect(db.CreateRefreshToken(ctx, newToken("a", "s1"))).To(Succeed())
+				Expect(db.CreateRefreshToken(ctx, newToken("a", "s2"))).ToNot(Succeed())
+			})
+
+			It("should be marked used", func() {
+				Expect(db.CreateRefreshToken(ctx, newToken("a", "s1"))).To(Succeed())
+				Expect(db.UseRefreshToken(ctx, "a")).To(Succeed())
+
+				token, err := db.GetRefreshToken(ctx, "a")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(token.Used).To(BeTrue())
+
+				Expect(db.UseRefreshToken(ctx, "b")).To(MatchError(database.ErrNotFound))
+			})
+
+			It("should be deleted with their session", func() {
+				Expect(db.CreateRefreshToken(ctx, newToken("a", "s1"))).To(Succeed())
+				Expect(db.CreateRefreshToken(ctx, newToken("b", "s1"))).To(Succeed())
+				Expect(db.CreateRefreshToken(ctx, newToken("c", "s2"))).To(Succeed())
+
+				Expect(db.DeleteSession(ctx, "s1")).To(Succeed())
+
+				_, err := db.GetRefreshToken(ctx, "a")
+				Expect(err).To(MatchError(database.ErrNotFound))
+				_, err = db.GetRefreshToken(ctx, "b")
+				Expect(err).To(MatchError(database.ErrNotFound))
+				_, err = db.GetRefreshToken(ctx, "c")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should be restored on rollback", func() {
+				Expect(db.CreateRefreshToken(ctx, newToken("a", "s1"))).To(Succeed())
+
+				tx, err := db.Begin(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.UseRefreshToken(ctx, "a")).To(Succeed())
+				Expect(tx.CreateRefreshToken(ctx, newToken("b", "s1"))).To(Succeed())
+				Expect(tx.DeleteSession(ctx, "s1")).To(Succeed())
+				Expect(tx.Rollback()).To(Succeed())
+
+				token, err := db.GetRefreshToken(ctx, "a")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(token.Used).To(BeFalse())
+				_, err = db.GetRefreshToken(ctx, "b")
+				Expect(err).To(MatchError(database.ErrNotFound))
+				_, err = db.GetSession(ctx, "s1")
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Describe("cancellation", func() {
+			It("should fail operations whose context is already done", func() {
+				cancelled, cancel := context.WithCancel(ctx)
+				cancel()
+
+				_, err := db.GetUser(cancelled, "alice")
+				Expect(err).To(MatchError(context.Canceled))
+				_, err = db.Begin(cancelled)
+				Expect(err).To(MatchError(context.Canceled))
+			})
+
+			It("should stop waiting when the deadline passes", func() {
+				tx, err := db.Begin(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				defer tx.Rollback()
+
+				deadline, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+				defer cancel()
+				_, err = db.GetUser(deadline, "alice")
+				Expect(err).To(MatchError(context.DeadlineExceeded))
+			})
+		})
+
+		Describe("concurrency", func() {
+			It("should not lose concurrent writes", func() {
+				const writers = 20
+				const writesPerWriter = 10
+
+				var wg sync.WaitGroup
+				for i := 0; i < writers; i++ {
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						defer wg.Done()
+						for j := 0; j < writesPerWriter; j++ {
+							_, err := db.CreateInboxItem(ctx, newCreate("in"), "alice")
+							Expect(err).ToNot(HaveOccurred())
+							_, err = db.CreateOutboxItem(ctx, newCreate("out"), "alice", baseUrl)
+							Expect(err).ToNot(HaveOccurred())
+						}
+					}()
+				}
+				wg.Wait()
+
+				Expect(db.GetInboxCount(ctx, "alice")).To(Equal(writers * writesPerWriter))
+				Expect(db.GetOutboxCount(ctx, "alice")).To(Equal(writers * writesPerWriter))
+
+				ids := make(map[string]bool)
+				items, err := db.GetOutboxItems(ctx, "alice", database.PageQuery{Limit: writers * writesPerWriter})
+				Expect(err).ToNot(HaveOccurred())
+				for _, item := range items {
+					ids[activitystreams.ToObject(item.Activity).Id] = true
+				}
+				Expect(ids).To(HaveLen(writers * writesPerWriter))
+			})
+
+			It("should serialize transactions against one another", func() {
+				const txs = 10
+
+				var wg sync.WaitGroup
+				for i := 0; i < txs; i++ {
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						defer wg.Done()
+
+						tx, err := db.Begin(ctx)
+						Expect(err).ToNot(HaveOccurred())
+						defer tx.Rollback()
+
+						count, err := tx.GetOutboxCount(ctx, "alice")
+						Expect(err).ToNot(HaveOccurred())
+						_, err = tx.CreateOutboxItem(ctx, newCreate(strconv.Itoa(count)), "alice", baseUrl)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(tx.Commit()).To(Succeed())
+					}()
+				}
+				wg.Wait()
+
+				items, err := db.GetOutboxItems(ctx, "alice", database.PageQuery{Limit: txs})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(items).To(HaveLen(txs))
+				for i, item := range items {
+					Expect(contentOf(item.Activity)).To(Equal(strconv.Itoa(txs - 1 - i)))
+				}
+			})
+		})
+	})
+}
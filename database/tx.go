@@ -0,0 +1,1659 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+)
+
+// PubblrTx is a transaction against a PubblrDatabase.  It holds the database
+// exclusively while open, and writes through to it directly; Rollback undoes
+// those writes using the state captured before each was first modified.
+type PubblrTx struct {
+	ctx  context.Context
+	db   *PubblrDatabase
+	done bool
+
+	// state captured before its first modification in this transaction;
+	// a nil value means the entry did not exist
+	undoUsers             map[string]*UserData
+	undoDeliveryJobs      map[int]*deliveryJobData
+	undoNextDeliveryJobId int
+	// undoes the writes made to the remaining tables, in order
+	undo []func()
+}
+
+func (tx *PubblrTx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	if err := tx.ctx.Err(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.done = true
+	<-tx.db.lock
+	return nil
+}
+
+func (tx *PubblrTx) Rollback() error {
+	if tx.done {
+		return nil
+	}
+
+	for username, userData := range tx.undoUsers {
+		if userData == nil {
+			delete(tx.db.users, username)
+		} else {
+			tx.db.users[username] = *userData
+		}
+	}
+
+	for id, job := range tx.undoDeliveryJobs {
+		if job == nil {
+			delete(tx.db.deliveryJobs, id)
+		} else {
+			tx.db.deliveryJobs[id] = *job
+		}
+	}
+	tx.db.nextDeliveryJobId = tx.undoNextDeliveryJobId
+
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+
+	tx.done = true
+	<-tx.db.lock
+	return nil
+}
+
+// check returns an error if the transaction can no longer be used
+func (tx *PubblrTx) check(ctx context.Context) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if err := tx.ctx.Err(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// saveUser records the state of the given user, if it has not already been
+// recorded, so that it can be restored on rollback
+func (tx *PubblrTx) saveUser(username string) {
+	if _, ok := tx.undoUsers[username]; ok {
+		return
+	}
+
+	userData, ok := tx.db.users[username]
+	if !ok {
+		tx.undoUsers[username] = nil
+		return
+	}
+	saved := userData.clone()
+	tx.undoUsers[username] = &saved
+}
+
+// saveDeliveryJob records the state of the given delivery job, if it has not
+// already been recorded, so that it can be restored on rollback
+func (tx *PubblrTx) saveDeliveryJob(id int) {
+	if _, ok := tx.undoDeliveryJobs[id]; ok {
+		return
+	}
+
+	job, ok := tx.db.deliveryJobs[id]
+	if !ok {
+		tx.undoDeliveryJobs[id] = nil
+		return
+	}
+	tx.undoDeliveryJobs[id] = &job
+}
+
+// setRow writes a row of one of the database's tables, recording how to undo
+// the write on rollback.  A nil value deletes the row.
+func setRow[K comparable, V any](tx *PubblrTx, table map[K]V, key K, value *V) {
+	old, existed := table[key]
+	tx.undo = append(tx.undo, func() {
+		if existed {
+			table[key] = old
+		} else {
+			delete(table, key)
+		}
+	})
+
+	if value == nil {
+		delete(table, key)
+	} else {
+		table[key] = *value
+	}
+}
+
+func (tx *PubblrTx) CreateObject(ctx context.Context, post activitystreams.ObjectIface, user string, baseUrl url.URL) (activitystreams.ObjectIface, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	postType, err := post.Type()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get post type: %w", err)
+	}
+	postType = strings.ToLower(postType)
+
+	baseUrl.Path = path.Join(baseUrl.Path, user, postType, strconv.Itoa(len(userData.Objects[postType])))
+	id := baseUrl.String()
+	activitystreams.ToObject(post).Id = id
+
+	postJson, err := json.Marshal(post)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal post: %w", err)
+	}
+
+	tx.saveUser(user)
+	if userData.Objects == nil {
+		userData.Objects = make(map[string][]json.RawMessage)
+	}
+	userData.Objects[postType] = append(userData.Objects[postType], postJson)
+	tx.db.users[user] = userData
+
+	return post, nil
+}
+
+func (tx *PubblrTx) CreateInboxItem(ctx context.Context, a activitystreams.ActivityIface, user string) (activitystreams.ActivityIface, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	marshalledActivity, err := json.Marshal(a)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal activity: %w", err)
+	}
+
+	tx.saveUser(user)
+	userData.Inbox = append(userData.Inbox, marshalledActivity)
+	tx.db.users[user] = userData
+
+	return a, nil
+}
+
+func (tx *PubblrTx) CreateOutboxItem(ctx context.Context, activity activitystreams.ActivityIface, user string, baseUrl url.URL) (activitystreams.ActivityIface, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	baseUrl.Path = path.Join(baseUrl.Path, user, "outbox", strconv.Itoa(len(userData.Outbox)))
+	id := baseUrl.String()
+	activitystreams.ToObject(activity).Id = id
+
+	activityJson, err := json.Marshal(activity)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal activity: %w", err)
+	}
+
+	tx.saveUser(user)
+	userData.Outbox = append(userData.Outbox, activityJson)
+	tx.db.users[user] = userData
+
+	return activity, nil
+}
+
+func (tx *PubblrTx) CreateDeliveryJob(ctx context.Context, activity activitystreams.ActivityIface, recipient string) (*DeliveryJob, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	activityJson, err := json.Marshal(activity)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal activity: %w", err)
+	}
+
+	id := tx.db.nextDeliveryJobId
+	tx.saveDeliveryJob(id)
+	tx.db.deliveryJobs[id] = deliveryJobData{
+		recipient: recipient,
+		activity:  activityJson,
+	}
+	tx.db.nextDeliveryJobId++
+
+	return &DeliveryJob{
+		Id:        strconv.Itoa(id),
+		Recipient: recipient,
+		Activity:  activity,
+	}, nil
+}
+
+// GetDeliveryJobs returns up to limit of the jobs which are not dead and are
+// due by the given time, oldest first
+func (tx *PubblrTx) GetDeliveryJobs(ctx context.Context, due time.Time, limit int) ([]*DeliveryJob, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(tx.db.deliveryJobs))
+	for id, job := range tx.db.deliveryJobs {
+		if !job.dead && !job.nextAttempt.After(due) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	if limit >= 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	jobs := make([]*DeliveryJob, len(ids))
+	for i, id := range ids {
+		job := tx.db.deliveryJobs[id]
+
+		var activity activitystreams.ActivityIface
+		err := activitystreams.DefaultEntityUnmarshaler.Unmarshal(job.activity, &activity)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal activity: %w", err)
+		}
+
+		jobs[i] = &DeliveryJob{
+			Id:          strconv.Itoa(id),
+			Recipient:   job.recipient,
+			Activity:    activity,
+			Attempts:    job.attempts,
+			NextAttempt: job.nextAttempt,
+			LastError:   job.lastError,
+			Dead:        job.dead,
+		}
+	}
+
+	return jobs, nil
+}
+
+// UpdateDeliveryJob records the outcome of an attempt at a delivery job.  Its
+// recipient and activity are left as they were created.
+func (tx *PubblrTx) UpdateDeliveryJob(ctx context.Context, job DeliveryJob) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	parsedId, err := strconv.Atoi(job.Id)
+	if err != nil {
+		return fmt.Errorf("Failed to parse id: %w", err)
+	}
+
+	data, ok := tx.db.deliveryJobs[parsedId]
+	if !ok {
+		return fmt.Errorf("%w: no delivery job with id %s", ErrNotFound, job.Id)
+	}
+
+	tx.saveDeliveryJob(parsedId)
+	data.attempts = job.Attempts
+	data.nextAttempt = job.NextAttempt
+	data.lastError = job.LastError
+	data.dead = job.Dead
+	tx.db.deliveryJobs[parsedId] = data
+	return nil
+}
+
+func (tx *PubblrTx) DeleteDeliveryJob(ctx context.Context, id string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	parsedId, err := strconv.Atoi(id)
+	if err != nil {
+		return fmt.Errorf("Failed to parse id: %w", err)
+	}
+
+	if _, ok := tx.db.deliveryJobs[parsedId]; !ok {
+		return fmt.Errorf("%w: no delivery job with id %s", ErrNotFound, id)
+	}
+
+	tx.saveDeliveryJob(parsedId)
+	delete(tx.db.deliveryJobs, parsedId)
+	return nil
+}
+
+func (tx *PubblrTx) GetInboxItems(ctx context.Context, user string, query PageQuery) ([]*BoxItem, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	return selectItems(userData.Inbox, query)
+}
+
+func (tx *PubblrTx) GetOutboxItems(ctx context.Context, user string, query PageQuery) ([]*BoxItem, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	return selectItems(userData.Outbox, query)
+}
+
+// selectItems selects the items matching the given query from a box.  An
+// item's id is its index in the box, so the bounds of the page can be found
+// without scanning.
+func selectItems(raw []json.RawMessage, query PageQuery) ([]*BoxItem, error) {
+	// inclusive bounds on the indices that may be selected
+	lo, hi := 0, len(raw)-1
+
+	if query.MaxId != "" {
+		maxId, err := parseItemId(query.MaxId)
+		if err != nil {
+			return nil, err
+		}
+		if maxId-1 < hi {
+			hi = maxId - 1
+		}
+	}
+
+	fromOldest := false
+	if query.MinId != "" {
+		minId, err := parseItemId(query.MinId)
+		if err != nil {
+			return nil, err
+		}
+		if minId+1 > lo {
+			lo = minId + 1
+		}
+		fromOldest = true
+	} else if query.SinceId != "" {
+		sinceId, err := parseItemId(query.SinceId)
+		if err != nil {
+			return nil, err
+		}
+		if sinceId+1 > lo {
+			lo = sinceId + 1
+		}
+	}
+
+	if query.Limit > 0 && hi-lo+1 > query.Limit {
+		if fromOldest {
+			hi = lo + query.Limit - 1
+		} else {
+			lo = hi - query.Limit + 1
+		}
+	}
+
+	var items []*BoxItem
+	for i := hi; i >= lo; i-- {
+		var activity activitystreams.ActivityIface
+		err := activitystreams.DefaultEntityUnmarshaler.Unmarshal(raw[i], &activity)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal activity: %w", err)
+		}
+
+		items = append(items, &BoxItem{
+			Id:       strconv.Itoa(i),
+			Activity: activity,
+		})
+	}
+
+	return items, nil
+}
+
+func parseItemId(id string) (int, error) {
+	parsedId, err := strconv.Atoi(id)
+	if err != nil || parsedId < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidId, id)
+	}
+	return parsedId, nil
+}
+
+func (tx *PubblrTx) GetInboxCount(ctx context.Context, user string) (int, error) {
+	if err := tx.check(ctx); err != nil {
+		return 0, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	return len(userData.Inbox), nil
+}
+
+func (tx *PubblrTx) GetOutboxCount(ctx context.Context, user string) (int, error) {
+	if err := tx.check(ctx); err != nil {
+		return 0, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	return len(userData.Outbox), nil
+}
+
+func (tx *PubblrTx) GetInboxItem(ctx context.Context, user, id string) (activitystreams.ActivityIface, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	return unmarshalItem(userData.Inbox, id)
+}
+
+func (tx *PubblrTx) GetOutboxItem(ctx context.Context, user, id string) (activitystreams.ActivityIface, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	return unmarshalItem(userData.Outbox, id)
+}
+
+func unmarshalItem(raw []json.RawMessage, id string) (activitystreams.ActivityIface, error) {
+	parsedId, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse id: %w", err)
+	}
+
+	if parsedId < 0 || len(raw) <= parsedId {
+		return nil, fmt.Errorf("%w: no activity with id %s", ErrNotFound, id)
+	}
+
+	var activity activitystreams.ActivityIface
+	err = activitystreams.DefaultEntityUnmarshaler.Unmarshal(raw[parsedId], &activity)
+
+	return activity, err
+}
+
+func (tx *PubblrTx) GetObject(ctx context.Context, user, typ, id string) (activitystreams.ObjectIface, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	parsedId, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse id: %w", err)
+	}
+
+	objects := userData.Objects[typ]
+	if parsedId < 0 || len(objects) <= parsedId {
+		return nil, fmt.Errorf("%w: no %s with id %s", ErrNotFound, typ, id)
+	}
+
+	var post activitystreams.ObjectIface
+	err = activitystreams.DefaultEntityUnmarshaler.Unmarshal(objects[parsedId], &post)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal retrieved post: %w", err)
+	}
+
+	return post, nil
+}
+
+// GetObjects returns all of the user's objects, grouped by type and in order
+// of creation within each type
+func (tx *PubblrTx) GetObjects(ctx context.Context, user string) ([]activitystreams.ObjectIface, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	types := make([]string, 0, len(userData.Objects))
+	for typ := range userData.Objects {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	var objects []activitystreams.ObjectIface
+	for _, typ := range types {
+		for _, objectJson := range userData.Objects[typ] {
+			var object activitystreams.ObjectIface
+			err := activitystreams.DefaultEntityUnmarshaler.Unmarshal(objectJson, &object)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to unmarshal retrieved object: %w", err)
+			}
+			objects = append(objects, object)
+		}
+	}
+
+	return objects, nil
+}
+
+func (tx *PubblrTx) AddFollower(ctx context.Context, user, actorId string) error {
+	return tx.addRelation(ctx, user, actorId, func(u *UserData) *[]string { return &u.Followers })
+}
+
+func (tx *PubblrTx) GetFollowers(ctx context.Context, user string) ([]string, error) {
+	return tx.getRelations(ctx, user, func(u *UserData) *[]string { return &u.Followers })
+}
+
+func (tx *PubblrTx) AddFollowing(ctx context.Context, user, actorId string) error {
+	return tx.addRelation(ctx, user, actorId, func(u *UserData) *[]string { return &u.Following })
+}
+
+func (tx *PubblrTx) GetFollowing(ctx context.Context, user string) ([]string, error) {
+	return tx.getRelations(ctx, user, func(u *UserData) *[]string { return &u.Following })
+}
+
+func (tx *PubblrTx) AddBlock(ctx context.Context, user, actorId string) error {
+	return tx.addRelation(ctx, user, actorId, func(u *UserData) *[]string { return &u.Blocks })
+}
+
+func (tx *PubblrTx) GetBlocks(ctx context.Context, user string) ([]string, error) {
+	return tx.getRelations(ctx, user, func(u *UserData) *[]string { return &u.Blocks })
+}
+
+// addRelation adds an actor id to one of the user's lists of actors, if it is
+// not already present
+func (tx *PubblrTx) addRelation(ctx context.Context, user, actorId string, list func(*UserData) *[]string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	ids := list(&userData)
+	for _, id := range *ids {
+		if id == actorId {
+			return nil
+		}
+	}
+
+	tx.saveUser(user)
+	*ids = append(*ids, actorId)
+	tx.db.users[user] = userData
+	return nil
+}
+
+func (tx *PubblrTx) getRelations(ctx context.Context, user string, list func(*UserData) *[]string) ([]string, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	return append([]string(nil), *list(&userData)...), nil
+}
+
+func (tx *PubblrTx) CreateUser(ctx context.Context, user activitystreams.ActorIface, username, password string, baseUrl url.URL) (activitystreams.ActorIface, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	return tx.createActor(user, username, UserData{Password: password}, baseUrl)
+}
+
+// CreateBlog creates a side blog owned by the given account
+func (tx *PubblrTx) CreateBlog(ctx context.Context, blog activitystreams.ActorIface, name, account string, baseUrl url.URL) (activitystreams.ActorIface, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := tx.checkAccount(account); err != nil {
+		return nil, err
+	}
+
+	return tx.createActor(blog, name, UserData{Account: account}, baseUrl)
+}
+
+// checkAccount returns an error unless the named actor is an account, rather
+// than a side blog or group
+func (tx *PubblrTx) checkAccount(name string) error {
+	userData, ok := tx.db.users[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, name)
+	}
+	if userData.Account != "" {
+		return fmt.Errorf("%s is a side blog, not an account", name)
+	}
+	if userData.Members != nil {
+		return fmt.Errorf("%s is a group, not an account", name)
+	}
+	return nil
+}
+
+// CreateGroup creates a group with the given account as its owner
+func (tx *PubblrTx) CreateGroup(ctx context.Context, group activitystreams.ActorIface, name, owner string, baseUrl url.URL) (activitystreams.ActorIface, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := tx.checkAccount(owner); err != nil {
+		return nil, err
+	}
+
+	members := map[string]GroupMember{
+		owner: {
+			Group:    name,
+			Username: owner,
+			Role:     GroupOwner,
+			Added:    time.Now(),
+		},
+	}
+	return tx.createActor(group, name, UserData{Members: members}, baseUrl)
+}
+
+// groupData returns the data of the named group
+func (tx *PubblrTx) groupData(group string) (UserData, error) {
+	userData, ok := tx.db.users[group]
+	if !ok {
+		return UserData{}, fmt.Errorf("%w: %s", ErrUserNotFound, group)
+	}
+	if userData.Members == nil {
+		return UserData{}, fmt.Errorf("%w: %s", ErrNotGroup, group)
+	}
+	return userData, nil
+}
+
+// GetGroupMembers returns the members of a group, ordered by username
+func (tx *PubblrTx) GetGroupMembers(ctx context.Context, group string) ([]*GroupMember, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	groupData, err := tx.groupData(group)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]*GroupMember, 0, len(groupData.Members))
+	for _, member := range groupData.Members {
+		member := member
+		members = append(members, &member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Username < members[j].Username
+	})
+	return members, nil
+}
+
+// GetGroupMember returns an account's membership of a group
+func (tx *PubblrTx) GetGroupMember(ctx context.Context, group, username string) (*GroupMember, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	groupData, err := tx.groupData(group)
+	if err != nil {
+		return nil, err
+	}
+
+	member, ok := groupData.Members[username]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not a member of %s", ErrNotFound, username, group)
+	}
+	return &member, nil
+}
+
+// SetGroupMember adds an account to a group, or changes its role if it is
+// already a member
+func (tx *PubblrTx) SetGroupMember(ctx context.Context, member GroupMember) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	groupData, err := tx.groupData(member.Group)
+	if err != nil {
+		return err
+	}
+	if err := tx.checkAccount(member.Username); err != nil {
+		return err
+	}
+
+	tx.saveUser(member.Group)
+	groupData.Members[member.Username] = member
+	tx.db.users[member.Group] = groupData
+	return nil
+}
+
+// RemoveGroupMember removes an account from a group
+func (tx *PubblrTx) RemoveGroupMember(ctx context.Context, group, username string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	groupData, err := tx.groupData(group)
+	if err != nil {
+		return err
+	}
+	if _, ok := groupData.Members[username]; !ok {
+		return fmt.Errorf("%w: %s is not a member of %s", ErrNotFound, username, group)
+	}
+
+	tx.saveUser(group)
+	delete(groupData.Members, username)
+	tx.db.users[group] = groupData
+	return nil
+}
+
+// GetGroups returns an account's memberships of groups, ordered by group
+func (tx *PubblrTx) GetGroups(ctx context.Context, username string) ([]*GroupMember, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, ok := tx.db.users[username]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	var groups []*GroupMember
+	for _, userData := range tx.db.users {
+		if member, ok := userData.Members[username]; ok {
+			groups = append(groups, &member)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Group < groups[j].Group
+	})
+	return groups, nil
+}
+
+// createActor stores a new actor under the given name, with its id derived
+// from the name
+func (tx *PubblrTx) createActor(user activitystreams.ActorIface, username string, userData UserData, baseUrl url.URL) (activitystreams.ActorIface, error) {
+	_, ok := tx.db.users[username]
+	if ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserExists, username)
+	}
+
+	baseUrl.Path = path.Join(baseUrl.Path, username)
+	id := baseUrl.String()
+
+	activitystreams.ToObject(user).Id = id
+
+	bytes, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+
+	userData.Actor = bytes
+	tx.saveUser(username)
+	tx.db.users[username] = userData
+	return user, nil
+}
+
+// GetAccount returns the account owning the named actor: the account itself,
+// if it is not a side blog.  Groups are owned by no one account, so
+// ErrNotFound is returned for them.
+func (tx *PubblrTx) GetAccount(ctx context.Context, name string) (string, error) {
+	if err := tx.check(ctx); err != nil {
+		return "", err
+	}
+
+	userData, ok := tx.db.users[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUserNotFound, name)
+	}
+	if userData.Members != nil {
+		return "", fmt.Errorf("%w: %s is a group", ErrNotFound, name)
+	}
+	if userData.Account != "" {
+		return userData.Account, nil
+	}
+	return name, nil
+}
+
+// GetBlogs returns the names of the side blogs owned by an account, in order
+func (tx *PubblrTx) GetBlogs(ctx context.Context, account string) ([]string, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, ok := tx.db.users[account]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, account)
+	}
+
+	var blogs []string
+	for name, userData := range tx.db.users {
+		if userData.Account == account {
+			blogs = append(blogs, name)
+		}
+	}
+	sort.Strings(blogs)
+	return blogs, nil
+}
+
+func (tx *PubblrTx) GetUser(ctx context.Context, username string) (activitystreams.ActorIface, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[username]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	var user activitystreams.ActorIface
+	err := activitystreams.DefaultEntityUnmarshaler.Unmarshal(userData.Actor, &user)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal retrieved user: %w", err)
+	}
+
+	return user, nil
+}
+
+// UpdateUser replaces the actor document of the named actor.  The actor keeps
+// the id it was created with.
+func (tx *PubblrTx) UpdateUser(ctx context.Context, user activitystreams.ActorIface, username string) (activitystreams.ActorIface, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[username]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	var current struct {
+		Id string `json:"id"`
+	}
+	err := json.Unmarshal(userData.Actor, &current)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal retrieved user: %w", err)
+	}
+	activitystreams.ToObject(user).Id = current.Id
+
+	bytes, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.saveUser(username)
+	userData.Actor = bytes
+	tx.db.users[username] = userData
+	return user, nil
+}
+
+func (tx *PubblrTx) CheckPassword(ctx context.Context, username, password string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	userData, ok := tx.db.users[username]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	// Side blogs are logged into through their account, and groups through
+	// their members'
+	if userData.Account != "" || userData.Members != nil || userData.Password != password {
+		return fmt.Errorf("Wrong password")
+	}
+
+	return nil
+}
+
+func (tx *PubblrTx) GetAppearance(ctx context.Context, user string) (*Appearance, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	appearance := userData.Appearance
+	return &appearance, nil
+}
+
+func (tx *PubblrTx) SetAppearance(ctx context.Context, user string, appearance Appearance) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	userData, ok := tx.db.users[user]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+
+	tx.saveUser(user)
+	userData.Appearance = appearance
+	tx.db.users[user] = userData
+	return nil
+}
+
+func (tx *PubblrTx) GetRoles(ctx context.Context, username string) ([]string, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[username]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	return append([]string(nil), userData.Roles...), nil
+}
+
+func (tx *PubblrTx) SetRoles(ctx context.Context, username string, roles []string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	userData, ok := tx.db.users[username]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	tx.saveUser(username)
+	userData.Roles = append([]string(nil), roles...)
+	tx.db.users[username] = userData
+	return nil
+}
+
+func (tx *PubblrTx) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	session, ok := tx.db.sessions[token.Session]
+	if !ok || session.Username != token.Username {
+		return fmt.Errorf("%w: no session %s for user %s", ErrNotFound, token.Session, token.Username)
+	}
+
+	if _, ok := tx.db.refreshTokens[token.Hash]; ok {
+		return errors.New("refresh token already exists")
+	}
+
+	setRow(tx, tx.db.refreshTokens, token.Hash, &token)
+	return nil
+}
+
+func (tx *PubblrTx) GetRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	token, ok := tx.db.refreshTokens[hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: no such refresh token", ErrNotFound)
+	}
+	return &token, nil
+}
+
+// UseRefreshToken marks a refresh token as exchanged
+func (tx *PubblrTx) UseRefreshToken(ctx context.Context, hash string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	token, ok := tx.db.refreshTokens[hash]
+	if !ok {
+		return fmt.Errorf("%w: no such refresh token", ErrNotFound)
+	}
+
+	token.Used = true
+	setRow(tx, tx.db.refreshTokens, hash, &token)
+	return nil
+}
+
+func (tx *PubblrTx) CreateSession(ctx context.Context, session Session) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.users[session.Username]; !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, session.Username)
+	}
+
+	if _, ok := tx.db.sessions[session.Id]; ok {
+		return errors.New("session already exists")
+	}
+
+	setRow(tx, tx.db.sessions, session.Id, &session)
+	return nil
+}
+
+func (tx *PubblrTx) GetSession(ctx context.Context, id string) (*Session, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	session, ok := tx.db.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: no session with id %s", ErrNotFound, id)
+	}
+	return &session, nil
+}
+
+// GetSessions returns the user's sessions, oldest first
+func (tx *PubblrTx) GetSessions(ctx context.Context, username string) ([]*Session, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, ok := tx.db.users[username]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	var sessions []*Session
+	for _, session := range tx.db.sessions {
+		if session.Username == username {
+			session := session
+			sessions = append(sessions, &session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Created.Equal(sessions[j].Created) {
+			return sessions[i].Id < sessions[j].Id
+		}
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+
+	return sessions, nil
+}
+
+func (tx *PubblrTx) UpdateSession(ctx context.Context, session Session) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	existing, ok := tx.db.sessions[session.Id]
+	if !ok {
+		return fmt.Errorf("%w: no session with id %s", ErrNotFound, session.Id)
+	}
+	if existing.Username != session.Username {
+		return errors.New("cannot move a session to another user")
+	}
+
+	setRow(tx, tx.db.sessions, session.Id, &session)
+	return nil
+}
+
+// DeleteSession ends a session, deleting every refresh token issued in it
+func (tx *PubblrTx) DeleteSession(ctx context.Context, id string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.sessions[id]; !ok {
+		return fmt.Errorf("%w: no session with id %s", ErrNotFound, id)
+	}
+
+	for hash, token := range tx.db.refreshTokens {
+		if token.Session == id {
+			setRow[string, RefreshToken](tx, tx.db.refreshTokens, hash, nil)
+		}
+	}
+	setRow[string, Session](tx, tx.db.sessions, id, nil)
+	return nil
+}
+
+func (tx *PubblrTx) CreateOAuthClient(ctx context.Context, client OAuthClient) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.oauthClients[client.Id]; ok {
+		return errors.New("client already exists")
+	}
+
+	client.RedirectUris = append([]string(nil), client.RedirectUris...)
+	setRow(tx, tx.db.oauthClients, client.Id, &client)
+	return nil
+}
+
+func (tx *PubblrTx) GetOAuthClient(ctx context.Context, id string) (*OAuthClient, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	client, ok := tx.db.oauthClients[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: no client with id %s", ErrNotFound, id)
+	}
+	client.RedirectUris = append([]string(nil), client.RedirectUris...)
+	return &client, nil
+}
+
+func (tx *PubblrTx) CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.users[code.Username]; !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, code.Username)
+	}
+	if _, ok := tx.db.oauthClients[code.ClientId]; !ok {
+		return fmt.Errorf("%w: no client with id %s", ErrNotFound, code.ClientId)
+	}
+	if _, ok := tx.db.authorizationCodes[code.Hash]; ok {
+		return errors.New("authorization code already exists")
+	}
+
+	setRow(tx, tx.db.authorizationCodes, code.Hash, &code)
+	return nil
+}
+
+func (tx *PubblrTx) GetAuthorizationCode(ctx context.Context, hash string) (*AuthorizationCode, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	code, ok := tx.db.authorizationCodes[hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: no such authorization code", ErrNotFound)
+	}
+	return &code, nil
+}
+
+func (tx *PubblrTx) DeleteAuthorizationCode(ctx context.Context, hash string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.authorizationCodes[hash]; !ok {
+		return fmt.Errorf("%w: no such authorization code", ErrNotFound)
+	}
+
+	setRow[string, AuthorizationCode](tx, tx.db.authorizationCodes, hash, nil)
+	return nil
+}
+
+func (tx *PubblrTx) GetTwoFactor(ctx context.Context, username string) (*TwoFactor, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, ok := tx.db.users[username]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	twoFactor, ok := tx.db.twoFactors[username]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has not enrolled in two-factor authentication", ErrNotFound, username)
+	}
+	twoFactor.RecoveryCodes = append([]string(nil), twoFactor.RecoveryCodes...)
+	return &twoFactor, nil
+}
+
+// SetTwoFactor enrolls a user in two-factor authentication, replacing any
+// enrollment they already have
+func (tx *PubblrTx) SetTwoFactor(ctx context.Context, twoFactor TwoFactor) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.users[twoFactor.Username]; !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, twoFactor.Username)
+	}
+
+	twoFactor.RecoveryCodes = append([]string(nil), twoFactor.RecoveryCodes...)
+	setRow(tx, tx.db.twoFactors, twoFactor.Username, &twoFactor)
+	return nil
+}
+
+func (tx *PubblrTx) DeleteTwoFactor(ctx context.Context, username string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.twoFactors[username]; !ok {
+		return fmt.Errorf("%w: %s has not enrolled in two-factor authentication", ErrNotFound, username)
+	}
+
+	setRow[string, TwoFactor](tx, tx.db.twoFactors, username, nil)
+	return nil
+}
+
+func (tx *PubblrTx) CreateLoginChallenge(ctx context.Context, challenge LoginChallenge) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.users[challenge.Username]; !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, challenge.Username)
+	}
+	if _, ok := tx.db.loginChallenges[challenge.Hash]; ok {
+		return errors.New("login challenge already exists")
+	}
+
+	setRow(tx, tx.db.loginChallenges, challenge.Hash, &challenge)
+	return nil
+}
+
+func (tx *PubblrTx) GetLoginChallenge(ctx context.Context, hash string) (*LoginChallenge, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	challenge, ok := tx.db.loginChallenges[hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: no such login challenge", ErrNotFound)
+	}
+	return &challenge, nil
+}
+
+func (tx *PubblrTx) UpdateLoginChallenge(ctx context.Context, challenge LoginChallenge) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.loginChallenges[challenge.Hash]; !ok {
+		return fmt.Errorf("%w: no such login challenge", ErrNotFound)
+	}
+
+	setRow(tx, tx.db.loginChallenges, challenge.Hash, &challenge)
+	return nil
+}
+
+func (tx *PubblrTx) DeleteLoginChallenge(ctx context.Context, hash string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.loginChallenges[hash]; !ok {
+		return fmt.Errorf("%w: no such login challenge", ErrNotFound)
+	}
+
+	setRow[string, LoginChallenge](tx, tx.db.loginChallenges, hash, nil)
+	return nil
+}
+
+func (tx *PubblrTx) SetPassword(ctx context.Context, username, password string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	userData, ok := tx.db.users[username]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	tx.saveUser(username)
+	userData.Password = password
+	tx.db.users[username] = userData
+	return nil
+}
+
+// GetEmail returns the email address of a user, failing with ErrNotFound if
+// they have not given one
+func (tx *PubblrTx) GetEmail(ctx context.Context, username string) (*Email, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[username]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if userData.Email.Address == "" {
+		return nil, fmt.Errorf("%w: %s has no email address", ErrNotFound, username)
+	}
+
+	email := userData.Email
+	return &email, nil
+}
+
+// SetEmail sets the email address of a user, or removes it if the address is
+// empty.  Addresses are compared without regard to case, and no two users may
+// share one.
+func (tx *PubblrTx) SetEmail(ctx context.Context, username string, email Email) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	userData, ok := tx.db.users[username]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if email.Address != "" {
+		for other, otherData := range tx.db.users {
+			if other != username && strings.EqualFold(otherData.Email.Address, email.Address) {
+				return fmt.Errorf("%w: %s", ErrEmailExists, email.Address)
+			}
+		}
+	}
+
+	tx.saveUser(username)
+	userData.Email = email
+	tx.db.users[username] = userData
+	return nil
+}
+
+func (tx *PubblrTx) GetUsernameByEmail(ctx context.Context, address string) (string, error) {
+	if err := tx.check(ctx); err != nil {
+		return "", err
+	}
+
+	if address != "" {
+		for username, userData := range tx.db.users {
+			if strings.EqualFold(userData.Email.Address, address) {
+				return username, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: no user with email address %s", ErrNotFound, address)
+}
+
+func (tx *PubblrTx) CreateAccountToken(ctx context.Context, token AccountToken) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.users[token.Username]; !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, token.Username)
+	}
+	if _, ok := tx.db.accountTokens[token.Hash]; ok {
+		return errors.New("account token already exists")
+	}
+
+	setRow(tx, tx.db.accountTokens, token.Hash, &token)
+	return nil
+}
+
+func (tx *PubblrTx) GetAccountToken(ctx context.Context, hash string) (*AccountToken, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	token, ok := tx.db.accountTokens[hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: no such account token", ErrNotFound)
+	}
+	return &token, nil
+}
+
+func (tx *PubblrTx) DeleteAccountToken(ctx context.Context, hash string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.accountTokens[hash]; !ok {
+		return fmt.Errorf("%w: no such account token", ErrNotFound)
+	}
+
+	setRow[string, AccountToken](tx, tx.db.accountTokens, hash, nil)
+	return nil
+}
+
+// DeleteAccountTokens deletes all of a user's tokens for the given purpose
+func (tx *PubblrTx) DeleteAccountTokens(ctx context.Context, username string, purpose AccountTokenPurpose) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	for hash, token := range tx.db.accountTokens {
+		if token.Username == username && token.Purpose == purpose {
+			setRow[string, AccountToken](tx, tx.db.accountTokens, hash, nil)
+		}
+	}
+	return nil
+}
+
+// GetRegistration returns how a user registered.  Users created without a
+// registration are reported active.
+func (tx *PubblrTx) GetRegistration(ctx context.Context, username string) (*Registration, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, ok := tx.db.users[username]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if userData.Registration == nil {
+		return &Registration{Username: username, Status: AccountActive}, nil
+	}
+
+	registration := *userData.Registration
+	return &registration, nil
+}
+
+func (tx *PubblrTx) SetRegistration(ctx context.Context, registration Registration) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	userData, ok := tx.db.users[registration.Username]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, registration.Username)
+	}
+
+	tx.saveUser(registration.Username)
+	userData.Registration = &registration
+	tx.db.users[registration.Username] = userData
+	return nil
+}
+
+// GetRegistrations returns the registrations of the accounts with the given
+// status, oldest first
+func (tx *PubblrTx) GetRegistrations(ctx context.Context, status AccountStatus) ([]*Registration, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	var registrations []*Registration
+	for username, userData := range tx.db.users {
+		if userData.Account != "" || userData.Members != nil {
+			// Side blogs and groups are not registered, but created by an
+			// account
+			continue
+		}
+		registration := Registration{Username: username, Status: AccountActive}
+		if userData.Registration != nil {
+			registration = *userData.Registration
+		}
+		if registration.Status == status {
+			registrations = append(registrations, &registration)
+		}
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		if registrations[i].Created.Equal(registrations[j].Created) {
+			return registrations[i].Username < registrations[j].Username
+		}
+		return registrations[i].Created.Before(registrations[j].Created)
+	})
+
+	return registrations, nil
+}
+
+func (tx *PubblrTx) CreateInvite(ctx context.Context, invite Invite) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.invites[invite.Hash]; ok {
+		return errors.New("invite already exists")
+	}
+
+	setRow(tx, tx.db.invites, invite.Hash, &invite)
+	return nil
+}
+
+func (tx *PubblrTx) GetInvite(ctx context.Context, hash string) (*Invite, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	invite, ok := tx.db.invites[hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: no such invite", ErrNotFound)
+	}
+	return &invite, nil
+}
+
+// GetInvites returns every invite, oldest first
+func (tx *PubblrTx) GetInvites(ctx context.Context) ([]*Invite, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	var invites []*Invite
+	for _, invite := range tx.db.invites {
+		invite := invite
+		invites = append(invites, &invite)
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		if invites[i].Created.Equal(invites[j].Created) {
+			return invites[i].Hash < invites[j].Hash
+		}
+		return invites[i].Created.Before(invites[j].Created)
+	})
+
+	return invites, nil
+}
+
+func (tx *PubblrTx) UpdateInvite(ctx context.Context, invite Invite) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.invites[invite.Hash]; !ok {
+		return fmt.Errorf("%w: no such invite", ErrNotFound)
+	}
+
+	setRow(tx, tx.db.invites, invite.Hash, &invite)
+	return nil
+}
+
+func (tx *PubblrTx) DeleteInvite(ctx context.Context, hash string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.invites[hash]; !ok {
+		return fmt.Errorf("%w: no such invite", ErrNotFound)
+	}
+
+	setRow[string, Invite](tx, tx.db.invites, hash, nil)
+	return nil
+}
+
+func (tx *PubblrTx) CreatePersonalAccessToken(ctx context.Context, token PersonalAccessToken) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.users[token.Username]; !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, token.Username)
+	}
+	if _, ok := tx.db.personalAccessTokens[token.Hash]; ok {
+		return errors.New("personal access token already exists")
+	}
+	for _, existing := range tx.db.personalAccessTokens {
+		if existing.Id == token.Id {
+			return errors.New("personal access token already exists")
+		}
+	}
+
+	setRow(tx, tx.db.personalAccessTokens, token.Hash, &token)
+	return nil
+}
+
+func (tx *PubblrTx) GetPersonalAccessToken(ctx context.Context, hash string) (*PersonalAccessToken, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	token, ok := tx.db.personalAccessTokens[hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: no such personal access token", ErrNotFound)
+	}
+	return &token, nil
+}
+
+// GetPersonalAccessTokens returns a user's personal access tokens, oldest
+// first
+func (tx *PubblrTx) GetPersonalAccessTokens(ctx context.Context, username string) ([]*PersonalAccessToken, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, ok := tx.db.users[username]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	var tokens []*PersonalAccessToken
+	for _, token := range tx.db.personalAccessTokens {
+		if token.Username == username {
+			token := token
+			tokens = append(tokens, &token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Created.Equal(tokens[j].Created) {
+			return tokens[i].Id < tokens[j].Id
+		}
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+
+	return tokens, nil
+}
+
+func (tx *PubblrTx) UpdatePersonalAccessToken(ctx context.Context, token PersonalAccessToken) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	existing, ok := tx.db.personalAccessTokens[token.Hash]
+	if !ok || existing.Id != token.Id {
+		return fmt.Errorf("%w: no such personal access token", ErrNotFound)
+	}
+
+	setRow(tx, tx.db.personalAccessTokens, token.Hash, &token)
+	return nil
+}
+
+// DeletePersonalAccessToken deletes the user's personal access token with the
+// given id
+func (tx *PubblrTx) DeletePersonalAccessToken(ctx context.Context, username, id string) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+
+	for hash, token := range tx.db.personalAccessTokens {
+		if token.Id == id && token.Username == username {
+			setRow[string, PersonalAccessToken](tx, tx.db.personalAccessTokens, hash, nil)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no such personal access token", ErrNotFound)
+}
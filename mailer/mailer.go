@@ -0,0 +1,183 @@
+// Package mailer sends email, such as the password reset and verification
+// messages sent to users
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidHeader = errors.New("invalid header")
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// MailerConfig chooses how mail is sent.  Mail is sent through the SMTP server
+// if one is given; otherwise it is appended to File, or failing that written
+// to standard output, which is only suitable for development and tests.
+type MailerConfig struct {
+	// the address from which mail is sent
+	From string     `json:"from"`
+	SMTP SMTPConfig `json:"smtp"`
+	File string     `json:"file"`
+}
+
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func NewMailer(config MailerConfig) (Mailer, error) {
+	if config.From == "" {
+		config.From = "pubblr@localhost"
+	}
+	if _, err := mail.ParseAddress(config.From); err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+
+	if config.SMTP.Host != "" {
+		return NewSMTPMailer(config.SMTP, config.From), nil
+	}
+	if config.File != "" {
+		return NewFileMailer(config.File, config.From)
+	}
+	return NewWriterMailer(os.Stdout, config.From), nil
+}
+
+// ValidAddress reports whether address is a bare email address, such as
+// alice@example.org, without a display name or angle brackets
+func ValidAddress(address string) bool {
+	parsed, err := mail.ParseAddress(address)
+	return err == nil && parsed.Address == address
+}
+
+// Format renders a message as it is sent, with its headers
+func Format(from string, msg Message, date time.Time) ([]byte, error) {
+	for _, value := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, value)
+		}
+	}
+	if !ValidAddress(msg.To) {
+		return nil, fmt.Errorf("%w: invalid recipient %q", ErrInvalidHeader, msg.To)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	buf.WriteString("\r\n")
+	return buf.Bytes(), nil
+}
+
+// SMTPMailer sends mail through an SMTP server
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(config SMTPConfig, from string) *SMTPMailer {
+	if config.Port == 0 {
+		config.Port = 587
+	}
+
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	return &SMTPMailer{
+		addr: net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		from: from,
+		auth: auth,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b, err := Format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return err
+	}
+
+	err = smtp.SendMail(m.addr, m.auth, from.Address, []string{msg.To}, b)
+	if err != nil {
+		return fmt.Errorf("failed to send mail to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// WriterMailer writes mail to a writer rather than sending it, for development
+// and tests
+type WriterMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+func NewWriterMailer(w io.Writer, from string) *WriterMailer {
+	return &WriterMailer{
+		w:    w,
+		from: from,
+	}
+}
+
+// NewFileMailer returns a WriterMailer which appends mail to the file at path
+func NewFileMailer(path, from string) (*WriterMailer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mail file: %w", err)
+	}
+	return NewWriterMailer(f, from), nil
+}
+
+func (m *WriterMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b, err := Format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = m.w.Write(append(b, "\r\n"...))
+	return err
+}
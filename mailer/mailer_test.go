@@ -0,0 +1,102 @@
+package mailer_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/mailer"
+)
+
+var _ = Describe("Mailer", func() {
+	ctx := context.Background()
+	msg := mailer.Message{
+		To:      "alice@example.org",
+		Subject: "Reset your password",
+		Body:    "Your token is\nabc",
+	}
+
+	Describe("Format", func() {
+		It("should render headers and CRLF line endings", func() {
+			b, err := mailer.Format("pubblr@example.org", msg, time.Unix(0, 0).UTC())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).To(Equal("From: pubblr@example.org\r\n" +
+				"To: alice@example.org\r\n" +
+				"Subject: Reset your password\r\n" +
+				"Date: Thu, 01 Jan 1970 00:00:00 +0000\r\n" +
+				"MIME-Version: 1.0\r\n" +
+				"Content-Type: text/plain; charset=utf-8\r\n" +
+				"\r\n" +
+				"Your token is\r\nabc\r\n"))
+		})
+
+		It("should refuse header injection", func() {
+			injected := msg
+			injected.Subject = "hi\r\nBcc: mallory@example.org"
+			_, err := mailer.Format("pubblr@example.org", injected, time.Now())
+			Expect(err).To(MatchError(mailer.ErrInvalidHeader))
+
+			injected = msg
+			injected.To = "alice@example.org\nBcc: mallory@example.org"
+			_, err = mailer.Format("pubblr@example.org", injected, time.Now())
+			Expect(err).To(MatchError(mailer.ErrInvalidHeader))
+		})
+
+		It("should refuse recipients which are not bare addresses", func() {
+			named := msg
+			named.To = "Alice <alice@example.org>"
+			_, err := mailer.Format("pubblr@example.org", named, time.Now())
+			Expect(err).To(MatchError(mailer.ErrInvalidHeader))
+		})
+	})
+
+	It("should validate addresses", func() {
+		Expect(mailer.ValidAddress("alice@example.org")).To(BeTrue())
+		Expect(mailer.ValidAddress("alice")).To(BeFalse())
+		Expect(mailer.ValidAddress("Alice <alice@example.org>")).To(BeFalse())
+	})
+
+	Describe("WriterMailer", func() {
+		It("should write messages", func() {
+			var buf bytes.Buffer
+			m := mailer.NewWriterMailer(&buf, "pubblr@example.org")
+			Expect(m.Send(ctx, msg)).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring("To: alice@example.org\r\n"))
+			Expect(buf.String()).To(ContainSubstring("Your token is\r\nabc"))
+		})
+
+		It("should append messages to a file", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "mail")
+			m, err := mailer.NewFileMailer(path, "pubblr@example.org")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.Send(ctx, msg)).To(Succeed())
+			Expect(m.Send(ctx, msg)).To(Succeed())
+
+			b, err := os.ReadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bytes.Count(b, []byte("To: alice@example.org"))).To(Equal(2))
+		})
+	})
+
+	Describe("NewMailer", func() {
+		It("should write to a file unless an SMTP server is given", func() {
+			m, err := mailer.NewMailer(mailer.MailerConfig{File: filepath.Join(GinkgoT().TempDir(), "mail")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(BeAssignableToTypeOf(&mailer.WriterMailer{}))
+
+			m, err = mailer.NewMailer(mailer.MailerConfig{SMTP: mailer.SMTPConfig{Host: "smtp.example.org"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(BeAssignableToTypeOf(&mailer.SMTPMailer{}))
+		})
+
+		It("should refuse an invalid from address", func() {
+			_, err := mailer.NewMailer(mailer.MailerConfig{From: "not an address"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
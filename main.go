@@ -21,8 +21,9 @@ func main() {
 		MountPath: "/pubblr",
 		PageSize:  25,
 		Auth: auth.AuthConfig{
-			AuthKeyLocation:       "auth.pem",
-			JWTExpirationDuration: 36 * time.Hour,
+			AuthKeyLocation:                "auth.pem",
+			JWTExpirationDuration:          15 * time.Minute,
+			RefreshTokenExpirationDuration: 30 * 24 * time.Hour,
 		},
 	}, router).ListenAndServe()
 }
@@ -0,0 +1,402 @@
+package search
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/util/markup"
+)
+
+var (
+	ErrInvalidQuery = errors.New("invalid search query")
+	ErrInvalidId    = errors.New("invalid id")
+)
+
+// fields of an object which are indexed
+const (
+	fieldName    = "name"
+	fieldSummary = "summary"
+	fieldContent = "content"
+	fieldTag     = "tag"
+)
+
+// Query is a search over an Index.  Text is a sequence of clauses separated by
+// whitespace, all of which must match for an object to be returned:
+//   - a plain term, which matches objects containing that word
+//   - a term ending in '*', which matches objects containing any word
+//     beginning with that prefix
+//   - a phrase in double quotes, which matches objects containing those
+//     words consecutively within a single field
+//
+// Matching objects are returned newest first.
+type Query struct {
+	Text string
+	// If set, only return objects bearing this hashtag; Text may then be
+	// empty, in which case every object bearing the hashtag matches
+	Tag string
+	// Only return objects indexed before this one
+	MaxId string
+	// The maximum number of objects to return
+	Limit int
+	// If set, only objects for which Visible returns true are returned
+	Visible func(activitystreams.ObjectIface) bool
+}
+
+// Hit is an object matching a search, along with the id by which it is known
+// within the index.
+type Hit struct {
+	Id     string
+	Object activitystreams.ObjectIface
+}
+
+type document struct {
+	id     string
+	seq    int
+	object json.RawMessage
+	// the tokens of each indexed field, in order
+	fields map[string][]string
+	// the normalized names of the object's hashtags
+	tags []string
+}
+
+// Index is an in-memory full-text index over the name, summary, content and
+// tags of ActivityStreams objects.  It is safe for concurrent use.
+type Index struct {
+	lock    sync.RWMutex
+	byId    map[string]*document
+	bySeq   map[int]*document
+	terms   map[string]map[int]struct{}
+	tags    map[string]map[int]struct{}
+	nextSeq int
+}
+
+func NewIndex() *Index {
+	return &Index{
+		byId:  make(map[string]*document),
+		bySeq: make(map[int]*document),
+		terms: make(map[string]map[int]struct{}),
+		tags:  make(map[string]map[int]struct{}),
+	}
+}
+
+// Index adds an object to the index, replacing any previously indexed version
+// of it.  Replacing an object does not change its position in search results.
+func (i *Index) Index(objectIface activitystreams.ObjectIface) error {
+	object := activitystreams.ToObject(objectIface)
+	if object.Id == "" {
+		return errors.New("cannot index an object without an id")
+	}
+
+	raw, err := json.Marshal(objectIface)
+	if err != nil {
+		return fmt.Errorf("failed to marshal object: %w", err)
+	}
+
+	fields := map[string][]string{
+		fieldName:    tokenize(object.Name),
+		fieldSummary: tokenize(markup.Text(object.Summary)),
+		fieldContent: tokenize(markup.Text(object.Content)),
+	}
+	var tags []string
+	for _, tag := range object.Tag {
+		if tag == nil {
+			continue
+		}
+		name := activitystreams.ToEntity(tag).Name
+		fields[fieldTag] = append(fields[fieldTag], tokenize(name)...)
+		if _, ok := tag.(*activitystreams.Hashtag); ok {
+			tags = append(tags, NormalizeTag(name))
+		}
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	seq := i.nextSeq
+	if existing, ok := i.byId[object.Id]; ok {
+		seq = existing.seq
+		i.remove(existing)
+	} else {
+		i.nextSeq++
+	}
+
+	doc := &document{
+		id:     object.Id,
+		seq:    seq,
+		object: raw,
+		fields: fields,
+		tags:   tags,
+	}
+	i.byId[object.Id] = doc
+	i.bySeq[seq] = doc
+	for _, tokens := range fields {
+		for _, token := range tokens {
+			docs, ok := i.terms[token]
+			if !ok {
+				docs = make(map[int]struct{})
+				i.terms[token] = docs
+			}
+			docs[seq] = struct{}{}
+		}
+	}
+	for _, tag := range tags {
+		docs, ok := i.tags[tag]
+		if !ok {
+			docs = make(map[int]struct{})
+			i.tags[tag] = docs
+		}
+		docs[seq] = struct{}{}
+	}
+
+	return nil
+}
+
+// Remove removes the object with the given id from the index, if present.
+func (i *Index) Remove(id string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if doc, ok := i.byId[id]; ok {
+		i.remove(doc)
+	}
+}
+
+func (i *Index) remove(doc *document) {
+	for _, tokens := range doc.fields {
+		for _, token := range tokens {
+			delete(i.terms[token], doc.seq)
+			if len(i.terms[token]) == 0 {
+				delete(i.terms, token)
+			}
+		}
+	}
+	for _, tag := range doc.tags {
+		delete(i.tags[tag], doc.seq)
+		if len(i.tags[tag]) == 0 {
+			delete(i.tags, tag)
+		}
+	}
+	delete(i.bySeq, doc.seq)
+	delete(i.byId, doc.id)
+}
+
+// Search returns the objects matching the given query
+func (i *Index) Search(query Query) ([]*Hit, error) {
+	var clauses []clause
+	var err error
+	if query.Text != "" || query.Tag == "" {
+		clauses, err = parseQuery(query.Text)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	maxSeq := -1
+	if query.MaxId != "" {
+		maxSeq, err = strconv.Atoi(query.MaxId)
+		if err != nil || maxSeq < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidId, query.MaxId)
+		}
+	}
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	var candidates map[int]struct{}
+	if query.Tag != "" {
+		candidates = intersect(candidates, i.tags[NormalizeTag(query.Tag)])
+		if len(candidates) == 0 {
+			return nil, nil
+		}
+	}
+	for _, c := range clauses {
+		candidates = intersect(candidates, c.candidates(i))
+		if len(candidates) == 0 {
+			return nil, nil
+		}
+	}
+
+	seqs := make([]int, 0, len(candidates))
+	for seq := range candidates {
+		if maxSeq < 0 || seq < maxSeq {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(seqs)))
+
+	var hits []*Hit
+	for _, seq := range seqs {
+		if query.Limit > 0 && len(hits) >= query.Limit {
+			break
+		}
+
+		doc := i.bySeq[seq]
+		if !matchesAll(doc, clauses) {
+			continue
+		}
+
+		var object activitystreams.ObjectIface
+		err := activitystreams.DefaultEntityUnmarshaler.Unmarshal(doc.object, &object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal indexed object: %w", err)
+		}
+		if query.Visible != nil && !query.Visible(object) {
+			continue
+		}
+
+		hits = append(hits, &Hit{
+			Id:     strconv.Itoa(seq),
+			Object: object,
+		})
+	}
+
+	return hits, nil
+}
+
+// a single clause of a query
+type clause struct {
+	// the consecutive terms to be matched; only the last may be a prefix
+	terms  []string
+	prefix bool
+}
+
+func parseQuery(text string) ([]clause, error) {
+	var clauses []clause
+
+	rest := strings.TrimSpace(text)
+	for rest != "" {
+		var c clause
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated phrase", ErrInvalidQuery)
+			}
+			c.terms = tokenize(rest[1 : end+1])
+			rest = rest[end+2:]
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			word := rest[:end]
+			rest = rest[end:]
+
+			c.prefix = strings.HasSuffix(word, "*")
+			c.terms = tokenize(strings.TrimSuffix(word, "*"))
+			if c.prefix && len(c.terms) != 1 {
+				return nil, fmt.Errorf("%w: invalid prefix %q", ErrInvalidQuery, word)
+			}
+		}
+		rest = strings.TrimSpace(rest)
+
+		if len(c.terms) > 0 {
+			clauses = append(clauses, c)
+		}
+	}
+
+	if len(clauses) == 0 {
+		return nil, fmt.Errorf("%w: empty query", ErrInvalidQuery)
+	}
+	return clauses, nil
+}
+
+// candidates returns the documents which contain every term of the clause,
+// though not necessarily in order
+func (c clause) candidates(i *Index) map[int]struct{} {
+	var ret map[int]struct{}
+	for j, term := range c.terms {
+		var docs map[int]struct{}
+		if c.prefix && j == len(c.terms)-1 {
+			docs = make(map[int]struct{})
+			for indexed, termDocs := range i.terms {
+				if strings.HasPrefix(indexed, term) {
+					for seq := range termDocs {
+						docs[seq] = struct{}{}
+					}
+				}
+			}
+		} else {
+			docs = i.terms[term]
+		}
+		ret = intersect(ret, docs)
+	}
+	return ret
+}
+
+func (c clause) matches(doc *document) bool {
+	if len(c.terms) == 1 {
+		// candidates are exact for single terms
+		return true
+	}
+
+	for _, tokens := range doc.fields {
+		for start := 0; start+len(c.terms) <= len(tokens); start++ {
+			if c.matchesAt(tokens[start:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c clause) matchesAt(tokens []string) bool {
+	for j, term := range c.terms {
+		if c.prefix && j == len(c.terms)-1 {
+			if !strings.HasPrefix(tokens[j], term) {
+				return false
+			}
+		} else if tokens[j] != term {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAll(doc *document, clauses []clause) bool {
+	for _, c := range clauses {
+		if !c.matches(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+// intersect returns the documents in both a and b; a nil a is treated as the
+// set of all documents
+func intersect(a, b map[int]struct{}) map[int]struct{} {
+	if a == nil {
+		ret := make(map[int]struct{}, len(b))
+		for seq := range b {
+			ret[seq] = struct{}{}
+		}
+		return ret
+	}
+
+	ret := make(map[int]struct{})
+	for seq := range a {
+		if _, ok := b[seq]; ok {
+			ret[seq] = struct{}{}
+		}
+	}
+	return ret
+}
+
+// NormalizeTag returns the form of a hashtag by which it is indexed, so that
+// "#Cats" and "cats" are the same tag
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// tokenize splits text into lowercase words
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
@@ -0,0 +1,183 @@
+package search_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/search"
+)
+
+func note(id, content string) *activitystreams.Note {
+	return &activitystreams.Note{
+		Object: activitystreams.Object{
+			Entity: activitystreams.Entity{
+				Id: id,
+			},
+			Content: content,
+		},
+	}
+}
+
+func ids(hits []*search.Hit) []string {
+	var ret []string
+	for _, hit := range hits {
+		ret = append(ret, activitystreams.ToObject(hit.Object).Id)
+	}
+	return ret
+}
+
+var _ = Describe("Index", func() {
+	var index *search.Index
+
+	find := func(text string) []string {
+		hits, err := index.Search(search.Query{Text: text})
+		Expect(err).ToNot(HaveOccurred())
+		return ids(hits)
+	}
+
+	BeforeEach(func() {
+		index = search.NewIndex()
+		Expect(index.Index(note("1", "<p>The quick brown fox</p>"))).To(Succeed())
+		Expect(index.Index(note("2", "Brown bread &amp; butter"))).To(Succeed())
+		Expect(index.Index(&activitystreams.Article{
+			Object: activitystreams.Object{
+				Entity: activitystreams.Entity{
+					Id:   "3",
+					Name: "Foxes of the world",
+				},
+				Summary: "All about quick foxes",
+				Tag: []activitystreams.EntityIface{
+					&activitystreams.Link{Entity: activitystreams.Entity{Name: "#wildlife"}},
+				},
+			},
+		})).To(Succeed())
+	})
+
+	It("should find terms case-insensitively, newest first", func() {
+		Expect(find("BROWN")).To(Equal([]string{"2", "1"}))
+	})
+
+	It("should search names, summaries and tags", func() {
+		Expect(find("world")).To(Equal([]string{"3"}))
+		Expect(find("about")).To(Equal([]string{"3"}))
+		Expect(find("wildlife")).To(Equal([]string{"3"}))
+	})
+
+	It("should ignore markup and decode entities", func() {
+		Expect(find("p")).To(BeEmpty())
+		Expect(find("butter")).To(Equal([]string{"2"}))
+	})
+
+	It("should require every clause to match", func() {
+		Expect(find("quick brown")).To(Equal([]string{"1"}))
+		Expect(find("quick bread")).To(BeEmpty())
+	})
+
+	It("should match prefixes", func() {
+		Expect(find("fox*")).To(Equal([]string{"3", "1"}))
+		Expect(find("bro*")).To(Equal([]string{"2", "1"}))
+	})
+
+	It("should match phrases only when consecutive", func() {
+		Expect(find(`"quick brown"`)).To(Equal([]string{"1"}))
+		Expect(find(`"brown quick"`)).To(BeEmpty())
+		Expect(find(`"quick foxes"`)).To(Equal([]string{"3"}))
+	})
+
+	It("should not match phrases across fields", func() {
+		Expect(find(`"world all"`)).To(BeEmpty())
+	})
+
+	It("should reject malformed queries", func() {
+		_, err := index.Search(search.Query{Text: `"unterminated`})
+		Expect(err).To(MatchError(search.ErrInvalidQuery))
+		_, err = index.Search(search.Query{Text: "   "})
+		Expect(err).To(MatchError(search.ErrInvalidQuery))
+		_, err = index.Search(search.Query{Text: "brown", MaxId: "nope"})
+		Expect(err).To(MatchError(search.ErrInvalidId))
+	})
+
+	It("should reindex updated objects in place", func() {
+		Expect(index.Index(note("1", "A slow grey wolf"))).To(Succeed())
+		Expect(find("fox")).To(BeEmpty())
+		Expect(find("wolf")).To(Equal([]string{"1"}))
+		Expect(find("brown")).To(Equal([]string{"2"}))
+		Expect(find("wolf OR brown")).To(BeEmpty())
+	})
+
+	It("should forget removed objects", func() {
+		index.Remove("2")
+		Expect(find("brown")).To(Equal([]string{"1"}))
+		Expect(find("butter")).To(BeEmpty())
+	})
+
+	It("should page with MaxId and Limit", func() {
+		hits, err := index.Search(search.Query{Text: "brown", Limit: 1})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ids(hits)).To(Equal([]string{"2"}))
+
+		hits, err = index.Search(search.Query{Text: "brown", Limit: 1, MaxId: hits[0].Id})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ids(hits)).To(Equal([]string{"1"}))
+
+		hits, err = index.Search(search.Query{Text: "brown", Limit: 1, MaxId: hits[0].Id})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hits).To(BeEmpty())
+	})
+
+	It("should only return visible objects", func() {
+		hits, err := index.Search(search.Query{
+			Text: "brown",
+			Visible: func(o activitystreams.ObjectIface) bool {
+				return activitystreams.ToObject(o).Id != "2"
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ids(hits)).To(Equal([]string{"1"}))
+	})
+	Describe("tags", func() {
+		tagged := func(id, content string, tags ...string) *activitystreams.Note {
+			n := note(id, content)
+			for _, tag := range tags {
+				n.Tag = append(n.Tag, &activitystreams.Hashtag{
+					Link: activitystreams.Link{Entity: activitystreams.Entity{Name: tag}},
+				})
+			}
+			return n
+		}
+
+		findTag := func(tag, text string) []string {
+			hits, err := index.Search(search.Query{Tag: tag, Text: text})
+			Expect(err).ToNot(HaveOccurred())
+			return ids(hits)
+		}
+
+		BeforeEach(func() {
+			Expect(index.Index(tagged("4", "a cat picture", "#Cats"))).To(Succeed())
+			Expect(index.Index(tagged("5", "another cat picture", "#cats", "#photos"))).To(Succeed())
+		})
+
+		It("should find objects by hashtag regardless of case", func() {
+			Expect(findTag("cats", "")).To(Equal([]string{"5", "4"}))
+			Expect(findTag("#CATS", "")).To(Equal([]string{"5", "4"}))
+			Expect(findTag("photos", "")).To(Equal([]string{"5"}))
+			Expect(findTag("dogs", "")).To(BeEmpty())
+		})
+
+		It("should only treat Hashtags as tags", func() {
+			Expect(findTag("wildlife", "")).To(BeEmpty())
+		})
+
+		It("should combine tags with text", func() {
+			Expect(findTag("cats", "another")).To(Equal([]string{"5"}))
+		})
+
+		It("should forget the tags of removed and updated objects", func() {
+			index.Remove("5")
+			Expect(index.Index(tagged("4", "no longer a cat"))).To(Succeed())
+			Expect(findTag("cats", "")).To(BeEmpty())
+			Expect(findTag("photos", "")).To(BeEmpty())
+		})
+	})
+})
@@ -1,15 +1,19 @@
 package server
 
 import (
+	"context"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/database"
 	"github.com/brandonsides/pubblr/server/apiutil"
 )
 
-func (router *PubblrRouter) Create(create *activitystreams.Create) (activitystreams.ObjectIface, apiutil.Status) {
+// Create stores the object of the given Create activity along with the
+// activity itself, through the given transaction.
+func (router *PubblrRouter) Create(ctx context.Context, tx database.Queries, create *activitystreams.Create) (activitystreams.ObjectIface, apiutil.Status) {
 	if create.Actor == nil {
 		return nil, apiutil.NewStatus(http.StatusBadRequest, "Create activity must have an actor")
 	}
@@ -30,6 +34,7 @@ func (router *PubblrRouter) Create(create *activitystreams.Create) (activitystre
 	object := activitystreams.ToObject(objectObjIface)
 
 	object.AttributedTo = create.AttributedTo
+	router.addHashtags(objectObjIface)
 
 	mergedTo := merge(object.To, create.To)
 	create.To = mergedTo
@@ -60,12 +65,38 @@ func (router *PubblrRouter) Create(create *activitystreams.Create) (activitystre
 	actorId := activitystreams.ToObject(actorObjIface).Id
 	shortId := shortId(actorId)
 
-	router.Database.CreateObject(objectObjIface, shortId, router.baseUrl)
-	router.Database.CreateOutboxItem(create, shortId, router.baseUrl)
+	_, err := tx.CreateObject(ctx, objectObjIface, shortId, router.baseUrl)
+	if err != nil {
+		return nil, apiutil.Statusf(http.StatusInternalServerError, "failed to create object: %w", err)
+	}
+
+	_, err = tx.CreateOutboxItem(ctx, create, shortId, router.baseUrl)
+	if err != nil {
+		return nil, apiutil.Statusf(http.StatusInternalServerError, "failed to create outbox item: %w", err)
+	}
 
 	return object, apiutil.StatusFromCode(http.StatusCreated)
 }
 
+// indexActivity adds the object created by a committed activity posted to a
+// local outbox or imported from an archive to the search index.  Only Create
+// activities are accepted, so nothing else changes what is indexed.
+func (router *PubblrRouter) indexActivity(activityIface activitystreams.ActivityIface) {
+	create, ok := activityIface.(*activitystreams.Create)
+	if !ok {
+		return
+	}
+	object, ok := create.Object.(activitystreams.ObjectIface)
+	if !ok || object == nil {
+		return
+	}
+
+	err := router.Search.Index(object)
+	if err != nil {
+		router.Logger.Errorf("Failed to index %s: %s\n", activitystreams.ToObject(object).Id, err)
+	}
+}
+
 func shortId(id string) string {
 	spl := strings.Split(id, "/")
 	return spl[len(spl)-1]
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/mailer"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/auth"
+	"github.com/go-chi/chi"
+)
+
+// RolesRequest sets the roles granted to a user
+type RolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// RolesResponse lists the roles granted to a user
+type RolesResponse struct {
+	Username string   `json:"username"`
+	Roles    []string `json:"roles"`
+}
+
+func (router *PubblrRouter) GetRoles(r *http.Request) (*RolesResponse, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "username")
+
+	roles, err := router.Database.GetRoles(r.Context(), username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	return &RolesResponse{
+		Username: username,
+		Roles:    append([]string{}, roles...),
+	}, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// PutRoles replaces the roles granted to a user.  Sessions the user has
+// already begun keep the scopes they were issued with, but the admin role is
+// checked on every request, so revoking it takes effect at once.
+func (router *PubblrRouter) PutRoles(r *http.Request) (*RolesResponse, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "username")
+
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
+	}
+	var body RolesRequest
+	err = json.Unmarshal(reqBody, &body)
+	if err != nil {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
+	}
+
+	for _, role := range body.Roles {
+		if !in(role, knownRoles) {
+			return nil, nil, apiutil.NewStatus(http.StatusBadRequest, fmt.Sprintf("Unknown role %s", role))
+		}
+	}
+
+	err = router.Database.SetRoles(r.Context(), username, body.Roles)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to set roles: %w", err)
+	}
+
+	return &RolesResponse{
+		Username: username,
+		Roles:    append([]string{}, body.Roles...),
+	}, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// InviteRequest creates an invite
+type InviteRequest struct {
+	// the number of times the invite may be used; unlimited, if zero
+	MaxUses int `json:"maxUses"`
+	// the number of seconds for which the invite may be used; forever, if
+	// zero
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+// InviteResponse describes an invite.  Its code is only given when it is
+// created, and it is afterwards known by its id.
+type InviteResponse struct {
+	Id        string     `json:"id"`
+	Code      string     `json:"code,omitempty"`
+	CreatedBy string     `json:"createdBy"`
+	Created   time.Time  `json:"created"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	MaxUses   int        `json:"maxUses,omitempty"`
+	Uses      int        `json:"uses"`
+}
+
+func inviteResponse(invite *database.Invite) *InviteResponse {
+	ret := &InviteResponse{
+		Id:        invite.Hash,
+		CreatedBy: invite.CreatedBy,
+		Created:   invite.Created,
+		MaxUses:   invite.MaxUses,
+		Uses:      invite.Uses,
+	}
+	if !invite.Expires.IsZero() {
+		expires := invite.Expires
+		ret.Expires = &expires
+	}
+	return ret
+}
+
+func (router *PubblrRouter) GetInvites(r *http.Request) ([]*InviteResponse, http.Header, apiutil.Status) {
+	invites, err := router.Database.GetInvites(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	ret := make([]*InviteResponse, 0, len(invites))
+	for _, invite := range invites {
+		ret = append(ret, inviteResponse(invite))
+	}
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+func (router *PubblrRouter) PostInvite(r *http.Request) (*InviteResponse, http.Header, apiutil.Status) {
+	username, _ := currentUser(r)
+
+	var body InviteRequest
+	if status := readJSON(r, &body); status != nil {
+		return nil, nil, status
+	}
+	if body.MaxUses < 0 || body.ExpiresIn < 0 {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "maxUses and expiresIn may not be negative")
+	}
+
+	code, err := auth.NewSecret()
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	now := time.Now()
+	invite := database.Invite{
+		Hash:      auth.HashToken(code),
+		CreatedBy: username,
+		Created:   now,
+		MaxUses:   body.MaxUses,
+	}
+	if body.ExpiresIn > 0 {
+		invite.Expires = now.Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+
+	err = router.Database.CreateInvite(r.Context(), invite)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to create invite: %w", err)
+	}
+
+	ret := inviteResponse(&invite)
+	ret.Code = code
+	return ret, nil, apiutil.StatusFromCode(http.StatusCreated)
+}
+
+func (router *PubblrRouter) DeleteInvite(r *http.Request) (*struct{}, http.Header, apiutil.Status) {
+	err := router.Database.DeleteInvite(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to delete invite: %w", err)
+	}
+
+	return nil, nil, apiutil.StatusFromCode(http.StatusNoContent)
+}
+
+type RegistrationResponse struct {
+	Username string                 `json:"username"`
+	Status   database.AccountStatus `json:"status"`
+	Reason   string                 `json:"reason,omitempty"`
+	Created  time.Time              `json:"created"`
+}
+
+func registrationResponse(registration *database.Registration) *RegistrationResponse {
+	return &RegistrationResponse{
+		Username: registration.Username,
+		Status:   registration.Status,
+		Reason:   registration.Reason,
+		Created:  registration.Created,
+	}
+}
+
+// GetRegistrations lists the registrations with the status given in the
+// "status" query parameter, by default those awaiting approval
+func (router *PubblrRouter) GetRegistrations(r *http.Request) ([]*RegistrationResponse, http.Header, apiutil.Status) {
+	status := database.AccountStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "":
+		status = database.AccountPending
+	case database.AccountActive, database.AccountPending, database.AccountRejected:
+	default:
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, fmt.Sprintf("Unknown status %s", status))
+	}
+
+	registrations, err := router.Database.GetRegistrations(r.Context(), status)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	ret := make([]*RegistrationResponse, 0, len(registrations))
+	for _, registration := range registrations {
+		ret = append(ret, registrationResponse(registration))
+	}
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// decideRegistration approves or rejects the registration of the user named in
+// the path.  Registrations once rejected may still be approved.
+func (router *PubblrRouter) decideRegistration(r *http.Request, status database.AccountStatus) (*RegistrationResponse, apiutil.Status) {
+	username := chi.URLParam(r, "username")
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	registration, err := tx.GetRegistration(r.Context(), username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if registration.Status == database.AccountActive {
+		return nil, apiutil.NewStatus(http.StatusConflict, "The account is already active")
+	}
+
+	registration.Status = status
+	err = tx.SetRegistration(r.Context(), *registration)
+	if err != nil {
+		return nil, apiutil.Statusf(http.StatusInternalServerError, "failed to set registration: %w", err)
+	}
+
+	email, err := tx.GetEmail(r.Context(), username)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit registration: %w", err)
+	}
+
+	if email != nil && status == database.AccountActive {
+		router.sendMail(mailer.Message{
+			To:      email.Address,
+			Subject: "Your account has been approved",
+			Body:    fmt.Sprintf("Your pubblr account %s has been approved, and you may now log in.\n", username),
+		})
+	}
+
+	return registrationResponse(registration), nil
+}
+
+func (router *PubblrRouter) ApproveRegistration(r *http.Request) (*RegistrationResponse, http.Header, apiutil.Status) {
+	ret, status := router.decideRegistration(r, database.AccountActive)
+	if status != nil {
+		return nil, nil, status
+	}
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+func (router *PubblrRouter) RejectRegistration(r *http.Request) (*RegistrationResponse, http.Header, apiutil.Status) {
+	ret, status := router.decideRegistration(r, database.AccountRejected)
+	if status != nil {
+		return nil, nil, status
+	}
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
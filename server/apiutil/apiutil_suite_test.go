@@ -0,0 +1,13 @@
+package apiutil_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestApiutil(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Apiutil Suite")
+}
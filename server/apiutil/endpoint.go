@@ -21,32 +21,46 @@ type UntypedEndpoint interface {
 
 type Endpoint[T any] func(*http.Request) (T, http.Header, Status)
 
+// RawResponse is a response body which is written as-is with the given
+// content type, rather than being marshalled to JSON
+type RawResponse struct {
+	ContentType string
+	Body        []byte
+}
+
 // Endpoint[T] implements UntypedEndpoint
 var _ UntypedEndpoint = Endpoint[int](nil)
 
-func LogEndpoint[T any](endpoint Endpoint[T], logger Logger) Endpoint[T] {
-	return Endpoint[T](func(r *http.Request) (ret T, header http.Header, status Status) {
+// Logged logs requests which fail, with the error they failed with and their
+// request id, and recovers from panics, failing the request
+func Logged(logger Logger) Middleware {
+	return func(r *http.Request, next Next) (header http.Header, status Status) {
 		defer func() {
-			if r := recover(); r != nil {
-				logger.Errorf("Recovered from panic: %s\n%v", r, string(debug.Stack()))
-				status = Statusf(http.StatusInternalServerError, "Recovered from panic: %s", r)
-
+			if rec := recover(); rec != nil {
+				logger.Errorf("%s: Recovered from panic: %s\n%v", RequestId(r.Context()), rec, string(debug.Stack()))
+				status = Statusf(http.StatusInternalServerError, "Recovered from panic: %s", rec)
 			}
 		}()
 
-		ret, header, status = endpoint(r)
+		header, status = next(r)
 		if status != nil && status.StatusCode()/100 != 2 {
-			logger.Errorf("%s\n", status.Error())
+			logger.Errorf("%s: %s\n", RequestId(r.Context()), status.Error())
 		}
 		return
-	})
+	}
 }
 
+// ServeHTTP writes the response an endpoint returns: its body, or, if it
+// fails, an ErrorResponse identifying the request
 func (e Endpoint[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, requestId := withRequestId(r)
+	if requestId != "" {
+		w.Header().Set(RequestIdHeader, requestId)
+	}
+
 	defer func() {
-		if r := recover(); r != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("internal server error"))
+		if rec := recover(); rec != nil {
+			writeError(w, requestId, Statusf(http.StatusInternalServerError, "Recovered from panic: %s", rec))
 		}
 	}()
 
@@ -58,24 +72,46 @@ func (e Endpoint[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if statusCode/100 != 2 {
-		w.WriteHeader(statusCode)
-		if status.StatusCode()/100 == 4 {
-			w.Write([]byte(status.Error()))
+		for k, v := range header {
+			w.Header()[k] = v
 		}
+		writeError(w, requestId, status)
 		return
 	}
 
-	marshalled, err := json.Marshal(resp)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if statusCode == http.StatusNoContent {
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(statusCode)
 		return
 	}
 
+	var body []byte
+	switch raw := interface{}(resp).(type) {
+	case RawResponse:
+		w.Header().Set("Content-type", raw.ContentType)
+		body = raw.Body
+	case *RawResponse:
+		w.Header().Set("Content-type", raw.ContentType)
+		body = raw.Body
+	default:
+		marshalled, err := json.Marshal(resp)
+		if err != nil {
+			writeError(w, requestId, Statusf(http.StatusInternalServerError, "failed to marshal response: %w", err))
+			return
+		}
+		if w.Header().Get("Content-type") == "" {
+			w.Header().Set("Content-type", "application/json")
+		}
+		body = marshalled
+	}
+
 	for k, v := range header {
 		w.Header()[k] = v
 	}
 	w.WriteHeader(statusCode)
-	w.Write(marshalled)
+	w.Write(body)
 }
 
 func (e Endpoint[T]) Process(r *http.Request) (interface{}, http.Header, Status) {
@@ -1,12 +1,17 @@
 package apiutil
 
-import "errors"
+import (
+	"errors"
+	"net/http"
+)
 
 type UntypedEndpointMiddleware interface {
 	Wrap(UntypedEndpoint) (UntypedEndpoint, error)
 }
 
-type EndpointMiddleware[T, U any] func(Endpoint[T]) *Endpoint[U]
+// EndpointMiddleware wraps an endpoint returning T in one returning U, such
+// as one serving what the endpoint returns in another representation
+type EndpointMiddleware[T, U any] func(Endpoint[T]) Endpoint[U]
 
 func (mw EndpointMiddleware[T, U]) Wrap(u UntypedEndpoint) (UntypedEndpoint, error) {
 	e1, ok := u.(Endpoint[T])
@@ -14,6 +19,69 @@ func (mw EndpointMiddleware[T, U]) Wrap(u UntypedEndpoint) (UntypedEndpoint, err
 		return nil, errors.New("EndpointMiddleware: expected endpoint of type Endpoint[T]")
 	}
 
-	e2 := mw(e1)
-	return e2, nil
+	return mw(e1), nil
+}
+
+// Next calls the endpoint a Middleware wraps, returning the header and status
+// of its response
+type Next func(r *http.Request) (http.Header, Status)
+
+// Middleware wraps endpoints whatever they return.  It may refuse a request
+// with a status of its own, or pass the request, perhaps changed, on to the
+// endpoint, and may change the header and status of the endpoint's response,
+// though not its body.
+type Middleware func(r *http.Request, next Next) (http.Header, Status)
+
+// Typed returns middlewares as one wrapping endpoints which return T, the
+// first outermost
+func Typed[T any](middlewares ...Middleware) EndpointMiddleware[T, T] {
+	return func(endpoint Endpoint[T]) Endpoint[T] {
+		return Apply(endpoint, middlewares...)
+	}
+}
+
+// Apply wraps an endpoint in middlewares, the first outermost.  What the
+// endpoint returns is dropped if a middleware fails the request after it.
+func Apply[T any](endpoint Endpoint[T], middlewares ...Middleware) Endpoint[T] {
+	if len(middlewares) == 0 {
+		return endpoint
+	}
+
+	return Endpoint[T](func(r *http.Request) (T, http.Header, Status) {
+		var ret T
+		next := Next(func(r *http.Request) (header http.Header, status Status) {
+			ret, header, status = endpoint(r)
+			return header, status
+		})
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			middleware, inner := middlewares[i], next
+			next = func(r *http.Request) (http.Header, Status) {
+				return middleware(r, inner)
+			}
+		}
+
+		header, status := next(r)
+		if !IsOK(status) {
+			var zero T
+			return zero, header, status
+		}
+		return ret, header, status
+	})
+}
+
+// CacheControl lets caches keep successful responses as the given directives
+// say, unless the endpoint says otherwise
+func CacheControl(directives string) Middleware {
+	return func(r *http.Request, next Next) (http.Header, Status) {
+		header, status := next(r)
+		if !IsOK(status) || header.Get("Cache-Control") != "" {
+			return header, status
+		}
+
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set("Cache-Control", directives)
+		return header, status
+	}
 }
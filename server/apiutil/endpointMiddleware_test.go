@@ -0,0 +1,130 @@
+package apiutil_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server/apiutil"
+)
+
+type contextKey struct{}
+
+var _ = Describe("Middleware", func() {
+	var calls []string
+
+	record := func(name string) apiutil.Middleware {
+		return func(r *http.Request, next apiutil.Next) (http.Header, apiutil.Status) {
+			calls = append(calls, name)
+			return next(r)
+		}
+	}
+	endpoint := apiutil.Endpoint[string](func(r *http.Request) (string, http.Header, apiutil.Status) {
+		calls = append(calls, "endpoint")
+		value, _ := r.Context().Value(contextKey{}).(string)
+		return "hello" + value, nil, apiutil.StatusFromCode(http.StatusOK)
+	})
+
+	BeforeEach(func() {
+		calls = nil
+	})
+
+	It("should wrap endpoints, the first middleware outermost", func() {
+		ret, _, status := apiutil.Apply(endpoint, record("outer"), record("inner"))(httptest.NewRequest("GET", "/", nil))
+		Expect(apiutil.IsOK(status)).To(BeTrue())
+		Expect(ret).To(Equal("hello"))
+		Expect(calls).To(Equal([]string{"outer", "inner", "endpoint"}))
+	})
+
+	It("should pass on requests middlewares change", func() {
+		withValue := func(r *http.Request, next apiutil.Next) (http.Header, apiutil.Status) {
+			return next(r.WithContext(context.WithValue(r.Context(), contextKey{}, ", world")))
+		}
+		ret, _, _ := apiutil.Typed[string](withValue)(endpoint)(httptest.NewRequest("GET", "/", nil))
+		Expect(ret).To(Equal("hello, world"))
+	})
+
+	It("should let middlewares refuse requests without calling the endpoint", func() {
+		refuse := func(r *http.Request, next apiutil.Next) (http.Header, apiutil.Status) {
+			return http.Header{"Retry-After": []string{"60"}}, apiutil.StatusFromCode(http.StatusTooManyRequests)
+		}
+		ret, header, status := apiutil.Apply(endpoint, record("outer"), refuse, record("inner"))(httptest.NewRequest("GET", "/", nil))
+		Expect(status.StatusCode()).To(Equal(http.StatusTooManyRequests))
+		Expect(header.Get("Retry-After")).To(Equal("60"))
+		Expect(ret).To(BeEmpty())
+		Expect(calls).To(Equal([]string{"outer"}))
+	})
+
+	It("should drop what endpoints return when a middleware fails the request after them", func() {
+		fail := func(r *http.Request, next apiutil.Next) (http.Header, apiutil.Status) {
+			next(r)
+			return nil, apiutil.StatusFromCode(http.StatusForbidden)
+		}
+		ret, _, status := apiutil.Apply(endpoint, fail)(httptest.NewRequest("GET", "/", nil))
+		Expect(status.StatusCode()).To(Equal(http.StatusForbidden))
+		Expect(ret).To(BeEmpty())
+	})
+
+	Describe("CacheControl", func() {
+		It("should let caches keep successful responses", func() {
+			_, header, _ := apiutil.Apply(endpoint, apiutil.CacheControl("public, max-age=60"))(httptest.NewRequest("GET", "/", nil))
+			Expect(header.Get("Cache-Control")).To(Equal("public, max-age=60"))
+		})
+
+		It("should leave failed responses and those the endpoint sets itself alone", func() {
+			fail := apiutil.Endpoint[string](func(r *http.Request) (string, http.Header, apiutil.Status) {
+				return "", nil, apiutil.StatusFromCode(http.StatusNotFound)
+			})
+			_, header, _ := apiutil.Apply(fail, apiutil.CacheControl("public, max-age=60"))(httptest.NewRequest("GET", "/", nil))
+			Expect(header.Get("Cache-Control")).To(BeEmpty())
+
+			noStore := apiutil.Endpoint[string](func(r *http.Request) (string, http.Header, apiutil.Status) {
+				return "", http.Header{"Cache-Control": []string{"no-store"}}, apiutil.StatusFromCode(http.StatusOK)
+			})
+			_, header, _ = apiutil.Apply(noStore, apiutil.CacheControl("public, max-age=60"))(httptest.NewRequest("GET", "/", nil))
+			Expect(header.Get("Cache-Control")).To(Equal("no-store"))
+		})
+	})
+})
+
+type mux map[string]http.Handler
+
+func (m mux) Method(method, pattern string, handler http.Handler) {
+	m[method+" "+pattern] = handler
+}
+
+var _ = Describe("Routes", func() {
+	It("should serve each route through the shared middlewares and then its own", func() {
+		var calls []string
+		record := func(name string) apiutil.Middleware {
+			return func(r *http.Request, next apiutil.Next) (http.Header, apiutil.Status) {
+				calls = append(calls, name)
+				return next(r)
+			}
+		}
+		hello := apiutil.Endpoint[string](func(r *http.Request) (string, http.Header, apiutil.Status) {
+			calls = append(calls, "endpoint")
+			return "hello", nil, apiutil.StatusFromCode(http.StatusOK)
+		})
+
+		m := mux{}
+		apiutil.Routes{
+			apiutil.NewRoute("GET", "/hello", hello, record("route")),
+			apiutil.NewRoute("POST", "/hello", hello),
+		}.Register(m, record("shared"))
+		Expect(m).To(HaveLen(2))
+
+		w := httptest.NewRecorder()
+		m["GET /hello"].ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal(`"hello"`))
+		Expect(calls).To(Equal([]string{"shared", "route", "endpoint"}))
+
+		calls = nil
+		m["POST /hello"].ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/hello", nil))
+		Expect(calls).To(Equal([]string{"shared", "endpoint"}))
+	})
+})
@@ -0,0 +1,164 @@
+package apiutil_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server/apiutil"
+)
+
+var errTest = errors.New("test error")
+
+type coded struct{}
+
+func (coded) Error() string     { return "coded" }
+func (coded) ErrorCode() string { return "custom_code" }
+
+type responder struct{}
+
+func (responder) Error() string { return "responder" }
+func (responder) ErrorResponse() apiutil.RawResponse {
+	return apiutil.RawResponse{ContentType: "text/plain", Body: []byte("written by responder")}
+}
+
+// recordingLogger keeps the errors logged to it
+type recordingLogger struct {
+	errors []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {}
+func (l *recordingLogger) Infof(format string, args ...interface{})  {}
+func (l *recordingLogger) Warnf(format string, args ...interface{})  {}
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+func (l *recordingLogger) Fatalf(format string, args ...interface{}) {}
+
+var _ = Describe("Endpoint", func() {
+	serve := func(endpoint apiutil.Endpoint[interface{}], header http.Header) (*httptest.ResponseRecorder, *apiutil.ErrorResponse) {
+		r := httptest.NewRequest("GET", "/", nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		endpoint.ServeHTTP(w, r)
+
+		var body apiutil.ErrorResponse
+		if w.Header().Get("Content-type") == "application/json" {
+			Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		}
+		return w, &body
+	}
+	failWith := func(status apiutil.Status) apiutil.Endpoint[interface{}] {
+		return func(r *http.Request) (interface{}, http.Header, apiutil.Status) {
+			return nil, http.Header{"Content-type": []string{"application/activity+json"}}, status
+		}
+	}
+
+	It("should write errors as JSON, coded by their status", func() {
+		w, body := serve(failWith(apiutil.NewStatus(http.StatusNotFound, "No such thing")), nil)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+		Expect(w.Header().Get("Content-type")).To(Equal("application/json"))
+		Expect(body.Code).To(Equal("not_found"))
+		Expect(body.Message).To(Equal("No such thing"))
+		Expect(body.Details).To(BeEmpty())
+	})
+
+	It("should identify each request, using the id a proxy gave it if valid", func() {
+		w, body := serve(failWith(apiutil.StatusFromCode(http.StatusBadRequest)), nil)
+		Expect(body.RequestId).ToNot(BeEmpty())
+		Expect(w.Header().Get(apiutil.RequestIdHeader)).To(Equal(body.RequestId))
+		Expect(body.Message).To(Equal("Bad Request"))
+
+		w, body = serve(failWith(apiutil.StatusFromCode(http.StatusBadRequest)), http.Header{apiutil.RequestIdHeader: []string{"abc-123"}})
+		Expect(body.RequestId).To(Equal("abc-123"))
+		Expect(w.Header().Get(apiutil.RequestIdHeader)).To(Equal("abc-123"))
+
+		_, body = serve(failWith(apiutil.StatusFromCode(http.StatusBadRequest)), http.Header{apiutil.RequestIdHeader: []string{"<script>"}})
+		Expect(body.RequestId).ToNot(Equal("<script>"))
+		Expect(body.RequestId).ToNot(BeEmpty())
+	})
+
+	It("should report the fields of the request which were invalid", func() {
+		_, body := serve(failWith(apiutil.NewFieldStatus(http.StatusBadRequest, "name", "Too long")), nil)
+		Expect(body.Code).To(Equal("bad_request"))
+		Expect(body.Details).To(Equal([]*apiutil.FieldError{{Field: "name", Message: "Too long"}}))
+
+		_, body = serve(failWith(apiutil.NewStatusFromError(http.StatusUnprocessableEntity, apiutil.FieldErrors{
+			{Field: "name", Message: "Too long"},
+			{Field: "email", Message: "Missing"},
+		})), nil)
+		Expect(body.Code).To(Equal("unprocessable_entity"))
+		Expect(body.Message).To(Equal("Too long; Missing"))
+		Expect(body.Details).To(HaveLen(2))
+	})
+
+	It("should use the codes errors give themselves", func() {
+		_, body := serve(failWith(apiutil.NewCodedStatus(http.StatusForbidden, "account_pending", "Awaiting approval")), nil)
+		Expect(body.Code).To(Equal("account_pending"))
+		Expect(body.Message).To(Equal("Awaiting approval"))
+
+		_, body = serve(failWith(apiutil.Statusf(http.StatusForbidden, "wrapped: %w", coded{})), nil)
+		Expect(body.Code).To(Equal("custom_code"))
+	})
+
+	It("should let errors which must be written in their own form write themselves", func() {
+		w, _ := serve(failWith(apiutil.NewStatusFromError(http.StatusBadRequest, responder{})), nil)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Header().Get("Content-type")).To(Equal("text/plain"))
+		Expect(w.Body.String()).To(Equal("written by responder"))
+	})
+
+	It("should keep the messages of internal server errors out of responses", func() {
+		w, body := serve(failWith(apiutil.Statusf(http.StatusInternalServerError, "database at 10.0.0.1 is down")), nil)
+		Expect(w.Code).To(Equal(http.StatusInternalServerError))
+		Expect(body.Code).To(Equal("internal_server_error"))
+		Expect(body.Message).To(Equal("Internal Server Error"))
+		Expect(body.RequestId).ToNot(BeEmpty())
+	})
+
+	It("should keep the messages of every server error out of responses, logging them instead", func() {
+		logger := &recordingLogger{}
+		w, body := serve(apiutil.Apply(
+			failWith(apiutil.Statusf(http.StatusServiceUnavailable, "database at 10.0.0.1 is down")),
+			apiutil.Logged(logger),
+		), nil)
+		Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(body.Code).To(Equal("service_unavailable"))
+		Expect(body.Message).To(Equal("Service Unavailable"))
+		Expect(logger.errors).To(ConsistOf(
+			And(ContainSubstring(body.RequestId), ContainSubstring("database at 10.0.0.1 is down")),
+		))
+	})
+
+	It("should report responses which cannot be marshalled as internal server errors", func() {
+		w, body := serve(func(r *http.Request) (interface{}, http.Header, apiutil.Status) {
+			return make(chan int), nil, apiutil.StatusFromCode(http.StatusOK)
+		}, nil)
+		Expect(w.Code).To(Equal(http.StatusInternalServerError))
+		Expect(body.Code).To(Equal("internal_server_error"))
+	})
+
+	It("should report panics as internal server errors", func() {
+		w, body := serve(func(r *http.Request) (interface{}, http.Header, apiutil.Status) {
+			panic("oops")
+		}, nil)
+		Expect(w.Code).To(Equal(http.StatusInternalServerError))
+		Expect(body.Code).To(Equal("internal_server_error"))
+	})
+})
+
+var _ = Describe("Status", func() {
+	It("should find the errors statuses are made from", func() {
+		status := apiutil.Statusf(http.StatusNotFound, "lookup failed: %w", errTest)
+		Expect(errors.Is(status, errTest)).To(BeTrue())
+		Expect(errors.Is(apiutil.NewStatusFromError(http.StatusNotFound, fmt.Errorf("wrapped: %w", errTest)), errTest)).To(BeTrue())
+		Expect(errors.Is(apiutil.NewStatus(http.StatusNotFound, "test error"), errTest)).To(BeFalse())
+	})
+})
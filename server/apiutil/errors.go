@@ -0,0 +1,144 @@
+package apiutil
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrorResponse is the body of every error response, unless its status is
+// an ErrorResponder
+type ErrorResponse struct {
+	// names the kind of error, for clients to act on; unlike the message, it
+	// does not change
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// the fields of the request which were invalid, if any
+	Details []*FieldError `json:"details,omitempty"`
+	// identifies the request in the server's logs
+	RequestId string `json:"requestId,omitempty"`
+}
+
+// Coder is implemented by errors which give their own code, rather than that
+// of their status, unless the code they give is empty
+type Coder interface {
+	ErrorCode() string
+}
+
+// ErrorResponder is implemented by errors which protocols require be written
+// in a form of their own, rather than as an ErrorResponse
+type ErrorResponder interface {
+	ErrorResponse() RawResponse
+}
+
+// FieldError is an error in one of the fields of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+// FieldErrors are errors in several of the fields of a request
+type FieldErrors []*FieldError
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+type codedError struct {
+	code string
+	error
+}
+
+func (e *codedError) ErrorCode() string {
+	return e.code
+}
+
+func (e *codedError) Unwrap() error {
+	return e.error
+}
+
+// NewCodedStatus returns a status whose error is reported with the given code
+func NewCodedStatus(statusCode int, code, message string) Status {
+	return &status{statusCode: statusCode, e: &codedError{code: code, error: errors.New(message)}}
+}
+
+// NewFieldStatus returns a status reporting an error in one of the fields of
+// a request
+func NewFieldStatus(statusCode int, field, message string) Status {
+	return &status{statusCode: statusCode, e: &FieldError{Field: field, Message: message}}
+}
+
+// NewErrorResponse returns the body reporting an error status.  The messages
+// of server errors, with statuses of 500 and above, are left to the server's
+// logs, where the request id finds them, since they may reveal more than
+// clients should know.
+func NewErrorResponse(status Status, requestId string) *ErrorResponse {
+	ret := &ErrorResponse{
+		Code:      statusCodeName(status.StatusCode()),
+		Message:   status.Error(),
+		RequestId: requestId,
+	}
+
+	var coder Coder
+	if errors.As(status, &coder) && coder.ErrorCode() != "" {
+		ret.Code = coder.ErrorCode()
+	}
+
+	var fieldErrors FieldErrors
+	var fieldError *FieldError
+	if errors.As(status, &fieldErrors) {
+		ret.Details = fieldErrors
+	} else if errors.As(status, &fieldError) {
+		ret.Details = []*FieldError{fieldError}
+	}
+
+	if ret.Message == "" || status.StatusCode() >= http.StatusInternalServerError {
+		ret.Message = http.StatusText(status.StatusCode())
+	}
+	return ret
+}
+
+// statusCodeName returns the default code of errors with the given status,
+// such as "not_found" for 404
+func statusCodeName(statusCode int) string {
+	text := http.StatusText(statusCode)
+	if text == "" {
+		return "error"
+	}
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9')
+	}), "_")
+}
+
+// writeError writes the response to a request which failed with the given
+// status, replacing whatever content type had been set for its body
+func writeError(w http.ResponseWriter, requestId string, status Status) {
+	var responder ErrorResponder
+	if errors.As(status, &responder) {
+		raw := responder.ErrorResponse()
+		w.Header().Set("Content-type", raw.ContentType)
+		w.WriteHeader(status.StatusCode())
+		w.Write(raw.Body)
+		return
+	}
+
+	body, err := json.Marshal(NewErrorResponse(status, requestId))
+	if err != nil {
+		w.Header().Set("Content-type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		return
+	}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status.StatusCode())
+	w.Write(body)
+}
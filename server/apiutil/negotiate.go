@@ -0,0 +1,107 @@
+package apiutil
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mediaRange is one of the media ranges listed in an Accept header, such as
+// "text/*;q=0.8"
+type mediaRange struct {
+	typ, subtype string
+	params       map[string]string
+	q            float64
+}
+
+// parseAccept parses the media ranges of an Accept header, skipping any which
+// are malformed.  An empty header accepts anything.
+func parseAccept(accept string) []mediaRange {
+	if strings.TrimSpace(accept) == "" {
+		return []mediaRange{{typ: "*", subtype: "*", q: 1}}
+	}
+
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		typ, subtype, ok := strings.Cut(mediaType, "/")
+		if !ok {
+			continue
+		}
+
+		rng := mediaRange{typ: typ, subtype: subtype, params: params, q: 1}
+		if q, ok := params["q"]; ok {
+			rng.q, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			delete(params, "q")
+		}
+		ranges = append(ranges, rng)
+	}
+	return ranges
+}
+
+// specificity ranks how closely a media range matches the given media type
+// and parameters, or returns -1 if it does not match at all.  A range naming
+// the type exactly is more specific than one with a wildcard subtype, which is
+// more specific than "*/*", and each of its parameters the type shares adds to
+// its specificity.
+func (rng mediaRange) specificity(typ, subtype string, params map[string]string) int {
+	ret := 0
+	switch {
+	case rng.typ == "*" && rng.subtype == "*":
+	case rng.typ == typ && rng.subtype == "*":
+		ret = 1
+	case rng.typ == typ && rng.subtype == subtype:
+		ret = 2
+	default:
+		return -1
+	}
+
+	for k, v := range rng.params {
+		if params[k] != v {
+			return -1
+		}
+		ret++
+	}
+	return ret
+}
+
+// quality returns the quality the client gives the offered media type, by the
+// most specific of its media ranges which matches it
+func quality(ranges []mediaRange, offered string) float64 {
+	mediaType, params, err := mime.ParseMediaType(offered)
+	if err != nil {
+		return 0
+	}
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+
+	best, q := -1, 0.0
+	for _, rng := range ranges {
+		if s := rng.specificity(typ, subtype, params); s > best {
+			best, q = s, rng.q
+		}
+	}
+	return q
+}
+
+// Negotiate returns whichever of the offered media types the request's Accept
+// header prefers, or the empty string if it accepts none of them.  Offers the
+// client prefers equally are chosen between in the order given, so the
+// server's preferred type should be offered first.
+func Negotiate(r *http.Request, offered ...string) string {
+	ranges := parseAccept(r.Header.Get("Accept"))
+
+	ret, best := "", 0.0
+	for _, offer := range offered {
+		if q := quality(ranges, offer); q > best {
+			ret, best = offer, q
+		}
+	}
+	return ret
+}
@@ -0,0 +1,52 @@
+package apiutil_test
+
+import (
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server/apiutil"
+)
+
+var _ = Describe("Negotiate", func() {
+	const (
+		ldJSON   = `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+		activity = "application/activity+json"
+		html     = "text/html; charset=utf-8"
+	)
+
+	negotiate := func(accept string, offered ...string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		return apiutil.Negotiate(r, offered...)
+	}
+
+	It("should choose the first offer when the client states no preference", func() {
+		Expect(negotiate("", ldJSON, html)).To(Equal(ldJSON))
+		Expect(negotiate("*/*", ldJSON, html)).To(Equal(ldJSON))
+	})
+
+	It("should choose the offer of highest quality", func() {
+		Expect(negotiate("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", ldJSON, html)).To(Equal(html))
+		Expect(negotiate("text/html;q=0.5, application/activity+json", ldJSON, activity, html)).To(Equal(activity))
+		Expect(negotiate("text/*", ldJSON, html)).To(Equal(html))
+	})
+
+	It("should match media type parameters", func() {
+		Expect(negotiate(`application/ld+json; profile="https://www.w3.org/ns/activitystreams"`, "application/ld+json", ldJSON)).To(Equal(ldJSON))
+		Expect(negotiate(`application/ld+json; profile="https://example.org/other"`, ldJSON)).To(BeEmpty())
+	})
+
+	It("should judge each offer by the most specific range matching it", func() {
+		Expect(negotiate("text/html;q=0, */*", html, ldJSON)).To(Equal(ldJSON))
+		Expect(negotiate("text/*;q=0.1, text/html", ldJSON, html)).To(Equal(html))
+	})
+
+	It("should choose nothing when no offer is acceptable", func() {
+		Expect(negotiate("text/html", ldJSON, activity)).To(BeEmpty())
+		Expect(negotiate("image/png;q=bad", ldJSON)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,43 @@
+package apiutil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"regexp"
+)
+
+// RequestIdHeader carries the id of a request, which a proxy in front of the
+// server may already have given it, and is echoed in every response
+const RequestIdHeader = "X-Request-Id"
+
+// requestIdPattern matches the request ids given by proxies which are used
+// rather than replaced, keeping arbitrary text out of logs and responses
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+type requestIdKey struct{}
+
+// RequestId returns the id of the request being served, by which its log
+// entries and any error reported for it are found
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// withRequestId gives a request an id, unless it already has one
+func withRequestId(r *http.Request) (*http.Request, string) {
+	if id := RequestId(r.Context()); id != "" {
+		return r, id
+	}
+
+	id := r.Header.Get(RequestIdHeader)
+	if !requestIdPattern.MatchString(id) {
+		b := make([]byte, 12)
+		if _, err := rand.Read(b); err != nil {
+			return r, ""
+		}
+		id = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestIdKey{}, id)), id
+}
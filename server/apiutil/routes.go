@@ -0,0 +1,45 @@
+package apiutil
+
+import "net/http"
+
+// Route declares an endpoint, the method and path pattern at which it is
+// served, and the middlewares it is served through, the first outermost
+type Route struct {
+	Method      string
+	Pattern     string
+	Middlewares []Middleware
+	// the endpoint, wrapped in the given middlewares
+	wrap func(middlewares ...Middleware) http.Handler
+}
+
+func NewRoute[T any](method, pattern string, endpoint Endpoint[T], middlewares ...Middleware) Route {
+	return Route{
+		Method:      method,
+		Pattern:     pattern,
+		Middlewares: middlewares,
+		wrap: func(middlewares ...Middleware) http.Handler {
+			return Typed[T](middlewares...)(endpoint)
+		},
+	}
+}
+
+// Handler returns the route's endpoint served through the given middlewares,
+// and then through its own
+func (route Route) Handler(middlewares ...Middleware) http.Handler {
+	return route.wrap(append(append([]Middleware(nil), middlewares...), route.Middlewares...)...)
+}
+
+// Mux serves routes by method and path pattern, as a chi.Router does
+type Mux interface {
+	Method(method, pattern string, handler http.Handler)
+}
+
+type Routes []Route
+
+// Register serves each route through the given middlewares, which every route
+// shares, and then through its own
+func (routes Routes) Register(mux Mux, middlewares ...Middleware) {
+	for _, route := range routes {
+		mux.Method(route.Method, route.Pattern, route.Handler(middlewares...))
+	}
+}
@@ -45,3 +45,9 @@ func StatusFromCode(statusCode int) Status {
 func IsOK(s Status) bool {
 	return s == nil || s.StatusCode()/100 == 2
 }
+
+// Unwrap returns the error a status was made from, so that its cause may be
+// found with errors.Is and errors.As
+func (e *status) Unwrap() error {
+	return e.e
+}
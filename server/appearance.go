@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/render"
+	"github.com/go-chi/chi"
+)
+
+// the longest custom stylesheet an actor may set, in bytes
+const maxCustomCSSLength = 64 * 1024
+
+// ThemeResponse describes one of the themes with which blogs may be shown
+type ThemeResponse struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Stylesheet  string `json:"stylesheet"`
+}
+
+// Appearance sets how an actor's blog is shown to readers in browsers
+type Appearance struct {
+	// the name of one of the server's themes; its default theme, if empty
+	Theme string `json:"theme"`
+	// a stylesheet applied over the theme's
+	CSS string `json:"css"`
+}
+
+func (router *PubblrRouter) GetThemes(r *http.Request) ([]*ThemeResponse, http.Header, apiutil.Status) {
+	themes := render.Themes()
+	ret := make([]*ThemeResponse, 0, len(themes))
+	for _, theme := range themes {
+		ret = append(ret, &ThemeResponse{
+			Name:        theme.Name,
+			Description: theme.Description,
+			Stylesheet:  router.endpointUrl("themes", theme.Name+".css"),
+		})
+	}
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// stylesheet serves CSS, which browsers must not take for anything else
+func stylesheet(css []byte) (apiutil.RawResponse, http.Header, apiutil.Status) {
+	return apiutil.RawResponse{
+		ContentType: "text/css; charset=utf-8",
+		Body:        css,
+	}, http.Header{"X-Content-Type-Options": []string{"nosniff"}}, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// GetThemeStylesheet serves the stylesheet of one of the server's themes
+func (router *PubblrRouter) GetThemeStylesheet(r *http.Request) (apiutil.RawResponse, http.Header, apiutil.Status) {
+	theme, ok := render.LookupTheme(chi.URLParam(r, "theme"))
+	if !ok || chi.URLParam(r, "theme") == "" {
+		return apiutil.RawResponse{}, nil, apiutil.NewStatus(http.StatusNotFound, "No such theme")
+	}
+	return stylesheet(theme.CSS)
+}
+
+// GetCustomStylesheet serves the stylesheet an actor has set to be applied
+// over its theme
+func (router *PubblrRouter) GetCustomStylesheet(r *http.Request) (apiutil.RawResponse, http.Header, apiutil.Status) {
+	appearance, err := router.Database.GetAppearance(r.Context(), chi.URLParam(r, "actor"))
+	if errors.Is(err, database.ErrUserNotFound) {
+		return apiutil.RawResponse{}, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return apiutil.RawResponse{}, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	return stylesheet([]byte(appearance.CSS))
+}
+
+func (router *PubblrRouter) GetAppearance(r *http.Request) (*Appearance, http.Header, apiutil.Status) {
+	appearance, err := router.Database.GetAppearance(r.Context(), chi.URLParam(r, "actor"))
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	return &Appearance{
+		Theme: appearance.Theme,
+		CSS:   appearance.CSS,
+	}, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// PutAppearance sets the theme and custom stylesheet of an actor's blog.  The
+// appearance of a group is set by its owners and admins.
+func (router *PubblrRouter) PutAppearance(r *http.Request) (*Appearance, http.Header, apiutil.Status) {
+	actor := chi.URLParam(r, "actor")
+
+	var body Appearance
+	if status := readJSON(r, &body); status != nil {
+		return nil, nil, status
+	}
+	if _, ok := render.LookupTheme(body.Theme); !ok {
+		return nil, nil, apiutil.NewFieldStatus(http.StatusBadRequest, "theme", fmt.Sprintf("Unknown theme %s", body.Theme))
+	}
+	if len(body.CSS) > maxCustomCSSLength {
+		return nil, nil, apiutil.NewFieldStatus(http.StatusBadRequest, "css", fmt.Sprintf("Stylesheets may be at most %d bytes", maxCustomCSSLength))
+	}
+
+	// only groups have members, so others are refused membership
+	member, status := groupMembership(r.Context(), router.Database, r)
+	if status == nil && member.Role == database.GroupPoster {
+		return nil, nil, apiutil.NewStatus(http.StatusForbidden, "Only owners and admins may change the appearance of a group")
+	}
+
+	err := router.Database.SetAppearance(r.Context(), actor, database.Appearance{
+		Theme: body.Theme,
+		CSS:   body.CSS,
+	})
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to set appearance: %w", err)
+	}
+
+	return &body, nil, apiutil.StatusFromCode(http.StatusOK)
+}
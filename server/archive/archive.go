@@ -0,0 +1,396 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/util/either"
+)
+
+// An account archive is a zip file containing the following ActivityStreams
+// documents.  Media is not yet stored by Pubblr, so attachments are archived
+// only as the references held by the objects they are attached to.
+const (
+	actorFile     = "actor.json"
+	outboxFile    = "outbox.json"
+	objectsFile   = "objects.json"
+	followersFile = "followers.json"
+	followingFile = "following.json"
+	blocksFile    = "blocks.json"
+)
+
+// ContentType is the media type of an account archive
+const ContentType = "application/zip"
+
+// Limits on what an imported archive may expand to.  Archives hold only the
+// files above, so any with many more are not account archives.
+const (
+	maxFiles     = 64
+	maxFileSize  = 64 << 20
+	maxTotalSize = 256 << 20
+)
+
+// ErrTooLarge is returned when importing an archive which expands beyond the
+// limits
+var ErrTooLarge = errors.New("archive is too large")
+
+const activityStreamsContext = "https://www.w3.org/ns/activitystreams"
+
+// number of outbox items read from the database at a time
+const exportBatchSize = 100
+
+// Export produces an archive of the given user's account.  It should be given
+// a transaction, so that the archive is a consistent snapshot of the account.
+func Export(ctx context.Context, db database.Queries, username string) ([]byte, error) {
+	actor, err := db.GetUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	actorId := activitystreams.ToObject(actor).Id
+
+	var outbox []activitystreams.ObjectIface
+	query := database.PageQuery{Limit: exportBatchSize}
+	for {
+		items, err := db.GetOutboxItems(ctx, username, query)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			break
+		}
+		for _, item := range items {
+			outbox = append(outbox, item.Activity)
+		}
+		query.MaxId = items[len(items)-1].Id
+	}
+
+	objects, err := db.GetObjects(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	followers, err := db.GetFollowers(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	following, err := db.GetFollowing(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := db.GetBlocks(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name   string
+		entity activitystreams.EntityIface
+	}{
+		{actorFile, actor},
+		{outboxFile, objectCollection(actorId+"/outbox", true, outbox)},
+		{objectsFile, objectCollection(actorId+"/objects", false, objects)},
+		{followersFile, linkCollection(actorId+"/followers", followers)},
+		{followingFile, linkCollection(actorId+"/following", following)},
+		{blocksFile, linkCollection(actorId+"/blocks", blocks)},
+	}
+
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	for _, file := range files {
+		b, err := json.Marshal(&activitystreams.TopLevelEntity{
+			EntityIface: file.entity,
+			Context:     activityStreamsContext,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s: %w", file.name, err)
+		}
+
+		w, err := zipWriter.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = zipWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Imported is what an import added to an account
+type Imported struct {
+	Actor activitystreams.ActorIface
+	// the activities added to the account's outbox, oldest first
+	Outbox []activitystreams.ActivityIface
+}
+
+// Import restores the account in the given archive into the given user's
+// account, which should be new: the archived actor replaces the user's, and
+// the archived objects, outbox and relations are added to theirs.  All ids
+// belonging to the archived account are rewritten to belong to the user.  It
+// should be given a transaction, so that a failed import leaves nothing
+// behind.  The archive is read as its files are needed, so that it need not be
+// held in memory.
+func Import(ctx context.Context, tx database.Queries, archive io.ReaderAt, size int64, username string, baseUrl url.URL) (*Imported, error) {
+	zipReader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	if len(zipReader.File) > maxFiles {
+		return nil, fmt.Errorf("%w: more than %d files", ErrTooLarge, maxFiles)
+	}
+	files := &limitedReader{zip: zipReader, remaining: maxTotalSize}
+
+	actorJson, err := files.readFile(actorFile)
+	if err != nil {
+		return nil, err
+	}
+	var actorEntity activitystreams.TopLevelEntity
+	err = activitystreams.DefaultEntityUnmarshaler.Unmarshal(actorJson, &actorEntity)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", actorFile, err)
+	}
+	actor, ok := actorEntity.EntityIface.(activitystreams.ActorIface)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s: not an actor", actorFile)
+	}
+
+	oldActorId := activitystreams.ToObject(actor).Id
+	actor, err = tx.UpdateUser(ctx, actor, username)
+	if err != nil {
+		return nil, err
+	}
+	newActorId := activitystreams.ToObject(actor).Id
+
+	// ids which have been reassigned, besides those which can be derived
+	// from the actor's id
+	newIds := map[string]string{oldActorId: newActorId}
+	rewrite := func(id string) string {
+		if newId, ok := newIds[id]; ok {
+			return newId
+		}
+		if oldActorId != "" && strings.HasPrefix(id, oldActorId+"/") {
+			return newActorId + strings.TrimPrefix(id, oldActorId)
+		}
+		return id
+	}
+
+	objects, err := files.readCollection(objectsFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, objectJson := range objects {
+		var ref struct {
+			Id string `json:"id"`
+		}
+		err := json.Unmarshal(objectJson, &ref)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object in %s: %w", objectsFile, err)
+		}
+
+		var object activitystreams.ObjectIface
+		err = unmarshalRewritten(objectJson, rewrite, &object)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object in %s: %w", objectsFile, err)
+		}
+
+		object, err = tx.CreateObject(ctx, object, username, baseUrl)
+		if err != nil {
+			return nil, err
+		}
+		if ref.Id != "" {
+			newIds[ref.Id] = activitystreams.ToObject(object).Id
+		}
+	}
+
+	outbox, err := files.readCollection(outboxFile)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Imported{Actor: actor}
+	// The outbox is archived newest first, but must be recreated oldest first
+	for i := len(outbox) - 1; i >= 0; i-- {
+		var activity activitystreams.ActivityIface
+		err := unmarshalRewritten(outbox[i], rewrite, &activity)
+		if err != nil {
+			return nil, fmt.Errorf("invalid activity in %s: %w", outboxFile, err)
+		}
+
+		activity, err = tx.CreateOutboxItem(ctx, activity, username, baseUrl)
+		if err != nil {
+			return nil, err
+		}
+		ret.Outbox = append(ret.Outbox, activity)
+	}
+
+	for _, relation := range []struct {
+		file string
+		add  func(ctx context.Context, user, actorId string) error
+	}{
+		{followersFile, tx.AddFollower},
+		{followingFile, tx.AddFollowing},
+		{blocksFile, tx.AddBlock},
+	} {
+		items, err := files.readCollection(relation.file)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, itemJson := range items {
+			var link struct {
+				Href string `json:"href"`
+			}
+			err := json.Unmarshal(itemJson, &link)
+			if err != nil || link.Href == "" {
+				return nil, fmt.Errorf("invalid link in %s", relation.file)
+			}
+
+			err = relation.add(ctx, username, rewrite(link.Href))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func objectCollection(id string, ordered bool, objects []activitystreams.ObjectIface) *activitystreams.Collection {
+	items := make([]*either.Either[activitystreams.ObjectIface, activitystreams.LinkIface], len(objects))
+	for i, object := range objects {
+		items[i] = either.Left[activitystreams.ObjectIface, activitystreams.LinkIface](object)
+	}
+
+	return &activitystreams.Collection{
+		Object: activitystreams.Object{
+			Entity: activitystreams.Entity{
+				Id: id,
+			},
+		},
+		Ordered:    ordered,
+		TotalItems: uint64(len(items)),
+		Items:      items,
+	}
+}
+
+func linkCollection(id string, hrefs []string) *activitystreams.Collection {
+	items := make([]*either.Either[activitystreams.ObjectIface, activitystreams.LinkIface], len(hrefs))
+	for i, href := range hrefs {
+		items[i] = either.Right[activitystreams.ObjectIface, activitystreams.LinkIface](
+			&activitystreams.Link{Href: href},
+		)
+	}
+
+	return &activitystreams.Collection{
+		Object: activitystreams.Object{
+			Entity: activitystreams.Entity{
+				Id: id,
+			},
+		},
+		Ordered:    true,
+		TotalItems: uint64(len(items)),
+		Items:      items,
+	}
+}
+
+// limitedReader reads the files of an archive, refusing those which expand
+// beyond the limits, so that a small archive cannot exhaust the server's
+// memory
+type limitedReader struct {
+	zip *zip.Reader
+	// the number of bytes which may yet be read, across all files
+	remaining int64
+}
+
+func (files *limitedReader) readFile(name string) ([]byte, error) {
+	f, err := files.zip.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: missing %s", name)
+	}
+	defer f.Close()
+
+	limit := int64(maxFileSize)
+	if files.remaining < limit {
+		limit = files.remaining
+	}
+	// read a byte past the limit, to find out whether the file exceeds it
+	b, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("%w: %s expands beyond the size allowed", ErrTooLarge, name)
+	}
+	files.remaining -= int64(len(b))
+	return b, nil
+}
+
+// readCollection returns the raw items of the collection in the given file
+func (files *limitedReader) readCollection(name string) ([]json.RawMessage, error) {
+	b, err := files.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var collection struct {
+		Items        []json.RawMessage `json:"items"`
+		OrderedItems []json.RawMessage `json:"orderedItems"`
+	}
+	err = json.Unmarshal(b, &collection)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	return append(collection.OrderedItems, collection.Items...), nil
+}
+
+// unmarshalRewritten unmarshals an entity after passing each of the ids and
+// links within it through rewrite
+func unmarshalRewritten(b []byte, rewrite func(string) string, dest interface{}) error {
+	var generic interface{}
+	err := json.Unmarshal(b, &generic)
+	if err != nil {
+		return err
+	}
+
+	rewritten, err := json.Marshal(rewriteIds(generic, rewrite))
+	if err != nil {
+		return err
+	}
+
+	return activitystreams.DefaultEntityUnmarshaler.Unmarshal(rewritten, dest)
+}
+
+func rewriteIds(v interface{}, rewrite func(string) string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if s, ok := value.(string); ok && (key == "id" || key == "href" || key == "url") {
+				v[key] = rewrite(s)
+			} else {
+				v[key] = rewriteIds(value, rewrite)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = rewriteIds(value, rewrite)
+		}
+	}
+	return v
+}
@@ -0,0 +1,131 @@
+package archive_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/url"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server/archive"
+)
+
+func newActor(name string) activitystreams.ActorIface {
+	return &activitystreams.Person{
+		Actor: activitystreams.Actor{
+			Object: activitystreams.Object{
+				Entity: activitystreams.Entity{
+					Name: name,
+				},
+			},
+		},
+	}
+}
+
+// zipFiles returns an archive of the given files, by name
+func zipFiles(files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.Write(content)
+		Expect(err).ToNot(HaveOccurred())
+	}
+	Expect(w.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+const emptyCollection = `{"@context":"https://www.w3.org/ns/activitystreams","type":"OrderedCollection","orderedItems":[]}`
+
+var _ = Describe("Archive", func() {
+	ctx := context.Background()
+	oldUrl := url.URL{Scheme: "https", Host: "old.example"}
+	newUrl := url.URL{Scheme: "https", Host: "new.example"}
+
+	var db *database.PubblrDatabase
+
+	BeforeEach(func() {
+		db = database.NewPubblrDatabase(database.PubblrDatabaseConfig{})
+		_, err := db.CreateUser(ctx, newActor("Alice"), "alice", "password", newUrl)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should restore an exported account under the new account's ids", func() {
+		old := database.NewPubblrDatabase(database.PubblrDatabaseConfig{})
+		actor, err := old.CreateUser(ctx, newActor("Alice Liddell"), "liddell", "password", oldUrl)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 2; i++ {
+			note := &activitystreams.Note{
+				Object: activitystreams.Object{
+					Entity: activitystreams.Entity{
+						AttributedTo: []activitystreams.EntityIface{actor},
+					},
+					Content: "post " + strconv.Itoa(i),
+				},
+			}
+			object, err := old.CreateObject(ctx, note, "liddell", oldUrl)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = old.CreateOutboxItem(ctx, &activitystreams.Create{
+				TransitiveActivity: activitystreams.TransitiveActivity{
+					Object: object,
+				},
+			}, "liddell", oldUrl)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(old.AddFollower(ctx, "liddell", "https://other.example/bob")).To(Succeed())
+
+		b, err := archive.Export(ctx, old, "liddell")
+		Expect(err).ToNot(HaveOccurred())
+
+		imported, err := archive.Import(ctx, db, bytes.NewReader(b), int64(len(b)), "alice", newUrl)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(activitystreams.ToObject(imported.Actor).Id).To(Equal("https://new.example/alice"))
+		Expect(activitystreams.ToObject(imported.Actor).Name).To(Equal("Alice Liddell"))
+		Expect(imported.Outbox).To(HaveLen(2))
+
+		create, ok := imported.Outbox[0].(*activitystreams.Create)
+		Expect(ok).To(BeTrue())
+		object := activitystreams.ToObject(create.Object.(activitystreams.ObjectIface))
+		Expect(object.Content).To(Equal("post 0"))
+		Expect(object.Id).To(HavePrefix("https://new.example/alice/"))
+		Expect(activitystreams.ToEntity(object.AttributedTo[0]).Id).To(Equal("https://new.example/alice"))
+
+		Expect(db.GetOutboxCount(ctx, "alice")).To(Equal(2))
+		Expect(db.GetFollowers(ctx, "alice")).To(Equal([]string{"https://other.example/bob"}))
+	})
+
+	It("should refuse objects which are not JSON", func() {
+		b := zipFiles(map[string][]byte{
+			"actor.json":   []byte(`{"@context":"https://www.w3.org/ns/activitystreams","type":"Person","id":"https://old.example/alice"}`),
+			"objects.json": []byte(`{"type":"Collection","items":["not an object"]}`),
+		})
+		_, err := archive.Import(ctx, db, bytes.NewReader(b), int64(len(b)), "alice", newUrl)
+		Expect(err).To(MatchError(ContainSubstring("invalid object in objects.json")))
+	})
+
+	It("should refuse archives with files which expand too far", func() {
+		b := zipFiles(map[string][]byte{
+			"actor.json": make([]byte, 65<<20),
+		})
+		Expect(len(b)).To(BeNumerically("<", 1<<20))
+
+		_, err := archive.Import(ctx, db, bytes.NewReader(b), int64(len(b)), "alice", newUrl)
+		Expect(err).To(MatchError(archive.ErrTooLarge))
+	})
+
+	It("should refuse archives with too many files", func() {
+		files := map[string][]byte{}
+		for i := 0; i < 100; i++ {
+			files[strconv.Itoa(i)+".json"] = []byte(emptyCollection)
+		}
+		b := zipFiles(files)
+		_, err := archive.Import(ctx, db, bytes.NewReader(b), int64(len(b)), "alice", newUrl)
+		Expect(err).To(MatchError(archive.ErrTooLarge))
+	})
+})
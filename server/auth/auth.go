@@ -1,53 +1,130 @@
 package auth
 
 import (
-	"crypto/rsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
+const (
+	defaultJWTExpirationDuration          = 15 * time.Minute
+	defaultRefreshTokenExpirationDuration = 30 * 24 * time.Hour
+)
+
 type Auth struct {
-	AuthKey               rsa.PrivateKey
-	JWTExpirationDuration time.Duration
+	// the keys with which tokens are signed and verified
+	Keys                           []*Key
+	JWTExpirationDuration          time.Duration
+	RefreshTokenExpirationDuration time.Duration
+	// the issuer and audience of the access tokens issued and accepted
+	Issuer   string
+	Audience string
 }
 
 type AuthConfig struct {
-	AuthKeyLocation       string        `json:"authKeyLocation"`
-	JWTExpirationDuration time.Duration `json:"jwtExpirationDuration"`
+	// the location of a single key, which signs tokens at all times.  Keys
+	// which are to be rotated are given by Keys instead.
+	AuthKeyLocation                string        `json:"authKeyLocation"`
+	Keys                           []KeyConfig   `json:"keys"`
+	JWTExpirationDuration          time.Duration `json:"jwtExpirationDuration"`
+	RefreshTokenExpirationDuration time.Duration `json:"refreshTokenExpirationDuration"`
+	// Defaults to the server's base URL
+	Issuer string `json:"issuer"`
+	// Defaults to the issuer
+	Audience string `json:"audience"`
 }
 
-func loadKey(keyLocation string) (*rsa.PrivateKey, error) {
-	keyBytes, err := ioutil.ReadFile(keyLocation)
-	if err != nil {
-		return nil, err
-	}
-
-	return jwt.ParseRSAPrivateKeyFromPEM(keyBytes)
+// Claims are the claims of an access token
+type Claims struct {
+	jwt.StandardClaims
+	Username string `json:"username"`
+	// the login session in which the token was issued
+	Session string `json:"sid,omitempty"`
+	// the OAuth client to which the token was issued, and the space-separated
+	// scopes it was granted; both are empty for tokens issued on login
+	ClientId string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// the id of the personal access token presented in place of an access
+	// token, if any; never part of a JWT
+	PersonalAccessToken string `json:"-"`
 }
 
 func NewAuth(config AuthConfig) (*Auth, error) {
-	authKey, err := loadKey(config.AuthKeyLocation)
-	if err != nil {
-		return nil, err
+	keyConfigs := config.Keys
+	if config.AuthKeyLocation != "" {
+		keyConfigs = append([]KeyConfig{{Location: config.AuthKeyLocation}}, keyConfigs...)
+	}
+	if len(keyConfigs) == 0 {
+		return nil, errors.New("no signing keys configured")
+	}
+
+	keys := make([]*Key, len(keyConfigs))
+	ids := make(map[string]bool, len(keyConfigs))
+	for i, keyConfig := range keyConfigs {
+		key, err := loadKey(keyConfig)
+		if err != nil {
+			return nil, err
+		}
+		if ids[key.Id] {
+			return nil, fmt.Errorf("duplicate key id %s", key.Id)
+		}
+		ids[key.Id] = true
+		keys[i] = key
+	}
+
+	if config.JWTExpirationDuration == 0 {
+		config.JWTExpirationDuration = defaultJWTExpirationDuration
+	}
+	if config.RefreshTokenExpirationDuration == 0 {
+		config.RefreshTokenExpirationDuration = defaultRefreshTokenExpirationDuration
+	}
+	if config.Audience == "" {
+		config.Audience = config.Issuer
 	}
 
 	return &Auth{
-		AuthKey:               *authKey,
-		JWTExpirationDuration: config.JWTExpirationDuration,
+		Keys:                           keys,
+		JWTExpirationDuration:          config.JWTExpirationDuration,
+		RefreshTokenExpirationDuration: config.RefreshTokenExpirationDuration,
+		Issuer:                         config.Issuer,
+		Audience:                       config.Audience,
 	}, nil
 }
 
-func (auth Auth) GenerateToken(username string) (string, error) {
-	claimsMap := jwt.MapClaims(map[string]interface{}{
-		"username": username,
-	})
+// GenerateToken issues a short-lived access token with the given claims, to
+// which it adds the standard claims identifying and limiting the token.  The
+// token is signed with the current signing key, which it names in its kid
+// header.
+func (auth Auth) GenerateToken(claims Claims) (string, error) {
+	now := time.Now()
+	key, err := auth.signingKey(now)
+	if err != nil {
+		return "", fmt.Errorf("Error generating token: %w", err)
+	}
+
+	jti, err := NewId()
+	if err != nil {
+		return "", fmt.Errorf("Error generating token: %w", err)
+	}
+
+	claims.StandardClaims = jwt.StandardClaims{
+		Id:        jti,
+		Subject:   claims.Username,
+		Issuer:    auth.Issuer,
+		Audience:  auth.Audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(auth.JWTExpirationDuration).Unix(),
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS512, claimsMap)
-	tokenString, err := token.SignedString(&auth.AuthKey)
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Id
+	tokenString, err := token.SignedString(key.Private)
 	if err != nil {
 		return "", fmt.Errorf("Error generating token: %w", err)
 	}
@@ -55,23 +132,69 @@ func (auth Auth) GenerateToken(username string) (string, error) {
 	return tokenString, nil
 }
 
-func (auth Auth) VerifyToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return &auth.AuthKey.PublicKey, nil
+// VerifyToken checks the signature, lifetime, issuer and audience of an access
+// token, and returns its claims if it is valid.  The token must name the key
+// which signed it, and be signed with that key's method, so that a token
+// cannot pass off a public key as an HMAC secret.
+func (auth Auth) VerifyToken(tokenString string) (*Claims, error) {
+	var claims Claims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := auth.verificationKey(kid, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if token.Method != key.Method {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Header["alg"])
+		}
+		return key.Private.Public(), nil
 	})
 	if err != nil {
-		return "", errors.New("Error parsing token")
+		return nil, errors.New("Error parsing token")
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return "", errors.New("Invalid token")
+	if !token.Valid || claims.ExpiresAt == 0 || claims.Username == "" ||
+		!claims.VerifyIssuer(auth.Issuer, true) || !claims.VerifyAudience(auth.Audience, true) {
+		return nil, errors.New("Invalid token")
 	}
 
-	username, ok := claims["username"].(string)
-	if !ok {
-		return "", errors.New("Invalid token")
+	return &claims, nil
+}
+
+// GenerateRefreshToken returns a new opaque refresh token, along with the time
+// at which it expires.  Only the token's hash, as returned by HashToken, should
+// be stored.
+func (auth Auth) GenerateRefreshToken() (string, time.Time, error) {
+	token, err := NewSecret()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Error generating refresh token: %w", err)
 	}
 
-	return username, nil
+	return token, time.Now().Add(auth.RefreshTokenExpirationDuration), nil
+}
+
+// HashToken returns the hash by which an opaque token is stored
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// NewId returns a random identifier, suitable for naming sessions and tokens
+func NewId() (string, error) {
+	return randomString(16)
+}
+
+// NewSecret returns a random secret, long enough to serve as a credential
+func NewSecret() (string, error) {
+	return randomString(32)
+}
+
+// randomString returns n random bytes, encoded for use in URLs
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,198 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server/auth"
+)
+
+// writeKey writes a new RSA private key to a file in dir, and returns its path
+func writeKey(dir string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).ToNot(HaveOccurred())
+
+	path := filepath.Join(dir, "auth.pem")
+	Expect(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0600)).To(Succeed())
+	return path
+}
+
+// writeEdKey writes a new Ed25519 private key to a file in dir, and returns its
+// path
+func writeEdKey(dir string) string {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	path := filepath.Join(dir, "ed25519.pem")
+	Expect(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: b,
+	}), 0600)).To(Succeed())
+	return path
+}
+
+var _ = Describe("Auth", func() {
+	var a *auth.Auth
+	var config auth.AuthConfig
+
+	BeforeEach(func() {
+		config = auth.AuthConfig{
+			AuthKeyLocation: writeKey(GinkgoT().TempDir()),
+			Issuer:          "http://example.org/pubblr",
+		}
+
+		var err error
+		a, err = auth.NewAuth(config)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should issue tokens which it accepts", func() {
+		token, err := a.GenerateToken(auth.Claims{Username: "alice", Session: "session"})
+		Expect(err).ToNot(HaveOccurred())
+
+		claims, err := a.VerifyToken(token)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claims.Username).To(Equal("alice"))
+		Expect(claims.Session).To(Equal("session"))
+		Expect(claims.Issuer).To(Equal("http://example.org/pubblr"))
+		Expect(claims.Audience).To(Equal("http://example.org/pubblr"))
+		Expect(claims.Id).ToNot(BeEmpty())
+		Expect(claims.IssuedAt).To(BeNumerically("~", time.Now().Unix(), 5))
+		Expect(claims.ExpiresAt).To(BeNumerically("~", time.Now().Add(15*time.Minute).Unix(), 5))
+	})
+
+	It("should carry the client and scope of tokens issued to OAuth clients", func() {
+		token, err := a.GenerateToken(auth.Claims{
+			Username: "alice",
+			Session:  "session",
+			ClientId: "client",
+			Scope:    "read write",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		claims, err := a.VerifyToken(token)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claims.ClientId).To(Equal("client"))
+		Expect(claims.Scope).To(Equal("read write"))
+	})
+
+	It("should give each token a distinct id", func() {
+		first, err := a.GenerateToken(auth.Claims{Username: "alice", Session: "session"})
+		Expect(err).ToNot(HaveOccurred())
+		second, err := a.GenerateToken(auth.Claims{Username: "alice", Session: "session"})
+		Expect(err).ToNot(HaveOccurred())
+
+		firstClaims, err := a.VerifyToken(first)
+		Expect(err).ToNot(HaveOccurred())
+		secondClaims, err := a.VerifyToken(second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(firstClaims.Id).ToNot(Equal(secondClaims.Id))
+	})
+
+	It("should reject expired tokens", func() {
+		a.JWTExpirationDuration = -time.Minute
+		token, err := a.GenerateToken(auth.Claims{Username: "alice", Session: "session"})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.VerifyToken(token)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject tokens for another issuer or audience", func() {
+		token, err := a.GenerateToken(auth.Claims{Username: "alice", Session: "session"})
+		Expect(err).ToNot(HaveOccurred())
+
+		other := *a
+		other.Issuer = "http://example.com"
+		_, err = other.VerifyToken(token)
+		Expect(err).To(HaveOccurred())
+
+		other = *a
+		other.Audience = "http://example.com"
+		_, err = other.VerifyToken(token)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject tokens signed by another key", func() {
+		config.AuthKeyLocation = writeKey(GinkgoT().TempDir())
+		other, err := auth.NewAuth(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		token, err := other.GenerateToken(auth.Claims{Username: "alice", Session: "session"})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.VerifyToken(token)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject tokens without an expiry", func() {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS512, jwt.MapClaims{
+			"username": "alice",
+			"iss":      a.Issuer,
+			"aud":      a.Audience,
+		})
+		token.Header["kid"] = a.Keys[0].Id
+		tokenString, err := token.SignedString(a.Keys[0].Private)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.VerifyToken(tokenString)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject tokens which do not name their key", func() {
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodRS512, auth.Claims{
+			StandardClaims: jwt.StandardClaims{
+				Issuer:    a.Issuer,
+				Audience:  a.Audience,
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			},
+			Username: "alice",
+		}).SignedString(a.Keys[0].Private)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.VerifyToken(tokenString)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject unsigned tokens", func() {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodNone, auth.Claims{
+			StandardClaims: jwt.StandardClaims{
+				Issuer:    a.Issuer,
+				Audience:  a.Audience,
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			},
+			Username: "alice",
+		}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.VerifyToken(token)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should issue distinct refresh tokens which expire later", func() {
+		first, expires, err := a.GenerateRefreshToken()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(expires).To(BeTemporally("~", time.Now().Add(30*24*time.Hour), time.Minute))
+
+		second, _, err := a.GenerateRefreshToken()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(first).ToNot(Equal(second))
+		Expect(auth.HashToken(first)).ToNot(Equal(auth.HashToken(second)))
+		Expect(auth.HashToken(first)).To(Equal(auth.HashToken(first)))
+	})
+})
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// KeyConfig configures one of the keys with which tokens are signed.  Keys are
+// rotated by adding a new key, with a NotBefore time far enough ahead that
+// services verifying our tokens will have fetched it by then, and giving the
+// old key a NotAfter time.  An old key stops signing tokens at its NotAfter
+// time, but goes on verifying them until the last tokens it signed expire, so
+// that no session ends because of the rotation.
+type KeyConfig struct {
+	// Defaults to the key's JWK thumbprint
+	Id       string `json:"id"`
+	Location string `json:"location"`
+	// the times between which the key signs tokens; zero times are unbounded
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+// Key is a private key with which tokens are signed.  RSA keys sign with
+// RS512, and Ed25519 keys with EdDSA; tokens naming a key are only accepted if
+// signed with that key's method.
+type Key struct {
+	Id        string
+	Private   crypto.Signer
+	Method    jwt.SigningMethod
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+func loadKey(config KeyConfig) (*Key, error) {
+	keyBytes, err := ioutil.ReadFile(config.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{
+		Id:        config.Id,
+		NotBefore: config.NotBefore,
+		NotAfter:  config.NotAfter,
+	}
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(keyBytes); err == nil {
+		key.Private = rsaKey
+		key.Method = jwt.SigningMethodRS512
+	} else if edKey, err := jwt.ParseEdPrivateKeyFromPEM(keyBytes); err == nil {
+		key.Private = edKey.(ed25519.PrivateKey)
+		key.Method = jwt.SigningMethodEdDSA
+	} else {
+		return nil, fmt.Errorf("key %s is neither an RSA nor an Ed25519 private key", config.Location)
+	}
+
+	if key.Id == "" {
+		key.Id = key.JWK().Thumbprint()
+	}
+	return key, nil
+}
+
+// signs reports whether the key signs tokens at the given time
+func (key *Key) signs(now time.Time) bool {
+	return !now.Before(key.NotBefore) && (key.NotAfter.IsZero() || now.Before(key.NotAfter))
+}
+
+// verifies reports whether tokens signed with the key may still be valid at
+// the given time, for tokens which live for the given duration
+func (key *Key) verifies(now time.Time, lifetime time.Duration) bool {
+	return key.NotAfter.IsZero() || now.Before(key.NotAfter.Add(lifetime))
+}
+
+// JWK is the public half of a key, as described by RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA public keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 public keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is a set of keys, as published at a jwks_uri
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK returns the public half of the key
+func (key *Key) JWK() JWK {
+	jwk := JWK{
+		Use: "sig",
+		Kid: key.Id,
+		Alg: key.Method.Alg(),
+	}
+
+	switch public := key.Private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+// Thumbprint returns the JWK thumbprint of the key, as described by RFC 7638
+func (jwk JWK) Thumbprint() string {
+	var canonical string
+	switch jwk.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, jwk.Crv, jwk.X)
+	}
+	hash := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// signingKey returns the key with which tokens are signed at the given time:
+// of the keys which sign then, the one which began signing last
+func (auth Auth) signingKey(now time.Time) (*Key, error) {
+	var ret *Key
+	for _, key := range auth.Keys {
+		if key.signs(now) && (ret == nil || !key.NotBefore.Before(ret.NotBefore)) {
+			ret = key
+		}
+	}
+	if ret == nil {
+		return nil, errors.New("no key signs tokens at this time")
+	}
+	return ret, nil
+}
+
+// verificationKey returns the key with the given id, if tokens signed with it
+// may still be valid at the given time
+func (auth Auth) verificationKey(id string, now time.Time) (*Key, error) {
+	for _, key := range auth.Keys {
+		if key.Id == id && key.verifies(now, auth.JWTExpirationDuration) {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %s", id)
+}
+
+// JWKS returns the public halves of the keys with which valid tokens may have
+// been signed, including those which have yet to begin signing
+func (auth Auth) JWKS() JWKSet {
+	now := time.Now()
+	ret := JWKSet{Keys: []JWK{}}
+	for _, key := range auth.Keys {
+		if key.verifies(now, auth.JWTExpirationDuration) {
+			ret.Keys = append(ret.Keys, key.JWK())
+		}
+	}
+	return ret
+}
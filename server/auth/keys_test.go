@@ -0,0 +1,202 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server/auth"
+)
+
+// keyId returns the kid header of a token, without verifying it
+func keyId(tokenString string) string {
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, &auth.Claims{})
+	Expect(err).ToNot(HaveOccurred())
+	kid, _ := token.Header["kid"].(string)
+	return kid
+}
+
+var _ = Describe("Keys", func() {
+	const issuer = "http://example.org/pubblr"
+	var oldKey, newKey string
+
+	newAuth := func(keys ...auth.KeyConfig) *auth.Auth {
+		a, err := auth.NewAuth(auth.AuthConfig{Keys: keys, Issuer: issuer})
+		Expect(err).ToNot(HaveOccurred())
+		return a
+	}
+
+	BeforeEach(func() {
+		oldKey = writeKey(GinkgoT().TempDir())
+		newKey = writeEdKey(GinkgoT().TempDir())
+	})
+
+	It("should sign with EdDSA using Ed25519 keys", func() {
+		a := newAuth(auth.KeyConfig{Location: newKey})
+
+		token, err := a.GenerateToken(auth.Claims{Username: "alice", Session: "session"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keyId(token)).To(Equal(a.Keys[0].Id))
+
+		claims, err := a.VerifyToken(token)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claims.Username).To(Equal("alice"))
+	})
+
+	It("should name keys by their thumbprints unless given ids", func() {
+		a := newAuth(auth.KeyConfig{Location: oldKey}, auth.KeyConfig{Location: newKey, Id: "next"})
+		Expect(a.Keys[0].Id).To(Equal(a.Keys[0].JWK().Thumbprint()))
+		Expect(a.Keys[1].Id).To(Equal("next"))
+	})
+
+	It("should refuse duplicate key ids", func() {
+		_, err := auth.NewAuth(auth.AuthConfig{Keys: []auth.KeyConfig{
+			{Location: oldKey, Id: "key"},
+			{Location: newKey, Id: "key"},
+		}})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should refuse to run without keys", func() {
+		_, err := auth.NewAuth(auth.AuthConfig{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("rotation", func() {
+		It("should sign with a new key while accepting tokens signed with the old", func() {
+			before := newAuth(auth.KeyConfig{Location: oldKey})
+			oldToken, err := before.GenerateToken(auth.Claims{Username: "alice", Session: "session"})
+			Expect(err).ToNot(HaveOccurred())
+
+			after := newAuth(
+				auth.KeyConfig{Location: oldKey, NotAfter: time.Now().Add(-time.Minute)},
+				auth.KeyConfig{Location: newKey, NotBefore: time.Now().Add(-time.Minute)},
+			)
+			_, err = after.VerifyToken(oldToken)
+			Expect(err).ToNot(HaveOccurred())
+
+			newToken, err := after.GenerateToken(auth.Claims{Username: "alice", Session: "session"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keyId(newToken)).To(Equal(after.Keys[1].Id))
+			Expect(keyId(newToken)).ToNot(Equal(keyId(oldToken)))
+		})
+
+		It("should stop accepting tokens signed with an old key once they have all expired", func() {
+			before := newAuth(auth.KeyConfig{Location: oldKey})
+			oldToken, err := before.GenerateToken(auth.Claims{Username: "alice", Session: "session"})
+			Expect(err).ToNot(HaveOccurred())
+
+			after := newAuth(
+				auth.KeyConfig{Location: oldKey, NotAfter: time.Now().Add(-time.Hour)},
+				auth.KeyConfig{Location: newKey},
+			)
+			_, err = after.VerifyToken(oldToken)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should publish keys before they begin signing", func() {
+			a := newAuth(
+				auth.KeyConfig{Location: oldKey},
+				auth.KeyConfig{Location: newKey, NotBefore: time.Now().Add(time.Hour)},
+			)
+
+			token, err := a.GenerateToken(auth.Claims{Username: "alice", Session: "session"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keyId(token)).To(Equal(a.Keys[0].Id))
+
+			Expect(a.JWKS().Keys).To(HaveLen(2))
+		})
+	})
+
+	Describe("algorithm pinning", func() {
+		var a *auth.Auth
+		claims := jwt.MapClaims{
+			"username": "alice",
+			"iss":      issuer,
+			"aud":      issuer,
+			"exp":      time.Now().Add(time.Hour).Unix(),
+		}
+
+		BeforeEach(func() {
+			a = newAuth(auth.KeyConfig{Location: oldKey})
+		})
+
+		It("should reject tokens using an RSA public key as an HMAC secret", func() {
+			public, err := x509.MarshalPKIXPublicKey(a.Keys[0].Private.Public())
+			Expect(err).ToNot(HaveOccurred())
+			secret := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})
+
+			token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+			token.Header["kid"] = a.Keys[0].Id
+			tokenString, err := token.SignedString(secret)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = a.VerifyToken(tokenString)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject tokens signed with another method than their key's", func() {
+			_, edKey, err := ed25519.GenerateKey(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+
+			token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+			token.Header["kid"] = a.Keys[0].Id
+			tokenString, err := token.SignedString(edKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = a.VerifyToken(tokenString)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("JWKS", func() {
+		It("should publish the public halves of live keys", func() {
+			a := newAuth(
+				auth.KeyConfig{Location: oldKey},
+				auth.KeyConfig{Location: newKey, Id: "next"},
+			)
+
+			jwks := a.JWKS()
+			Expect(jwks.Keys).To(HaveLen(2))
+
+			rsaJWK := jwks.Keys[0]
+			Expect(rsaJWK.Kty).To(Equal("RSA"))
+			Expect(rsaJWK.Alg).To(Equal("RS512"))
+			Expect(rsaJWK.Use).To(Equal("sig"))
+			Expect(rsaJWK.Kid).To(Equal(a.Keys[0].Id))
+			Expect(rsaJWK.E).To(Equal("AQAB"))
+			Expect(rsaJWK.N).ToNot(BeEmpty())
+			Expect(a.Keys[0].Private.Public().(*rsa.PublicKey).N.BitLen()).To(Equal(2048))
+
+			edJWK := jwks.Keys[1]
+			Expect(edJWK.Kty).To(Equal("OKP"))
+			Expect(edJWK.Crv).To(Equal("Ed25519"))
+			Expect(edJWK.Alg).To(Equal("EdDSA"))
+			Expect(edJWK.Kid).To(Equal("next"))
+			Expect(edJWK.X).ToNot(BeEmpty())
+
+			b, err := json.Marshal(jwks)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).ToNot(ContainSubstring(`"d"`))
+		})
+
+		It("should stop publishing keys once no token they signed is valid", func() {
+			a := newAuth(
+				auth.KeyConfig{Location: oldKey, NotAfter: time.Now().Add(-time.Hour)},
+				auth.KeyConfig{Location: newKey, Id: "next"},
+			)
+
+			jwks := a.JWKS()
+			Expect(jwks.Keys).To(HaveLen(1))
+			Expect(jwks.Keys[0].Kid).To(Equal("next"))
+		})
+	})
+})
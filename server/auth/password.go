@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	DefaultMinPasswordLength = 10
+	// the longest password accepted, so that checking passwords stays cheap
+	MaxPasswordLength = 256
+)
+
+var ErrWeakPassword = errors.New("password is too weak")
+
+// commonPasswords are among the passwords most often found in breaches, which
+// are the first guessed by anyone trying to break into an account
+var commonPasswords = map[string]bool{
+	"123456789012": true, "1234567890": true, "0123456789": true, "9876543210": true,
+	"1q2w3e4r5t": true, "1qaz2wsx3edc": true, "qwertyuiop": true, "asdfghjkl;": true,
+	"qwerty1234": true, "qwerty12345": true, "qwertyuiop1": true, "password12": true,
+	"password123": true, "password1234": true, "password!": true, "passw0rd123": true,
+	"iloveyou12": true, "letmein123": true, "welcome123": true, "admin12345": true,
+	"administrator": true, "changeme123": true, "football123": true, "baseball123": true,
+	"starwars123": true, "sunshine123": true, "princess123": true, "trustno1234": true,
+	"abcdefghij": true, "abc1234567": true, "monkey12345": true, "dragon12345": true,
+}
+
+// CheckPasswordStrength returns an error wrapping ErrWeakPassword, saying why,
+// if password is shorter than minLength characters, is one of the most common
+// passwords, repeats too few distinct characters or contains any of the given
+// words, such as the user's name.
+func CheckPasswordStrength(password string, minLength int, words ...string) error {
+	if minLength <= 0 {
+		minLength = DefaultMinPasswordLength
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < minLength {
+		return fmt.Errorf("%w: it must be at least %d characters long", ErrWeakPassword, minLength)
+	}
+	if length > MaxPasswordLength {
+		return fmt.Errorf("%w: it must be at most %d characters long", ErrWeakPassword, MaxPasswordLength)
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return fmt.Errorf("%w: it is too common", ErrWeakPassword)
+	}
+
+	distinct := make(map[rune]bool)
+	for _, r := range lower {
+		distinct[r] = true
+	}
+	if len(distinct) < 4 {
+		return fmt.Errorf("%w: it has too few distinct characters", ErrWeakPassword)
+	}
+
+	for _, word := range words {
+		if len(word) >= 3 && strings.Contains(lower, strings.ToLower(word)) {
+			return fmt.Errorf("%w: it must not contain %q", ErrWeakPassword, word)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package auth_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server/auth"
+)
+
+var _ = Describe("CheckPasswordStrength", func() {
+	It("should accept long, uncommon passwords", func() {
+		Expect(auth.CheckPasswordStrength("correct horse battery staple", 0, "alice")).To(Succeed())
+	})
+
+	DescribeTable("should refuse weak passwords",
+		func(password string, minLength int) {
+			Expect(auth.CheckPasswordStrength(password, minLength, "alice")).To(MatchError(auth.ErrWeakPassword))
+		},
+		Entry("shorter than the default minimum", "tr0ub4dor", 0),
+		Entry("shorter than the configured minimum", "tr0ub4dor&3", 12),
+		Entry("too long", strings.Repeat("abcdefgh", 40), 0),
+		Entry("common, regardless of case", "Password123", 0),
+		Entry("with too few distinct characters", "abababababab", 0),
+		Entry("containing the username", "xyzzyALICE42", 0),
+	)
+
+	It("should count characters rather than bytes", func() {
+		Expect(auth.CheckPasswordStrength("éüöäßçñøåæœ", 12)).To(MatchError(auth.ErrWeakPassword))
+		Expect(auth.CheckPasswordStrength("éüöäßçñøåæœ!", 12)).To(Succeed())
+	})
+})
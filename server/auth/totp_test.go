@@ -0,0 +1,86 @@
+package auth_test
+
+import (
+	"encoding/base32"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server/auth"
+)
+
+var _ = Describe("TOTP", func() {
+	// the SHA1 secret from the test vectors of RFC 6238
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	DescribeTable("should match the RFC 6238 test vectors",
+		func(unix int64, code string) {
+			Expect(auth.TOTPCode(secret, time.Unix(unix, 0))).To(Equal(code))
+		},
+		Entry("at 59", int64(59), "287082"),
+		Entry("at 1111111109", int64(1111111109), "081804"),
+		Entry("at 1111111111", int64(1111111111), "050471"),
+		Entry("at 1234567890", int64(1234567890), "005924"),
+		Entry("at 2000000000", int64(2000000000), "279037"),
+	)
+
+	It("should accept codes from adjacent steps, but not further", func() {
+		now := time.Unix(1234567890, 0)
+		for _, offset := range []time.Duration{-30 * time.Second, 0, 30 * time.Second} {
+			code, err := auth.TOTPCode(secret, now.Add(offset))
+			Expect(err).ToNot(HaveOccurred())
+			_, ok := auth.VerifyTOTP(secret, code, now, 0)
+			Expect(ok).To(BeTrue())
+		}
+
+		code, err := auth.TOTPCode(secret, now.Add(-90*time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		_, ok := auth.VerifyTOTP(secret, code, now, 0)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should refuse codes which have already been used", func() {
+		now := time.Unix(1234567890, 0)
+		code, err := auth.TOTPCode(secret, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		step, ok := auth.VerifyTOTP(secret, code, now, 0)
+		Expect(ok).To(BeTrue())
+		_, ok = auth.VerifyTOTP(secret, code, now, step)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should generate secrets which produce codes", func() {
+		generated, err := auth.GenerateTOTPSecret()
+		Expect(err).ToNot(HaveOccurred())
+
+		code, err := auth.TOTPCode(generated, time.Now())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(code).To(MatchRegexp(`^[0-9]{6}$`))
+	})
+
+	It("should provision authenticators with an otpauth URI", func() {
+		u, err := url.Parse(auth.TOTPURI("pubblr", "alice", secret))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(u.Scheme).To(Equal("otpauth"))
+		Expect(u.Host).To(Equal("totp"))
+		Expect(u.Path).To(Equal("/pubblr:alice"))
+		Expect(u.Query().Get("secret")).To(Equal(secret))
+		Expect(u.Query().Get("issuer")).To(Equal("pubblr"))
+	})
+
+	Describe("recovery codes", func() {
+		It("should be distinct, and hashed regardless of formatting", func() {
+			codes, err := auth.GenerateRecoveryCodes(10)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(codes).To(HaveLen(10))
+			Expect(codes[0]).To(MatchRegexp(`^[a-z2-7]{5}-[a-z2-7]{5}$`))
+			Expect(codes[0]).ToNot(Equal(codes[1]))
+
+			Expect(auth.HashRecoveryCode("ABCDE-FGHIJ")).To(Equal(auth.HashRecoveryCode("abcde fghij")))
+			Expect(auth.HashRecoveryCode(codes[0])).ToNot(Equal(auth.HashRecoveryCode(codes[1])))
+		})
+	})
+})
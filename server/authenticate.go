@@ -0,0 +1,320 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/brandonsides/pubblr/server/httpsig"
+)
+
+// the realm named in authentication challenges
+const authRealm = "pubblr"
+
+// sessionCookie is the name of the cookie carrying the access token of
+// browser clients which ask for one on login
+const sessionCookie = "pubblr_session"
+
+// AuthMethod is how the principal making a request authenticated
+type AuthMethod string
+
+const (
+	AuthBearer    AuthMethod = "bearer"
+	AuthCookie    AuthMethod = "cookie"
+	AuthSignature AuthMethod = "signature"
+)
+
+// Principal is who a request is made by
+type Principal struct {
+	// the local user making the request; empty for remote actors
+	Username string
+	// the id of the actor making the request, for remote actors
+	Actor string
+	// the local actors the user may act as: their own and those of their
+	// side blogs, but not the groups of which they are members
+	Actors []string
+	Method AuthMethod
+	// the login session in which the access token was issued
+	Session string
+	// the space-separated scopes the request carries
+	Scope string
+	// the OAuth client to which the access token was issued, if any
+	ClientId string
+	// the id of the personal access token presented, if any
+	TokenId string
+}
+
+// ActsAs reports whether the principal may act as the named local actor
+func (principal *Principal) ActsAs(actor string) bool {
+	return principal.Username != "" && (actor == principal.Username || in(actor, principal.Actors))
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the principal making a request
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal making a request, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// currentUser returns the local user making a request, if any
+func currentUser(r *http.Request) (string, bool) {
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok || principal.Username == "" {
+		return "", false
+	}
+	return principal.Username, true
+}
+
+// currentActors returns the local actors as which the user making a request
+// may act, if any
+func currentActors(r *http.Request) []string {
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok || principal.Username == "" {
+		return nil
+	}
+	if len(principal.Actors) == 0 {
+		return []string{principal.Username}
+	}
+	return principal.Actors
+}
+
+// actsAs reports whether the user making a request may act as the named
+// local actor
+func actsAs(r *http.Request, actor string) bool {
+	principal, ok := PrincipalFromContext(r.Context())
+	return ok && principal.ActsAs(actor)
+}
+
+// ErrNoCredentials is returned by authenticators for requests which carry
+// none of the credentials they check
+var ErrNoCredentials = errors.New("no credentials")
+
+// Challenge is returned by authenticators for requests whose credentials they
+// refuse, and is given to the client in a WWW-Authenticate header
+type Challenge struct {
+	Scheme string
+	// the error code and description given with the challenge, if any
+	Code        string
+	Description string
+	// further parameters of the challenge, in order, as name and value
+	Params []string
+}
+
+func (c *Challenge) Error() string {
+	if c.Description != "" {
+		return c.Description
+	}
+	return "invalid credentials"
+}
+
+// ErrorCode reports the challenge's error code in the body of the response,
+// as well as in its header
+func (c *Challenge) ErrorCode() string {
+	return c.Code
+}
+
+// String renders the challenge as the value of a WWW-Authenticate header
+func (c *Challenge) String() string {
+	params := append([]string{"realm", authRealm}, c.Params...)
+	if c.Code != "" {
+		params = append(params, "error", c.Code)
+	}
+	if c.Description != "" {
+		params = append(params, "error_description", c.Description)
+	}
+
+	parts := make([]string, 0, len(params)/2)
+	for i := 0; i+1 < len(params); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(params[i+1])
+		parts = append(parts, params[i]+`="`+value+`"`)
+	}
+	return c.Scheme + " " + strings.Join(parts, ", ")
+}
+
+// challengeHeader returns the WWW-Authenticate header for challenges
+func challengeHeader(challenges ...*Challenge) http.Header {
+	header := make(http.Header)
+	for _, challenge := range challenges {
+		header.Add("WWW-Authenticate", challenge.String())
+	}
+	return header
+}
+
+// Authenticator identifies the principal making a request from one kind of
+// credentials.  It returns ErrNoCredentials if the request carries none of
+// that kind, and a *Challenge if the credentials it carries are invalid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Authenticators tries each of a list of authenticators in turn, identifying
+// a request by the first whose credentials it carries
+type Authenticators []Authenticator
+
+func (authenticators Authenticators) Authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range authenticators {
+		principal, err := authenticator.Authenticate(r)
+		if !errors.Is(err, ErrNoCredentials) {
+			return principal, err
+		}
+	}
+
+	// An Authorization header which no authenticator understood is refused
+	// rather than ignored, so that clients learn their mistake
+	if r.Header.Get("Authorization") != "" {
+		return nil, &Challenge{
+			Scheme:      "Bearer",
+			Code:        "invalid_request",
+			Description: "Unsupported authorization scheme",
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+// tokenPrincipal returns the principal identified by an access token
+func tokenPrincipal(verifier TokenVerifier, r *http.Request, token string, method AuthMethod) (*Principal, error) {
+	claims, err := verifier.VerifyToken(r.Context(), token)
+	if err != nil {
+		return nil, &Challenge{Scheme: "Bearer", Code: "invalid_token", Description: err.Error()}
+	}
+
+	return &Principal{
+		Username: claims.Username,
+		Method:   method,
+		Session:  claims.Session,
+		Scope:    claims.Scope,
+		ClientId: claims.ClientId,
+		TokenId:  claims.PersonalAccessToken,
+	}, nil
+}
+
+// BearerAuthenticator identifies requests by the access tokens or personal
+// access tokens they carry as Bearer tokens in their Authorization header
+type BearerAuthenticator struct {
+	Verifier TokenVerifier
+}
+
+func (a BearerAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, &Challenge{Scheme: "Bearer", Code: "invalid_request", Description: "Missing token"}
+	}
+	return tokenPrincipal(a.Verifier, r, token, AuthBearer)
+}
+
+// CookieAuthenticator identifies requests from browser clients by the access
+// token in their session cookie.  Since browsers send cookies with requests
+// made from any site, requests which may change anything are only identified
+// if they come from the server's own origin.
+type CookieAuthenticator struct {
+	Verifier TokenVerifier
+	// the scheme and host of the server, such as https://example.org
+	Origin string
+}
+
+func (a CookieAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, ErrNoCredentials
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if r.Header.Get("Origin") != a.Origin {
+			return nil, ErrNoCredentials
+		}
+	}
+
+	return tokenPrincipal(a.Verifier, r, cookie.Value, AuthCookie)
+}
+
+// cookieHeader returns the header which sets the session cookie to an access
+// token, or clears it if token is empty
+func (router *PubblrRouter) cookieHeader(token string) http.Header {
+	path := router.baseUrl.Path
+	if path == "" {
+		path = "/"
+	}
+
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     path,
+		Secure:   router.baseUrl.Scheme == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	}
+
+	header := make(http.Header)
+	header.Add("Set-Cookie", cookie.String())
+	return header
+}
+
+// KeyResolver finds the public keys with which actors sign requests
+type KeyResolver interface {
+	// ResolveKey returns the key with the given id and the id of the actor
+	// who owns it
+	ResolveKey(ctx context.Context, keyId string) (crypto.PublicKey, string, error)
+}
+
+// SignatureAuthenticator identifies requests signed with HTTP Signatures by
+// other servers on behalf of their actors
+type SignatureAuthenticator struct {
+	Keys KeyResolver
+	// defaults to httpsig.DefaultMaxSkew
+	MaxSkew time.Duration
+}
+
+func (a SignatureAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	sig, err := httpsig.Parse(r)
+	if errors.Is(err, httpsig.ErrNoSignature) {
+		return nil, ErrNoCredentials
+	} else if err != nil {
+		return nil, a.challenge(err)
+	}
+
+	key, owner, err := a.Keys.ResolveKey(r.Context(), sig.KeyId)
+	if err != nil {
+		return nil, a.challenge(err)
+	}
+
+	maxSkew := a.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = httpsig.DefaultMaxSkew
+	}
+	err = httpsig.Verify(r, sig, key, time.Now(), maxSkew)
+	if err != nil {
+		return nil, a.challenge(err)
+	}
+
+	return &Principal{
+		Actor:  owner,
+		Method: AuthSignature,
+	}, nil
+}
+
+func (a SignatureAuthenticator) challenge(err error) *Challenge {
+	return &Challenge{
+		Scheme:      "Signature",
+		Description: err.Error(),
+		Params:      []string{"headers", "(request-target) host date digest"},
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/go-chi/chi"
+)
+
+// CreateBlogRequest creates a side blog
+type CreateBlogRequest struct {
+	// the name of the blog, by which its actor is known, as a username
+	Name string `json:"name"`
+	// defaults to a Person with the blog's name
+	Actor activitystreams.ActorIface `json:"actor,omitempty"`
+}
+
+// GetBlogs lists the side blogs of the account making the request
+func (router *PubblrRouter) GetBlogs(r *http.Request) ([]activitystreams.ActorIface, http.Header, apiutil.Status) {
+	names, err := router.Database.GetBlogs(r.Context(), chi.URLParam(r, "actor"))
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	ret := make([]activitystreams.ActorIface, 0, len(names))
+	for _, name := range names {
+		blog, err := router.Database.GetUser(r.Context(), name)
+		if err != nil {
+			return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+		router.setEndpoints(blog)
+		ret = append(ret, blog)
+	}
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// PostBlog creates a side blog for the account making the request, which it
+// may then post as through the blog's outbox
+func (router *PubblrRouter) PostBlog(r *http.Request) (activitystreams.ActorIface, http.Header, apiutil.Status) {
+	account := chi.URLParam(r, "actor")
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusBadRequest, err)
+	}
+	var body CreateBlogRequest
+	err = activitystreams.DefaultEntityUnmarshaler.Unmarshal(b, &body)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusBadRequest, "invalid ActivityStreams actor: %w", err)
+	}
+
+	if status := checkUsername(body.Name); status != nil {
+		return nil, nil, status
+	}
+	if router.reservedUsername(body.Name) {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Username is reserved")
+	}
+	if body.Actor == nil {
+		person := &activitystreams.Person{}
+		person.Name = body.Name
+		body.Actor = person
+	}
+
+	blog, err := router.Database.CreateBlog(r.Context(), body.Actor, body.Name, account, router.baseUrl)
+	if errors.Is(err, database.ErrUserExists) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusConflict, err)
+	} else if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to create blog: %w", err)
+	}
+
+	router.setEndpoints(blog)
+	return blog, http.Header{
+		"Location": []string{activitystreams.ToObject(blog).Id},
+	}, apiutil.StatusFromCode(http.StatusCreated)
+}
@@ -0,0 +1,61 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+)
+
+var _ = Describe("Side blogs", func() {
+	var router server.PubblrRouter
+	var alice, bob string
+
+	BeforeEach(func() {
+		router = newRouter()
+		alice = register(router, "alice")
+		bob = register(router, "bob")
+
+		w := do(router, "POST", "/alice/blogs", `{"name":"films"}`, alice)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		Expect(w.Header().Get("Location")).To(Equal(baseUrl + "/films"))
+	})
+
+	It("should list an account's blogs", func() {
+		w := do(router, "GET", "/alice/blogs", "", alice)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var blogs []struct {
+			Id string `json:"id"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &blogs)).To(Succeed())
+		Expect(blogs).To(ConsistOf(HaveField("Id", baseUrl+"/films")))
+	})
+
+	It("should let an account post through its blogs' outboxes", func() {
+		w := post(router, "films", alice, "a review")
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		Expect(w.Header().Get("Location")).To(HavePrefix(baseUrl + "/films/"))
+		Expect(totalItems(router, "/films/outbox", alice)).To(Equal(1))
+		Expect(totalItems(router, "/alice/outbox", alice)).To(BeZero())
+	})
+
+	It("should not let other accounts post through a blog", func() {
+		Expect(post(router, "films", bob, "not mine").Code).To(Equal(http.StatusForbidden))
+		Expect(do(router, "POST", "/bob/blogs", `{"name":"films"}`, alice).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should refuse reserved, invalid and taken names", func() {
+		for _, name := range []string{"admin", "oauth", "no spaces"} {
+			w := do(router, "POST", "/alice/blogs", `{"name":`+jsonString(name)+`}`, alice)
+			Expect(w.Code).To(Equal(http.StatusBadRequest), name)
+		}
+		for _, name := range []string{"films", "bob"} {
+			w := do(router, "POST", "/alice/blogs", `{"name":`+jsonString(name)+`}`, alice)
+			Expect(w.Code).To(Equal(http.StatusConflict), name)
+		}
+	})
+})
@@ -1,40 +1,243 @@
 package server
 
-import "github.com/brandonsides/pubblr/activitystreams"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
-func (router *PubblrRouter) Deliver(activity activitystreams.ActivityIface) {
-	go router.deliver(activity)
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/database"
+	"github.com/go-chi/chi"
+)
+
+// number of delivery jobs fetched from the database at a time
+const deliveryBatchSize = 100
+
+// DeliveryConfig configures how failed deliveries are retried.  Each retry
+// waits twice as long as the last, starting from RetryDelay, up to
+// MaxRetryDelay.
+type DeliveryConfig struct {
+	// the number of attempts after which delivery is given up on; defaults to
+	// 10
+	MaxAttempts int `json:"maxAttempts"`
+	// defaults to a minute
+	RetryDelay time.Duration `json:"retryDelay"`
+	// defaults to six hours
+	MaxRetryDelay time.Duration `json:"maxRetryDelay"`
+}
+
+func (config DeliveryConfig) withDefaults() DeliveryConfig {
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 10
+	}
+	if config.RetryDelay == 0 {
+		config.RetryDelay = time.Minute
+	}
+	if config.MaxRetryDelay == 0 {
+		config.MaxRetryDelay = 6 * time.Hour
+	}
+	return config
+}
+
+// backoff returns how long to wait before retrying a delivery which has
+// failed the given number of times
+func (config DeliveryConfig) backoff(attempts int) time.Duration {
+	delay := config.RetryDelay
+	for i := 1; i < attempts && delay < config.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > config.MaxRetryDelay {
+		delay = config.MaxRetryDelay
+	}
+	return delay
 }
 
-func (router *PubblrRouter) deliver(a activitystreams.ActivityIface) {
+// EnqueueDelivery records a delivery job for each recipient of the given
+// activity.  The jobs are created through the given transaction, so that they
+// are only ever run if the transaction which produced the activity commits.
+func (router *PubblrRouter) EnqueueDelivery(ctx context.Context, tx database.Queries, a activitystreams.ActivityIface) error {
 	activity := activitystreams.ToIntransitiveActivity(a)
 	recipients := merge(activity.To, activity.Bto, activity.Audience, activity.Bcc, activity.Cc)
 
+	var delivered []string
+
 	for _, recipient := range recipients {
-		router.deliverTo(recipient, a)
+		recipientId := activitystreams.ToEntity(recipient).Id
+		if recipientId == publicAddress {
+			// the public collection has no inbox to deliver to
+			continue
+		}
+		if !router.isLocal(recipientId) {
+			router.Logger.Warnf("Not delivering to %s: delivery to other servers is not supported\n", recipientId)
+			continue
+		}
+
+		inboxes, err := router.recipientActors(ctx, tx, recipientId)
+		if err != nil {
+			return err
+		}
+
+		for _, inbox := range inboxes {
+			if in(inbox, delivered) {
+				continue
+			}
+			delivered = append(delivered, inbox)
+
+			_, err := tx.CreateDeliveryJob(ctx, a, inbox)
+			if err != nil {
+				return err
+			}
+		}
 	}
+	return nil
 }
 
-func (router *PubblrRouter) deliverTo(recipient activitystreams.EntityIface, activity activitystreams.ActivityIface) {
-	if router.isLocal(recipient) {
-		router.deliverToLocal(recipient, activity)
-	} else {
-		router.deliverToRemote(recipient, activity)
+// recipientActors returns the ids of the actors to which an activity addressed
+// to the given id is delivered.  The followers of local actors are known, so
+// are delivered to directly; other local resources have no inbox to deliver
+// to.  Activities are not delivered to other servers, so none of their actors
+// are returned, whether addressed directly or among a local actor's followers.
+func (router *PubblrRouter) recipientActors(ctx context.Context, tx database.Queries, id string) ([]string, error) {
+	if !router.isLocal(id) {
+		return nil, nil
 	}
+
+	rctx, ok := router.matchLocal(id)
+	if !ok {
+		return nil, nil
+	}
+	switch rctx.RoutePattern() {
+	case "/{actor}":
+		return []string{id}, nil
+	case "/{actor}/followers", "/{actor}/streams/{id}/followers":
+		// Streams have no followers of their own; following an actor is
+		// following all of its streams
+		followers, err := tx.GetFollowers(ctx, rctx.URLParam("actor"))
+		if errors.Is(err, database.ErrUserNotFound) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		// Followers on other servers, such as those imported from an archive,
+		// are left out like any other remote recipient
+		var local []string
+		for _, follower := range followers {
+			if router.isLocal(follower) {
+				local = append(local, follower)
+			}
+		}
+		return local, nil
+	}
+	return nil, nil
 }
 
-func (router *PubblrRouter) isLocal(entity activitystreams.EntityIface) bool {
-	// TODO
-	return true
+// matchLocal finds the route serving the local resource with the given id,
+// returning the routing context from which its pattern and URL parameters may
+// be read
+func (router *PubblrRouter) matchLocal(id string) (*chi.Context, bool) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, false
+	}
+	routePath := "/" + strings.TrimPrefix(strings.TrimPrefix(u.Path, router.baseUrl.Path), "/")
+
+	rctx := chi.NewRouteContext()
+	if !router.Router.Match(rctx, "GET", routePath) {
+		return nil, false
+	}
+	return rctx, true
+}
+
+// Deliver wakes the delivery worker to run any pending delivery jobs
+func (router *PubblrRouter) Deliver() {
+	select {
+	case router.deliveries <- struct{}{}:
+	default:
+		// a wakeup is already pending
+	}
+}
+
+func (router *PubblrRouter) runDeliveries(ctx context.Context) {
+	// retries come due without anything waking the worker, so it also looks
+	// for them as often as they may come due
+	ticker := time.NewTicker(router.delivery.RetryDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-router.deliveries:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		now := time.Now()
+		for {
+			jobs, err := router.Database.GetDeliveryJobs(ctx, now, deliveryBatchSize)
+			if err != nil {
+				router.Logger.Errorf("Failed to fetch delivery jobs: %s\n", err)
+				break
+			}
+
+			for _, job := range jobs {
+				err := router.deliverTo(ctx, job.Recipient, job.Activity)
+				if err != nil {
+					router.deliveryFailed(ctx, job, err)
+					continue
+				}
+
+				err = router.Database.DeleteDeliveryJob(ctx, job.Id)
+				if err != nil {
+					router.Logger.Errorf("Failed to delete delivery job %s: %s\n", job.Id, err)
+				}
+			}
+
+			if len(jobs) < deliveryBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// deliveryFailed schedules a failed delivery job to be retried, or gives up on
+// it once it has been attempted as often as configured
+func (router *PubblrRouter) deliveryFailed(ctx context.Context, job *database.DeliveryJob, err error) {
+	job.Attempts++
+	job.LastError = err.Error()
+	if job.Attempts >= router.delivery.MaxAttempts {
+		job.Dead = true
+		router.Logger.Errorf("Gave up delivering to %s after %d attempts: %s\n", job.Recipient, job.Attempts, err)
+	} else {
+		job.NextAttempt = time.Now().Add(router.delivery.backoff(job.Attempts))
+		router.Logger.Warnf("Failed to deliver to %s, retrying at %s: %s\n",
+			job.Recipient, job.NextAttempt.Format(time.RFC3339), err)
+	}
+
+	err = router.Database.UpdateDeliveryJob(ctx, *job)
+	if err != nil {
+		router.Logger.Errorf("Failed to update delivery job %s: %s\n", job.Id, err)
+	}
 }
 
-func (router *PubblrRouter) deliverToLocal(recipient activitystreams.EntityIface, activity activitystreams.ActivityIface) {
-	recipientEntity := activitystreams.ToEntity(recipient)
-	shordId := shortId(recipientEntity.Id)
+func (router *PubblrRouter) deliverTo(ctx context.Context, recipient string, activity activitystreams.ActivityIface) error {
+	if !router.isLocal(recipient) {
+		return fmt.Errorf("cannot deliver to %s: delivery to other servers is not supported", recipient)
+	}
+	return router.deliverToLocal(ctx, recipient, activity)
+}
 
-	router.Database.CreateInboxItem(activity, shordId)
+func (router *PubblrRouter) isLocal(recipient string) bool {
+	return strings.HasPrefix(recipient, strings.TrimSuffix(router.baseUrl.String(), "/")+"/")
 }
 
-func (router *PubblrRouter) deliverToRemote(recipient activitystreams.EntityIface, activity activitystreams.ActivityIface) {
-	router.Logger.Errorf("deliverToRemote not implemented")
+// deliverToLocal adds an activity to the inbox of a local actor.  What is
+// delivered is not indexed again, since only local posts are delivered, and
+// those are indexed when they are posted; indexing posts from other servers
+// awaits their being received through PostToInbox.
+func (router *PubblrRouter) deliverToLocal(ctx context.Context, recipient string, activity activitystreams.ActivityIface) error {
+	_, err := router.Database.CreateInboxItem(ctx, activity, shortId(recipient))
+	return err
 }
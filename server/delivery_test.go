@@ -0,0 +1,105 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server"
+)
+
+var _ = Describe("Delivery", func() {
+	var router server.PubblrRouter
+	var alice string
+
+	ctx := context.Background()
+
+	// pendingJobs returns the delivery jobs which are neither delivered nor
+	// given up on, whenever they are due
+	pendingJobs := func() []*database.DeliveryJob {
+		jobs, err := router.Database.GetDeliveryJobs(ctx, time.Now().Add(time.Hour), 100)
+		Expect(err).ToNot(HaveOccurred())
+		return jobs
+	}
+
+	configure := func(config *server.PubblrRouterConfig) {
+		config.Delivery = server.DeliveryConfig{
+			MaxAttempts:   1000,
+			RetryDelay:    10 * time.Millisecond,
+			MaxRetryDelay: 50 * time.Millisecond,
+		}
+	}
+
+	BeforeEach(func() {
+		router = newRouter(configure)
+		alice = register(router, "alice")
+	})
+
+	It("should deliver to local actors", func() {
+		bob := register(router, "bob")
+		Expect(post(router, "alice", alice, "hi", baseUrl+"/bob").Code).To(Equal(http.StatusCreated))
+		Eventually(func() int { return totalItems(router, "/bob/inbox", bob) }).Should(Equal(1))
+		Expect(pendingJobs()).To(BeEmpty())
+	})
+
+	It("should deliver to the followers of an actor's streams", func() {
+		bob := register(router, "bob")
+		Expect(router.Database.AddFollower(ctx, "alice", baseUrl+"/bob")).To(Succeed())
+
+		w := post(router, "alice", alice, "hi", baseUrl+"/alice/streams/films/followers")
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		Eventually(func() int { return totalItems(router, "/bob/inbox", bob) }).Should(Equal(1))
+	})
+
+	It("should not deliver to local resources without inboxes", func() {
+		w := post(router, "alice", alice, "hi", baseUrl+"/nobody/followers", baseUrl+"/alice/outbox")
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		Expect(pendingJobs()).To(BeEmpty())
+	})
+
+	It("should not enqueue deliveries to other servers", func() {
+		w := post(router, "alice", alice, "hi", "https://other.example/bob", "https://other.example/bob/followers")
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		Expect(pendingJobs()).To(BeEmpty())
+	})
+
+	It("should not enqueue deliveries to followers on other servers", func() {
+		bob := register(router, "bob")
+		Expect(router.Database.AddFollower(ctx, "alice", baseUrl+"/bob")).To(Succeed())
+		Expect(router.Database.AddFollower(ctx, "alice", "https://other.example/carol")).To(Succeed())
+
+		w := post(router, "alice", alice, "hi", baseUrl+"/alice/followers")
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		Eventually(func() int { return totalItems(router, "/bob/inbox", bob) }).Should(Equal(1))
+		Expect(pendingJobs()).To(BeEmpty())
+	})
+
+	It("should retry failed deliveries until they succeed", func() {
+		Expect(post(router, "alice", alice, "hi", baseUrl+"/carol").Code).To(Equal(http.StatusCreated))
+		Eventually(func() []*database.DeliveryJob { return pendingJobs() }).Should(ContainElement(
+			HaveField("Attempts", BeNumerically(">=", 1)),
+		))
+		Expect(pendingJobs()[0].LastError).To(ContainSubstring(database.ErrUserNotFound.Error()))
+
+		carol := register(router, "carol")
+		Eventually(func() int { return totalItems(router, "/carol/inbox", carol) }).Should(Equal(1))
+		Expect(pendingJobs()).To(BeEmpty())
+	})
+
+	It("should give up on deliveries which fail too often", func() {
+		router = newRouter(configure, func(config *server.PubblrRouterConfig) {
+			config.Delivery.MaxAttempts = 2
+		})
+		alice = register(router, "alice")
+
+		Expect(post(router, "alice", alice, "hi", baseUrl+"/carol").Code).To(Equal(http.StatusCreated))
+		Eventually(func() []*database.DeliveryJob { return pendingJobs() }).Should(BeEmpty())
+
+		carol := register(router, "carol")
+		Consistently(func() int { return totalItems(router, "/carol/inbox", carol) }, 100*time.Millisecond).Should(Equal(0))
+	})
+})
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/mailer"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/auth"
+	"github.com/go-chi/chi"
+)
+
+const (
+	passwordResetLifetime     = time.Hour
+	emailVerificationLifetime = 24 * time.Hour
+	// how long to spend trying to send each message
+	mailTimeout = time.Minute
+)
+
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+type EmailResponse struct {
+	Email    string `json:"email"`
+	Verified bool   `json:"verified"`
+}
+
+// AccountTokenRequest redeems a token mailed to a user
+type AccountTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// PasswordResetRequest names the account whose password is to be reset, by
+// either its username or its email address
+type PasswordResetRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type CompletePasswordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// readJSON reads a request's JSON body into v
+func readJSON(r *http.Request, v interface{}) apiutil.Status {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
+	}
+	err = json.Unmarshal(reqBody, v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return apiutil.NewFieldStatus(http.StatusBadRequest, typeErr.Field, "May not be a "+typeErr.Value)
+	} else if err != nil {
+		return apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
+	}
+	return nil
+}
+
+// endpointUrl returns the URL of one of the router's own endpoints
+func (router *PubblrRouter) endpointUrl(elem ...string) string {
+	u := router.baseUrl
+	u.Path = path.Join(append([]string{u.Path}, elem...)...)
+	return u.String()
+}
+
+// sendMail sends mail in the background, so that requests neither wait on the
+// mail server nor reveal by their timing whether mail was sent
+func (router *PubblrRouter) sendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+
+		err := router.Mailer.Send(ctx, msg)
+		if err != nil {
+			router.Logger.Errorf("Failed to send mail to %s: %s\n", msg.To, err)
+		}
+	}()
+}
+
+// newAccountToken creates a token for a user to redeem for the given purpose,
+// replacing any they already have for it
+func newAccountToken(ctx context.Context, db database.Queries, username, address string, purpose database.AccountTokenPurpose, lifetime time.Duration) (string, error) {
+	err := db.DeleteAccountTokens(ctx, username, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := auth.NewSecret()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.CreateAccountToken(ctx, database.AccountToken{
+		Hash:     auth.HashToken(token),
+		Username: username,
+		Purpose:  purpose,
+		Email:    address,
+		Expires:  time.Now().Add(lifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// redeemAccountToken looks up a token for the given purpose and deletes it, so
+// that it cannot be used again
+func redeemAccountToken(ctx context.Context, db database.Queries, token string, purpose database.AccountTokenPurpose) (*database.AccountToken, apiutil.Status) {
+	if token == "" {
+		return nil, apiutil.NewStatus(http.StatusBadRequest, "Missing token")
+	}
+
+	hash := auth.HashToken(token)
+	accountToken, err := db.GetAccountToken(ctx, hash)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && accountToken.Purpose != purpose) {
+		return nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid token")
+	} else if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	err = db.DeleteAccountToken(ctx, hash)
+	if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	if time.Now().After(accountToken.Expires) {
+		return nil, apiutil.NewStatus(http.StatusBadRequest, "Token has expired")
+	}
+	return accountToken, nil
+}
+
+// setEmail gives a user a new, unverified email address, and returns the
+// verification message to send to it once the change is committed
+func (router *PubblrRouter) setEmail(ctx context.Context, db database.Queries, username, address string) (*mailer.Message, apiutil.Status) {
+	if !mailer.ValidAddress(address) {
+		return nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid email address")
+	}
+
+	err := db.SetEmail(ctx, username, database.Email{Address: address})
+	if errors.Is(err, database.ErrEmailExists) {
+		return nil, apiutil.NewStatusFromError(http.StatusConflict, err)
+	} else if errors.Is(err, database.ErrUserNotFound) {
+		return nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return nil, apiutil.Statusf(http.StatusInternalServerError, "failed to set email address: %w", err)
+	}
+
+	token, err := newAccountToken(ctx, db, username, address, database.PurposeEmailVerification, emailVerificationLifetime)
+	if err != nil {
+		return nil, apiutil.Statusf(http.StatusInternalServerError, "failed to create verification token: %w", err)
+	}
+
+	return &mailer.Message{
+		To:      address,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("To verify that %s is the email address of the pubblr account %s, send this token to %s:\n\n"+
+			"%s\n\n"+
+			"It expires in %d hours.  If you did not give this address, you can ignore this email.\n",
+			address, username, router.endpointUrl("email", "verify"), token, int(emailVerificationLifetime.Hours())),
+	}, nil
+}
+
+// GetEmail returns the email address of the user making the request
+func (router *PubblrRouter) GetEmail(r *http.Request) (*EmailResponse, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "actor")
+
+	email, err := router.Database.GetEmail(r.Context(), username)
+	if errors.Is(err, database.ErrNotFound) || errors.Is(err, database.ErrUserNotFound) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	return &EmailResponse{
+		Email:    email.Address,
+		Verified: email.Verified,
+	}, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// PutEmail changes the email address of the user making the request, and
+// mails a token to the new address with which to verify it.  Giving the
+// current address again sends a new token if it has not yet been verified.
+func (router *PubblrRouter) PutEmail(r *http.Request) (*EmailResponse, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "actor")
+
+	var body EmailRequest
+	if status := readJSON(r, &body); status != nil {
+		return nil, nil, status
+	}
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	current, err := tx.GetEmail(r.Context(), username)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if current != nil && current.Verified && current.Address == body.Email {
+		return &EmailResponse{
+			Email:    current.Address,
+			Verified: true,
+		}, nil, apiutil.StatusFromCode(http.StatusOK)
+	}
+
+	msg, status := router.setEmail(r.Context(), tx, username, body.Email)
+	if status != nil {
+		return nil, nil, status
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit email address: %w", err)
+	}
+	router.sendMail(*msg)
+
+	return &EmailResponse{
+		Email:    body.Email,
+		Verified: false,
+	}, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// VerifyEmail redeems a token mailed by PutEmail, marking the address to which
+// it was sent as verified
+func (router *PubblrRouter) VerifyEmail(r *http.Request) (*struct{}, http.Header, apiutil.Status) {
+	var body AccountTokenRequest
+	if status := readJSON(r, &body); status != nil {
+		return nil, nil, status
+	}
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	token, status := redeemAccountToken(r.Context(), tx, body.Token, database.PurposeEmailVerification)
+	if status != nil {
+		if err := tx.Commit(); err != nil {
+			return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+		return nil, nil, status
+	}
+
+	// The user may have changed their address since the token was sent
+	email, err := tx.GetEmail(r.Context(), token.Username)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if email == nil || !strings.EqualFold(email.Address, token.Email) {
+		if err := tx.Commit(); err != nil {
+			return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "The email address has changed since the token was sent")
+	}
+
+	email.Verified = true
+	err = tx.SetEmail(r.Context(), token.Username, *email)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to verify email address: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit email address: %w", err)
+	}
+	return nil, nil, apiutil.StatusFromCode(http.StatusNoContent)
+}
+
+// RequestPasswordReset mails a token with which to reset the password of the
+// account named, if it has a verified email address.  It succeeds whether or
+// not it does, so as not to reveal which accounts exist or what their
+// addresses are.
+func (router *PubblrRouter) RequestPasswordReset(r *http.Request) (*struct{}, http.Header, apiutil.Status) {
+	var body PasswordResetRequest
+	if status := readJSON(r, &body); status != nil {
+		return nil, nil, status
+	}
+	if body.Username == "" && body.Email == "" {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Missing username or email address")
+	}
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	username := body.Username
+	if username == "" {
+		username, err = tx.GetUsernameByEmail(r.Context(), body.Email)
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, nil, apiutil.StatusFromCode(http.StatusNoContent)
+		} else if err != nil {
+			return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+	}
+
+	email, err := tx.GetEmail(r.Context(), username)
+	if errors.Is(err, database.ErrNotFound) || errors.Is(err, database.ErrUserNotFound) {
+		return nil, nil, apiutil.StatusFromCode(http.StatusNoContent)
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if !email.Verified {
+		return nil, nil, apiutil.StatusFromCode(http.StatusNoContent)
+	}
+
+	token, err := newAccountToken(r.Context(), tx, username, email.Address, database.PurposePasswordReset, passwordResetLifetime)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to create reset token: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit reset token: %w", err)
+	}
+
+	router.sendMail(mailer.Message{
+		To:      email.Address,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of the pubblr account %s.  "+
+			"To choose a new password, send it along with this token to %s:\n\n"+
+			"%s\n\n"+
+			"It expires in %d hour(s).  If you did not ask to reset your password, you can ignore this email.\n",
+			username, router.endpointUrl("password", "reset", "confirm"), token, int(passwordResetLifetime.Hours())),
+	})
+
+	return nil, nil, apiutil.StatusFromCode(http.StatusNoContent)
+}
+
+// CompletePasswordReset redeems a token mailed by RequestPasswordReset,
+// setting a new password, ending every session of the account and revoking
+// its personal access tokens
+func (router *PubblrRouter) CompletePasswordReset(r *http.Request) (*struct{}, http.Header, apiutil.Status) {
+	var body CompletePasswordResetRequest
+	if status := readJSON(r, &body); status != nil {
+		return nil, nil, status
+	}
+	if body.Password == "" {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Missing password")
+	}
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	token, status := redeemAccountToken(r.Context(), tx, body.Token, database.PurposePasswordReset)
+	if status != nil {
+		if err := tx.Commit(); err != nil {
+			return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+		return nil, nil, status
+	}
+
+	// Returning before committing leaves the token to be used again with a
+	// stronger password
+	err = auth.CheckPasswordStrength(body.Password, router.registration.MinPasswordLength, token.Username)
+	if err != nil {
+		return nil, nil, apiutil.NewFieldStatus(http.StatusBadRequest, "password", err.Error())
+	}
+
+	err = tx.SetPassword(r.Context(), token.Username, body.Password)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to set password: %w", err)
+	}
+
+	err = tx.DeleteAccountTokens(r.Context(), token.Username, database.PurposePasswordReset)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	err = endSessions(r.Context(), tx, token.Username)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to end sessions: %w", err)
+	}
+	// Whoever knew the old password may have minted tokens with it
+	err = revokePersonalAccessTokens(r.Context(), tx, token.Username)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to revoke personal access tokens: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit password: %w", err)
+	}
+	router.loginSucceeded(r, token.Username)
+
+	return nil, nil, apiutil.StatusFromCode(http.StatusNoContent)
+}
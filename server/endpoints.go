@@ -1,13 +1,20 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
-	"strconv"
+	"net/url"
+	"path"
 
 	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/mailer"
+	"github.com/brandonsides/pubblr/search"
 	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/archive"
 	"github.com/brandonsides/pubblr/util/either"
 	"github.com/go-chi/chi"
 )
@@ -17,38 +24,283 @@ import (
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// whether to set a session cookie carrying the access token, for clients
+	// running in browsers
+	Cookie bool `json:"cookie"`
 }
 
-func (router *PubblrRouter) Login(r *http.Request) (string, http.Header, apiutil.Status) {
+// LoginResponse carries either the tokens issued on login or, for users
+// enrolled in two-factor authentication, the challenge token with which to
+// give their second factor to LoginSecondFactor
+type LoginResponse struct {
+	*TokenResponse
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+}
+
+func (router *PubblrRouter) Login(r *http.Request) (*LoginResponse, http.Header, apiutil.Status) {
 	reqBody, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return "", nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
 	}
 	var body LoginRequest
 	err = json.Unmarshal(reqBody, &body)
 	if err != nil {
-		return "", nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
 	}
 
 	if body.Username == "" {
-		return "", nil, apiutil.NewStatus(http.StatusBadRequest, "Missing username")
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Missing username")
 	}
 
 	if body.Password == "" {
-		return "", nil, apiutil.NewStatus(http.StatusBadRequest, "Missing password")
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Missing password")
+	}
+
+	if header, status := router.checkLockout(r, body.Username); status != nil {
+		return nil, header, status
 	}
 
-	if router.Database.CheckPassword(body.Username, body.Password) != nil {
-		return "", nil, apiutil.NewStatus(http.StatusUnauthorized, "Invalid username or password")
+	if router.Database.CheckPassword(r.Context(), body.Username, body.Password) != nil {
+		router.loginFailed(r, body.Username)
+		return nil, nil, apiutil.NewStatus(http.StatusUnauthorized, "Invalid username or password")
 	}
 
-	ret, err := router.Auth.GenerateToken(body.Username)
+	tx, err := router.Database.Begin(r.Context())
 	if err != nil {
-		return "", nil, apiutil.NewStatus(http.StatusInternalServerError, "Error generating token")
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	if status := checkAccountStatus(r.Context(), tx, body.Username); status != nil {
+		return nil, nil, status
+	}
+
+	twoFactor, err := tx.GetTwoFactor(r.Context(), body.Username)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	ret := &LoginResponse{}
+	if twoFactor != nil && twoFactor.Enabled {
+		ret.TwoFactorRequired = true
+		ret.ChallengeToken, err = router.beginLoginChallenge(r.Context(), tx, body.Username, r.UserAgent())
+	} else {
+		ret.TokenResponse, err = router.startLoginSession(r.Context(), tx, body.Username, r.UserAgent())
+	}
+	if err != nil {
+		return nil, nil, apiutil.NewStatus(http.StatusInternalServerError, "Error generating token")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit session: %w", err)
+	}
+	if ret.TwoFactorRequired {
+		return ret, nil, nil
+	}
+
+	router.loginSucceeded(r, body.Username)
+	if body.Cookie {
+		return ret, router.cookieHeader(ret.AccessToken), nil
 	}
 	return ret, nil, nil
 }
 
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+	// whether to set a session cookie carrying the new access token
+	Cookie bool `json:"cookie"`
+}
+
+// TokenResponse carries the credentials issued on login or refresh.  The
+// access token authorizes requests until it expires, after which the refresh
+// token may be exchanged for a new pair.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	// the space-separated scopes granted
+	Scope string `json:"scope,omitempty"`
+}
+
+// RefreshToken exchanges a refresh token issued on login for a new access
+// token and a new refresh token
+func (router *PubblrRouter) RefreshToken(r *http.Request) (*TokenResponse, http.Header, apiutil.Status) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
+	}
+	var body RefreshTokenRequest
+	err = json.Unmarshal(reqBody, &body)
+	if err != nil {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
+	}
+
+	if body.RefreshToken == "" {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Missing refresh token")
+	}
+
+	ret, status := router.refreshTokens(r.Context(), body.RefreshToken, "")
+	if status != nil {
+		return nil, nil, status
+	}
+
+	if body.Cookie {
+		return ret, router.cookieHeader(ret.AccessToken), apiutil.StatusFromCode(http.StatusOK)
+	}
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// SEARCH
+
+// GetSearch serves a page of the objects matching the query in the "q"
+// parameter which are visible to the requesting user, newest first.
+func (router *PubblrRouter) GetSearch(r *http.Request) (*activitystreams.CollectionPage, http.Header, apiutil.Status) {
+	params := r.URL.Query()
+	readers := router.readerIds(r)
+
+	query := search.Query{
+		Text:  params.Get("q"),
+		MaxId: params.Get(maxIdParam),
+		// fetch one extra hit to find out whether there is a next page
+		Limit: router.pageSize + 1,
+		Visible: func(object activitystreams.ObjectIface) bool {
+			return router.intendedFor(r.Context(), readers, owner(object), object)
+		},
+	}
+
+	hits, err := router.Search.Search(query)
+	if errors.Is(err, search.ErrInvalidQuery) || errors.Is(err, search.ErrInvalidId) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusBadRequest, err)
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	hasNext := len(hits) > router.pageSize
+	if hasNext {
+		hits = hits[:router.pageSize]
+	}
+
+	items := make([]*either.Either[activitystreams.ObjectIface, activitystreams.LinkIface], len(hits))
+	for i, hit := range hits {
+		if !in(owner(hit.Object), readers) {
+			obj := activitystreams.ToObject(hit.Object)
+			obj.Bcc = nil
+			obj.Bto = nil
+		}
+		items[i] = either.Left[activitystreams.ObjectIface, activitystreams.LinkIface](hit.Object)
+	}
+
+	ret := router.searchPageRef(query.Text, query.MaxId)
+	ret.Items = items
+	if hasNext {
+		ret.Next = either.Left[activitystreams.CollectionPage, activitystreams.Link](
+			*router.searchPageRef(query.Text, hits[len(hits)-1].Id),
+		)
+	}
+
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// searchPageRef returns an empty page of search results, identified by the URL
+// at which it can be retrieved
+func (router *PubblrRouter) searchPageRef(text, maxId string) *activitystreams.CollectionPage {
+	params := url.Values{}
+	params.Set("q", text)
+	if maxId != "" {
+		params.Set(maxIdParam, maxId)
+	}
+
+	searchUrl := router.baseUrl
+	searchUrl.Path = path.Join(searchUrl.Path, "search")
+	searchUrl.RawQuery = params.Encode()
+
+	return &activitystreams.CollectionPage{
+		Collection: activitystreams.Collection{
+			Object: activitystreams.Object{
+				Entity: activitystreams.Entity{
+					Id: searchUrl.String(),
+				},
+			},
+			Ordered: true,
+		},
+	}
+}
+
+// TAGS
+
+// GetTag serves the collection of public objects bearing the given hashtag or,
+// when a page is requested, a page of them, newest first
+func (router *PubblrRouter) GetTag(r *http.Request) (activitystreams.CollectionIface, http.Header, apiutil.Status) {
+	tag := chi.URLParam(r, "tag")
+	params := r.URL.Query()
+	tagId := router.tagUrl(tag)
+
+	if params.Get(pageParam) == "" && params.Get(maxIdParam) == "" {
+		return &activitystreams.Collection{
+			Object: activitystreams.Object{
+				Entity: activitystreams.Entity{
+					Id:   tagId,
+					Name: "#" + search.NormalizeTag(tag),
+				},
+			},
+			Ordered: true,
+			First: either.Left[*activitystreams.CollectionPage, activitystreams.LinkIface](
+				pageRef(tagId, database.PageQuery{}),
+			),
+		}, nil, apiutil.StatusFromCode(http.StatusOK)
+	}
+
+	query := search.Query{
+		Tag:   tag,
+		MaxId: params.Get(maxIdParam),
+		// fetch one extra hit to find out whether there is a next page
+		Limit:   router.pageSize + 1,
+		Visible: isPublic,
+	}
+
+	hits, err := router.Search.Search(query)
+	if errors.Is(err, search.ErrInvalidId) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusBadRequest, err)
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	hasNext := len(hits) > router.pageSize
+	if hasNext {
+		hits = hits[:router.pageSize]
+	}
+
+	items := make([]*either.Either[activitystreams.ObjectIface, activitystreams.LinkIface], len(hits))
+	for i, hit := range hits {
+		obj := activitystreams.ToObject(hit.Object)
+		obj.Bcc = nil
+		obj.Bto = nil
+		items[i] = either.Left[activitystreams.ObjectIface, activitystreams.LinkIface](hit.Object)
+	}
+
+	ret := pageRef(tagId, database.PageQuery{MaxId: query.MaxId})
+	ret.Items = items
+	ret.PartOf = either.Left[activitystreams.Collection, activitystreams.Link](
+		activitystreams.Collection{
+			Object: activitystreams.Object{
+				Entity: activitystreams.Entity{
+					Id: tagId,
+				},
+			},
+			Ordered: true,
+		},
+	)
+	if hasNext {
+		ret.Next = either.Left[activitystreams.CollectionPage, activitystreams.Link](
+			*pageRef(tagId, database.PageQuery{MaxId: hits[len(hits)-1].Id}),
+		)
+	}
+
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
 // OBJECTS
 
 func (router *PubblrRouter) GetObject(r *http.Request) (activitystreams.ObjectIface, http.Header, apiutil.Status) {
@@ -56,7 +308,7 @@ func (router *PubblrRouter) GetObject(r *http.Request) (activitystreams.ObjectIf
 	typ := chi.URLParam(r, "type")
 	id := chi.URLParam(r, "id")
 
-	post, err := router.Database.GetObject(user, typ, id)
+	post, err := router.Database.GetObject(r.Context(), user, typ, id)
 	if err != nil {
 		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
 	}
@@ -69,11 +321,20 @@ func (router *PubblrRouter) GetObject(r *http.Request) (activitystreams.ObjectIf
 func (router *PubblrRouter) GetUser(r *http.Request) (activitystreams.ObjectIface, http.Header, apiutil.Status) {
 	username := chi.URLParam(r, "actor")
 
-	user, err := router.Database.GetUser(username)
+	user, err := router.Database.GetUser(r.Context(), username)
 	if err != nil {
 		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
 	}
 
+	// Accounts are not shown until they may be used
+	registration, err := router.Database.GetRegistration(r.Context(), username)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if registration.Status != database.AccountActive {
+		return nil, nil, apiutil.Statusf(http.StatusNotFound, "%w: %s", database.ErrUserNotFound, username)
+	}
+
 	router.setEndpoints(user)
 
 	return user, nil, apiutil.StatusFromCode(http.StatusOK)
@@ -82,11 +343,40 @@ func (router *PubblrRouter) GetUser(r *http.Request) (activitystreams.ObjectIfac
 type CreateAccountRequest struct {
 	Password string                     `json:"password"`
 	Actor    activitystreams.ActorIface `json:"actor"`
+	// optional; a token with which to verify it is mailed to it
+	Email string `json:"email,omitempty"`
+	// required when registration is by invitation
+	Invite string `json:"invite,omitempty"`
+	// for the administrators approving registrations, when they must
+	Reason string `json:"reason,omitempty"`
 }
 
+// CreateAccountResponse carries the new account and, unless it awaits
+// approval, the tokens of a session begun in it
 type CreateAccountResponse struct {
-	Actor activitystreams.ActorIface `json:"actor"`
-	JWT   string                     `json:"jwt"`
+	Actor        activitystreams.ActorIface `json:"actor"`
+	Status       database.AccountStatus     `json:"status"`
+	JWT          string                     `json:"jwt,omitempty"`
+	RefreshToken string                     `json:"refreshToken,omitempty"`
+}
+
+// accountCreated returns the response to a request which created an account
+func (router *PubblrRouter) accountCreated(ctx context.Context, db database.Queries, actor activitystreams.ActorIface, registration *database.Registration, userAgent string) (*CreateAccountResponse, error) {
+	ret := &CreateAccountResponse{
+		Actor:  actor,
+		Status: registration.Status,
+	}
+	if registration.Status != database.AccountActive {
+		return ret, nil
+	}
+
+	tokens, err := router.startLoginSession(ctx, db, registration.Username, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	ret.JWT = tokens.AccessToken
+	ret.RefreshToken = tokens.RefreshToken
+	return ret, nil
 }
 
 func (router *PubblrRouter) PostUser(r *http.Request) (*CreateAccountResponse, http.Header, apiutil.Status) {
@@ -102,261 +392,203 @@ func (router *PubblrRouter) PostUser(r *http.Request) (*CreateAccountResponse, h
 		return nil, nil, apiutil.Statusf(http.StatusBadRequest, "invalid ActivityStreams actor: %w", err)
 	}
 
-	jwt, err := router.Auth.GenerateToken(username)
+	tx, err := router.Database.Begin(r.Context())
 	if err != nil {
-		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
 	}
+	defer tx.Rollback()
 
-	createAccountRequest.Actor, err = router.Database.CreateUser(createAccountRequest.Actor, username,
-		createAccountRequest.Password, router.baseUrl)
-	if err != nil {
-		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	if _, err := tx.GetUser(r.Context(), username); err == nil {
+		return nil, nil, apiutil.Statusf(http.StatusConflict, "%w: %s", database.ErrUserExists, username)
 	}
 
-	router.setEndpoints(createAccountRequest.Actor)
-
-	return &CreateAccountResponse{
-		Actor: createAccountRequest.Actor,
-		JWT:   jwt,
-	}, nil, apiutil.Statusf(http.StatusCreated, "created user %s", username)
-}
-
-//INBOX
+	registration, status := router.register(r.Context(), tx, username, createAccountRequest.Password, RegistrationRequest{
+		Invite: createAccountRequest.Invite,
+		Reason: createAccountRequest.Reason,
+	})
+	if status != nil {
+		return nil, nil, status
+	}
 
-func (router *PubblrRouter) PostToInbox(r *http.Request) (*activitystreams.ActivityIface, http.Header, apiutil.Status) {
-	return nil, nil, apiutil.NewStatus(http.StatusNotImplemented, "PostToInbox not yet implemented")
-}
+	createAccountRequest.Actor, err = tx.CreateUser(r.Context(), createAccountRequest.Actor, username,
+		createAccountRequest.Password, router.baseUrl)
+	if errors.Is(err, database.ErrUserExists) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusConflict, err)
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
 
-func (router *PubblrRouter) GetInbox(r *http.Request) (*activitystreams.Collection, http.Header, apiutil.Status) {
-	// params
-	actorShortId := chi.URLParam(r, "actor")
+	err = tx.SetRegistration(r.Context(), *registration)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
 
-	// Make sure user actually exists
-	actorIface, err := router.Database.GetUser(actorShortId)
+	err = router.grantConfiguredRoles(r.Context(), tx, username)
 	if err != nil {
-		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
 	}
-	actor := activitystreams.ToActor(actorIface)
 
-	router.setEndpoints(actorIface)
+	var verification *mailer.Message
+	if createAccountRequest.Email != "" {
+		var status apiutil.Status
+		verification, status = router.setEmail(r.Context(), tx, username, createAccountRequest.Email)
+		if status != nil {
+			return nil, nil, status
+		}
+	}
 
-	count, err := router.Database.GetInboxCount(actorShortId)
+	ret, err := router.accountCreated(r.Context(), tx, createAccountRequest.Actor, registration, r.UserAgent())
 	if err != nil {
-		count = 0
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
 	}
 
-	ret := &activitystreams.Collection{
-		Object: activitystreams.Object{
-			Entity: activitystreams.Entity{
-				Id: actor.Id + "/inbox",
-			},
-		},
-		TotalItems: uint64(count),
-		Ordered:    true,
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit user: %w", err)
 	}
-
-	if ret.TotalItems == 0 {
-		return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+	if verification != nil {
+		router.sendMail(*verification)
 	}
-	lastPage := ret.TotalItems / uint64(router.pageSize)
 
-	ret.First = either.Left[*activitystreams.CollectionPage, activitystreams.LinkIface](
-		&activitystreams.CollectionPage{
-			Collection: activitystreams.Collection{
-				Object: activitystreams.Object{
-					Entity: activitystreams.Entity{
-						Id: actor.Id + "/inbox/page/0",
-					},
-				},
-			},
-		},
-	)
-	ret.Last = either.Left[*activitystreams.CollectionPage, activitystreams.LinkIface](
-		&activitystreams.CollectionPage{
-			Collection: activitystreams.Collection{
-				Object: activitystreams.Object{
-					Entity: activitystreams.Entity{
-						Id: actor.Id + "/inbox/page/" + strconv.Itoa(int(lastPage)),
-					},
-				},
-			},
-		},
-	)
+	router.setEndpoints(ret.Actor)
 
-	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+	if registration.Status == database.AccountPending {
+		return ret, nil, apiutil.Statusf(http.StatusAccepted, "user %s awaits approval", username)
+	}
+	return ret, nil, apiutil.Statusf(http.StatusCreated, "created user %s", username)
 }
 
-func (router *PubblrRouter) GetInboxPage(r *http.Request) (*activitystreams.CollectionPage, http.Header, apiutil.Status) {
-	actorShortId := chi.URLParam(r, "actor")
-	page, err := strconv.Atoi(chi.URLParam(r, "page"))
-	if err != nil {
-		return nil, nil, apiutil.NewStatusFromError(http.StatusBadRequest, err)
-	}
+// ARCHIVES
 
-	actorIface, err := router.Database.GetUser(actorShortId)
-	if err != nil {
-		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
-	}
-	actor := activitystreams.ToActor(actorIface)
+// maximum size of an account archive accepted for import
+const maxImportSize = 256 << 20
+
+// how much of an import form is held in memory rather than in temporary files
+const importFormMemory = 1 << 20
+
+func (router *PubblrRouter) Export(r *http.Request) (apiutil.RawResponse, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "actor")
 
-	posts, err := router.Database.GetInboxPage(actorShortId, page, router.pageSize)
+	tx, err := router.Database.Begin(r.Context())
 	if err != nil {
-		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		return apiutil.RawResponse{}, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
 	}
+	defer tx.Rollback()
 
-	items := make([]*either.Either[activitystreams.ObjectIface, activitystreams.LinkIface], len(posts))
-	for i, post := range posts {
-		obj := activitystreams.ToObject(post)
-		obj.Bcc = nil
-		obj.Bto = nil
-		items[i] = either.Left[activitystreams.ObjectIface, activitystreams.LinkIface](post)
+	b, err := archive.Export(r.Context(), tx, username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return apiutil.RawResponse{}, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return apiutil.RawResponse{}, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to export account: %w", err)
 	}
 
-	return &activitystreams.CollectionPage{
-		Collection: activitystreams.Collection{
-			Object: activitystreams.Object{
-				Entity: activitystreams.Entity{
-					Id: actor.Id + "/inbox/page/" + strconv.Itoa(page),
-				},
-			},
-			Ordered: true,
-			Items:   items,
-		},
-		PartOf: either.Left[activitystreams.Collection, activitystreams.Link](
-			activitystreams.Collection{
-				Object: activitystreams.Object{
-					Entity: activitystreams.Entity{
-						Id: actor.Id + "/inbox",
-					},
-				},
-			},
-		),
-	}, nil, nil
+	return apiutil.RawResponse{
+		ContentType: archive.ContentType,
+		Body:        b,
+	}, http.Header{
+		"Content-Disposition": []string{`attachment; filename="` + username + `.zip"`},
+	}, apiutil.StatusFromCode(http.StatusOK)
 }
 
-func (router *PubblrRouter) GetInboxItem(r *http.Request) (activitystreams.ActivityIface, http.Header, apiutil.Status) {
-	id := chi.URLParam(r, "id")
-	user := chi.URLParam(r, "actor")
+// Import restores an archive produced by Export into the account of the user
+// making the request, which must not yet have posted anything.  The request
+// is a multipart form with the archive in the "archive" file.
+func (router *PubblrRouter) Import(r *http.Request) (activitystreams.ActorIface, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "actor")
 
-	activity, err := router.Database.GetInboxItem(user, id)
+	// Archives larger than the form's memory are spilled to temporary files,
+	// from which they are read as they are imported
+	r.Body = http.MaxBytesReader(nil, r.Body, maxImportSize)
+	err := r.ParseMultipartForm(importFormMemory)
 	if err != nil {
-		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+		return nil, nil, apiutil.NewStatusFromError(http.StatusBadRequest, err)
 	}
+	defer r.MultipartForm.RemoveAll()
 
-	return activity, nil, apiutil.StatusFromCode(http.StatusOK)
-}
+	f, header, err := r.FormFile("archive")
+	if err != nil {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Missing archive")
+	}
+	defer f.Close()
 
-// OUTBOX
-func (router *PubblrRouter) GetOutbox(r *http.Request) (*activitystreams.Collection, http.Header, apiutil.Status) {
-	// params
-	actorShortId := chi.URLParam(r, "actor")
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
 
-	// Make sure user actually exists
-	actorIface, err := router.Database.GetUser(actorShortId)
+	// Archives are restored alongside nothing, so that what they hold keeps
+	// its order and nothing is posted twice
+	count, err := tx.GetOutboxCount(r.Context(), username)
 	if err != nil {
-		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	objects, err := tx.GetObjects(r.Context(), username)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if count > 0 || len(objects) > 0 {
+		return nil, nil, apiutil.NewStatus(http.StatusConflict, "Archives may only be imported into accounts which have not posted")
 	}
-	actor := activitystreams.ToActor(actorIface)
 
-	router.setEndpoints(actorIface)
+	imported, err := archive.Import(r.Context(), tx, f, header.Size, username, router.baseUrl)
+	if errors.Is(err, archive.ErrTooLarge) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusRequestEntityTooLarge, err)
+	} else if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusBadRequest, "failed to import account: %w", err)
+	}
 
-	count, err := router.Database.GetOutboxCount(actorShortId)
+	err = tx.Commit()
 	if err != nil {
-		count = 0
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit import: %w", err)
 	}
 
-	ret := &activitystreams.Collection{
-		Object: activitystreams.Object{
-			Entity: activitystreams.Entity{
-				Id: actor.Id + "/outbox",
-			},
-		},
-		TotalItems: uint64(count),
-		Ordered:    true,
+	for _, activity := range imported.Outbox {
+		router.indexActivity(activity)
 	}
 
-	if ret.TotalItems == 0 {
-		return ret, nil, apiutil.StatusFromCode(http.StatusOK)
-	}
-	lastPage := ret.TotalItems / uint64(router.pageSize)
+	router.setEndpoints(imported.Actor)
 
-	ret.First = either.Left[*activitystreams.CollectionPage, activitystreams.LinkIface](
-		&activitystreams.CollectionPage{
-			Collection: activitystreams.Collection{
-				Object: activitystreams.Object{
-					Entity: activitystreams.Entity{
-						Id: actor.Id + "/outbox/page/0",
-					},
-				},
-			},
-		},
-	)
-	ret.Last = either.Left[*activitystreams.CollectionPage, activitystreams.LinkIface](
-		&activitystreams.CollectionPage{
-			Collection: activitystreams.Collection{
-				Object: activitystreams.Object{
-					Entity: activitystreams.Entity{
-						Id: actor.Id + "/outbox/page/" + strconv.Itoa(int(lastPage)),
-					},
-				},
-			},
-		},
-	)
+	return imported.Actor, nil, apiutil.Statusf(http.StatusOK, "imported archive into %s", username)
+}
 
-	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+//INBOX
+
+func (router *PubblrRouter) PostToInbox(r *http.Request) (*activitystreams.ActivityIface, http.Header, apiutil.Status) {
+	return nil, nil, apiutil.NewStatus(http.StatusNotImplemented, "PostToInbox not yet implemented")
 }
 
-func (router *PubblrRouter) GetOutboxPage(r *http.Request) (*activitystreams.CollectionPage, http.Header, apiutil.Status) {
-	actorShortId := chi.URLParam(r, "actor")
-	page, err := strconv.Atoi(chi.URLParam(r, "page"))
-	if err != nil {
-		return nil, nil, apiutil.NewStatusFromError(http.StatusBadRequest, err)
-	}
+// GetInbox serves the inbox collection, or, when a page is requested, a page of
+// its items
+func (router *PubblrRouter) GetInbox(r *http.Request) (activitystreams.CollectionIface, http.Header, apiutil.Status) {
+	return router.getBox(r, "inbox", router.Database.GetInboxCount, router.Database.GetInboxItems)
+}
 
-	actorIface, err := router.Database.GetUser(actorShortId)
-	if err != nil {
-		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
-	}
-	actor := activitystreams.ToActor(actorIface)
+func (router *PubblrRouter) GetInboxItem(r *http.Request) (activitystreams.ActivityIface, http.Header, apiutil.Status) {
+	id := chi.URLParam(r, "id")
+	user := chi.URLParam(r, "actor")
 
-	posts, err := router.Database.GetOutboxPage(actorShortId, page, router.pageSize)
+	activity, err := router.Database.GetInboxItem(r.Context(), user, id)
 	if err != nil {
-		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
 	}
 
-	items := make([]*either.Either[activitystreams.ObjectIface, activitystreams.LinkIface], len(posts))
-	for i, post := range posts {
-		obj := activitystreams.ToObject(post)
-		obj.Bcc = nil
-		obj.Bto = nil
-		items[i] = either.Left[activitystreams.ObjectIface, activitystreams.LinkIface](post)
-	}
+	return activity, nil, apiutil.StatusFromCode(http.StatusOK)
+}
 
-	return &activitystreams.CollectionPage{
-		Collection: activitystreams.Collection{
-			Object: activitystreams.Object{
-				Entity: activitystreams.Entity{
-					Id: actor.Id + "/outbox/page/" + strconv.Itoa(page),
-				},
-			},
-			Ordered: true,
-			Items:   items,
-		},
-		PartOf: either.Left[activitystreams.Collection, activitystreams.Link](
-			activitystreams.Collection{
-				Object: activitystreams.Object{
-					Entity: activitystreams.Entity{
-						Id: actor.Id + "/outbox",
-					},
-				},
-			},
-		),
-	}, nil, nil
+// OUTBOX
+// GetOutbox serves the outbox collection, or, when a page is requested, a page
+// of its items
+func (router *PubblrRouter) GetOutbox(r *http.Request) (activitystreams.CollectionIface, http.Header, apiutil.Status) {
+	return router.getBox(r, "outbox", router.Database.GetOutboxCount, router.Database.GetOutboxItems)
 }
 
+// PostObject posts an activity, or an object to be wrapped in a Create, as
+// the actor to whose outbox it is posted, which may be any of the blogs of the
+// account making the request
 func (router *PubblrRouter) PostObject(r *http.Request) (activitystreams.ObjectIface, http.Header, apiutil.Status) {
 	actorId := chi.URLParam(r, "actor")
-	actor, err := router.Database.GetUser(actorId)
+	actor, err := router.Database.GetUser(r.Context(), actorId)
 	if err != nil {
 		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
 	}
@@ -385,15 +617,59 @@ func (router *PubblrRouter) PostObject(r *http.Request) (activitystreams.ObjectI
 		return nil, nil, apiutil.Statusf(http.StatusBadRequest, "invalid ActivityStreams entity: %w", err)
 	}
 
+	if status := RequireScope(activityScope(activityIface))(r); status != nil {
+		return nil, nil, status
+	}
+
+	// Members post to a group through its outbox as themselves, addressing the
+	// group, which then announces what they post to its followers
+	var group activitystreams.ActorIface
+	if !actsAs(r, actorId) {
+		if _, status := groupMembership(r.Context(), router.Database, r); status != nil {
+			return nil, nil, status
+		}
+		if typ != "Create" {
+			return nil, nil, apiutil.NewStatus(http.StatusForbidden, "Members may only post new objects to a group")
+		}
+		username, _ := currentUser(r)
+		member, err := router.Database.GetUser(r.Context(), username)
+		if err != nil {
+			return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+		group, actor = actor, member
+	}
+
+	// Accounts with side blogs choose which to post as by the outbox they post
+	// to, so an activity naming another actor was likely meant for another
+	// outbox
 	intransitiveActivity := activitystreams.ToIntransitiveActivity(activityIface)
+	if intransitiveActivity.Actor != nil &&
+		activitystreams.ToEntity(intransitiveActivity.Actor).Id != activitystreams.ToObject(actor).Id {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Activities must be posted to the outbox of their actor")
+	}
 	intransitiveActivity.Actor = actor
 	intransitiveActivity.AttributedTo = []activitystreams.EntityIface{actor}
+	if group != nil {
+		intransitiveActivity.To = append(intransitiveActivity.To, group)
+	}
+
+	if create, ok := activityIface.(*activitystreams.Create); ok {
+		if object, ok := create.Object.(activitystreams.ObjectIface); ok {
+			router.addMentions(r.Context(), object)
+		}
+	}
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
 
 	var result activitystreams.ObjectIface
 	var status apiutil.Status
 	switch typ {
 	case "Create":
-		result, status = router.Create(activityIface.(*activitystreams.Create))
+		result, status = router.Create(r.Context(), tx, activityIface.(*activitystreams.Create))
 	default:
 		status = apiutil.Statusf(http.StatusBadRequest, "invalid ActivityStreams activity type: %s", typ)
 	}
@@ -401,7 +677,30 @@ func (router *PubblrRouter) PostObject(r *http.Request) (activitystreams.ObjectI
 		return nil, nil, status
 	}
 
-	router.Deliver(activityIface)
+	err = router.EnqueueDelivery(r.Context(), tx, activityIface)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to enqueue delivery: %w", err)
+	}
+
+	if group != nil {
+		object := activityIface.(*activitystreams.Create).Object.(activitystreams.ObjectIface)
+		announce, err := router.announceToGroup(r.Context(), tx, group, object)
+		if err != nil {
+			return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to announce to group: %w", err)
+		}
+		err = router.EnqueueDelivery(r.Context(), tx, announce)
+		if err != nil {
+			return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to enqueue delivery: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit activity: %w", err)
+	}
+
+	router.Deliver()
+	router.indexActivity(activityIface)
 
 	return result, http.Header{
 		"Location": []string{intransitiveActivity.Id},
@@ -412,7 +711,7 @@ func (router *PubblrRouter) GetOutboxActivity(r *http.Request) (activitystreams.
 	id := chi.URLParam(r, "id")
 	user := chi.URLParam(r, "actor")
 
-	activity, err := router.Database.GetOutboxItem(user, id)
+	activity, err := router.Database.GetOutboxItem(r.Context(), user, id)
 	if err != nil {
 		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
 	}
@@ -474,6 +773,149 @@ func (router *PubblrRouter) GetLikedPage(r *http.Request) (*activitystreams.Coll
 
 // helpers
 
+// query parameters used to page through inboxes and outboxes
+const (
+	pageParam    = "page"
+	maxIdParam   = "max_id"
+	minIdParam   = "min_id"
+	sinceIdParam = "since_id"
+)
+
+// getBox serves an inbox or outbox.  Without paging parameters, it returns the
+// collection itself; otherwise, it returns the page of items selected by the
+// max_id, min_id and since_id parameters, newest first.
+func (router *PubblrRouter) getBox(
+	r *http.Request,
+	box string,
+	getCount func(ctx context.Context, user string) (int, error),
+	getItems func(ctx context.Context, user string, query database.PageQuery) ([]*database.BoxItem, error),
+) (activitystreams.CollectionIface, http.Header, apiutil.Status) {
+	// params
+	actorShortId := chi.URLParam(r, "actor")
+	params := r.URL.Query()
+
+	// Make sure user actually exists
+	actorIface, err := router.Database.GetUser(r.Context(), actorShortId)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	}
+	actor := activitystreams.ToActor(actorIface)
+	boxId := actor.Id + "/" + box
+
+	if params.Get(pageParam) == "" && params.Get(maxIdParam) == "" &&
+		params.Get(minIdParam) == "" && params.Get(sinceIdParam) == "" {
+		count, err := getCount(r.Context(), actorShortId)
+		if err != nil {
+			return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to count %s items: %w", box, err)
+		}
+
+		ret := &activitystreams.Collection{
+			Object: activitystreams.Object{
+				Entity: activitystreams.Entity{
+					Id: boxId,
+				},
+			},
+			TotalItems: uint64(count),
+			Ordered:    true,
+		}
+
+		if ret.TotalItems == 0 {
+			return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+		}
+
+		ret.First = either.Left[*activitystreams.CollectionPage, activitystreams.LinkIface](
+			pageRef(boxId, database.PageQuery{}),
+		)
+
+		return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+	}
+
+	query := database.PageQuery{
+		MaxId:   params.Get(maxIdParam),
+		MinId:   params.Get(minIdParam),
+		SinceId: params.Get(sinceIdParam),
+		Limit:   router.pageSize,
+	}
+
+	boxItems, err := getItems(r.Context(), actorShortId, query)
+	if errors.Is(err, database.ErrInvalidId) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusBadRequest, err)
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	items := make([]*either.Either[activitystreams.ObjectIface, activitystreams.LinkIface], len(boxItems))
+	for i, boxItem := range boxItems {
+		obj := activitystreams.ToObject(boxItem.Activity)
+		obj.Bcc = nil
+		obj.Bto = nil
+		items[i] = either.Left[activitystreams.ObjectIface, activitystreams.LinkIface](boxItem.Activity)
+	}
+
+	ret := pageRef(boxId, query)
+	ret.Items = items
+	ret.PartOf = either.Left[activitystreams.Collection, activitystreams.Link](
+		activitystreams.Collection{
+			Object: activitystreams.Object{
+				Entity: activitystreams.Entity{
+					Id: boxId,
+				},
+			},
+			Ordered: true,
+		},
+	)
+
+	if len(boxItems) == 0 {
+		return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+	}
+
+	// Newer items may arrive at any time, so there is always a previous page
+	newest := boxItems[0].Id
+	ret.Prev = either.Left[activitystreams.CollectionPage, activitystreams.Link](
+		*pageRef(boxId, database.PageQuery{MinId: newest}),
+	)
+
+	oldest := boxItems[len(boxItems)-1].Id
+	older, err := getItems(r.Context(), actorShortId, database.PageQuery{MaxId: oldest, Limit: 1})
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if len(older) > 0 {
+		ret.Next = either.Left[activitystreams.CollectionPage, activitystreams.Link](
+			*pageRef(boxId, database.PageQuery{MaxId: oldest}),
+		)
+	}
+
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// pageRef returns an empty page of the given collection, identified by the URL
+// at which the given query can be retrieved
+func pageRef(boxId string, query database.PageQuery) *activitystreams.CollectionPage {
+	params := url.Values{}
+	params.Set(pageParam, "true")
+	if query.MaxId != "" {
+		params.Set(maxIdParam, query.MaxId)
+	}
+	if query.MinId != "" {
+		params.Set(minIdParam, query.MinId)
+	}
+	if query.SinceId != "" {
+		params.Set(sinceIdParam, query.SinceId)
+	}
+
+	return &activitystreams.CollectionPage{
+		Collection: activitystreams.Collection{
+			Object: activitystreams.Object{
+				Entity: activitystreams.Entity{
+					Id: boxId + "?" + params.Encode(),
+				},
+			},
+			Ordered: true,
+		},
+	}
+}
+
 func (router *PubblrRouter) setEndpoints(a activitystreams.ActorIface) {
 	actor := activitystreams.ToActor(a)
 
@@ -500,4 +942,9 @@ func (router *PubblrRouter) setEndpoints(a activitystreams.ActorIface) {
 	streams := &activitystreams.Collection{}
 	streams.Id = actor.Id + "/streams"
 	actor.Streams = streams
+
+	actor.Endpoints = &activitystreams.ActorEndpoints{
+		OauthAuthorizationEndpoint: router.oauthUrl("authorize"),
+		OauthTokenEndpoint:         router.oauthUrl("token"),
+	}
 }
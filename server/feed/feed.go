@@ -0,0 +1,268 @@
+// Package feed writes RSS and Atom feeds, for readers following blogs in feed
+// readers rather than from the fediverse
+package feed
+
+import (
+	"encoding/xml"
+	"html"
+	"strconv"
+	"time"
+)
+
+// The media types of the feeds
+const (
+	RSSType  = "application/rss+xml; charset=utf-8"
+	AtomType = "application/atom+xml; charset=utf-8"
+)
+
+// Feed is a list of items, newest first, along with what describes them
+type Feed struct {
+	Title       string
+	Description string
+	// the URL of the page the feed follows
+	Link string
+	// the URL of the feed itself
+	Self string
+	// when any of the feed's items last changed; the time of writing the feed,
+	// if zero
+	Updated time.Time
+	Items   []Item
+}
+
+type Person struct {
+	Name string
+	URI  string
+}
+
+// Enclosure is media attached to an item
+type Enclosure struct {
+	URL  string
+	Type string
+	// in bytes, or 0 if unknown
+	Length uint64
+}
+
+// Item is one of the posts in a feed.  Its summary and content are text,
+// never markup.
+type Item struct {
+	Id         string
+	Link       string
+	Title      string
+	Summary    string
+	Content    string
+	Authors    []Person
+	Published  *time.Time
+	Updated    *time.Time
+	Categories []string
+	Enclosures []Enclosure
+}
+
+// updated returns when an item last changed, if known
+func (item *Item) updated() *time.Time {
+	if item.Updated != nil {
+		return item.Updated
+	}
+	return item.Published
+}
+
+func (feed *Feed) updated() time.Time {
+	if !feed.Updated.IsZero() {
+		return feed.Updated
+	}
+	return time.Now()
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title,omitempty"`
+	Link        string        `xml:"link,omitempty"`
+	Description string        `xml:"description,omitempty"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate,omitempty"`
+	Creators    []string      `xml:"dc:creator"`
+	Categories  []string      `xml:"category"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length uint64 `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// RSS writes a feed as RSS 2.0.  RSS allows an item only one enclosure, so
+// only the first of each item's is written.
+func RSS(feed *Feed) ([]byte, error) {
+	doc := rss{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         feed.Title,
+			Link:          feed.Link,
+			Description:   feed.Description,
+			Self:          atomLink{Rel: "self", Type: "application/rss+xml", Href: feed.Self},
+			LastBuildDate: feed.updated().Format(time.RFC1123Z),
+		},
+	}
+	for _, item := range feed.Items {
+		ret := rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			Description: rssDescription(item),
+			GUID:        rssGUID{IsPermaLink: item.Id == item.Link, Value: item.Id},
+			Categories:  item.Categories,
+		}
+		if item.Published != nil {
+			ret.PubDate = item.Published.Format(time.RFC1123Z)
+		}
+		for _, author := range item.Authors {
+			ret.Creators = append(ret.Creators, author.Name)
+		}
+		if len(item.Enclosures) > 0 {
+			enclosure := item.Enclosures[0]
+			ret.Enclosure = &rssEnclosure{URL: enclosure.URL, Length: enclosure.Length, Type: enclosure.Type}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, ret)
+	}
+	return marshal(doc)
+}
+
+// rssDescription returns the markup readers show as an item's description,
+// which RSS expects to be HTML
+func rssDescription(item Item) string {
+	ret := ""
+	if item.Summary != "" {
+		ret += "<p>" + html.EscapeString(item.Summary) + "</p>"
+	}
+	if item.Content != "" {
+		ret += "<p>" + html.EscapeString(item.Content) + "</p>"
+	}
+	return ret
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Length string `xml:"length,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Id         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published,omitempty"`
+	Authors    []atomPerson   `xml:"author"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary"`
+	Content    *atomText      `xml:"content"`
+}
+
+// Atom writes a feed as Atom
+func Atom(feed *Feed) ([]byte, error) {
+	updated := feed.updated()
+	doc := atomFeed{
+		Id:       feed.Self,
+		Title:    feed.Title,
+		Subtitle: feed.Description,
+		Updated:  updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "alternate", Type: "text/html", Href: feed.Link},
+			{Rel: "self", Type: "application/atom+xml", Href: feed.Self},
+		},
+	}
+	for _, item := range feed.Items {
+		ret := atomEntry{
+			Id:    item.Id,
+			Title: item.Title,
+			// entries must say when they last changed, which feeds of
+			// undated items can only guess
+			Updated: updated.Format(time.RFC3339),
+		}
+		if itemUpdated := item.updated(); itemUpdated != nil {
+			ret.Updated = itemUpdated.Format(time.RFC3339)
+		}
+		if item.Published != nil {
+			ret.Published = item.Published.Format(time.RFC3339)
+		}
+		for _, author := range item.Authors {
+			ret.Authors = append(ret.Authors, atomPerson{Name: author.Name, URI: author.URI})
+		}
+		if item.Link != "" {
+			ret.Links = append(ret.Links, atomLink{Rel: "alternate", Type: "text/html", Href: item.Link})
+		}
+		for _, enclosure := range item.Enclosures {
+			link := atomLink{Rel: "enclosure", Type: enclosure.Type, Href: enclosure.URL}
+			if enclosure.Length > 0 {
+				link.Length = strconv.FormatUint(enclosure.Length, 10)
+			}
+			ret.Links = append(ret.Links, link)
+		}
+		for _, category := range item.Categories {
+			ret.Categories = append(ret.Categories, atomCategory{Term: category})
+		}
+		if item.Summary != "" {
+			ret.Summary = &atomText{Type: "text", Value: item.Summary}
+		}
+		if item.Content != "" {
+			ret.Content = &atomText{Type: "text", Value: item.Content}
+		}
+		doc.Entries = append(doc.Entries, ret)
+	}
+	return marshal(doc)
+}
+
+func marshal(doc interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
@@ -0,0 +1,187 @@
+package feed_test
+
+import (
+	"encoding/xml"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server/feed"
+)
+
+var _ = Describe("Feed", func() {
+	published := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	updated := published.Add(time.Hour)
+	testFeed := &feed.Feed{
+		Title:       "Alice",
+		Description: "Posts by <Alice>",
+		Link:        "http://example.org/alice",
+		Self:        "http://example.org/alice/feed",
+		Items: []feed.Item{
+			{
+				Id:         "http://example.org/alice/note/0",
+				Link:       "http://example.org/alice/note/0",
+				Title:      "Hello",
+				Content:    "<b>hi</b>",
+				Authors:    []feed.Person{{Name: "Alice", URI: "http://example.org/alice"}},
+				Published:  &published,
+				Updated:    &updated,
+				Categories: []string{"go"},
+				Enclosures: []feed.Enclosure{
+					{URL: "http://example.org/a.png", Type: "image/png"},
+					{URL: "http://example.org/b.mp4", Type: "video/mp4", Length: 1024},
+				},
+			},
+		},
+	}
+
+	Describe("RSS", func() {
+		type rss struct {
+			Version string `xml:"version,attr"`
+			Channel struct {
+				Title       string `xml:"title"`
+				Link        string `xml:"link"`
+				Description string `xml:"description"`
+				Items       []struct {
+					Title       string   `xml:"title"`
+					Link        string   `xml:"link"`
+					Description string   `xml:"description"`
+					GUID        string   `xml:"guid"`
+					PubDate     string   `xml:"pubDate"`
+					Creators    []string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+					Categories  []string `xml:"category"`
+					Enclosures  []struct {
+						URL    string `xml:"url,attr"`
+						Length string `xml:"length,attr"`
+						Type   string `xml:"type,attr"`
+					} `xml:"enclosure"`
+				} `xml:"item"`
+			} `xml:"channel"`
+		}
+
+		It("should write a feed as RSS 2.0", func() {
+			body, err := feed.RSS(testFeed)
+			Expect(err).ToNot(HaveOccurred())
+
+			var doc rss
+			Expect(xml.Unmarshal(body, &doc)).To(Succeed())
+			Expect(doc.Version).To(Equal("2.0"))
+			Expect(doc.Channel.Title).To(Equal("Alice"))
+			Expect(doc.Channel.Description).To(Equal("Posts by <Alice>"))
+			Expect(doc.Channel.Items).To(HaveLen(1))
+
+			item := doc.Channel.Items[0]
+			Expect(item.Title).To(Equal("Hello"))
+			Expect(item.GUID).To(Equal("http://example.org/alice/note/0"))
+			Expect(item.PubDate).To(Equal("Fri, 01 Mar 2024 12:00:00 +0000"))
+			Expect(item.Creators).To(Equal([]string{"Alice"}))
+			Expect(item.Categories).To(Equal([]string{"go"}))
+		})
+
+		It("should escape content, which is text, within descriptions, which are HTML", func() {
+			body, err := feed.RSS(testFeed)
+			Expect(err).ToNot(HaveOccurred())
+
+			var doc rss
+			Expect(xml.Unmarshal(body, &doc)).To(Succeed())
+			Expect(doc.Channel.Items[0].Description).To(Equal("<p>&lt;b&gt;hi&lt;/b&gt;</p>"))
+		})
+
+		It("should write only the first enclosure of each item", func() {
+			body, err := feed.RSS(testFeed)
+			Expect(err).ToNot(HaveOccurred())
+
+			var doc rss
+			Expect(xml.Unmarshal(body, &doc)).To(Succeed())
+			Expect(doc.Channel.Items[0].Enclosures).To(HaveLen(1))
+			Expect(doc.Channel.Items[0].Enclosures[0].URL).To(Equal("http://example.org/a.png"))
+			Expect(doc.Channel.Items[0].Enclosures[0].Length).To(Equal("0"))
+			Expect(doc.Channel.Items[0].Enclosures[0].Type).To(Equal("image/png"))
+		})
+	})
+
+	Describe("Atom", func() {
+		type link struct {
+			Rel    string `xml:"rel,attr"`
+			Type   string `xml:"type,attr"`
+			Href   string `xml:"href,attr"`
+			Length string `xml:"length,attr"`
+		}
+		type atom struct {
+			XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+			Id      string   `xml:"id"`
+			Title   string   `xml:"title"`
+			Updated string   `xml:"updated"`
+			Links   []link   `xml:"link"`
+			Entries []struct {
+				Id        string `xml:"id"`
+				Title     string `xml:"title"`
+				Updated   string `xml:"updated"`
+				Published string `xml:"published"`
+				Author    struct {
+					Name string `xml:"name"`
+					URI  string `xml:"uri"`
+				} `xml:"author"`
+				Links    []link `xml:"link"`
+				Category struct {
+					Term string `xml:"term,attr"`
+				} `xml:"category"`
+				Content struct {
+					Type  string `xml:"type,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"content"`
+			} `xml:"entry"`
+		}
+
+		It("should write a feed as Atom", func() {
+			body, err := feed.Atom(testFeed)
+			Expect(err).ToNot(HaveOccurred())
+
+			var doc atom
+			Expect(xml.Unmarshal(body, &doc)).To(Succeed())
+			Expect(doc.Id).To(Equal("http://example.org/alice/feed"))
+			Expect(doc.Title).To(Equal("Alice"))
+			Expect(doc.Links).To(ContainElement(link{Rel: "self", Type: "application/atom+xml", Href: "http://example.org/alice/feed"}))
+			Expect(doc.Entries).To(HaveLen(1))
+
+			entry := doc.Entries[0]
+			Expect(entry.Id).To(Equal("http://example.org/alice/note/0"))
+			Expect(entry.Published).To(Equal("2024-03-01T12:00:00Z"))
+			Expect(entry.Updated).To(Equal("2024-03-01T13:00:00Z"))
+			Expect(entry.Author.Name).To(Equal("Alice"))
+			Expect(entry.Author.URI).To(Equal("http://example.org/alice"))
+			Expect(entry.Category.Term).To(Equal("go"))
+			Expect(entry.Content.Type).To(Equal("text"))
+			Expect(entry.Content.Value).To(Equal("<b>hi</b>"))
+		})
+
+		It("should write every enclosure of each entry", func() {
+			body, err := feed.Atom(testFeed)
+			Expect(err).ToNot(HaveOccurred())
+
+			var doc atom
+			Expect(xml.Unmarshal(body, &doc)).To(Succeed())
+			Expect(doc.Entries[0].Links).To(Equal([]link{
+				{Rel: "alternate", Type: "text/html", Href: "http://example.org/alice/note/0"},
+				{Rel: "enclosure", Type: "image/png", Href: "http://example.org/a.png"},
+				{Rel: "enclosure", Type: "video/mp4", Href: "http://example.org/b.mp4", Length: "1024"},
+			}))
+		})
+
+		It("should date undated entries by the feed", func() {
+			body, err := feed.Atom(&feed.Feed{
+				Title:   "Alice",
+				Self:    "http://example.org/alice/feed",
+				Updated: updated,
+				Items:   []feed.Item{{Id: "http://example.org/alice/note/0", Title: "Hello"}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			var doc atom
+			Expect(xml.Unmarshal(body, &doc)).To(Succeed())
+			Expect(doc.Updated).To(Equal("2024-03-01T13:00:00Z"))
+			Expect(doc.Entries[0].Updated).To(Equal("2024-03-01T13:00:00Z"))
+		})
+	})
+})
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/search"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/feed"
+	"github.com/brandonsides/pubblr/server/render"
+	"github.com/brandonsides/pubblr/util/markup"
+	"github.com/go-chi/chi"
+)
+
+// the longest title made from the start of an untitled post's content, in
+// characters
+const maxExcerptLength = 80
+
+// FeedFormat is a format in which feeds are written
+type FeedFormat struct {
+	// the extension of the file name at which feeds are served in the format
+	Extension   string
+	ContentType string
+	Write       func(*feed.Feed) ([]byte, error)
+}
+
+var (
+	RSSFeed  = FeedFormat{Extension: "rss", ContentType: feed.RSSType, Write: feed.RSS}
+	AtomFeed = FeedFormat{Extension: "atom", ContentType: feed.AtomType, Write: feed.Atom}
+)
+
+// feedFormats are the formats in which every feed is offered
+var feedFormats = []FeedFormat{RSSFeed, AtomFeed}
+
+// serveFeed writes a feed in the given format
+func serveFeed(format FeedFormat, ret *feed.Feed) (apiutil.RawResponse, http.Header, apiutil.Status) {
+	// a feed changes when any of its items last did
+	for _, item := range ret.Items {
+		updated := item.Updated
+		if updated == nil {
+			updated = item.Published
+		}
+		if updated != nil && updated.After(ret.Updated) {
+			ret.Updated = *updated
+		}
+	}
+
+	body, err := format.Write(ret)
+	if err != nil {
+		return apiutil.RawResponse{}, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to write feed: %w", err)
+	}
+	return apiutil.RawResponse{
+		ContentType: format.ContentType,
+		Body:        body,
+	}, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// ActorFeed serves a feed of the public posts an actor has created
+func (router *PubblrRouter) ActorFeed(format FeedFormat) apiutil.Endpoint[apiutil.RawResponse] {
+	return func(r *http.Request) (apiutil.RawResponse, http.Header, apiutil.Status) {
+		name := chi.URLParam(r, "actor")
+		actor, status := router.feedActor(r, name)
+		if status != nil {
+			return apiutil.RawResponse{}, nil, status
+		}
+
+		objects, _, err := router.outboxObjects(r.Context(), name, "", isPublic)
+		if err != nil {
+			return apiutil.RawResponse{}, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+
+		return serveFeed(format, &feed.Feed{
+			Title:       actor.Name,
+			Description: actor.Summary,
+			Link:        actor.Id,
+			Self:        router.endpointUrl(name, "feed."+format.Extension),
+			Items:       feedItems(objects),
+		})
+	}
+}
+
+// StreamFeed serves a feed of the public posts an actor has created and
+// addressed to one of its streams
+func (router *PubblrRouter) StreamFeed(format FeedFormat) apiutil.Endpoint[apiutil.RawResponse] {
+	return func(r *http.Request) (apiutil.RawResponse, http.Header, apiutil.Status) {
+		name := chi.URLParam(r, "actor")
+		stream := chi.URLParam(r, "id")
+		actor, status := router.feedActor(r, name)
+		if status != nil {
+			return apiutil.RawResponse{}, nil, status
+		}
+
+		streamId := actor.Id + "/streams/" + url.PathEscape(stream)
+		objects, _, err := router.outboxObjects(r.Context(), name, "", func(object activitystreams.ObjectIface) bool {
+			return isPublic(object) && addressedTo(object, streamId)
+		})
+		if err != nil {
+			return apiutil.RawResponse{}, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+
+		return serveFeed(format, &feed.Feed{
+			Title:       fmt.Sprintf("%s: %s", actor.Name, stream),
+			Description: actor.Summary,
+			Link:        actor.Id,
+			Self:        router.endpointUrl(name, "streams", stream, "feed."+format.Extension),
+			Items:       feedItems(objects),
+		})
+	}
+}
+
+// TagFeed serves a feed of the public posts bearing a hashtag
+func (router *PubblrRouter) TagFeed(format FeedFormat) apiutil.Endpoint[apiutil.RawResponse] {
+	return func(r *http.Request) (apiutil.RawResponse, http.Header, apiutil.Status) {
+		tag := search.NormalizeTag(chi.URLParam(r, "tag"))
+		hits, err := router.Search.Search(search.Query{
+			Tag:     tag,
+			Limit:   router.pageSize,
+			Visible: isPublic,
+		})
+		if err != nil {
+			return apiutil.RawResponse{}, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+
+		objects := make([]activitystreams.ObjectIface, 0, len(hits))
+		for _, hit := range hits {
+			objects = append(objects, hit.Object)
+		}
+		return serveFeed(format, &feed.Feed{
+			Title: "#" + tag,
+			Link:  router.tagUrl(tag),
+			Self:  router.endpointUrl("tags", tag, "feed."+format.Extension),
+			Items: feedItems(objects),
+		})
+	}
+}
+
+// feedActor returns the actor whose posts a feed follows, named by its
+// username if it has no other name
+func (router *PubblrRouter) feedActor(r *http.Request, name string) (*activitystreams.Actor, apiutil.Status) {
+	actorIface, err := router.Database.GetUser(r.Context(), name)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	actor := *activitystreams.ToActor(actorIface)
+	if actor.Name == "" {
+		actor.Name = name
+	}
+	return &actor, nil
+}
+
+// addressedTo reports whether an object is addressed to the given collection
+func addressedTo(objectIface activitystreams.ObjectIface, id string) bool {
+	object := activitystreams.ToObject(objectIface)
+	return in(id, mapitems(
+		func(e activitystreams.EntityIface) string {
+			return activitystreams.ToEntity(e).Id
+		}, object.To, object.Cc, object.Bto, object.Bcc, object.Audience,
+	))
+}
+
+func feedItems(objects []activitystreams.ObjectIface) []feed.Item {
+	ret := make([]feed.Item, 0, len(objects))
+	for _, object := range objects {
+		ret = append(ret, feedItem(object))
+	}
+	return ret
+}
+
+// feedItem maps an object to a feed item, whose summary and content are the
+// text of the object's, as feeds carry no markup.  The url of an image or video is
+// its media, which is enclosed along with the object's attachments, rather
+// than a page on which it is shown.
+func feedItem(objectIface activitystreams.ObjectIface) feed.Item {
+	object := activitystreams.ToObject(objectIface)
+
+	ret := feed.Item{
+		Id:        object.Id,
+		Link:      object.Id,
+		Title:     object.Name,
+		Summary:   markup.Text(object.Summary),
+		Content:   markup.Text(object.Content),
+		Published: object.Published,
+		Updated:   object.Updated,
+	}
+	if ret.Title == "" {
+		ret.Title = excerpt(ret.Summary)
+	}
+	if ret.Title == "" {
+		ret.Title = excerpt(ret.Content)
+	}
+
+	if isMedia(objectIface) {
+		if enclosure, ok := feedEnclosure(objectIface); ok {
+			ret.Enclosures = append(ret.Enclosures, enclosure)
+		}
+	} else if object.URL != nil {
+		ret.Link = entityUrl(objectIface)
+	}
+	attachments := append([]activitystreams.EntityIface(nil), object.Attachment...)
+	if object.Image != nil {
+		attachments = append(attachments, object.Image)
+	}
+	for _, attachment := range attachments {
+		if enclosure, ok := feedEnclosure(attachment); ok {
+			ret.Enclosures = append(ret.Enclosures, enclosure)
+		}
+	}
+
+	for _, author := range object.AttributedTo {
+		if author == nil {
+			continue
+		}
+		entity := activitystreams.ToEntity(author)
+		name := entity.Name
+		if name == "" {
+			name = shortId(entity.Id)
+		}
+		ret.Authors = append(ret.Authors, feed.Person{Name: name, URI: entity.Id})
+	}
+	for _, tag := range object.Tag {
+		if hashtag, ok := tag.(*activitystreams.Hashtag); ok {
+			ret.Categories = append(ret.Categories, strings.TrimPrefix(hashtag.Name, "#"))
+		}
+	}
+	return ret
+}
+
+// isMedia reports whether an object is itself media, whose url is that of a
+// file rather than a page
+func isMedia(entity activitystreams.EntityIface) bool {
+	switch entity.(type) {
+	case *activitystreams.Image, *activitystreams.Video, *activitystreams.Audio, *activitystreams.Document:
+		return true
+	}
+	return false
+}
+
+// feedEnclosure returns media attached to an object as an enclosure: either a
+// link to it, or a media object with a url
+func feedEnclosure(entityIface activitystreams.EntityIface) (feed.Enclosure, bool) {
+	var ret feed.Enclosure
+	switch entity := entityIface.(type) {
+	case *activitystreams.Link:
+		ret = feed.Enclosure{URL: entity.Href, Type: entity.MediaType}
+	case activitystreams.ObjectIface:
+		object := activitystreams.ToObject(entity)
+		if !isMedia(entity) || object.URL == nil {
+			return ret, false
+		}
+		ret = feed.Enclosure{URL: entityUrl(entity), Type: object.MediaType}
+		if link := object.URL.Right(); ret.Type == "" && link != nil && *link != nil {
+			ret.Type = activitystreams.ToLink(*link).MediaType
+		}
+	default:
+		return ret, false
+	}
+	if ret.URL == "" {
+		return ret, false
+	}
+
+	if ret.Type == "" {
+		if u, err := url.Parse(ret.URL); err == nil {
+			ret.Type = mime.TypeByExtension(path.Ext(u.Path))
+		}
+	}
+	if ret.Type == "" {
+		ret.Type = "application/octet-stream"
+	}
+	return ret, true
+}
+
+// excerpt returns the first line of some text, shortened to a title
+func excerpt(text string) string {
+	text, _, _ = strings.Cut(strings.TrimSpace(text), "\n")
+	if utf8.RuneCountInString(text) <= maxExcerptLength {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:maxExcerptLength-1])) + "…"
+}
+
+// feedLinks returns the links to the feeds served at the given path, by which
+// readers may discover them from its pages
+func (router *PubblrRouter) feedLinks(title string, elem ...string) []render.Feed {
+	var ret []render.Feed
+	for _, format := range feedFormats {
+		mediaType, _, _ := mime.ParseMediaType(format.ContentType)
+		ret = append(ret, render.Feed{
+			Title: title,
+			Type:  mediaType,
+			Href:  router.endpointUrl(append(elem, "feed."+format.Extension)...),
+		})
+	}
+	return ret
+}
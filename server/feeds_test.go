@@ -0,0 +1,85 @@
+package server_test
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+)
+
+var _ = Describe("Feeds", func() {
+	var router server.PubblrRouter
+	var alice string
+
+	type atom struct {
+		Entries []struct {
+			Title   string `xml:"title"`
+			Content string `xml:"content"`
+			Links   []struct {
+				Rel  string `xml:"rel,attr"`
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+
+	// feed returns alice's Atom feed
+	feed := func() atom {
+		w := do(router, "GET", "/alice/feed.atom", "", "")
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var ret atom
+		Expect(xml.Unmarshal(w.Body.Bytes(), &ret)).To(Succeed())
+		return ret
+	}
+
+	// postJSON posts a public note with the given properties
+	postJSON := func(properties string) {
+		w := do(router, "POST", "/alice/outbox",
+			`{"@context":"https://www.w3.org/ns/activitystreams","type":"Note",`+properties+
+				`,"to":[{"type":"Link","id":"https://www.w3.org/ns/activitystreams#Public"}]}`, alice)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+	}
+
+	BeforeEach(func() {
+		router = newRouter()
+		alice = register(router, "alice")
+	})
+
+	It("should title items with the text of their content, without its markup", func() {
+		postJSON(`"content":"<p>hi <a href=\"https://example.com/bob\" class=\"mention\">@bob</a> &amp; friends</p><p>more</p>"`)
+		Expect(feed().Entries).To(ConsistOf(HaveField("Title", "hi @bob & friends")))
+	})
+
+	It("should give the text of items' content, without its markup", func() {
+		postJSON(`"content":"<p>hi <a href=\"https://example.com/bob\" class=\"mention\">@bob</a></p>"`)
+		entries := feed().Entries
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Content).To(ContainSubstring("hi @bob"))
+		Expect(entries[0].Content).ToNot(ContainSubstring("<"))
+	})
+
+	It("should enclose both the attachments and the image of an item", func() {
+		postJSON(`"content":"pictures",` +
+			`"attachment":[{"type":"Image","url":"https://example.com/a.png"}],` +
+			`"image":{"type":"Image","url":"https://example.com/b.png"}`)
+		postJSON(`"content":"no image","attachment":[{"type":"Image","url":"https://example.com/c.png"}]`)
+
+		entries := feed().Entries
+		Expect(entries).To(HaveLen(2))
+		enclosures := func(i int) []string {
+			var ret []string
+			for _, link := range entries[i].Links {
+				if link.Rel == "enclosure" {
+					ret = append(ret, link.Href)
+				}
+			}
+			return ret
+		}
+		Expect([][]string{enclosures(0), enclosures(1)}).To(ConsistOf(
+			ConsistOf("https://example.com/a.png", "https://example.com/b.png"),
+			ConsistOf("https://example.com/c.png"),
+		))
+	})
+})
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/go-chi/chi"
+)
+
+var groupRoles = []database.GroupRole{database.GroupOwner, database.GroupAdmin, database.GroupPoster}
+
+// CreateGroupRequest creates a group
+type CreateGroupRequest struct {
+	// the name of the group, by which its actor is known, as a username
+	Name string `json:"name"`
+	// defaults to a Group with the group's name
+	Actor activitystreams.ActorIface `json:"actor,omitempty"`
+}
+
+// GroupMemberRequest sets the role of a member of a group
+type GroupMemberRequest struct {
+	Role database.GroupRole `json:"role"`
+}
+
+// GroupMemberResponse describes an account's membership of a group
+type GroupMemberResponse struct {
+	Group    string             `json:"group"`
+	Username string             `json:"username"`
+	Role     database.GroupRole `json:"role"`
+	Added    time.Time          `json:"added"`
+}
+
+func groupMemberResponse(member *database.GroupMember) *GroupMemberResponse {
+	return &GroupMemberResponse{
+		Group:    member.Group,
+		Username: member.Username,
+		Role:     member.Role,
+		Added:    member.Added,
+	}
+}
+
+// GetGroups lists the groups of which the account making the request is a
+// member
+func (router *PubblrRouter) GetGroups(r *http.Request) ([]*GroupMemberResponse, http.Header, apiutil.Status) {
+	groups, err := router.Database.GetGroups(r.Context(), chi.URLParam(r, "actor"))
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	ret := make([]*GroupMemberResponse, 0, len(groups))
+	for _, group := range groups {
+		ret = append(ret, groupMemberResponse(group))
+	}
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// PostGroup creates a group with the account making the request as its owner.
+// Its members may then post through the group's outbox.
+func (router *PubblrRouter) PostGroup(r *http.Request) (activitystreams.ActorIface, http.Header, apiutil.Status) {
+	account := chi.URLParam(r, "actor")
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusBadRequest, err)
+	}
+	var body CreateGroupRequest
+	err = activitystreams.DefaultEntityUnmarshaler.Unmarshal(b, &body)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusBadRequest, "invalid ActivityStreams actor: %w", err)
+	}
+
+	if status := checkUsername(body.Name); status != nil {
+		return nil, nil, status
+	}
+	if router.reservedUsername(body.Name) {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Username is reserved")
+	}
+	if body.Actor == nil {
+		group := &activitystreams.Group{}
+		group.Name = body.Name
+		body.Actor = group
+	}
+
+	group, err := router.Database.CreateGroup(r.Context(), body.Actor, body.Name, account, router.baseUrl)
+	if errors.Is(err, database.ErrUserExists) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusConflict, err)
+	} else if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to create group: %w", err)
+	}
+
+	router.setEndpoints(group)
+	return group, http.Header{
+		"Location": []string{activitystreams.ToObject(group).Id},
+	}, apiutil.StatusFromCode(http.StatusCreated)
+}
+
+// groupMembership returns the membership of the group named by the actor URL
+// parameter held by the user making the request, refusing requests from
+// anyone else
+func groupMembership(ctx context.Context, db database.Queries, r *http.Request) (*database.GroupMember, apiutil.Status) {
+	username, _ := currentUser(r)
+	member, err := db.GetGroupMember(ctx, chi.URLParam(r, "actor"), username)
+	if errors.Is(err, database.ErrUserNotFound) || errors.Is(err, database.ErrNotGroup) {
+		return nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if errors.Is(err, database.ErrNotFound) {
+		return nil, apiutil.NewStatus(http.StatusForbidden, "You are not a member of this group")
+	} else if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	return member, nil
+}
+
+// GetGroupMembers lists the members of a group to its members
+func (router *PubblrRouter) GetGroupMembers(r *http.Request) ([]*GroupMemberResponse, http.Header, apiutil.Status) {
+	if _, status := groupMembership(r.Context(), router.Database, r); status != nil {
+		return nil, nil, status
+	}
+
+	members, err := router.Database.GetGroupMembers(r.Context(), chi.URLParam(r, "actor"))
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	ret := make([]*GroupMemberResponse, 0, len(members))
+	for _, member := range members {
+		ret = append(ret, groupMemberResponse(member))
+	}
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// mayManage reports whether a member of a group may change the membership of
+// another member, or of an account joining, with the given role.  Owners
+// manage everyone, and admins manage posters.
+func mayManage(manager *database.GroupMember, role database.GroupRole) bool {
+	switch manager.Role {
+	case database.GroupOwner:
+		return true
+	case database.GroupAdmin:
+		return role == database.GroupPoster
+	}
+	return false
+}
+
+// checkOwnerRemains refuses to change the membership of a group's owner if no
+// other owner would remain, as the group could then no longer be managed
+func checkOwnerRemains(ctx context.Context, db database.Queries, member *database.GroupMember) apiutil.Status {
+	if member.Role != database.GroupOwner {
+		return nil
+	}
+
+	members, err := db.GetGroupMembers(ctx, member.Group)
+	if err != nil {
+		return apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	for _, other := range members {
+		if other.Role == database.GroupOwner && other.Username != member.Username {
+			return nil
+		}
+	}
+	return apiutil.NewStatus(http.StatusConflict, "A group must keep at least one owner")
+}
+
+// PutGroupMember adds an account to a group or changes its role
+func (router *PubblrRouter) PutGroupMember(r *http.Request) (*GroupMemberResponse, http.Header, apiutil.Status) {
+	group := chi.URLParam(r, "actor")
+	username := chi.URLParam(r, "username")
+
+	var body GroupMemberRequest
+	if status := readJSON(r, &body); status != nil {
+		return nil, nil, status
+	}
+	if !in(body.Role, groupRoles) {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, fmt.Sprintf("Unknown role %s", body.Role))
+	}
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	manager, status := groupMembership(r.Context(), tx, r)
+	if status != nil {
+		return nil, nil, status
+	}
+
+	member, err := tx.GetGroupMember(r.Context(), group, username)
+	if errors.Is(err, database.ErrNotFound) {
+		member = &database.GroupMember{
+			Group:    group,
+			Username: username,
+			Added:    time.Now(),
+		}
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	} else if !mayManage(manager, member.Role) {
+		return nil, nil, apiutil.NewStatus(http.StatusForbidden, "You may not change the role of this member")
+	}
+	if !mayManage(manager, body.Role) {
+		return nil, nil, apiutil.NewStatus(http.StatusForbidden, "You may not grant this role")
+	}
+	if body.Role != database.GroupOwner {
+		if status := checkOwnerRemains(r.Context(), tx, member); status != nil {
+			return nil, nil, status
+		}
+	}
+
+	member.Role = body.Role
+	err = tx.SetGroupMember(r.Context(), *member)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusBadRequest, "failed to set member: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit member: %w", err)
+	}
+
+	return groupMemberResponse(member), nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// DeleteGroupMember removes an account from a group.  Members may always
+// leave a group themselves, so long as an owner remains.
+func (router *PubblrRouter) DeleteGroupMember(r *http.Request) (*struct{}, http.Header, apiutil.Status) {
+	group := chi.URLParam(r, "actor")
+	username := chi.URLParam(r, "username")
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	manager, status := groupMembership(r.Context(), tx, r)
+	if status != nil {
+		return nil, nil, status
+	}
+
+	member, err := tx.GetGroupMember(r.Context(), group, username)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusNotFound, err)
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if member.Username != manager.Username && !mayManage(manager, member.Role) {
+		return nil, nil, apiutil.NewStatus(http.StatusForbidden, "You may not remove this member")
+	}
+	if status := checkOwnerRemains(r.Context(), tx, member); status != nil {
+		return nil, nil, status
+	}
+
+	err = tx.RemoveGroupMember(r.Context(), group, username)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to remove member: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit member: %w", err)
+	}
+
+	return nil, nil, apiutil.StatusFromCode(http.StatusNoContent)
+}
+
+// announceToGroup adds to a group's outbox an Announce of an object one of its
+// members has posted through it, addressed to the group's followers, so that
+// those following the group see what its members post
+func (router *PubblrRouter) announceToGroup(ctx context.Context, tx database.Queries, group activitystreams.ActorIface, object activitystreams.ObjectIface) (*activitystreams.Announce, error) {
+	followers := &activitystreams.Collection{}
+	followers.Id = activitystreams.ToObject(group).Id + "/followers"
+
+	announce := &activitystreams.Announce{}
+	announce.Actor = group
+	announce.AttributedTo = []activitystreams.EntityIface{group}
+	announce.Object = object
+	announce.To = []activitystreams.EntityIface{followers}
+	if isPublic(object) {
+		public := &activitystreams.Collection{}
+		public.Id = publicAddress
+		announce.Cc = []activitystreams.EntityIface{public}
+	}
+	published := time.Now()
+	announce.Published = &published
+	announce.Updated = &published
+
+	_, err := tx.CreateOutboxItem(ctx, announce, shortId(activitystreams.ToObject(group).Id), router.baseUrl)
+	if err != nil {
+		return nil, err
+	}
+	return announce, nil
+}
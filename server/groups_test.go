@@ -0,0 +1,65 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server"
+)
+
+var _ = Describe("Groups", func() {
+	var router server.PubblrRouter
+	var alice, bob, carol string
+
+	BeforeEach(func() {
+		router = newRouter()
+		alice = register(router, "alice")
+		bob = register(router, "bob")
+		carol = register(router, "carol")
+
+		w := do(router, "POST", "/alice/groups", `{"name":"club"}`, alice)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		w = do(router, "PUT", "/club/members/bob", `{"role":"poster"}`, alice)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+	})
+
+	It("should let members post through a group as themselves, announced by the group", func() {
+		w := post(router, "club", bob, "hello club", "https://www.w3.org/ns/activitystreams#Public")
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		Expect(w.Header().Get("Location")).To(HavePrefix(baseUrl + "/bob/"))
+
+		Expect(totalItems(router, "/bob/outbox", bob)).To(Equal(1))
+		items, err := router.Database.GetOutboxItems(context.Background(), "club", database.PageQuery{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(items).To(HaveLen(1))
+		announce, ok := items[0].Activity.(*activitystreams.Announce)
+		Expect(ok).To(BeTrue())
+		Expect(activitystreams.ToEntity(announce.Object).Id).To(HavePrefix(baseUrl + "/bob/"))
+	})
+
+	It("should not let anyone else post through a group", func() {
+		Expect(post(router, "club", carol, "gatecrash").Code).To(Equal(http.StatusForbidden))
+		Expect(post(router, "club", "", "anonymous").Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should not let members act as the group", func() {
+		w := do(router, "POST", "/club/outbox",
+			`{"@context":"https://www.w3.org/ns/activitystreams","type":"Follow","object":{"type":"Link","id":"`+baseUrl+`/carol"}}`, bob)
+		Expect(w.Code).To(Equal(http.StatusForbidden), w.Body.String())
+
+		Expect(do(router, "GET", "/club/inbox", "", bob).Code).To(Equal(http.StatusForbidden))
+		Expect(do(router, "POST", "/club/inbox", `{"type":"Note","content":"hi"}`, bob).Code).To(Equal(http.StatusForbidden))
+		Expect(do(router, "PUT", "/club/appearance", `{"css":"body{}"}`, bob).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should let owners manage the group's appearance", func() {
+		w := do(router, "PUT", "/club/appearance", `{"css":"body{}"}`, alice)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		Expect(w.Body.String()).To(ContainSubstring("body{}"))
+	})
+})
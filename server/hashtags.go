@@ -0,0 +1,65 @@
+package server
+
+import (
+	"path"
+	"regexp"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/search"
+)
+
+// A hashtag is a '#' followed by letters, digits and underscores, at least one
+// of which is not a digit.  The '#' must not follow a word character, '&' (as
+// in an HTML entity) or '/' (as in a URL fragment).
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&#/])#([\p{L}\p{N}_]*[\p{L}_][\p{L}\p{N}_]*)`)
+
+// extractHashtags returns the names of the hashtags in the given text, without
+// their leading '#', in the order in which they first appear
+func extractHashtags(text string) []string {
+	var ret []string
+	seen := make(map[string]bool)
+	for _, match := range hashtagPattern.FindAllStringSubmatch(text, -1) {
+		tag := match[1]
+		if !seen[search.NormalizeTag(tag)] {
+			seen[search.NormalizeTag(tag)] = true
+			ret = append(ret, tag)
+		}
+	}
+	return ret
+}
+
+// addHashtags tags the given object with each hashtag in its summary and
+// content which it is not already tagged with
+func (router *PubblrRouter) addHashtags(objectIface activitystreams.ObjectIface) {
+	object := activitystreams.ToObject(objectIface)
+
+	tagged := make(map[string]bool)
+	for _, tag := range object.Tag {
+		if hashtag, ok := tag.(*activitystreams.Hashtag); ok {
+			tagged[search.NormalizeTag(hashtag.Name)] = true
+		}
+	}
+
+	for _, tag := range extractHashtags(object.Summary + "\n" + object.Content) {
+		if tagged[search.NormalizeTag(tag)] {
+			continue
+		}
+		tagged[search.NormalizeTag(tag)] = true
+
+		object.Tag = append(object.Tag, &activitystreams.Hashtag{
+			Link: activitystreams.Link{
+				Entity: activitystreams.Entity{
+					Name: "#" + tag,
+				},
+				Href: router.tagUrl(tag),
+			},
+		})
+	}
+}
+
+// tagUrl returns the URL of the local page for the given hashtag
+func (router *PubblrRouter) tagUrl(tag string) string {
+	tagUrl := router.baseUrl
+	tagUrl.Path = path.Join(tagUrl.Path, "tags", search.NormalizeTag(tag))
+	return tagUrl.String()
+}
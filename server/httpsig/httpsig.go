@@ -0,0 +1,329 @@
+// Package httpsig signs and verifies requests with HTTP Signatures, by which
+// federated servers authenticate the actors on whose behalf they make requests
+package httpsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoSignature is returned for requests which are not signed at all
+	ErrNoSignature      = errors.New("request is not signed")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// DefaultMaxSkew is how far the time at which a request was signed may be
+// from the time at which it is verified, allowing for clock drift between
+// servers
+const DefaultMaxSkew = time.Hour
+
+// RequiredHeaders are the headers which must be signed for a signature to be
+// accepted, so that it cannot be replayed against another endpoint, server or
+// time.  Either date or (created) must be signed as well, and digest must be
+// for requests with a body.
+var RequiredHeaders = []string{"(request-target)", "host"}
+
+// Signature is a parsed Signature header
+type Signature struct {
+	KeyId     string
+	Algorithm string
+	// the lowercase names of the signed headers, in the order in which they
+	// were signed
+	Headers   []string
+	Signature []byte
+	// the times at which the signature was created and expires, as Unix
+	// times; zero, if not given
+	Created int64
+	Expires int64
+}
+
+// Parse parses the signature of a request, given either in a Signature
+// header or as the credentials of an Authorization header with the Signature
+// scheme.  It returns ErrNoSignature if there is neither.
+func Parse(r *http.Request) (*Signature, error) {
+	value := r.Header.Get("Signature")
+	if value == "" {
+		scheme, params, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Signature") {
+			return nil, ErrNoSignature
+		}
+		value = params
+	}
+
+	params, err := parseParams(value)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := &Signature{
+		KeyId:     params["keyid"],
+		Algorithm: strings.ToLower(params["algorithm"]),
+		Headers:   []string{"(created)"},
+	}
+	if sig.KeyId == "" {
+		return nil, fmt.Errorf("%w: no keyId", ErrInvalidSignature)
+	}
+	if headers, ok := params["headers"]; ok {
+		sig.Headers = strings.Fields(strings.ToLower(headers))
+	}
+	sig.Signature, err = base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil || len(sig.Signature) == 0 {
+		return nil, fmt.Errorf("%w: signature is not valid base64", ErrInvalidSignature)
+	}
+	for name, dest := range map[string]*int64{"created": &sig.Created, "expires": &sig.Expires} {
+		if value, ok := params[name]; ok {
+			*dest, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid %s", ErrInvalidSignature, name)
+			}
+		}
+	}
+
+	return sig, nil
+}
+
+// parseParams parses a comma-separated list of name="value" pairs, returning
+// them by lowercase name
+func parseParams(s string) (map[string]string, error) {
+	params := make(map[string]string)
+	for s = strings.TrimSpace(s); s != ""; {
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: malformed parameters", ErrInvalidSignature)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated %s", ErrInvalidSignature, name)
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+		params[name] = strings.TrimSpace(value)
+
+		rest = strings.TrimSpace(rest)
+		if rest != "" && !strings.HasPrefix(rest, ",") {
+			return nil, fmt.Errorf("%w: malformed parameters", ErrInvalidSignature)
+		}
+		s = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+	}
+	return params, nil
+}
+
+// signingString returns the string over which the signature of a request
+// with the given headers is made
+func signingString(r *http.Request, headers []string, created, expires int64) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, name := range headers {
+		var value string
+		switch name {
+		case "(request-target)":
+			value = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "(created)":
+			if created == 0 {
+				return "", fmt.Errorf("%w: (created) is signed but not given", ErrInvalidSignature)
+			}
+			value = strconv.FormatInt(created, 10)
+		case "(expires)":
+			if expires == 0 {
+				return "", fmt.Errorf("%w: (expires) is signed but not given", ErrInvalidSignature)
+			}
+			value = strconv.FormatInt(expires, 10)
+		case "host":
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+		default:
+			values := r.Header.Values(name)
+			if len(values) == 0 {
+				return "", fmt.Errorf("%w: signed header %s is missing", ErrInvalidSignature, name)
+			}
+			value = strings.Join(values, ", ")
+		}
+		lines = append(lines, name+": "+strings.TrimSpace(value))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Verify checks that sig, parsed from r, is a signature of r by key, made
+// within maxSkew of now and signing every required header.  The request's
+// body is checked against its signed digest, and restored so that it can be
+// read again.
+func Verify(r *http.Request, sig *Signature, key crypto.PublicKey, now time.Time, maxSkew time.Duration) error {
+	for _, required := range RequiredHeaders {
+		if !contains(sig.Headers, required) {
+			return fmt.Errorf("%w: %s must be signed", ErrInvalidSignature, required)
+		}
+	}
+
+	switch {
+	case contains(sig.Headers, "(created)"):
+		if abs(now.Sub(time.Unix(sig.Created, 0))) > maxSkew {
+			return fmt.Errorf("%w: signature was not created recently", ErrInvalidSignature)
+		}
+	case contains(sig.Headers, "date"):
+		date, err := http.ParseTime(r.Header.Get("Date"))
+		if err != nil {
+			return fmt.Errorf("%w: invalid date", ErrInvalidSignature)
+		}
+		if abs(now.Sub(date)) > maxSkew {
+			return fmt.Errorf("%w: date is too far from the current time", ErrInvalidSignature)
+		}
+	default:
+		return fmt.Errorf("%w: date or (created) must be signed", ErrInvalidSignature)
+	}
+	if sig.Expires != 0 && now.After(time.Unix(sig.Expires, 0)) {
+		return fmt.Errorf("%w: signature has expired", ErrInvalidSignature)
+	}
+
+	if err := verifyDigest(r, contains(sig.Headers, "digest")); err != nil {
+		return err
+	}
+
+	signed, err := signingString(r, sig.Headers, sig.Created, sig.Expires)
+	if err != nil {
+		return err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if sig.Algorithm != "" && sig.Algorithm != "hs2019" && sig.Algorithm != "rsa-sha256" {
+			return fmt.Errorf("%w: algorithm %s does not match the key", ErrInvalidSignature, sig.Algorithm)
+		}
+		hash := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig.Signature) != nil {
+			return ErrInvalidSignature
+		}
+	case ed25519.PublicKey:
+		if sig.Algorithm != "" && sig.Algorithm != "hs2019" && sig.Algorithm != "ed25519" {
+			return fmt.Errorf("%w: algorithm %s does not match the key", ErrInvalidSignature, sig.Algorithm)
+		}
+		if !ed25519.Verify(key, []byte(signed), sig.Signature) {
+			return ErrInvalidSignature
+		}
+	default:
+		return fmt.Errorf("%w: unsupported key type %T", ErrInvalidSignature, key)
+	}
+	return nil
+}
+
+// verifyDigest checks the body of a request against its SHA-256 digest, which
+// must be signed if the request has a body
+func verifyDigest(r *http.Request, signed bool) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if len(body) == 0 {
+		return nil
+	}
+
+	if !signed {
+		return fmt.Errorf("%w: digest must be signed for requests with a body", ErrInvalidSignature)
+	}
+	for _, digest := range strings.Split(r.Header.Get("Digest"), ",") {
+		algorithm, value, _ := strings.Cut(strings.TrimSpace(digest), "=")
+		if strings.EqualFold(algorithm, "SHA-256") {
+			if value != Digest(body)[len("SHA-256="):] {
+				return fmt.Errorf("%w: digest does not match the body", ErrInvalidSignature)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no SHA-256 digest", ErrInvalidSignature)
+}
+
+// Digest returns the value of the Digest header for a request with the given
+// body
+func Digest(body []byte) string {
+	hash := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Sign signs a request with key, which must be an RSA or Ed25519 private key,
+// over the required headers, its date and, if it has a body, its digest.
+// The Date and Digest headers are set if they are not already.
+func Sign(r *http.Request, keyId string, key crypto.Signer, now time.Time) error {
+	headers := append([]string{}, RequiredHeaders...)
+	if r.Header.Get("Date") == "" {
+		r.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+	}
+	headers = append(headers, "date")
+
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if len(body) > 0 {
+			r.Header.Set("Digest", Digest(body))
+			headers = append(headers, "digest")
+		}
+	}
+
+	signed, err := signingString(r, headers, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	var algorithm string
+	var signature []byte
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		algorithm = "rsa-sha256"
+		hash := sha256.Sum256([]byte(signed))
+		signature, err = key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	case ed25519.PublicKey:
+		algorithm = "ed25519"
+		signature, err = key.Sign(rand.Reader, []byte(signed), crypto.Hash(0))
+	default:
+		return fmt.Errorf("unsupported key type %T", key.Public())
+	}
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="%s",headers="%s",signature="%s"`,
+		keyId, algorithm, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(signature)))
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,13 @@
+package httpsig_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestHttpsig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Httpsig Suite")
+}
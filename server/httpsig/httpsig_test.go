@@ -0,0 +1,135 @@
+package httpsig_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server/httpsig"
+)
+
+var _ = Describe("Signatures", func() {
+	now := time.Unix(1700000000, 0)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	newRequest := func(body string) *http.Request {
+		if body == "" {
+			return httptest.NewRequest("GET", "https://example.org/alice/outbox?page=true", nil)
+		}
+		return httptest.NewRequest("POST", "https://example.org/alice/inbox", strings.NewReader(body))
+	}
+
+	It("should verify requests signed with RSA and Ed25519 keys", func() {
+		for _, key := range []crypto.Signer{rsaKey, edKey} {
+			r := newRequest(`{"type":"Follow"}`)
+			Expect(httpsig.Sign(r, "https://remote.example/bob#main-key", key, now)).To(Succeed())
+
+			sig, err := httpsig.Parse(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sig.KeyId).To(Equal("https://remote.example/bob#main-key"))
+			Expect(sig.Headers).To(Equal([]string{"(request-target)", "host", "date", "digest"}))
+			Expect(httpsig.Verify(r, sig, key.Public(), now, httpsig.DefaultMaxSkew)).To(Succeed())
+
+			body, err := io.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal(`{"type":"Follow"}`))
+		}
+	})
+
+	It("should accept signatures given in an Authorization header", func() {
+		r := newRequest("")
+		Expect(httpsig.Sign(r, "key", rsaKey, now)).To(Succeed())
+		r.Header.Set("Authorization", "Signature "+r.Header.Get("Signature"))
+		r.Header.Del("Signature")
+
+		sig, err := httpsig.Parse(r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(httpsig.Verify(r, sig, &rsaKey.PublicKey, now, httpsig.DefaultMaxSkew)).To(Succeed())
+	})
+
+	It("should report unsigned requests", func() {
+		r := newRequest("")
+		_, err := httpsig.Parse(r)
+		Expect(err).To(MatchError(httpsig.ErrNoSignature))
+
+		r.Header.Set("Authorization", "Bearer token")
+		_, err = httpsig.Parse(r)
+		Expect(err).To(MatchError(httpsig.ErrNoSignature))
+	})
+
+	It("should refuse malformed signatures", func() {
+		for _, header := range []string{
+			`keyId="key",signature="not base64!"`,
+			`signature="c2lnbmF0dXJl"`,
+			`keyId="key,signature="c2lnbmF0dXJl"`,
+			`keyId="key" signature="c2lnbmF0dXJl"`,
+		} {
+			r := newRequest("")
+			r.Header.Set("Signature", header)
+			_, err := httpsig.Parse(r)
+			Expect(err).To(MatchError(httpsig.ErrInvalidSignature), header)
+		}
+	})
+
+	It("should refuse tampered requests", func() {
+		r := newRequest(`{"type":"Follow"}`)
+		Expect(httpsig.Sign(r, "key", rsaKey, now)).To(Succeed())
+		r.Body = io.NopCloser(strings.NewReader(`{"type":"Block"}`))
+		sig, err := httpsig.Parse(r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(httpsig.Verify(r, sig, &rsaKey.PublicKey, now, httpsig.DefaultMaxSkew)).To(MatchError(httpsig.ErrInvalidSignature))
+
+		r = newRequest("")
+		Expect(httpsig.Sign(r, "key", rsaKey, now)).To(Succeed())
+		r.URL.Path = "/bob/outbox"
+		Expect(httpsig.Verify(r, sig, &rsaKey.PublicKey, now, httpsig.DefaultMaxSkew)).To(MatchError(httpsig.ErrInvalidSignature))
+	})
+
+	It("should refuse signatures by other keys", func() {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+
+		r := newRequest("")
+		Expect(httpsig.Sign(r, "key", other, now)).To(Succeed())
+		sig, err := httpsig.Parse(r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(httpsig.Verify(r, sig, &rsaKey.PublicKey, now, httpsig.DefaultMaxSkew)).To(MatchError(httpsig.ErrInvalidSignature))
+		Expect(httpsig.Verify(r, sig, edKey.Public(), now, httpsig.DefaultMaxSkew)).To(MatchError(httpsig.ErrInvalidSignature))
+	})
+
+	It("should refuse stale signatures", func() {
+		r := newRequest("")
+		Expect(httpsig.Sign(r, "key", rsaKey, now)).To(Succeed())
+		sig, err := httpsig.Parse(r)
+		Expect(err).ToNot(HaveOccurred())
+
+		later := now.Add(httpsig.DefaultMaxSkew + time.Second)
+		Expect(httpsig.Verify(r, sig, &rsaKey.PublicKey, later, httpsig.DefaultMaxSkew)).To(MatchError(httpsig.ErrInvalidSignature))
+	})
+
+	It("should refuse signatures which leave out required headers", func() {
+		r := newRequest("")
+		Expect(httpsig.Sign(r, "key", rsaKey, now)).To(Succeed())
+		sig, err := httpsig.Parse(r)
+		Expect(err).ToNot(HaveOccurred())
+
+		sig.Headers = []string{"host", "date"}
+		Expect(httpsig.Verify(r, sig, &rsaKey.PublicKey, now, httpsig.DefaultMaxSkew)).To(MatchError(httpsig.ErrInvalidSignature))
+	})
+})
@@ -0,0 +1,95 @@
+package server_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+)
+
+var _ = Describe("Import", func() {
+	var router server.PubblrRouter
+	var alice, bob string
+	var exported []byte
+
+	// importArchive posts an archive to the import endpoint of an actor
+	importArchive := func(actor, token string, archive []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		f, err := form.CreateFormFile("archive", "archive.zip")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.Write(archive)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(form.Close()).To(Succeed())
+
+		req := httptest.NewRequest("POST", "/"+actor+"/import", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	BeforeEach(func() {
+		router = newRouter()
+		alice = register(router, "alice")
+		bob = register(router, "bob")
+
+		w := post(router, "alice", alice, "archived post", "https://www.w3.org/ns/activitystreams#Public")
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		w = do(router, "GET", "/alice/export", "", alice)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		exported = w.Body.Bytes()
+	})
+
+	It("should only import into the account of the user making the request", func() {
+		Expect(importArchive("bob", "", exported).Code).To(Equal(http.StatusUnauthorized))
+		Expect(importArchive("bob", alice, exported).Code).To(Equal(http.StatusForbidden))
+		Expect(totalItems(router, "/bob/outbox", bob)).To(Equal(0))
+	})
+
+	It("should restore posts into an account which has not posted, and index them", func() {
+		w := importArchive("bob", bob, exported)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		Expect(totalItems(router, "/bob/outbox", bob)).To(Equal(1))
+
+		w = do(router, "GET", "/search?q=archived", "", bob)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		Expect(w.Body.String()).To(ContainSubstring(baseUrl + "/bob/"))
+
+		w = importArchive("bob", bob, exported)
+		Expect(w.Code).To(Equal(http.StatusConflict), w.Body.String())
+		Expect(totalItems(router, "/bob/outbox", bob)).To(Equal(1))
+	})
+
+	It("should import archives larger than is held in memory", func() {
+		zipReader, err := zip.NewReader(bytes.NewReader(exported), int64(len(exported)))
+		Expect(err).ToNot(HaveOccurred())
+		var large bytes.Buffer
+		zipWriter := zip.NewWriter(&large)
+		for _, f := range zipReader.File {
+			Expect(zipWriter.Copy(f)).To(Succeed())
+		}
+		// stored rather than compressed, so that the archive is as large
+		padding, err := zipWriter.CreateHeader(&zip.FileHeader{Name: "padding.bin", Method: zip.Store})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = io.CopyN(padding, rand.Reader, 2<<20)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(zipWriter.Close()).To(Succeed())
+
+		w := importArchive("bob", bob, large.Bytes())
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		Expect(totalItems(router, "/bob/outbox", bob)).To(Equal(1))
+	})
+})
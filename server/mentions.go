@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/webfinger"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// A mention is '@' followed by a username and, for users of other servers, '@'
+// and the server's host.  The first '@' must not follow a word character, '/'
+// or '.', so that email addresses and URLs are not taken for mentions.
+var mentionPattern = regexp.MustCompile(
+	`(?:^|[^\p{L}\p{N}_/@.])@([\p{L}\p{N}_]+(?:[.-][\p{L}\p{N}_]+)*)(?:@([\p{L}\p{N}-]+(?:\.[\p{L}\p{N}-]+)*(?::\d+)?))?`,
+)
+
+// the most mentions of users of other servers looked up for a single post, so
+// that a post cannot have the server make requests without limit
+const maxRemoteMentions = 10
+
+// how long to wait for another server to resolve a mention
+const mentionTimeout = 5 * time.Second
+
+// addMentions resolves each mention in the given object's content to an
+// actor, tags the object with a Mention of that actor, copies the actor on
+// the object and links the mention to the actor.  Mentions which cannot be
+// resolved, and those of other servers beyond the first maxRemoteMentions,
+// are left as plain text, as are handles in attributes and in links.
+//
+// Resolving mentions of remote users requires network requests, so this
+// should not be called within a transaction.
+func (router *PubblrRouter) addMentions(ctx context.Context, objectIface activitystreams.ObjectIface) {
+	object := activitystreams.ToObject(objectIface)
+
+	addressed := make(map[string]bool)
+	for _, recipient := range merge(object.To, object.Cc, object.Bto, object.Bcc, object.Audience) {
+		addressed[activitystreams.ToEntity(recipient).Id] = true
+	}
+
+	mentioned := make(map[string]bool)
+	for _, tag := range object.Tag {
+		if mention, ok := tag.(*activitystreams.Mention); ok {
+			mentioned[mention.Href] = true
+		}
+	}
+
+	// actor ids of the mentions resolved so far, by handle; unresolvable
+	// handles map to ""
+	resolved := make(map[string]string)
+	lookups := 0
+
+	// linkMentions links the mentions in text which is not markup
+	linkMentions := func(text string) string {
+		var content strings.Builder
+		last := 0
+		for _, match := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+			// the match may begin with the character before the '@'
+			start, end := match[2]-1, match[1]
+			user := text[match[2]:match[3]]
+			host := ""
+			if match[4] >= 0 {
+				host = text[match[4]:match[5]]
+			}
+			handle := text[start:end]
+
+			actorId, ok := resolved[handle]
+			if !ok {
+				var err error
+				if router.isLocalHost(host) {
+					actorId, err = router.resolveLocalMention(ctx, user)
+				} else if lookups < maxRemoteMentions {
+					lookups++
+					actorId, err = router.resolveRemoteMention(ctx, user, host)
+				} else {
+					err = fmt.Errorf("more than %d users of other servers mentioned", maxRemoteMentions)
+				}
+				if err != nil {
+					router.Logger.Errorf("Failed to resolve mention %s: %s\n", handle, err)
+				}
+				resolved[handle] = actorId
+
+				if actorId != "" && !mentioned[actorId] {
+					mentioned[actorId] = true
+					object.Tag = append(object.Tag, &activitystreams.Mention{
+						Link: activitystreams.Link{
+							Entity: activitystreams.Entity{
+								Name: handle,
+							},
+							Href: actorId,
+						},
+					})
+
+					if !addressed[actorId] {
+						addressed[actorId] = true
+						object.Cc = append(object.Cc, &activitystreams.Link{
+							Entity: activitystreams.Entity{
+								Id: actorId,
+							},
+						})
+					}
+				}
+			}
+
+			content.WriteString(text[last:start])
+			if actorId == "" {
+				content.WriteString(handle)
+			} else {
+				fmt.Fprintf(&content, `<a href="%s" class="mention">%s</a>`, html.EscapeString(actorId), handle)
+			}
+			last = end
+		}
+		content.WriteString(text[last:])
+		return content.String()
+	}
+
+	// Only text is searched for mentions, and text already linked is not
+	// linked again
+	var content strings.Builder
+	links := 0
+	z := html.NewTokenizer(strings.NewReader(object.Content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		raw := string(z.Raw())
+
+		name, _ := z.TagName()
+		switch {
+		case tt == html.TextToken && links == 0:
+			raw = linkMentions(raw)
+		case tt == html.StartTagToken && atom.Lookup(name) == atom.A:
+			links++
+		case tt == html.EndTagToken && atom.Lookup(name) == atom.A && links > 0:
+			links--
+		}
+		content.WriteString(raw)
+	}
+	object.Content = content.String()
+}
+
+// isLocalHost reports whether a mention of a user of the given host, which is
+// empty for mentions without one, is of a local user
+func (router *PubblrRouter) isLocalHost(host string) bool {
+	return host == "" || strings.EqualFold(host, router.baseUrl.Host)
+}
+
+// resolveLocalMention returns the id of the local actor with the given name
+func (router *PubblrRouter) resolveLocalMention(ctx context.Context, user string) (string, error) {
+	actor, err := router.Database.GetUser(ctx, user)
+	if err != nil {
+		return "", err
+	}
+	return activitystreams.ToObject(actor).Id, nil
+}
+
+// resolveRemoteMention looks up the id of the actor for the given user of
+// another server, giving up after mentionTimeout
+func (router *PubblrRouter) resolveRemoteMention(ctx context.Context, user, host string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, mentionTimeout)
+	defer cancel()
+	return webfinger.LookupActor(ctx, router.HTTPClient, user, host)
+}
@@ -0,0 +1,66 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+)
+
+var _ = Describe("Mentions", func() {
+	var router server.PubblrRouter
+	var alice string
+
+	BeforeEach(func() {
+		router = newRouter()
+		alice = register(router, "alice")
+	})
+
+	It("should link mentions of local users", func() {
+		register(router, "bob")
+		w := post(router, "alice", alice, "hi @bob")
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		Expect(w.Body.String()).To(ContainSubstring(`class=\"mention\"`))
+	})
+
+	It("should link mentions only in text which is not already linked", func() {
+		register(router, "bob")
+		w := post(router, "alice", alice, `<p title=" @bob">hi <a href="https://example.com/">@bob</a> and @bob</p>`)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		var note struct {
+			Content string `json:"content"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &note)).To(Succeed())
+		Expect(note.Content).To(Equal(`<p title=" @bob">hi <a href="https://example.com/">@bob</a> and ` +
+			`<a href="` + baseUrl + `/bob" class="mention">@bob</a></p>`))
+	})
+
+	It("should not look up mentions on servers at addresses which are not public", func() {
+		// counts connections rather than requests, since the server's
+		// certificate is not trusted
+		var connections int32
+		internal := httptest.NewUnstartedServer(http.NotFoundHandler())
+		internal.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&connections, 1)
+			}
+		}
+		internal.StartTLS()
+		defer internal.Close()
+		internalUrl, err := url.Parse(internal.URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		w := post(router, "alice", alice, "hi @bob@"+internalUrl.Host)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		Expect(w.Body.String()).ToNot(ContainSubstring(`class=\"mention\"`))
+		Expect(atomic.LoadInt32(&connections)).To(BeZero())
+	})
+})
@@ -2,41 +2,28 @@ package server
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/brandonsides/pubblr/activitystreams"
 	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/auth"
 	"github.com/go-chi/chi"
 )
 
-type Middleware func(http.Handler) http.Handler
-
-func SetContentType(contentType string) Middleware {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-type", contentType)
-			next.ServeHTTP(w, r)
-		})
-	}
+// TokenVerifier checks the access tokens presented with requests
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, token string) (*auth.Claims, error)
 }
 
-func AuthMiddleware[T any](auth Auth, next apiutil.Endpoint[T]) apiutil.Endpoint[*T] {
+// Visible keeps users from reading objects an endpoint returns which are not
+// addressed to them, and from seeing to whom objects of actors they may not
+// act as were blind copied.  It relies on the user making the request having
+// been identified.
+func Visible[T any](router *PubblrRouter, next apiutil.Endpoint[T]) apiutil.Endpoint[*T] {
 	return apiutil.Endpoint[*T](func(r *http.Request) (*T, http.Header, apiutil.Status) {
 		owner := chi.URLParam(r, "actor")
-
-		var username string
-		var err error
-		tokenString := r.Header.Get("Authorization")
-		if tokenString != "" {
-			username, err = auth.VerifyToken(tokenString)
-			if err == nil {
-				r = r.WithContext(context.WithValue(r.Context(), "username", username))
-			}
-		}
-
-		if r.Method == "POST" && owner != "" && owner != username {
-			return nil, nil, apiutil.NewStatus(http.StatusForbidden, "You are not authorized act on behalf of this user")
-		}
+		actors := currentActors(r)
 
 		ret, header, status := next(r)
 		var retInterface interface{} = ret
@@ -49,11 +36,14 @@ func AuthMiddleware[T any](auth Auth, next apiutil.Endpoint[T]) apiutil.Endpoint
 			return &ret, header, status
 		}
 
-		if !intendedFor(username, owner, retObject) {
+		// Actors are shown to everyone, so that they may be found and followed
+		// from other servers and read in browsers
+		_, isActor := retObject.(activitystreams.ActorIface)
+		if !isActor && !router.intendedFor(r.Context(), router.readerIds(r), router.ownerId(owner), retObject) {
 			return nil, header, apiutil.NewStatus(http.StatusForbidden, "You are not authorized to access this resource")
 		}
 
-		if owner != "" && owner != username {
+		if owner != "" && !in(owner, actors) {
 			object := activitystreams.ToObject(retObject)
 			object.Bcc = nil
 			object.Bto = nil
@@ -63,18 +53,119 @@ func AuthMiddleware[T any](auth Auth, next apiutil.Endpoint[T]) apiutil.Endpoint
 	})
 }
 
-func intendedFor(username string, owner string, objectIface activitystreams.ObjectIface) bool {
+// Identify identifies the user making a request, if any, refusing requests
+// with invalid credentials but otherwise without restricting access to the
+// endpoint, which policies may then do
+func Identify(authenticator Authenticator) apiutil.Middleware {
+	return func(r *http.Request, next apiutil.Next) (http.Header, apiutil.Status) {
+		r, header, status := identify(authenticator, r)
+		if status != nil {
+			return header, status
+		}
+		return next(r)
+	}
+}
+
+// identify returns the request with the principal making it, if any, added to
+// its context.  Requests whose credentials are invalid are refused with a
+// challenge, rather than treated as anonymous, so that clients know to
+// authenticate again.
+func identify(authenticator Authenticator, r *http.Request) (*http.Request, http.Header, apiutil.Status) {
+	principal, err := authenticator.Authenticate(r)
+	var challenge *Challenge
+	if errors.Is(err, ErrNoCredentials) {
+		return r, nil, nil
+	} else if errors.As(err, &challenge) {
+		return r, challengeHeader(challenge), apiutil.NewStatusFromError(http.StatusUnauthorized, err)
+	} else if err != nil {
+		return r, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	return r.WithContext(WithPrincipal(r.Context(), principal)), nil, nil
+}
+
+// owner returns the id of the actor to which an object is attributed
+func owner(objectIface activitystreams.ObjectIface) string {
 	object := activitystreams.ToObject(objectIface)
+	if len(object.AttributedTo) == 0 || object.AttributedTo[0] == nil {
+		return ""
+	}
+	return activitystreams.ToEntity(object.AttributedTo[0]).Id
+}
 
-	return username == owner || in(
-		username, mapitems(
-			func(e activitystreams.EntityIface) string {
-				entity := activitystreams.ToEntity(e)
-				return shortId(entity.Id)
-			}, object.To, object.Cc, object.Bto, object.Bcc, object.Audience,
-		),
-	) || in(
-		"https://www.w3.org/ns/activitystreams#Public", mapitems(
+// ownerId returns the id of the named local actor, or nothing if none is named
+func (router *PubblrRouter) ownerId(actor string) string {
+	if actor == "" {
+		return ""
+	}
+	return router.endpointUrl(actor)
+}
+
+// readerIds returns the ids of the local actors as which the user making a
+// request may act, if any
+func (router *PubblrRouter) readerIds(r *http.Request) []string {
+	actors := currentActors(r)
+	ids := make([]string, len(actors))
+	for i, actor := range actors {
+		ids[i] = router.endpointUrl(actor)
+	}
+	return ids
+}
+
+// intendedFor reports whether an object may be seen by a user who may act as
+// the actors with the given ids: because one of them owns it or wrote it, or is
+// among its recipients or in a collection which is, or because it is public.  Recipients
+// are compared by their full ids, so that actors of other servers which share
+// a local user's name are not mistaken for them.
+func (router *PubblrRouter) intendedFor(ctx context.Context, readers []string, owner string, objectIface activitystreams.ObjectIface) bool {
+	if isPublic(objectIface) {
+		return true
+	}
+	if owner != "" && in(owner, readers) {
+		return true
+	}
+	if len(readers) == 0 {
+		return false
+	}
+
+	object := activitystreams.ToObject(objectIface)
+	for _, author := range object.AttributedTo {
+		if in(activitystreams.ToEntity(author).Id, readers) {
+			return true
+		}
+	}
+	recipients := mapitems(
+		func(e activitystreams.EntityIface) string {
+			return activitystreams.ToEntity(e).Id
+		}, object.To, object.Cc, object.Bto, object.Bcc, object.Audience,
+	)
+	for _, recipient := range recipients {
+		// Collections reach the same actors as activities addressed to them
+		// are delivered to
+		reached, err := router.recipientActors(ctx, router.Database, recipient)
+		if err != nil {
+			router.Logger.Errorf("Failed to resolve recipient %s: %s\n", recipient, err)
+			continue
+		}
+		for _, reader := range readers {
+			if in(reader, reached) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the address of the collection of all actors, to which public objects are
+// addressed
+const publicAddress = "https://www.w3.org/ns/activitystreams#Public"
+
+// isPublic reports whether an object is addressed to everyone
+func isPublic(objectIface activitystreams.ObjectIface) bool {
+	object := activitystreams.ToObject(objectIface)
+
+	return in(
+		publicAddress, mapitems(
 			func(e activitystreams.EntityIface) string {
 				entity := activitystreams.ToEntity(e)
 				return entity.Id
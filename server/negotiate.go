@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/server/apiutil"
+)
+
+// The media types in which ActivityStreams entities are served.  The first is
+// the one the ActivityPub specification requires servers to understand; the
+// second is commonly asked for by other servers.
+const (
+	activityStreamsType = `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+	activityJSONType    = "application/activity+json"
+	jsonType            = "application/json"
+	htmlType            = "text/html; charset=utf-8"
+)
+
+// Page renders an ActivityStreams object as an HTML page, for readers visiting
+// its URL in a browser
+type Page func(r *http.Request, object activitystreams.ObjectIface) ([]byte, error)
+
+// ActivityStreams serves the entity an endpoint returns in whichever
+// representation the request's Accept header prefers: as ActivityStreams JSON,
+// or, if the endpoint has a page, as HTML.  Clients which state no preference
+// are served ActivityStreams JSON.
+func ActivityStreams[T any](next apiutil.Endpoint[T], page Page) apiutil.Endpoint[apiutil.RawResponse] {
+	return apiutil.Endpoint[apiutil.RawResponse](func(r *http.Request) (apiutil.RawResponse, http.Header, apiutil.Status) {
+		offered := []string{activityStreamsType, activityJSONType, jsonType}
+		if page != nil {
+			offered = append(offered, htmlType)
+		}
+		contentType := apiutil.Negotiate(r, offered...)
+		if contentType == "" {
+			return apiutil.RawResponse{}, http.Header{"Vary": []string{"Accept"}}, apiutil.NewStatus(http.StatusNotAcceptable, "Not acceptable; this resource is available as ActivityStreams JSON")
+		}
+
+		ret, header, status := next(r)
+		if header == nil {
+			header = http.Header{}
+		}
+		// the representation served depends on the Accept header, so caches
+		// must not serve one to clients asking for another
+		header.Add("Vary", "Accept")
+		if !apiutil.IsOK(status) || (status != nil && status.StatusCode() == http.StatusNoContent) {
+			return apiutil.RawResponse{}, header, status
+		}
+
+		var body []byte
+		var err error
+		if contentType == htmlType {
+			object, ok := asObject(ret)
+			if !ok {
+				return apiutil.RawResponse{}, header, apiutil.NewStatus(http.StatusInternalServerError, "no page for resource")
+			}
+			body, err = page(r, object)
+		} else {
+			body, err = json.Marshal(ret)
+		}
+		if err != nil {
+			return apiutil.RawResponse{}, header, apiutil.Statusf(http.StatusInternalServerError, "failed to render response: %w", err)
+		}
+
+		return apiutil.RawResponse{
+			ContentType: contentType,
+			Body:        body,
+		}, header, status
+	})
+}
+
+// asObject returns the object an endpoint returned, whether directly or, as
+// from Visible, through a pointer
+func asObject(ret interface{}) (activitystreams.ObjectIface, bool) {
+	if ptr, ok := ret.(*activitystreams.ObjectIface); ok {
+		if ptr == nil || *ptr == nil {
+			return nil, false
+		}
+		return *ptr, true
+	}
+	object, ok := ret.(activitystreams.ObjectIface)
+	return object, ok
+}
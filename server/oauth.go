@@ -0,0 +1,594 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/auth"
+)
+
+// how long an authorization code may be exchanged for tokens after it is
+// issued
+const authorizationCodeLifetime = 10 * time.Minute
+
+// the scopes which clients may be granted
+var supportedScopes = []string{ScopeRead, ScopeWritePosts, ScopeWriteFollows, ScopeAdmin}
+
+// the scope granted to clients which do not ask for any
+const defaultScope = ScopeRead
+
+// the scopes which clients may request if they do not register any
+var defaultClientScope = strings.Join([]string{ScopeRead, ScopeWritePosts, ScopeWriteFollows}, " ")
+
+// oauthError is an error reported as described by RFC 6749, with a JSON body
+// giving its code and description
+type oauthError struct {
+	status      int
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func newOAuthError(status int, code, description string) *oauthError {
+	return &oauthError{
+		status:      status,
+		Code:        code,
+		Description: description,
+	}
+}
+
+func (e *oauthError) Error() string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return e.Code
+	}
+	return string(b)
+}
+
+func (e *oauthError) StatusCode() int {
+	return e.status
+}
+
+// ErrorResponse writes the error as RFC 6749 requires, rather than as other
+// endpoints' errors are written
+func (e *oauthError) ErrorResponse() apiutil.RawResponse {
+	return apiutil.RawResponse{
+		ContentType: "application/json",
+		Body:        []byte(e.Error()),
+	}
+}
+
+// redirect returns the given redirect URI with the error added to its query,
+// so that it may be reported to the client
+func (e *oauthError) redirect(redirectUri, state string) string {
+	params := url.Values{}
+	params.Set("error", e.Code)
+	if e.Description != "" {
+		params.Set("error_description", e.Description)
+	}
+	return withParams(redirectUri, params, state)
+}
+
+// withParams adds params, and the state if any, to the query of uri
+func withParams(uri string, params url.Values, state string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// parseScope checks that every scope in the space-separated list is supported
+// and allowed, and returns the list normalized.  An empty list is replaced by
+// def.
+func parseScope(scope, allowed, def string) (string, error) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		scopes = strings.Fields(def)
+	}
+
+	allowedScopes := strings.Fields(allowed)
+	for _, s := range scopes {
+		if !in(s, supportedScopes) {
+			return "", fmt.Errorf("unsupported scope %s", s)
+		}
+		if !in(s, allowedScopes) {
+			return "", fmt.Errorf("scope %s is not allowed", s)
+		}
+	}
+
+	return strings.Join(scopes, " "), nil
+}
+
+// validRedirectUri reports whether uri may be registered as a client's
+// redirect URI.  Plain HTTP is only allowed for loopback addresses, which
+// native applications listen on; other applications may use private schemes.
+func validRedirectUri(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return false
+	}
+
+	if u.Scheme == "http" {
+		if u.Hostname() == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(u.Hostname())
+		return ip != nil && ip.IsLoopback()
+	}
+	return u.Scheme == "https" || strings.Contains(u.Scheme, ".")
+}
+
+// verifyCodeChallenge checks a PKCE code verifier against the S256 challenge
+// made with the authorization request
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	hash := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(hash[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// METADATA
+
+// OAuthMetadata describes the authorization server, as in RFC 8414
+type OAuthMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	RegistrationEndpoint              string   `json:"registration_endpoint"`
+	JWKSUri                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+func (router *PubblrRouter) oauthUrl(endpoint string) string {
+	u := router.baseUrl
+	u.Path = path.Join(u.Path, "oauth", endpoint)
+	return u.String()
+}
+
+func (router *PubblrRouter) GetOAuthMetadata(r *http.Request) (*OAuthMetadata, http.Header, apiutil.Status) {
+	return &OAuthMetadata{
+		Issuer:                            router.baseUrl.String(),
+		AuthorizationEndpoint:             router.oauthUrl("authorize"),
+		TokenEndpoint:                     router.oauthUrl("token"),
+		RegistrationEndpoint:              router.oauthUrl("register"),
+		JWKSUri:                           router.jwksUrl(),
+		ScopesSupported:                   supportedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	}, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+func (router *PubblrRouter) jwksUrl() string {
+	u := router.baseUrl
+	u.Path = path.Join(u.Path, ".well-known", "jwks.json")
+	return u.String()
+}
+
+// GetJWKS publishes the keys with which access tokens are signed, so that other
+// services may verify them
+func (router *PubblrRouter) GetJWKS(r *http.Request) (*auth.JWKSet, http.Header, apiutil.Status) {
+	jwks := router.Auth.JWKS()
+	return &jwks, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// REGISTRATION
+
+// ClientRegistrationRequest is the metadata with which a client registers, as
+// in RFC 7591
+type ClientRegistrationRequest struct {
+	RedirectUris []string `json:"redirect_uris"`
+	ClientName   string   `json:"client_name"`
+	Scope        string   `json:"scope"`
+	// "none" for public clients, which are not issued a secret
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method"`
+}
+
+type ClientRegistrationResponse struct {
+	ClientId                string   `json:"client_id"`
+	ClientSecret            string   `json:"client_secret,omitempty"`
+	ClientIdIssuedAt        int64    `json:"client_id_issued_at"`
+	ClientSecretExpiresAt   *int64   `json:"client_secret_expires_at,omitempty"`
+	ClientName              string   `json:"client_name,omitempty"`
+	RedirectUris            []string `json:"redirect_uris"`
+	Scope                   string   `json:"scope"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types"`
+}
+
+// RegisterClient registers a new OAuth client.  Registration is open, since
+// clients can do nothing without the consent of the users they act for.
+func (router *PubblrRouter) RegisterClient(r *http.Request) (*ClientRegistrationResponse, http.Header, apiutil.Status) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, newOAuthError(http.StatusBadRequest, "invalid_client_metadata", "Invalid JSON")
+	}
+	var body ClientRegistrationRequest
+	err = json.Unmarshal(reqBody, &body)
+	if err != nil {
+		return nil, nil, newOAuthError(http.StatusBadRequest, "invalid_client_metadata", "Invalid JSON")
+	}
+
+	if len(body.RedirectUris) == 0 {
+		return nil, nil, newOAuthError(http.StatusBadRequest, "invalid_redirect_uri", "Missing redirect URIs")
+	}
+	for _, uri := range body.RedirectUris {
+		if !validRedirectUri(uri) {
+			return nil, nil, newOAuthError(http.StatusBadRequest, "invalid_redirect_uri",
+				fmt.Sprintf("Invalid redirect URI %s", uri))
+		}
+	}
+
+	scope, err := parseScope(body.Scope, strings.Join(supportedScopes, " "), defaultClientScope)
+	if err != nil {
+		return nil, nil, newOAuthError(http.StatusBadRequest, "invalid_client_metadata", err.Error())
+	}
+
+	if body.TokenEndpointAuthMethod == "" {
+		body.TokenEndpointAuthMethod = "client_secret_basic"
+	}
+
+	id, err := auth.NewId()
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	var secret, secretHash string
+	var secretExpiresAt *int64
+	switch body.TokenEndpointAuthMethod {
+	case "none":
+	case "client_secret_basic", "client_secret_post":
+		secret, err = auth.NewSecret()
+		if err != nil {
+			return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+		secretHash = auth.HashToken(secret)
+		// secrets do not expire
+		secretExpiresAt = new(int64)
+	default:
+		return nil, nil, newOAuthError(http.StatusBadRequest, "invalid_client_metadata",
+			fmt.Sprintf("Unsupported token endpoint auth method %s", body.TokenEndpointAuthMethod))
+	}
+
+	client := database.OAuthClient{
+		Id:           id,
+		SecretHash:   secretHash,
+		Name:         body.ClientName,
+		RedirectUris: body.RedirectUris,
+		Scope:        scope,
+		Created:      time.Now(),
+	}
+	err = router.Database.CreateOAuthClient(r.Context(), client)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to register client: %w", err)
+	}
+
+	return &ClientRegistrationResponse{
+		ClientId:                client.Id,
+		ClientSecret:            secret,
+		ClientIdIssuedAt:        client.Created.Unix(),
+		ClientSecretExpiresAt:   secretExpiresAt,
+		ClientName:              client.Name,
+		RedirectUris:            client.RedirectUris,
+		Scope:                   client.Scope,
+		TokenEndpointAuthMethod: body.TokenEndpointAuthMethod,
+		GrantTypes:              []string{"authorization_code", "refresh_token"},
+		ResponseTypes:           []string{"code"},
+	}, nil, apiutil.Statusf(http.StatusCreated, "registered client %s", client.Id)
+}
+
+// AUTHORIZATION
+
+// authorizationRequest is a client's request for a user's consent
+type authorizationRequest struct {
+	client        *database.OAuthClient
+	redirectUri   string
+	state         string
+	scope         string
+	codeChallenge string
+}
+
+// parseAuthorizationRequest validates the parameters of an authorization
+// request.  Errors in the client or redirect URI are returned without a
+// request, since they must not be reported by redirecting to a URI which may
+// not be the client's; later errors are returned with the request, so that
+// they may be.  The scope requested must be held by the user asked to grant
+// it.
+func (router *PubblrRouter) parseAuthorizationRequest(ctx context.Context, username string, params url.Values) (*authorizationRequest, *oauthError) {
+	client, err := router.Database.GetOAuthClient(ctx, params.Get("client_id"))
+	if err != nil {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "Unknown client")
+	}
+
+	req := &authorizationRequest{
+		client:      client,
+		redirectUri: params.Get("redirect_uri"),
+		state:       params.Get("state"),
+	}
+	if !in(req.redirectUri, client.RedirectUris) {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "Redirect URI is not registered to client")
+	}
+
+	if params.Get("response_type") != "code" {
+		return req, newOAuthError(http.StatusBadRequest, "unsupported_response_type", "Only the code response type is supported")
+	}
+
+	req.scope, err = parseScope(params.Get("scope"), client.Scope, defaultScope)
+	if err != nil {
+		return req, newOAuthError(http.StatusBadRequest, "invalid_scope", err.Error())
+	}
+	roles, err := router.Database.GetRoles(ctx, username)
+	if err != nil {
+		return req, newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+	_, err = parseScope(req.scope, userScopes(roles), "")
+	if err != nil {
+		return req, newOAuthError(http.StatusBadRequest, "invalid_scope", err.Error())
+	}
+
+	// PKCE is required of all clients, since public ones cannot otherwise
+	// prove that they made the request
+	req.codeChallenge = params.Get("code_challenge")
+	if req.codeChallenge == "" {
+		return req, newOAuthError(http.StatusBadRequest, "invalid_request", "Missing code challenge")
+	}
+	if params.Get("code_challenge_method") != "S256" {
+		return req, newOAuthError(http.StatusBadRequest, "invalid_request", "Only the S256 code challenge method is supported")
+	}
+
+	return req, nil
+}
+
+// ConsentRequest describes what a client asks to be authorized to do, so that
+// the user may be asked whether to allow it
+type ConsentRequest struct {
+	ClientId    string   `json:"clientId"`
+	ClientName  string   `json:"clientName,omitempty"`
+	RedirectUri string   `json:"redirectUri"`
+	Scopes      []string `json:"scopes"`
+}
+
+// GetAuthorization describes an authorization request, for the user making it
+// to consent to or refuse with PostAuthorization.  Both must be made by users
+// who logged in directly, so that clients cannot authorize other clients.
+func (router *PubblrRouter) GetAuthorization(r *http.Request) (*ConsentRequest, http.Header, apiutil.Status) {
+	username, _ := currentUser(r)
+
+	req, oauthErr := router.parseAuthorizationRequest(r.Context(), username, r.URL.Query())
+	if oauthErr != nil {
+		return nil, nil, oauthErr
+	}
+
+	return &ConsentRequest{
+		ClientId:    req.client.Id,
+		ClientName:  req.client.Name,
+		RedirectUri: req.redirectUri,
+		Scopes:      strings.Fields(req.scope),
+	}, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// AuthorizationResponse gives the URI to which the user should be sent back to
+// the client, carrying either an authorization code or an error
+type AuthorizationResponse struct {
+	RedirectTo string `json:"redirectTo"`
+}
+
+// PostAuthorization records the user's decision on an authorization request,
+// given by the "consent" parameter
+func (router *PubblrRouter) PostAuthorization(r *http.Request) (*AuthorizationResponse, http.Header, apiutil.Status) {
+	username, _ := currentUser(r)
+
+	err := r.ParseForm()
+	if err != nil {
+		return nil, nil, newOAuthError(http.StatusBadRequest, "invalid_request", "Invalid form")
+	}
+
+	req, oauthErr := router.parseAuthorizationRequest(r.Context(), username, r.Form)
+	if req == nil {
+		return nil, nil, oauthErr
+	}
+	if oauthErr != nil {
+		return &AuthorizationResponse{
+			RedirectTo: oauthErr.redirect(req.redirectUri, req.state),
+		}, nil, apiutil.StatusFromCode(http.StatusOK)
+	}
+
+	if r.Form.Get("consent") != "approve" {
+		denied := newOAuthError(http.StatusForbidden, "access_denied", "The user refused the request")
+		return &AuthorizationResponse{
+			RedirectTo: denied.redirect(req.redirectUri, req.state),
+		}, nil, apiutil.StatusFromCode(http.StatusOK)
+	}
+
+	code, err := auth.NewSecret()
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	err = router.Database.CreateAuthorizationCode(r.Context(), database.AuthorizationCode{
+		Hash:                auth.HashToken(code),
+		ClientId:            req.client.Id,
+		Username:            username,
+		RedirectUri:         req.redirectUri,
+		Scope:               req.scope,
+		CodeChallenge:       req.codeChallenge,
+		CodeChallengeMethod: "S256",
+		Expires:             time.Now().Add(authorizationCodeLifetime),
+	})
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to create authorization code: %w", err)
+	}
+
+	return &AuthorizationResponse{
+		RedirectTo: withParams(req.redirectUri, url.Values{"code": {code}}, req.state),
+	}, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// TOKENS
+
+// authenticateClient identifies the client making a token request, checking
+// its secret if it has one.  The credentials may be given by HTTP basic
+// authentication or in the form.
+func (router *PubblrRouter) authenticateClient(r *http.Request) (*database.OAuthClient, *oauthError) {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 has credentials form-encoded before basic encoding
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return nil, newOAuthError(http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, newOAuthError(http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		}
+	} else {
+		id = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	if id == "" {
+		return nil, newOAuthError(http.StatusUnauthorized, "invalid_client", "Missing client id")
+	}
+
+	client, err := router.Database.GetOAuthClient(r.Context(), id)
+	if err != nil {
+		return nil, newOAuthError(http.StatusUnauthorized, "invalid_client", "Unknown client")
+	}
+
+	if client.SecretHash != "" &&
+		subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, newOAuthError(http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+	}
+
+	return client, nil
+}
+
+// OAuthToken issues tokens to clients, in exchange for either an
+// authorization code or a refresh token
+func (router *PubblrRouter) OAuthToken(r *http.Request) (*TokenResponse, http.Header, apiutil.Status) {
+	err := r.ParseForm()
+	if err != nil {
+		return nil, nil, newOAuthError(http.StatusBadRequest, "invalid_request", "Invalid form")
+	}
+
+	client, oauthErr := router.authenticateClient(r)
+	if oauthErr != nil {
+		return nil, nil, oauthErr
+	}
+
+	var ret *TokenResponse
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		ret, oauthErr = router.exchangeAuthorizationCode(r, client)
+	case "refresh_token":
+		refreshToken := r.PostForm.Get("refresh_token")
+		if refreshToken == "" {
+			return nil, nil, newOAuthError(http.StatusBadRequest, "invalid_request", "Missing refresh token")
+		}
+
+		var status apiutil.Status
+		ret, status = router.refreshTokens(r.Context(), refreshToken, client.Id)
+		if status != nil && status.StatusCode() == http.StatusUnauthorized {
+			oauthErr = newOAuthError(http.StatusBadRequest, "invalid_grant", status.Error())
+		} else if status != nil {
+			return nil, nil, status
+		}
+	default:
+		return nil, nil, newOAuthError(http.StatusBadRequest, "unsupported_grant_type",
+			"Only the authorization_code and refresh_token grant types are supported")
+	}
+	if oauthErr != nil {
+		return nil, nil, oauthErr
+	}
+
+	header := http.Header{}
+	header.Set("Cache-Control", "no-store")
+	header.Set("Pragma", "no-cache")
+	return ret, header, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// exchangeAuthorizationCode begins a session for the client with the code
+// given in the request.  Codes may only be exchanged once; any attempt to
+// exchange one uses it up.
+func (router *PubblrRouter) exchangeAuthorizationCode(r *http.Request, client *database.OAuthClient) (*TokenResponse, *oauthError) {
+	invalidGrant := newOAuthError(http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+
+	code := r.PostForm.Get("code")
+	if code == "" {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "Missing code")
+	}
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, newOAuthError(http.StatusServiceUnavailable, "temporarily_unavailable", "")
+	}
+	defer tx.Rollback()
+
+	hash := auth.HashToken(code)
+	grant, err := tx.GetAuthorizationCode(r.Context(), hash)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, invalidGrant
+	} else if err != nil {
+		return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+
+	err = tx.DeleteAuthorizationCode(r.Context(), hash)
+	if err != nil {
+		return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+
+	if grant.ClientId != client.Id || grant.RedirectUri != r.PostForm.Get("redirect_uri") ||
+		time.Now().After(grant.Expires) || !verifyCodeChallenge(r.PostForm.Get("code_verifier"), grant.CodeChallenge) {
+		if tx.Commit() != nil {
+			return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+		}
+		return nil, invalidGrant
+	}
+
+	ret, err := router.startSession(r.Context(), tx, database.Session{
+		Username:  grant.Username,
+		UserAgent: client.Name,
+		ClientId:  client.Id,
+		Scope:     grant.Scope,
+	})
+	if err != nil {
+		return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,221 @@
+package server_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+)
+
+var _ = Describe("OAuth", func() {
+	var router server.PubblrRouter
+	var alice string
+
+	const redirectUri = "https://app.example/callback"
+	const verifier = "a-code-verifier-long-enough-to-satisfy-pkce-0123456789"
+
+	challenge := func(verifier string) string {
+		hash := sha256.Sum256([]byte(verifier))
+		return base64.RawURLEncoding.EncodeToString(hash[:])
+	}
+
+	// postForm makes a form-encoded POST request, authorized by the given
+	// access token if it is not empty
+	postForm := func(path string, form url.Values, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// registerClient registers a confidential client with the given scope,
+	// returning its id and secret
+	registerClient := func(scope string) (string, string) {
+		w := do(router, "POST", "/oauth/register",
+			`{"client_name":"app","redirect_uris":["`+redirectUri+`"],"scope":`+jsonString(scope)+`}`, "")
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		var resp struct {
+			ClientId     string `json:"client_id"`
+			ClientSecret string `json:"client_secret"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed())
+		return resp.ClientId, resp.ClientSecret
+	}
+
+	authorizationParams := func(clientId, scope string) url.Values {
+		return url.Values{
+			"response_type":         {"code"},
+			"client_id":             {clientId},
+			"redirect_uri":          {redirectUri},
+			"scope":                 {scope},
+			"state":                 {"xyz"},
+			"code_challenge":        {challenge(verifier)},
+			"code_challenge_method": {"S256"},
+			"consent":               {"approve"},
+		}
+	}
+
+	// authorize asks alice to consent to a request, returning the query of
+	// the URI she is sent back to the client with
+	authorize := func(params url.Values) url.Values {
+		w := postForm("/oauth/authorize", params, alice)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var resp struct {
+			RedirectTo string `json:"redirectTo"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed())
+		Expect(resp.RedirectTo).To(HavePrefix(redirectUri + "?"))
+		u, err := url.Parse(resp.RedirectTo)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(u.Query().Get("state")).To(Equal("xyz"))
+		return u.Query()
+	}
+
+	exchangeParams := func(clientId, secret, code string) url.Values {
+		return url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {clientId},
+			"client_secret": {secret},
+			"code":          {code},
+			"redirect_uri":  {redirectUri},
+			"code_verifier": {verifier},
+		}
+	}
+
+	// oauthError returns the RFC 6749 error code of a response
+	oauthError := func(w *httptest.ResponseRecorder) string {
+		var resp struct {
+			Error string `json:"error"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed(), w.Body.String())
+		return resp.Error
+	}
+
+	BeforeEach(func() {
+		router = newRouter()
+		alice = register(router, "alice")
+	})
+
+	It("should issue tokens with the scope granted for a code", func() {
+		id, secret := registerClient("read write:posts")
+		code := authorize(authorizationParams(id, "read write:posts")).Get("code")
+		Expect(code).ToNot(BeEmpty())
+
+		w := postForm("/oauth/token", exchangeParams(id, secret, code), "")
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		Expect(w.Header().Get("Cache-Control")).To(Equal("no-store"))
+
+		var resp server.TokenResponse
+		Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed())
+		Expect(resp.Scope).To(Equal("read write:posts"))
+		Expect(post(router, "alice", resp.AccessToken, "from the app").Code).To(Equal(http.StatusCreated))
+	})
+
+	It("should exchange a code only once", func() {
+		id, secret := registerClient("read")
+		code := authorize(authorizationParams(id, "read")).Get("code")
+
+		Expect(postForm("/oauth/token", exchangeParams(id, secret, code), "").Code).To(Equal(http.StatusOK))
+		w := postForm("/oauth/token", exchangeParams(id, secret, code), "")
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(oauthError(w)).To(Equal("invalid_grant"))
+	})
+
+	Describe("redirect URIs", func() {
+		It("should refuse to authorize with a redirect URI not registered exactly, without redirecting", func() {
+			id, _ := registerClient("read")
+			for _, uri := range []string{redirectUri + "/", redirectUri + "?next=1", "https://evil.example/callback"} {
+				params := authorizationParams(id, "read")
+				params.Set("redirect_uri", uri)
+				w := postForm("/oauth/authorize", params, alice)
+				Expect(w.Code).To(Equal(http.StatusBadRequest), uri)
+				Expect(oauthError(w)).To(Equal("invalid_request"))
+			}
+		})
+
+		It("should refuse to exchange a code for another redirect URI, using it up", func() {
+			id, secret := registerClient("read")
+			code := authorize(authorizationParams(id, "read")).Get("code")
+
+			params := exchangeParams(id, secret, code)
+			params.Set("redirect_uri", redirectUri+"/")
+			w := postForm("/oauth/token", params, "")
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(oauthError(w)).To(Equal("invalid_grant"))
+
+			Expect(postForm("/oauth/token", exchangeParams(id, secret, code), "").Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	It("should refuse to exchange a code with the wrong PKCE verifier", func() {
+		id, secret := registerClient("read")
+		code := authorize(authorizationParams(id, "read")).Get("code")
+
+		params := exchangeParams(id, secret, code)
+		params.Set("code_verifier", strings.Repeat("x", 50))
+		w := postForm("/oauth/token", params, "")
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(oauthError(w)).To(Equal("invalid_grant"))
+	})
+
+	It("should check client secrets", func() {
+		id, secret := registerClient("read")
+		code := authorize(authorizationParams(id, "read")).Get("code")
+
+		params := exchangeParams(id, "wrong", code)
+		w := postForm("/oauth/token", params, "")
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(oauthError(w)).To(Equal("invalid_client"))
+
+		params.Del("client_secret")
+		Expect(postForm("/oauth/token", params, "").Code).To(Equal(http.StatusUnauthorized))
+
+		// a refused client does not use up the code
+		params.Del("client_id")
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(params.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(url.QueryEscape(id), url.QueryEscape(secret))
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+	})
+
+	Describe("scopes", func() {
+		It("should not grant scopes the client did not register", func() {
+			id, _ := registerClient("read")
+			query := authorize(authorizationParams(id, "read write:posts"))
+			Expect(query.Get("code")).To(BeEmpty())
+			Expect(query.Get("error")).To(Equal("invalid_scope"))
+		})
+
+		It("should not grant scopes the user does not hold", func() {
+			id, _ := registerClient("read admin")
+			query := authorize(authorizationParams(id, "admin"))
+			Expect(query.Get("code")).To(BeEmpty())
+			Expect(query.Get("error")).To(Equal("invalid_scope"))
+		})
+	})
+
+	It("should send users who refuse back to the client with access_denied", func() {
+		id, _ := registerClient("read")
+		params := authorizationParams(id, "read")
+		params.Set("consent", "deny")
+		query := authorize(params)
+		Expect(query.Get("code")).To(BeEmpty())
+		Expect(query.Get("error")).To(Equal("access_denied"))
+	})
+})
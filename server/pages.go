@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/search"
+	"github.com/brandonsides/pubblr/server/render"
+	"github.com/go-chi/chi"
+)
+
+// ActorPage renders an actor's blog: its profile followed by a page of the
+// posts in its outbox which the reader may see, newest first
+func (router *PubblrRouter) ActorPage(r *http.Request, objectIface activitystreams.ObjectIface) ([]byte, error) {
+	actorIface, ok := objectIface.(activitystreams.ActorIface)
+	if !ok {
+		return nil, errors.New("not an actor")
+	}
+	name := shortId(activitystreams.ToObject(actorIface).Id)
+
+	posts, older, err := router.blogPosts(r, name)
+	if errors.Is(err, database.ErrInvalidId) {
+		posts, older = nil, ""
+	} else if err != nil {
+		return nil, err
+	}
+
+	page, err := router.blogPage(r.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+	profile := profileView(actorIface)
+	page.Title = profile.Name
+	page.Alternate = profile.Id
+	page.Feeds = router.feedLinks(profile.Name, name)
+
+	blog := &render.Blog{
+		Page:    *page,
+		Profile: profile,
+		Posts:   posts,
+	}
+	if older != "" {
+		blog.Older = pageUrl(profile.Id, older)
+	}
+	return render.RenderBlog(blog)
+}
+
+// ObjectPage renders an object as its permalink, on the blog of the actor in
+// whose collection it is
+func (router *PubblrRouter) ObjectPage(r *http.Request, objectIface activitystreams.ObjectIface) ([]byte, error) {
+	name := chi.URLParam(r, "actor")
+	actorIface, err := router.Database.GetUser(r.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := router.blogPage(r.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+	post := postView(objectIface)
+	page.Title = post.Name
+	if page.Title == "" {
+		page.Title = post.Summary
+	}
+	if page.Title == "" {
+		page.Title = activitystreams.ToObject(actorIface).Name
+	}
+	page.Alternate = post.Id
+
+	return render.RenderPermalink(&render.Permalink{
+		Page:    *page,
+		Profile: profileView(actorIface),
+		Post:    post,
+	})
+}
+
+// TagPage renders a page of the public posts bearing a hashtag, newest first,
+// as GetTag and TagFeed list them
+func (router *PubblrRouter) TagPage(r *http.Request, _ activitystreams.ObjectIface) ([]byte, error) {
+	tag := chi.URLParam(r, "tag")
+
+	query := search.Query{
+		Tag:   tag,
+		MaxId: r.URL.Query().Get(maxIdParam),
+		// fetch one extra hit to find out whether there is an older page
+		Limit:   router.pageSize + 1,
+		Visible: isPublic,
+	}
+	hits, err := router.Search.Search(query)
+	if errors.Is(err, search.ErrInvalidId) {
+		hits, err = nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ret := &render.TagPage{
+		Page: render.Page{
+			Title:       "#" + search.NormalizeTag(tag),
+			Stylesheets: []string{router.endpointUrl("themes", render.DefaultTheme+".css")},
+			Alternate:   router.tagUrl(tag),
+			Feeds:       router.feedLinks("#"+search.NormalizeTag(tag), "tags", search.NormalizeTag(tag)),
+		},
+		Tag: search.NormalizeTag(tag),
+	}
+	if len(hits) > router.pageSize {
+		hits = hits[:router.pageSize]
+		ret.Older = pageUrl(router.tagUrl(tag), hits[len(hits)-1].Id)
+	}
+	for _, hit := range hits {
+		ret.Posts = append(ret.Posts, postView(hit.Object))
+	}
+	return render.RenderTag(ret)
+}
+
+// blogPosts returns a page of the posts in an actor's outbox which the reader
+// may see, starting after the item given in the max_id parameter, along with
+// the id of the last item on the page if there may be more after it
+func (router *PubblrRouter) blogPosts(r *http.Request, actor string) ([]render.Post, string, error) {
+	readers := router.readerIds(r)
+	objects, older, err := router.outboxObjects(r.Context(), actor, r.URL.Query().Get(maxIdParam),
+		func(object activitystreams.ObjectIface) bool {
+			return router.intendedFor(r.Context(), readers, router.ownerId(actor), object)
+		})
+	if err != nil {
+		return nil, "", err
+	}
+
+	posts := make([]render.Post, 0, len(objects))
+	for _, object := range objects {
+		posts = append(posts, postView(object))
+	}
+	return posts, older, nil
+}
+
+// outboxObjects returns up to a page of the objects an actor created for
+// which include returns true, newest first, starting after the outbox item
+// with the given id, along with the id of the last item on the page if there
+// may be more after it
+func (router *PubblrRouter) outboxObjects(ctx context.Context, actor, maxId string, include func(activitystreams.ObjectIface) bool) ([]activitystreams.ObjectIface, string, error) {
+	query := database.PageQuery{
+		MaxId: maxId,
+		Limit: router.pageSize,
+	}
+
+	var objects []activitystreams.ObjectIface
+	for {
+		items, err := router.Database.GetOutboxItems(ctx, actor, query)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, item := range items {
+			// Only what the actor created is shown; what groups announce
+			// of their members' posts repeats their own Creates
+			create, ok := item.Activity.(*activitystreams.Create)
+			if !ok {
+				continue
+			}
+			object, ok := create.Object.(activitystreams.ObjectIface)
+			if !ok || !include(object) {
+				continue
+			}
+
+			objects = append(objects, object)
+			if len(objects) == router.pageSize {
+				return objects, item.Id, nil
+			}
+		}
+
+		if len(items) < query.Limit {
+			return objects, "", nil
+		}
+		query.MaxId = items[len(items)-1].Id
+	}
+}
+
+// blogPage returns the parts of a page on an actor's blog which its
+// appearance decides
+func (router *PubblrRouter) blogPage(ctx context.Context, actor string) (*render.Page, error) {
+	appearance, err := router.Database.GetAppearance(ctx, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	theme, ok := render.LookupTheme(appearance.Theme)
+	if !ok {
+		// the theme may since have been removed from the server
+		theme, _ = render.LookupTheme("")
+	}
+	ret := &render.Page{
+		Stylesheets: []string{router.endpointUrl("themes", theme.Name+".css")},
+	}
+	if appearance.CSS != "" {
+		ret.Stylesheets = append(ret.Stylesheets, router.endpointUrl(actor, "style.css"))
+	}
+	return ret, nil
+}
+
+// pageUrl returns the URL of the page of items older than the given item at
+// the given URL
+func pageUrl(base, maxId string) string {
+	params := url.Values{}
+	params.Set(maxIdParam, maxId)
+	return base + "?" + params.Encode()
+}
+
+func profileView(actorIface activitystreams.ActorIface) render.Profile {
+	actor := activitystreams.ToActor(actorIface)
+	ret := render.Profile{
+		Id:       actor.Id,
+		Name:     actor.Name,
+		Username: shortId(actor.Id),
+		Summary:  actor.Summary,
+		Icon:     entityUrl(actor.Icon),
+	}
+	if ret.Name == "" {
+		ret.Name = ret.Username
+	}
+	return ret
+}
+
+func postView(objectIface activitystreams.ObjectIface) render.Post {
+	object := activitystreams.ToObject(objectIface)
+
+	ret := render.Post{
+		Id:        object.Id,
+		Name:      object.Name,
+		Summary:   object.Summary,
+		Content:   object.Content,
+		Published: object.Published,
+	}
+	for _, author := range object.AttributedTo {
+		if author == nil {
+			continue
+		}
+		entity := activitystreams.ToEntity(author)
+		name := entity.Name
+		if name == "" {
+			name = shortId(entity.Id)
+		}
+		ret.Authors = append(ret.Authors, render.Author{Id: entity.Id, Name: name})
+	}
+	for _, attachment := range append([]activitystreams.EntityIface{object.Image}, object.Attachment...) {
+		if _, ok := attachment.(*activitystreams.Image); ok {
+			ret.Images = append(ret.Images, entityUrl(attachment))
+		}
+	}
+	for _, tag := range object.Tag {
+		if hashtag, ok := tag.(*activitystreams.Hashtag); ok {
+			ret.Tags = append(ret.Tags, render.Tag{Name: hashtag.Name, Href: hashtag.Href})
+		}
+	}
+	return ret
+}
+
+// entityUrl returns the URL at which an entity, such as an image, may be
+// fetched: the href of a link, or the url of an object if it has one
+func entityUrl(entityIface activitystreams.EntityIface) string {
+	switch entity := entityIface.(type) {
+	case nil:
+		return ""
+	case activitystreams.LinkIface:
+		return activitystreams.ToLink(entity).Href
+	case activitystreams.ObjectIface:
+		url := activitystreams.ToObject(entity).URL
+		if url == nil {
+			break
+		}
+		if s := url.Left(); s != nil {
+			return *s
+		}
+		if link := url.Right(); link != nil && *link != nil {
+			return activitystreams.ToLink(*link).Href
+		}
+	}
+	return activitystreams.ToEntity(entityIface).Id
+}
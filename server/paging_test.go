@@ -0,0 +1,131 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+)
+
+var _ = Describe("Paging", func() {
+	var router server.PubblrRouter
+	var alice string
+
+	type page struct {
+		Contents []string
+		// the query parameters of the pages linked, if any
+		Next, Prev url.Values
+	}
+
+	// query returns the query parameters of the URL a page is linked by
+	query := func(raw json.RawMessage) url.Values {
+		if len(raw) == 0 {
+			return nil
+		}
+		var link struct {
+			Id string `json:"id"`
+		}
+		if json.Unmarshal(raw, &link.Id) != nil {
+			Expect(json.Unmarshal(raw, &link)).To(Succeed())
+		}
+		u, err := url.Parse(link.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(u.Path).To(Equal("/alice/outbox"))
+		return u.Query()
+	}
+
+	// getPage returns the page of alice's outbox with the given query
+	getPage := func(params string) page {
+		w := do(router, "GET", "/alice/outbox?"+params, "", alice)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var resp struct {
+			Items []struct {
+				Object struct {
+					Content string `json:"content"`
+				} `json:"object"`
+			} `json:"items"`
+			Next json.RawMessage `json:"next"`
+			Prev json.RawMessage `json:"prev"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed())
+
+		ret := page{Next: query(resp.Next), Prev: query(resp.Prev)}
+		for _, item := range resp.Items {
+			ret.Contents = append(ret.Contents, item.Object.Content)
+		}
+		return ret
+	}
+
+	BeforeEach(func() {
+		router = newRouter(func(config *server.PubblrRouterConfig) {
+			config.PageSize = 2
+		})
+		alice = register(router, "alice")
+		// the outbox's item ids are 0 to 4, each posting its own id
+		for i := 0; i < 5; i++ {
+			Expect(post(router, "alice", alice, strconv.Itoa(i)).Code).To(Equal(http.StatusCreated))
+		}
+	})
+
+	It("should link the collection to its first page", func() {
+		w := do(router, "GET", "/alice/outbox", "", alice)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var resp struct {
+			TotalItems int             `json:"totalItems"`
+			First      json.RawMessage `json:"first"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed())
+		Expect(resp.TotalItems).To(Equal(5))
+		Expect(query(resp.First)).To(Equal(url.Values{"page": {"true"}}))
+	})
+
+	It("should serve the newest items first, linking to older and newer pages", func() {
+		first := getPage("page=true")
+		Expect(first.Contents).To(Equal([]string{"4", "3"}))
+		Expect(first.Next.Get("max_id")).To(Equal("3"))
+		Expect(first.Prev.Get("min_id")).To(Equal("4"))
+	})
+
+	It("should serve the items older than max_id", func() {
+		second := getPage("page=true&max_id=3")
+		Expect(second.Contents).To(Equal([]string{"2", "1"}))
+		Expect(second.Next.Get("max_id")).To(Equal("1"))
+		Expect(second.Prev.Get("min_id")).To(Equal("2"))
+	})
+
+	It("should not link past the oldest item", func() {
+		last := getPage("page=true&max_id=1")
+		Expect(last.Contents).To(Equal([]string{"0"}))
+		Expect(last.Next).To(BeNil())
+		Expect(last.Prev.Get("min_id")).To(Equal("0"))
+	})
+
+	It("should serve the items just newer than min_id", func() {
+		Expect(getPage("page=true&min_id=1").Contents).To(Equal([]string{"3", "2"}))
+	})
+
+	It("should serve the newest items newer than since_id", func() {
+		Expect(getPage("page=true&since_id=1").Contents).To(Equal([]string{"4", "3"}))
+		Expect(getPage("page=true&since_id=3").Contents).To(Equal([]string{"4"}))
+	})
+
+	It("should serve an empty page after the newest item, to be polled for newer ones", func() {
+		newest := getPage("page=true&min_id=4")
+		Expect(newest.Contents).To(BeEmpty())
+		Expect(newest.Next).To(BeNil())
+		Expect(newest.Prev).To(BeNil())
+	})
+
+	It("should refuse invalid cursors", func() {
+		for _, param := range []string{"max_id", "min_id", "since_id"} {
+			Expect(do(router, "GET", "/alice/outbox?"+param+"=nope", "", alice).Code).To(Equal(http.StatusBadRequest), param)
+		}
+	})
+})
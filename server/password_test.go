@@ -0,0 +1,71 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/auth"
+)
+
+var _ = Describe("Password reset", func() {
+	var router server.PubblrRouter
+	var alice, token string
+
+	ctx := context.Background()
+
+	// resetPassword completes a reset with the token mailed to alice
+	resetPassword := func(newPassword string) *http.Response {
+		w := do(router, "POST", "/password/reset/confirm",
+			`{"token":"`+token+`","password":`+jsonString(newPassword)+`}`, "")
+		return w.Result()
+	}
+
+	login := func(password string) int {
+		return do(router, "POST", "/login", `{"username":"alice","password":`+jsonString(password)+`}`, "").Code
+	}
+
+	BeforeEach(func() {
+		router = newRouter()
+		alice = register(router, "alice")
+
+		var err error
+		token, err = auth.NewSecret()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(router.Database.CreateAccountToken(ctx, database.AccountToken{
+			Hash:     auth.HashToken(token),
+			Username: "alice",
+			Purpose:  database.PurposePasswordReset,
+			Expires:  time.Now().Add(time.Hour),
+		})).To(Succeed())
+	})
+
+	It("should refuse weak passwords, leaving the token to be used again", func() {
+		resp := resetPassword("alice-alice-alice")
+		Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+
+		var body apiutil.ErrorResponse
+		Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+		Expect(body.Details).To(ConsistOf(HaveField("Field", "password")))
+
+		Expect(resetPassword("a much stronger one").StatusCode).To(Equal(http.StatusNoContent))
+		Expect(login(password)).To(Equal(http.StatusUnauthorized))
+		Expect(login("a much stronger one")).To(Equal(http.StatusOK))
+	})
+
+	It("should end every session and revoke every personal access token", func() {
+		pat := personalAccessToken(router, "alice", alice)
+		Expect(do(router, "GET", "/alice/outbox", "", pat).Code).To(Equal(http.StatusOK))
+
+		Expect(resetPassword("a much stronger one").StatusCode).To(Equal(http.StatusNoContent))
+		Expect(do(router, "GET", "/alice/outbox", "", alice).Code).To(Equal(http.StatusUnauthorized))
+		Expect(do(router, "GET", "/alice/outbox", "", pat).Code).To(Equal(http.StatusUnauthorized))
+	})
+})
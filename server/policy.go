@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/brandonsides/pubblr/activitystreams"
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/go-chi/chi"
+)
+
+// The scopes which access tokens may carry.  Tokens issued on login carry every
+// scope their user holds; tokens issued to OAuth clients carry only those the
+// user consented to.
+const (
+	ScopeRead         = "read"
+	ScopeWritePosts   = "write:posts"
+	ScopeWriteFollows = "write:follows"
+	ScopeAdmin        = "admin"
+)
+
+// The roles which may be granted to users
+const (
+	RoleAdmin = "admin"
+)
+
+var knownRoles = []string{RoleAdmin}
+
+// userScopes returns the space-separated scopes held by a user with the given
+// roles
+func userScopes(roles []string) string {
+	scopes := []string{ScopeRead, ScopeWritePosts, ScopeWriteFollows}
+	if in(RoleAdmin, roles) {
+		scopes = append(scopes, ScopeAdmin)
+	}
+	return strings.Join(scopes, " ")
+}
+
+// grantConfiguredRoles grants a new user the roles the configuration gives
+// them, so that a server's first administrators need no one to promote them
+func (router *PubblrRouter) grantConfiguredRoles(ctx context.Context, db database.Queries, username string) error {
+	if !in(username, router.admins) {
+		return nil
+	}
+	return db.SetRoles(ctx, username, []string{RoleAdmin})
+}
+
+// activityScope returns the scope needed to post the given activity.
+// Following and blocking, and undoing either, change whom the user hears from
+// rather than what they say, so they are granted separately.
+func activityScope(activity activitystreams.ActivityIface) string {
+	switch a := activity.(type) {
+	case *activitystreams.Follow, *activitystreams.Block:
+		return ScopeWriteFollows
+	case *activitystreams.Undo:
+		if undone, ok := a.Object.(activitystreams.ActivityIface); ok {
+			return activityScope(undone)
+		}
+	}
+	return ScopeWritePosts
+}
+
+// hasScope reports whether the token authorizing the request carries scope
+func hasScope(r *http.Request, scope string) bool {
+	principal, ok := PrincipalFromContext(r.Context())
+	return ok && in(scope, strings.Fields(principal.Scope))
+}
+
+// Policy is a requirement which a request must meet to reach an endpoint.  It
+// returns a status refusing the request if the request does not meet it, and
+// nil otherwise.  Policies rely on the user making the request having been
+// identified, so must be applied within Identify.
+type Policy func(r *http.Request) apiutil.Status
+
+// Authorized guards an endpoint with policies, calling it only for requests
+// which meet all of them.  Requests refused for want of credentials are
+// challenged to authenticate.
+func Authorized(policies ...Policy) apiutil.Middleware {
+	return func(r *http.Request, next apiutil.Next) (http.Header, apiutil.Status) {
+		for _, policy := range policies {
+			if status := policy(r); status != nil {
+				if status.StatusCode() == http.StatusUnauthorized {
+					return challengeHeader(&Challenge{Scheme: "Bearer"}), status
+				}
+				return nil, status
+			}
+		}
+		return next(r)
+	}
+}
+
+// PostingAs keeps users from posting to the endpoints of actors they may not
+// act as
+func PostingAs(r *http.Request) apiutil.Status {
+	owner := chi.URLParam(r, "actor")
+	if r.Method == "POST" && owner != "" && !in(owner, currentActors(r)) {
+		return apiutil.NewStatus(http.StatusForbidden, "You are not authorized act on behalf of this user")
+	}
+	return nil
+}
+
+// PostingThrough is PostingAs for outboxes, which members of a group may also
+// post to.  What they post is theirs, which the group then announces; they do
+// not act as the group.
+func (router *PubblrRouter) PostingThrough(r *http.Request) apiutil.Status {
+	status := PostingAs(r)
+	if status == nil || r.Method != "POST" {
+		return status
+	}
+	if _, denied := groupMembership(r.Context(), router.Database, r); denied != nil {
+		return status
+	}
+	return nil
+}
+
+// LoggedIn requires that the request be made by a user
+func LoggedIn(r *http.Request) apiutil.Status {
+	if _, ok := currentUser(r); !ok {
+		return apiutil.NewStatus(http.StatusUnauthorized, "You are not logged in")
+	}
+	return nil
+}
+
+// FirstParty requires that the request be made by a user with a token issued
+// on login, rather than to an OAuth client or as a personal access token.  It
+// guards endpoints which manage the account itself.
+func FirstParty(r *http.Request) apiutil.Status {
+	if status := LoggedIn(r); status != nil {
+		return status
+	}
+	principal, _ := PrincipalFromContext(r.Context())
+	if principal.ClientId != "" {
+		return apiutil.NewStatus(http.StatusForbidden, "Applications may not access this resource")
+	}
+	if principal.TokenId != "" {
+		return apiutil.NewStatus(http.StatusForbidden, "Personal access tokens may not access this resource")
+	}
+	return nil
+}
+
+// Owner requires that the request be made by the user named by the actor URL
+// parameter
+func Owner(r *http.Request) apiutil.Status {
+	if status := LoggedIn(r); status != nil {
+		return status
+	}
+	if username, _ := currentUser(r); username != chi.URLParam(r, "actor") {
+		return apiutil.NewStatus(http.StatusForbidden, "You are not authorized to manage this account")
+	}
+	return nil
+}
+
+// ActingAs requires that the request be made by a user who may act as the
+// actor named by the actor URL parameter: its account, or the account owning
+// it, if it is a side blog.  Groups are managed by their owners and admins.
+func (router *PubblrRouter) ActingAs(r *http.Request) apiutil.Status {
+	if status := LoggedIn(r); status != nil {
+		return status
+	}
+	if actsAs(r, chi.URLParam(r, "actor")) {
+		return nil
+	}
+	member, status := groupMembership(r.Context(), router.Database, r)
+	if status != nil || (member.Role != database.GroupOwner && member.Role != database.GroupAdmin) {
+		return apiutil.NewStatus(http.StatusForbidden, "You are not authorized to manage this actor")
+	}
+	return nil
+}
+
+// Scoped requires that a request made with a token have the given scope.
+// Anonymous requests are let through, for endpoints which serve public
+// resources to anyone.
+func Scoped(scope string) Policy {
+	return func(r *http.Request) apiutil.Status {
+		if _, ok := currentUser(r); ok && !hasScope(r, scope) {
+			return apiutil.NewCodedStatus(http.StatusForbidden, "insufficient_scope", "Token lacks the "+scope+" scope")
+		}
+		return nil
+	}
+}
+
+// RequireScope requires that the request be made by a user with a token
+// having the given scope
+func RequireScope(scope string) Policy {
+	return func(r *http.Request) apiutil.Status {
+		if status := LoggedIn(r); status != nil {
+			return status
+		}
+		return Scoped(scope)(r)
+	}
+}
+
+// Admin requires that the request be made by an administrator with a token
+// having the admin scope.  The user's roles are checked again, so that
+// administrators who are demoted lose access at once.
+func (router *PubblrRouter) Admin(r *http.Request) apiutil.Status {
+	if status := RequireScope(ScopeAdmin)(r); status != nil {
+		return status
+	}
+
+	username, _ := currentUser(r)
+	roles, err := router.Database.GetRoles(r.Context(), username)
+	if err != nil {
+		return apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if !in(RoleAdmin, roles) {
+		return apiutil.NewStatus(http.StatusForbidden, "You are not an administrator")
+	}
+	return nil
+}
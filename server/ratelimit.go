@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/ratelimit"
+	"github.com/go-chi/chi"
+)
+
+// rateLimits holds the state by which requests to endpoints which check
+// credentials or create accounts are limited
+type rateLimits struct {
+	perIP       *ratelimit.Limiter
+	perUsername *ratelimit.Limiter
+	// keyed by username and client IP, counting failed passwords and second
+	// factors
+	lockouts *ratelimit.Lockouts
+}
+
+func newRateLimits(config ratelimit.Config) *rateLimits {
+	config = config.WithDefaults()
+	return &rateLimits{
+		perIP:       ratelimit.NewLimiter(config.PerIP),
+		perUsername: ratelimit.NewLimiter(config.PerUsername),
+		lockouts:    ratelimit.NewLockouts(config.Lockout),
+	}
+}
+
+// tooManyRequests returns the response to a request refused for the given
+// time, telling the client when to try again
+func tooManyRequests(retryAfter time.Duration) (http.Header, apiutil.Status) {
+	seconds := int64((retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	header := http.Header{}
+	header.Set("Retry-After", strconv.FormatInt(seconds, 10))
+	return header, apiutil.NewStatus(http.StatusTooManyRequests, "Too many requests; try again later")
+}
+
+// clientIP returns the address of the client making a request.  Behind a
+// reverse proxy, this is the proxy's address unless the router is mounted
+// behind chi's RealIP middleware.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestUsername returns the username a request names, either as the actor
+// in its path or as the username in its JSON body, if any.  The body is left
+// for the endpoint to read.
+func requestUsername(r *http.Request) string {
+	if actor := chi.URLParam(r, "actor"); actor != "" {
+		return actor
+	}
+	if r.Body == nil {
+		return ""
+	}
+
+	reqBody, err := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	if err != nil {
+		return ""
+	}
+	var body struct {
+		Username string `json:"username"`
+	}
+	if json.Unmarshal(reqBody, &body) != nil {
+		return ""
+	}
+	return body.Username
+}
+
+// RateLimited limits the rate of requests to the endpoint from each IP
+// address, refusing those over the limit with 429 Too Many Requests.  Requests
+// naming a username whose failed logins are over their limit are refused too,
+// though only failures are charged to it, by loginFailed.
+func RateLimited(router *PubblrRouter) apiutil.Middleware {
+	return func(r *http.Request, next apiutil.Next) (http.Header, apiutil.Status) {
+		now := time.Now()
+
+		ip := clientIP(r)
+		if ok, retryAfter := router.limits.perIP.Allow(ip, now); !ok {
+			router.Logger.Warnf("Rate limited %s %s from %s", r.Method, r.URL.Path, ip)
+			return tooManyRequests(retryAfter)
+		}
+
+		if username := requestUsername(r); username != "" {
+			if ok, retryAfter := router.limits.perUsername.Check(username, now); !ok {
+				router.Logger.Warnf("Rate limited %s %s for user %s", r.Method, r.URL.Path, username)
+				return tooManyRequests(retryAfter)
+			}
+		}
+
+		return next(r)
+	}
+}
+
+// lockoutKey identifies the lockout of a user's logins from the client making
+// a request.  Lockouts are kept per client, so that failures from an
+// attacker's address do not lock the user out from their own.  Guessing from
+// many addresses is instead slowed by the per-username limit on failures,
+// which is shared by every address: an attacker failing often enough to
+// exhaust it does keep the user from logging in until it refills, which is the
+// price of bounding how fast their password may be guessed.
+func lockoutKey(r *http.Request, username string) string {
+	return username + " " + clientIP(r)
+}
+
+// checkLockout returns the response to a login attempt for a user who is
+// locked out from the client making it, or nil if they are not
+func (router *PubblrRouter) checkLockout(r *http.Request, username string) (http.Header, apiutil.Status) {
+	if locked, retryAfter := router.limits.lockouts.Locked(lockoutKey(r, username), time.Now()); locked {
+		router.Logger.Warnf("Refused login for user %s locked out from %s", username, clientIP(r))
+		return tooManyRequests(retryAfter)
+	}
+	return nil, nil
+}
+
+// loginFailed records a failed login attempt for a user, charging it to the
+// user's limit and locking them out from the client which made it if it has
+// failed too often
+func (router *PubblrRouter) loginFailed(r *http.Request, username string) {
+	now := time.Now()
+	router.limits.perUsername.Take(username, now)
+	if duration := router.limits.lockouts.Fail(lockoutKey(r, username), now); duration > 0 {
+		router.Logger.Warnf("Locked out user %s from %s for %s after repeated failed logins", username, clientIP(r), duration)
+	}
+}
+
+// loginSucceeded forgets the failed login attempts for a user from the client
+// which has now logged in as them
+func (router *PubblrRouter) loginSucceeded(r *http.Request, username string) {
+	router.limits.lockouts.Succeed(lockoutKey(r, username))
+}
+
+// RateLimitMetrics counts the requests refused by rate limiting
+type RateLimitMetrics struct {
+	BlockedByIP       uint64 `json:"blockedByIp"`
+	BlockedByUsername uint64 `json:"blockedByUsername"`
+	BlockedByLockout  uint64 `json:"blockedByLockout"`
+	LockoutsImposed   uint64 `json:"lockoutsImposed"`
+}
+
+func (router *PubblrRouter) GetRateLimitMetrics(r *http.Request) (*RateLimitMetrics, http.Header, apiutil.Status) {
+	return &RateLimitMetrics{
+		BlockedByIP:       router.limits.perIP.Blocked(),
+		BlockedByUsername: router.limits.perUsername.Blocked(),
+		BlockedByLockout:  router.limits.lockouts.Blocked(),
+		LockoutsImposed:   router.limits.lockouts.Imposed(),
+	}, nil, apiutil.StatusFromCode(http.StatusOK)
+}
@@ -0,0 +1,284 @@
+// Package ratelimit limits how often clients may make requests, with token
+// buckets kept per key, and locks keys out after repeated failures.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the number of calls between sweeps for idle entries
+const sweepInterval = 1024
+
+// Config configures rate limiting of the endpoints which check credentials
+type Config struct {
+	// the limit on requests from each IP address
+	PerIP BucketConfig `json:"perIp"`
+	// the limit on failed logins as each username, from any IP address
+	PerUsername BucketConfig `json:"perUsername"`
+	// the lockout of each username from each IP address after failed logins
+	Lockout LockoutConfig `json:"lockout"`
+}
+
+// BucketConfig configures a token bucket, which holds up to Burst tokens and
+// refills at Rate tokens per second.  Each request takes a token, and is
+// refused if none is left.
+type BucketConfig struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// LockoutConfig configures lockouts.  Once a key has failed Threshold times in
+// a row, it is locked out for BaseDuration, and each further failure doubles
+// the lockout, up to MaxDuration.
+type LockoutConfig struct {
+	Threshold    int           `json:"threshold"`
+	BaseDuration time.Duration `json:"baseDuration"`
+	MaxDuration  time.Duration `json:"maxDuration"`
+}
+
+// WithDefaults returns the config with unset fields given their defaults
+func (config Config) WithDefaults() Config {
+	if config.PerIP.Rate == 0 {
+		config.PerIP.Rate = 0.2
+	}
+	if config.PerIP.Burst == 0 {
+		config.PerIP.Burst = 20
+	}
+	if config.PerUsername.Rate == 0 {
+		config.PerUsername.Rate = 1.0 / 60
+	}
+	if config.PerUsername.Burst == 0 {
+		config.PerUsername.Burst = 10
+	}
+	if config.Lockout.Threshold == 0 {
+		config.Lockout.Threshold = 5
+	}
+	if config.Lockout.BaseDuration == 0 {
+		config.Lockout.BaseDuration = time.Minute
+	}
+	if config.Lockout.MaxDuration == 0 {
+		config.Lockout.MaxDuration = time.Hour
+	}
+	return config
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps a token bucket per key.  It is safe for concurrent use.
+type Limiter struct {
+	config  BucketConfig
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+	blocked uint64
+}
+
+func NewLimiter(config BucketConfig) *Limiter {
+	return &Limiter{
+		config:  config,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the key's bucket at the given time.  If the bucket
+// is empty, it returns false, along with how long it will be until a token is
+// available.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, now)
+	if b.tokens < 1 {
+		return false, l.refuse(b)
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Check is Allow without taking a token, for limits charged only for some
+// requests, which take their tokens with Take
+func (l *Limiter) Check(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, now)
+	if b.tokens < 1 {
+		return false, l.refuse(b)
+	}
+	return true, 0
+}
+
+// Take takes a token from the key's bucket at the given time, if one is left
+func (l *Limiter) Take(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, now)
+	if b.tokens >= 1 {
+		b.tokens--
+	}
+}
+
+// bucket returns the key's bucket, refilled to the given time.  l.mu must be
+// held.
+func (l *Limiter) bucket(key string, now time.Time) *bucket {
+	l.calls++
+	if l.calls%sweepInterval == 0 {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.config.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	return b
+}
+
+// refuse counts a request refused for want of a token in the bucket, and
+// returns how long it will be until a token is available
+func (l *Limiter) refuse(b *bucket) time.Duration {
+	atomic.AddUint64(&l.blocked, 1)
+	if l.config.Rate <= 0 {
+		return math.MaxInt64
+	}
+	return time.Duration((1 - b.tokens) / l.config.Rate * float64(time.Second))
+}
+
+// refill returns the tokens a bucket holds at the given time
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(l.config.Burst), b.tokens+elapsed*l.config.Rate)
+}
+
+// sweep forgets buckets which have refilled, since they are no different from
+// new ones
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.config.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Blocked returns the number of requests refused
+func (l *Limiter) Blocked() uint64 {
+	return atomic.LoadUint64(&l.blocked)
+}
+
+type lockout struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Lockouts counts consecutive failures per key, and locks out keys which fail
+// too often.  It is safe for concurrent use.
+type Lockouts struct {
+	config   LockoutConfig
+	mu       sync.Mutex
+	lockouts map[string]*lockout
+	calls    int
+	blocked  uint64
+	imposed  uint64
+}
+
+func NewLockouts(config LockoutConfig) *Lockouts {
+	return &Lockouts{
+		config:   config,
+		lockouts: make(map[string]*lockout),
+	}
+}
+
+// Locked reports whether the key is locked out at the given time, and if so,
+// for how much longer
+func (l *Lockouts) Locked(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lo, ok := l.lockouts[key]
+	if !ok || !now.Before(lo.lockedUntil) {
+		return false, 0
+	}
+	atomic.AddUint64(&l.blocked, 1)
+	return true, lo.lockedUntil.Sub(now)
+}
+
+// Fail records a failure for the key at the given time, and returns how long
+// the key is now locked out for, if at all.  Failures are forgotten once
+// MaxDuration passes without another.
+func (l *Lockouts) Fail(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.calls++
+	if l.calls%sweepInterval == 0 {
+		l.sweep(now)
+	}
+
+	lo, ok := l.lockouts[key]
+	if !ok || l.stale(lo, now) {
+		lo = &lockout{}
+		l.lockouts[key] = lo
+	}
+	lo.failures++
+	lo.lastFailure = now
+
+	excess := lo.failures - l.config.Threshold
+	if excess < 0 {
+		return 0
+	}
+
+	duration := l.config.MaxDuration
+	if excess < 32 {
+		duration = l.config.BaseDuration << excess
+	}
+	if duration > l.config.MaxDuration || duration <= 0 {
+		duration = l.config.MaxDuration
+	}
+	lo.lockedUntil = now.Add(duration)
+	atomic.AddUint64(&l.imposed, 1)
+	return duration
+}
+
+// Succeed forgets the key's failures
+func (l *Lockouts) Succeed(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.lockouts, key)
+}
+
+func (l *Lockouts) stale(lo *lockout, now time.Time) bool {
+	return !now.Before(lo.lockedUntil) && now.Sub(lo.lastFailure) >= l.config.MaxDuration
+}
+
+func (l *Lockouts) sweep(now time.Time) {
+	for key, lo := range l.lockouts {
+		if l.stale(lo, now) {
+			delete(l.lockouts, key)
+		}
+	}
+}
+
+// Blocked returns the number of attempts refused because their key was locked
+// out
+func (l *Lockouts) Blocked() uint64 {
+	return atomic.LoadUint64(&l.blocked)
+}
+
+// Imposed returns the number of lockouts imposed
+func (l *Lockouts) Imposed() uint64 {
+	return atomic.LoadUint64(&l.imposed)
+}
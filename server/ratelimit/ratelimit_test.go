@@ -0,0 +1,127 @@
+package ratelimit_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server/ratelimit"
+)
+
+var _ = Describe("Limiter", func() {
+	var limiter *ratelimit.Limiter
+	now := time.Unix(1000000, 0)
+
+	BeforeEach(func() {
+		limiter = ratelimit.NewLimiter(ratelimit.BucketConfig{Rate: 0.5, Burst: 3})
+	})
+
+	It("should allow a burst, then refuse until the bucket refills", func() {
+		for i := 0; i < 3; i++ {
+			ok, _ := limiter.Allow("1.2.3.4", now)
+			Expect(ok).To(BeTrue())
+		}
+
+		ok, retryAfter := limiter.Allow("1.2.3.4", now)
+		Expect(ok).To(BeFalse())
+		Expect(retryAfter).To(Equal(2 * time.Second))
+		Expect(limiter.Blocked()).To(Equal(uint64(1)))
+
+		ok, _ = limiter.Allow("1.2.3.4", now.Add(2*time.Second))
+		Expect(ok).To(BeTrue())
+		ok, _ = limiter.Allow("1.2.3.4", now.Add(2*time.Second))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should keep a bucket per key", func() {
+		for i := 0; i < 3; i++ {
+			limiter.Allow("1.2.3.4", now)
+		}
+		ok, _ := limiter.Allow("5.6.7.8", now)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should refill no further than the burst", func() {
+		ok, _ := limiter.Allow("1.2.3.4", now)
+		Expect(ok).To(BeTrue())
+
+		later := now.Add(time.Hour)
+		for i := 0; i < 3; i++ {
+			ok, _ := limiter.Allow("1.2.3.4", later)
+			Expect(ok).To(BeTrue())
+		}
+		ok, _ = limiter.Allow("1.2.3.4", later)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should check a bucket without taking from it, until told to take", func() {
+		for i := 0; i < 5; i++ {
+			ok, _ := limiter.Check("alice", now)
+			Expect(ok).To(BeTrue())
+		}
+
+		for i := 0; i < 3; i++ {
+			limiter.Take("alice", now)
+		}
+		ok, retryAfter := limiter.Check("alice", now)
+		Expect(ok).To(BeFalse())
+		Expect(retryAfter).To(Equal(2 * time.Second))
+		Expect(limiter.Blocked()).To(Equal(uint64(1)))
+	})
+})
+
+var _ = Describe("Lockouts", func() {
+	var lockouts *ratelimit.Lockouts
+	now := time.Unix(1000000, 0)
+
+	BeforeEach(func() {
+		lockouts = ratelimit.NewLockouts(ratelimit.LockoutConfig{
+			Threshold:    3,
+			BaseDuration: time.Minute,
+			MaxDuration:  5 * time.Minute,
+		})
+	})
+
+	It("should lock keys out once they reach the threshold", func() {
+		Expect(lockouts.Fail("alice", now)).To(BeZero())
+		Expect(lockouts.Fail("alice", now)).To(BeZero())
+		locked, _ := lockouts.Locked("alice", now)
+		Expect(locked).To(BeFalse())
+
+		Expect(lockouts.Fail("alice", now)).To(Equal(time.Minute))
+		locked, remaining := lockouts.Locked("alice", now.Add(20*time.Second))
+		Expect(locked).To(BeTrue())
+		Expect(remaining).To(Equal(40 * time.Second))
+		Expect(lockouts.Blocked()).To(Equal(uint64(1)))
+		Expect(lockouts.Imposed()).To(Equal(uint64(1)))
+
+		locked, _ = lockouts.Locked("alice", now.Add(time.Minute))
+		Expect(locked).To(BeFalse())
+		locked, _ = lockouts.Locked("bob", now)
+		Expect(locked).To(BeFalse())
+	})
+
+	It("should double lockouts with each further failure, up to the maximum", func() {
+		for i := 0; i < 3; i++ {
+			lockouts.Fail("alice", now)
+		}
+		Expect(lockouts.Fail("alice", now)).To(Equal(2 * time.Minute))
+		Expect(lockouts.Fail("alice", now)).To(Equal(4 * time.Minute))
+		Expect(lockouts.Fail("alice", now)).To(Equal(5 * time.Minute))
+		Expect(lockouts.Fail("alice", now)).To(Equal(5 * time.Minute))
+	})
+
+	It("should forget failures on success", func() {
+		lockouts.Fail("alice", now)
+		lockouts.Fail("alice", now)
+		lockouts.Succeed("alice")
+		Expect(lockouts.Fail("alice", now)).To(BeZero())
+	})
+
+	It("should forget failures once the maximum lockout passes without another", func() {
+		lockouts.Fail("alice", now)
+		lockouts.Fail("alice", now)
+		Expect(lockouts.Fail("alice", now.Add(time.Hour))).To(BeZero())
+	})
+})
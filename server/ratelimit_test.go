@@ -0,0 +1,73 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+	"github.com/brandonsides/pubblr/server/ratelimit"
+)
+
+var _ = Describe("Lockouts", func() {
+	var router server.PubblrRouter
+
+	// loginFrom attempts to log in as alice from the given address
+	loginFrom := func(addr, password string) int {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"alice","password":`+jsonString(password)+`}`))
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	const attacker = "203.0.113.1:4000"
+	const victim = "198.51.100.7:5000"
+
+	BeforeEach(func() {
+		router = newRouter(func(config *server.PubblrRouterConfig) {
+			config.RateLimit = ratelimit.Config{
+				PerIP:       ratelimit.BucketConfig{Rate: 1000, Burst: 1000},
+				PerUsername: ratelimit.BucketConfig{Rate: 1000, Burst: 1000},
+				Lockout:     ratelimit.LockoutConfig{Threshold: 3},
+			}
+		})
+		register(router, "alice")
+	})
+
+	It("should lock a user out from the address which failed to log in as them", func() {
+		for i := 0; i < 3; i++ {
+			Expect(loginFrom(attacker, "guess")).To(Equal(http.StatusUnauthorized))
+		}
+		Expect(loginFrom(attacker, password)).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("should not lock a user out from their own address for failures from another", func() {
+		for i := 0; i < 5; i++ {
+			loginFrom(attacker, "guess")
+		}
+		Expect(loginFrom(victim, password)).To(Equal(http.StatusOK))
+		Expect(loginFrom(attacker, password)).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("should charge a user's limit only with failed logins, from whichever address", func() {
+		router = newRouter(func(config *server.PubblrRouterConfig) {
+			config.RateLimit = ratelimit.Config{
+				PerIP:       ratelimit.BucketConfig{Rate: 1000, Burst: 1000},
+				PerUsername: ratelimit.BucketConfig{Rate: 0.001, Burst: 2},
+				Lockout:     ratelimit.LockoutConfig{Threshold: 100},
+			}
+		})
+		register(router, "alice")
+
+		for i := 0; i < 5; i++ {
+			Expect(loginFrom(victim, password)).To(Equal(http.StatusOK))
+		}
+		Expect(loginFrom(attacker, "guess")).To(Equal(http.StatusUnauthorized))
+		Expect(loginFrom(attacker, "guess")).To(Equal(http.StatusUnauthorized))
+		Expect(loginFrom(victim, password)).To(Equal(http.StatusTooManyRequests))
+	})
+})
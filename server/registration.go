@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/auth"
+)
+
+// RegistrationMode is who may register accounts on the server
+type RegistrationMode string
+
+const (
+	// anyone may register
+	RegistrationOpen RegistrationMode = "open"
+	// only those given an invite by an administrator may register
+	RegistrationInvite RegistrationMode = "invite"
+	// anyone may register, but their accounts cannot be used until an
+	// administrator approves them, unless they were given an invite
+	RegistrationApproval RegistrationMode = "approval"
+	// no one may register
+	RegistrationClosed RegistrationMode = "closed"
+)
+
+const maxUsernameLength = 30
+
+// usernamePattern matches the usernames users may register: letters, digits
+// and underscores, separated by single dots or dashes, as mentions expect
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+(?:[.-][A-Za-z0-9_]+)*$`)
+
+// reservedUsernames may not be registered, because they are the paths of the
+// server's own endpoints, or could be taken for the server speaking
+var reservedUsernames = []string{
+	".well-known", "admin", "email", "login", "logout", "oauth", "password", "search", "tags", "themes", "token",
+	"abuse", "administrator", "api", "hostmaster", "moderator", "no-reply", "noreply", "postmaster",
+	"pubblr", "root", "security", "support", "system", "webmaster", "www",
+}
+
+type RegistrationConfig struct {
+	// defaults to RegistrationOpen
+	Mode RegistrationMode `json:"mode"`
+	// usernames reserved in addition to those every server reserves
+	ReservedUsernames []string `json:"reservedUsernames"`
+	// defaults to auth.DefaultMinPasswordLength
+	MinPasswordLength int `json:"minPasswordLength"`
+}
+
+func (config RegistrationConfig) withDefaults() (RegistrationConfig, error) {
+	switch config.Mode {
+	case "":
+		config.Mode = RegistrationOpen
+	case RegistrationOpen, RegistrationInvite, RegistrationApproval, RegistrationClosed:
+	default:
+		return config, fmt.Errorf("unknown registration mode %q", config.Mode)
+	}
+	if config.MinPasswordLength == 0 {
+		config.MinPasswordLength = auth.DefaultMinPasswordLength
+	}
+	return config, nil
+}
+
+// RegistrationRequest carries what a new user gives besides their username and
+// password, as required by the server's registration mode
+type RegistrationRequest struct {
+	Invite string `json:"invite,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (router *PubblrRouter) reservedUsername(username string) bool {
+	for _, list := range [][]string{reservedUsernames, router.registration.ReservedUsernames} {
+		for _, reserved := range list {
+			if strings.EqualFold(username, reserved) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkUsername checks that a name may be given to a new actor
+func checkUsername(username string) apiutil.Status {
+	if len(username) > maxUsernameLength || !usernamePattern.MatchString(username) {
+		return apiutil.NewCodedStatus(http.StatusBadRequest, "invalid_username", fmt.Sprintf(
+			"Usernames must be at most %d letters, digits and underscores, which may be separated by dots or dashes",
+			maxUsernameLength))
+	}
+	return nil
+}
+
+// useInvite checks an invite code and counts its use
+func useInvite(ctx context.Context, db database.Queries, code string) (*database.Invite, apiutil.Status) {
+	invite, err := db.GetInvite(ctx, auth.HashToken(code))
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, apiutil.NewStatus(http.StatusForbidden, "Invalid invite")
+	} else if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	if !invite.Expires.IsZero() && time.Now().After(invite.Expires) {
+		return nil, apiutil.NewStatus(http.StatusForbidden, "Invite has expired")
+	}
+	if invite.MaxUses > 0 && invite.Uses >= invite.MaxUses {
+		return nil, apiutil.NewStatus(http.StatusForbidden, "Invite has been used up")
+	}
+
+	invite.Uses++
+	err = db.UpdateInvite(ctx, *invite)
+	if err != nil {
+		return nil, apiutil.Statusf(http.StatusInternalServerError, "failed to use invite: %w", err)
+	}
+	return invite, nil
+}
+
+// register checks that a user may register with the given username and
+// password under the server's registration mode, using their invite if they
+// give one, and returns the registration to record once their account is
+// created.  The accounts of configured administrators may always be
+// registered, so that a server's first administrators can invite others.
+func (router *PubblrRouter) register(ctx context.Context, db database.Queries, username, password string, req RegistrationRequest) (*database.Registration, apiutil.Status) {
+	if status := checkUsername(username); status != nil {
+		return nil, status
+	}
+
+	err := auth.CheckPasswordStrength(password, router.registration.MinPasswordLength, username)
+	if err != nil {
+		return nil, apiutil.NewFieldStatus(http.StatusBadRequest, "password", err.Error())
+	}
+
+	registration := &database.Registration{
+		Username: username,
+		Status:   database.AccountActive,
+		Reason:   req.Reason,
+		Created:  time.Now(),
+	}
+	if in(username, router.admins) {
+		return registration, nil
+	}
+
+	if router.reservedUsername(username) {
+		return nil, apiutil.NewCodedStatus(http.StatusBadRequest, "reserved_username", "Username is reserved")
+	}
+
+	mode := router.registration.Mode
+	if mode == RegistrationClosed {
+		return nil, apiutil.NewStatus(http.StatusForbidden, "Registration is closed")
+	}
+
+	if req.Invite != "" && mode != RegistrationOpen {
+		invite, status := useInvite(ctx, db, req.Invite)
+		if status != nil {
+			return nil, status
+		}
+		registration.Invite = invite.Hash
+		return registration, nil
+	}
+
+	switch mode {
+	case RegistrationInvite:
+		return nil, apiutil.NewStatus(http.StatusForbidden, "An invite is required to register")
+	case RegistrationApproval:
+		registration.Status = database.AccountPending
+	}
+	return registration, nil
+}
+
+// checkAccountStatus refuses logins to accounts which may not yet be used
+func checkAccountStatus(ctx context.Context, db database.Queries, username string) apiutil.Status {
+	registration, err := db.GetRegistration(ctx, username)
+	if err != nil {
+		return apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	switch registration.Status {
+	case database.AccountPending:
+		return apiutil.NewCodedStatus(http.StatusForbidden, "account_pending", "Your account is awaiting approval")
+	case database.AccountRejected:
+		return apiutil.NewCodedStatus(http.StatusForbidden, "account_rejected", "Your registration was not approved")
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+// Package render renders blogs as HTML pages, for readers visiting them in
+// browsers rather than through ActivityPub clients
+package render
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"time"
+
+	"github.com/brandonsides/pubblr/util/markup"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+//go:embed themes/*.css
+var themeFiles embed.FS
+
+// pages holds a template for each kind of page, each defining the "content"
+// of the shared layout
+var pages = map[string]*template.Template{
+	"blog":      parsePage("blog"),
+	"permalink": parsePage("permalink"),
+	"tag":       parsePage("tag"),
+}
+
+// funcs are the functions pages may call
+var funcs = template.FuncMap{
+	// content shows the HTML content of a post, keeping only the markup which
+	// is safe to show
+	"content": func(s string) template.HTML {
+		return template.HTML(markup.Sanitize(s))
+	},
+}
+
+func parsePage(name string) *template.Template {
+	return template.Must(template.New(name).Funcs(funcs).ParseFS(templateFiles, "templates/layout.html", "templates/"+name+".html"))
+}
+
+// Theme is a stylesheet with which blogs may be shown
+type Theme struct {
+	Name        string
+	Description string
+	CSS         []byte
+}
+
+// DefaultTheme is the theme of blogs whose actors have not chosen one
+const DefaultTheme = "plain"
+
+var themes = []*Theme{
+	{Name: "plain", Description: "Dark text on white, in the reader's system font"},
+	{Name: "dark", Description: "Light text on a dark background"},
+	{Name: "journal", Description: "A serif typeface on warm paper, for long reads"},
+}
+
+func init() {
+	for _, theme := range themes {
+		css, err := themeFiles.ReadFile("themes/" + theme.Name + ".css")
+		if err != nil {
+			panic(err)
+		}
+		theme.CSS = css
+	}
+}
+
+// Themes returns the themes with which blogs may be shown
+func Themes() []Theme {
+	ret := make([]Theme, 0, len(themes))
+	for _, theme := range themes {
+		ret = append(ret, *theme)
+	}
+	return ret
+}
+
+// LookupTheme returns the theme of the given name, or the default theme if
+// the name is empty
+func LookupTheme(name string) (Theme, bool) {
+	if name == "" {
+		name = DefaultTheme
+	}
+	for _, theme := range themes {
+		if theme.Name == name {
+			return *theme, true
+		}
+	}
+	return Theme{}, false
+}
+
+// Page is what every page has: its title, the URLs of the stylesheets with
+// which it is shown, in the order they apply, the URL of the ActivityStreams
+// entity it shows, if any, and the feeds following it
+type Page struct {
+	Title       string
+	Stylesheets []string
+	Alternate   string
+	Feeds       []Feed
+}
+
+// Feed links a page to a feed of its posts, so that feed readers given the
+// page's URL can find it
+type Feed struct {
+	Title string
+	// the media type of the feed, such as "application/atom+xml"
+	Type string
+	Href string
+}
+
+// Profile introduces the actor whose blog a page belongs to
+type Profile struct {
+	Id       string
+	Name     string
+	Username string
+	Summary  string
+	Icon     string
+}
+
+type Author struct {
+	Id   string
+	Name string
+}
+
+type Tag struct {
+	Name string
+	Href string
+}
+
+// Post is an object shown on a page.  Its content is HTML, of which only the
+// markup which is safe to show is kept.
+type Post struct {
+	Id        string
+	Name      string
+	Summary   string
+	Content   string
+	Images    []string
+	Authors   []Author
+	Published *time.Time
+	Tags      []Tag
+}
+
+// Blog is a page of an actor's posts, newest first, linking to the next page
+// of older posts if there may be more
+type Blog struct {
+	Page
+	Profile Profile
+	Posts   []Post
+	Older   string
+}
+
+// Permalink is the page of a single post
+type Permalink struct {
+	Page
+	Profile Profile
+	Post    Post
+}
+
+// TagPage is a page of posts bearing a hashtag, newest first
+type TagPage struct {
+	Page
+	Tag   string
+	Posts []Post
+	Older string
+}
+
+func render(name string, view interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := pages[name].ExecuteTemplate(&buf, "layout", view)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func RenderBlog(blog *Blog) ([]byte, error) {
+	return render("blog", blog)
+}
+
+func RenderPermalink(permalink *Permalink) ([]byte, error) {
+	return render("permalink", permalink)
+}
+
+func RenderTag(tag *TagPage) ([]byte, error) {
+	return render("tag", tag)
+}
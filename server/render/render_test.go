@@ -0,0 +1,77 @@
+package render_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server/render"
+)
+
+var _ = Describe("Themes", func() {
+	It("should look up themes by name, and the default theme by no name", func() {
+		theme, ok := render.LookupTheme("")
+		Expect(ok).To(BeTrue())
+		Expect(theme.Name).To(Equal(render.DefaultTheme))
+
+		for _, theme := range render.Themes() {
+			found, ok := render.LookupTheme(theme.Name)
+			Expect(ok).To(BeTrue())
+			Expect(found.CSS).ToNot(BeEmpty())
+		}
+
+		_, ok = render.LookupTheme("nope")
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("Pages", func() {
+	published := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	post := render.Post{
+		Id:        "http://example.org/alice/note/0",
+		Content:   `<p><script>alert(1)</script>hi <a href="http://example.org/bob" class="mention">@bob</a> <img src=x onerror="alert(1)"><em>#go</em></p>`,
+		Published: &published,
+		Authors:   []render.Author{{Id: "http://example.org/alice", Name: "Alice"}},
+		Tags:      []render.Tag{{Name: "#go", Href: "http://example.org/tags/go"}},
+		Images:    []string{"javascript:alert(1)"},
+	}
+	page := render.Page{
+		Title:       "Alice",
+		Stylesheets: []string{"http://example.org/themes/plain.css", "http://example.org/alice/style.css"},
+		Alternate:   "http://example.org/alice",
+	}
+
+	It("should render a blog with its stylesheets, posts and a link to older posts", func() {
+		html, err := render.RenderBlog(&render.Blog{
+			Page:    page,
+			Profile: render.Profile{Id: "http://example.org/alice", Name: "Alice", Username: "alice"},
+			Posts:   []render.Post{post},
+			Older:   "http://example.org/alice?max_id=0",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(html)).To(ContainSubstring(`<link rel="stylesheet" href="http://example.org/themes/plain.css">`))
+		Expect(string(html)).To(ContainSubstring(`<link rel="stylesheet" href="http://example.org/alice/style.css">`))
+		Expect(string(html)).To(ContainSubstring(`@alice`))
+		Expect(string(html)).To(ContainSubstring(`href="http://example.org/tags/go"`))
+		Expect(string(html)).To(ContainSubstring(`March 1, 2024`))
+		Expect(string(html)).To(ContainSubstring(`href="http://example.org/alice?max_id=0"`))
+	})
+
+	It("should show the safe markup of content and refuse unsafe URLs", func() {
+		html, err := render.RenderPermalink(&render.Permalink{Page: page, Post: post})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(html)).To(ContainSubstring(`<p>hi <a href="http://example.org/bob" class="mention">@bob</a> <em>#go</em></p>`))
+		Expect(string(html)).ToNot(ContainSubstring("<script>"))
+		Expect(string(html)).ToNot(ContainSubstring("alert(1)"))
+		Expect(string(html)).ToNot(ContainSubstring("onerror="))
+		Expect(string(html)).ToNot(ContainSubstring("javascript:"))
+	})
+
+	It("should say when there are no posts to show", func() {
+		html, err := render.RenderTag(&render.TagPage{Page: page, Tag: "go"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(html)).To(ContainSubstring("#go"))
+		Expect(string(html)).To(ContainSubstring("no posts to show"))
+	})
+})
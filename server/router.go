@@ -1,36 +1,35 @@
 package server
 
 import (
+	"context"
+	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
-	"github.com/brandonsides/pubblr/activitystreams"
 	"github.com/brandonsides/pubblr/database"
 	"github.com/brandonsides/pubblr/logging"
+	"github.com/brandonsides/pubblr/mailer"
+	"github.com/brandonsides/pubblr/search"
 	"github.com/brandonsides/pubblr/server/apiutil"
 	"github.com/brandonsides/pubblr/server/auth"
+	"github.com/brandonsides/pubblr/server/ratelimit"
 	"github.com/go-chi/chi"
 )
 
+// DB is the storage backend used by the router.  Operations made directly
+// against a DB each take effect on their own; those that must succeed or
+// fail together are made through a transaction opened with Begin.
 type DB interface {
-	CreateObject(obj activitystreams.ObjectIface, user string, baseIdUrl url.URL) (activitystreams.ObjectIface, error)
-	CreateInboxItem(item activitystreams.ActivityIface, user string) (activitystreams.ActivityIface, error)
-	CreateOutboxItem(act activitystreams.ActivityIface, user string, baseIdUrl url.URL) (activitystreams.ActivityIface, error)
-	GetOutboxItem(user, id string) (activitystreams.ActivityIface, error)
-	GetInboxPage(user string, page, pageSize int) ([]activitystreams.ActivityIface, error)
-	GetInboxCount(user string) (int, error)
-	GetInboxItem(user, id string) (activitystreams.ActivityIface, error)
-	GetOutboxPage(user string, page, pageSize int) ([]activitystreams.ActivityIface, error)
-	GetOutboxCount(user string) (int, error)
-	GetObject(user, typ, id string) (activitystreams.ObjectIface, error)
-	CreateUser(user activitystreams.ActorIface, username, password string, baseIdUrl url.URL) (activitystreams.ActorIface, error)
-	GetUser(username string) (activitystreams.ActorIface, error)
-	CheckPassword(username, password string) error
+	database.Queries
+	Begin(ctx context.Context) (database.Tx, error)
 }
 
 type Auth interface {
-	GenerateToken(username string) (string, error)
-	VerifyToken(token string) (string, error)
+	GenerateToken(claims auth.Claims) (string, error)
+	VerifyToken(token string) (*auth.Claims, error)
+	GenerateRefreshToken() (string, time.Time, error)
+	JWKS() auth.JWKSet
 }
 
 type PubblrRouter struct {
@@ -38,8 +37,21 @@ type PubblrRouter struct {
 	Database DB
 	Logger   apiutil.Logger
 	Auth     Auth
-	baseUrl  url.URL
-	pageSize int
+	Search   *search.Index
+	Mailer   mailer.Mailer
+	// used for requests to other servers; refuses to connect to addresses
+	// which are not public
+	HTTPClient   *http.Client
+	baseUrl      url.URL
+	pageSize     int
+	admins       []string
+	registration RegistrationConfig
+	limits       *rateLimits
+	// tried in turn to identify the user making each request
+	authenticators Authenticators
+	delivery       DeliveryConfig
+	// signalled whenever new delivery jobs may be pending
+	deliveries chan struct{}
 }
 
 type PubblrRouterConfig struct {
@@ -47,9 +59,40 @@ type PubblrRouterConfig struct {
 	Database  database.PubblrDatabaseConfig `json:"database"`
 	Logger    logging.PubblrLoggerConfig    `json:"logger"`
 	Auth      auth.AuthConfig               `json:"auth"`
+	Mail      mailer.MailerConfig           `json:"mail"`
 	Host      string                        `json:"host"`
 	Port      int                           `json:"port"`
 	PageSize  int                           `json:"pageSize"`
+	// usernames of the accounts granted the admin role when they are created
+	Admins       []string           `json:"admins"`
+	Registration RegistrationConfig `json:"registration"`
+	// limits on logins and other requests which check credentials
+	RateLimit ratelimit.Config `json:"rateLimit"`
+	Delivery  DeliveryConfig   `json:"delivery"`
+}
+
+// Authenticate identifies the principal making a request by the first of the
+// router's authenticators whose credentials it carries, along with the side
+// blogs local users may act as.  Groups are not among them: members post
+// through a group only as themselves, which PostObject checks.
+func (router *PubblrRouter) Authenticate(r *http.Request) (*Principal, error) {
+	principal, err := router.authenticators.Authenticate(r)
+	if err != nil || principal.Username == "" {
+		return principal, err
+	}
+
+	blogs, err := router.Database.GetBlogs(r.Context(), principal.Username)
+	if err != nil {
+		return nil, err
+	}
+	principal.Actors = append([]string{principal.Username}, blogs...)
+	return principal, nil
+}
+
+// origin returns the scheme and host of the server, as browsers send in the
+// Origin header of requests made from its pages
+func (router *PubblrRouter) origin() string {
+	return router.baseUrl.Scheme + "://" + router.baseUrl.Host
 }
 
 func NewPubblrRouter(cfg PubblrRouterConfig, baseRouter chi.Router) (chi.Router, error) {
@@ -57,88 +100,54 @@ func NewPubblrRouter(cfg PubblrRouterConfig, baseRouter chi.Router) (chi.Router,
 		cfg.PageSize = 50
 	}
 
+	baseUrl := url.URL{
+		Scheme: "http",
+		Host:   cfg.Host + ":" + strconv.Itoa(cfg.Port),
+		Path:   cfg.MountPath,
+	}
+
+	if cfg.Auth.Issuer == "" {
+		cfg.Auth.Issuer = baseUrl.String()
+	}
 	auth, err := auth.NewAuth(cfg.Auth)
 	if err != nil {
 		panic(err)
 	}
+	mail, err := mailer.NewMailer(cfg.Mail)
+	if err != nil {
+		panic(err)
+	}
+	registration, err := cfg.Registration.withDefaults()
+	if err != nil {
+		panic(err)
+	}
 
 	router := PubblrRouter{
-		Router:   chi.NewRouter(),
-		Database: database.NewPubblrDatabase(cfg.Database),
-		Logger:   logging.NewStandardPubblrLogger(cfg.Logger),
-		Auth:     auth,
-		baseUrl: url.URL{
-			Scheme: "http",
-			Host:   cfg.Host + ":" + strconv.Itoa(cfg.Port),
-			Path:   cfg.MountPath,
-		},
-		pageSize: cfg.PageSize,
+		Router:       chi.NewRouter(),
+		Database:     database.NewPubblrDatabase(cfg.Database),
+		Logger:       logging.NewStandardPubblrLogger(cfg.Logger),
+		Auth:         auth,
+		Search:       search.NewIndex(),
+		Mailer:       mail,
+		HTTPClient:   newPublicHTTPClient(10 * time.Second),
+		baseUrl:      baseUrl,
+		pageSize:     cfg.PageSize,
+		admins:       cfg.Admins,
+		registration: registration,
+		limits:       newRateLimits(cfg.RateLimit),
+		delivery:     cfg.Delivery.withDefaults(),
+		deliveries:   make(chan struct{}, 1),
 	}
 
-	router.Use(
-		SetContentType("application/ld+json; profile=\"https://www.w3.org/ns/activitystreams\""),
-	)
-
-	// AUTH
-	router.Method("POST", "/login", apiutil.LogEndpoint(router.Login, router.Logger))
-
-	// OBJECTS
-	router.Method("GET", "/{actor}/{type}/{id}",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetObject), router.Logger))
-
-	// ACTORS
-	router.Method("GET", "/{actor}", apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetUser), router.Logger))
-	router.Method("POST", "/{actor}", apiutil.LogEndpoint(router.PostUser, router.Logger))
-
-	// INBOX
-	router.Method("POST", "/{actor}/inbox",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.PostToInbox), router.Logger))
-	router.Method("GET", "/{actor}/inbox",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetInbox), router.Logger))
-	router.Method("GET", "/{actor}/inbox/page/{page}",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetInboxPage), router.Logger))
-	router.Method("GET", "/{actor}/inbox/{id}",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetInboxItem), router.Logger))
-
-	// OUTBOX
-	router.Method("POST", "/{actor}/outbox",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.PostObject), router.Logger))
-	router.Method("GET", "/{actor}/outbox",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetOutbox), router.Logger))
-	router.Method("GET", "/{actor}/outbox/page/{page}",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetOutboxPage), router.Logger))
-	router.Method("GET", "/{actor}/outbox/{id}",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetOutboxActivity), router.Logger))
-
-	// STREAMS
-	router.Method("GET", "/{actor}/streams",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetStreams), router.Logger))
-	router.Method("GET", "/{actor}/streams/{id}",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetStream), router.Logger))
-	router.Method("GET", "/{actor}/streams/{id}/page/{page}",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetStreamPage), router.Logger))
-	router.Method("GET", "/{actor}/streams/{id}/followers",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetStreamFollowers), router.Logger))
-	router.Method("GET", "/{actor}/streams/{id}/followers/page/{page}",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetStreamFollowersPage), router.Logger))
-
-	// FOLLOWING
-	router.Method("GET", "/{actor}/following",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetFollowing), router.Logger))
-	router.Method("GET", "/{actor}/following/page/{page}",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetFollowingPage), router.Logger))
-
-	// FOLLOWERS
-	router.Method("GET", "/{actor}/followers",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetFollowers), router.Logger))
-	router.Method("GET", "/{actor}/followers/page/{page}",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetFollowersPage), router.Logger))
-
-	// LIKED
-	router.Method("GET", "/{actor}/liked",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetLiked), router.Logger))
-	router.Method("GET", "/{actor}/liked/page/{page}",
-		apiutil.LogEndpoint(AuthMiddleware(router.Auth, router.GetLikedPage), router.Logger))
+	router.authenticators = Authenticators{
+		BearerAuthenticator{Verifier: &router},
+		SignatureAuthenticator{Keys: &router},
+		CookieAuthenticator{Verifier: &router, Origin: router.origin()},
+	}
+
+	go router.runDeliveries(context.Background())
+
+	router.routes().Register(router.Router, apiutil.Logged(router.Logger))
 
 	if baseRouter != nil {
 		baseRouter.Mount(cfg.MountPath, router)
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"github.com/brandonsides/pubblr/server/apiutil"
+)
+
+// Cache-Control directives routes declare for their responses
+const (
+	// for responses carrying credentials, which must not be kept
+	noStore = "no-store"
+	// for the server's own resources, which change only with the server
+	cacheStatic = "public, max-age=86400"
+	// for public resources which change as actors post
+	cachePublic = "public, max-age=300"
+)
+
+// routes declares every route the router serves, and what each requires of
+// requests: who may make them, how often, and how long their responses may be
+// kept.  Every route is also logged, as the router registers them.
+func (router *PubblrRouter) routes() apiutil.Routes {
+	var (
+		identify    = Identify(router)
+		rateLimited = RateLimited(router)
+	)
+
+	routes := apiutil.Routes{
+		// AUTH
+		apiutil.NewRoute("POST", "/login", router.Login, apiutil.CacheControl(noStore), rateLimited),
+		apiutil.NewRoute("POST", "/token/refresh", router.RefreshToken, apiutil.CacheControl(noStore), rateLimited),
+		apiutil.NewRoute("POST", "/logout", router.Logout, identify, Authorized(LoggedIn)),
+		apiutil.NewRoute("POST", "/logout/all", router.LogoutAll, identify, Authorized(FirstParty)),
+
+		// PASSWORD RESET AND EMAIL VERIFICATION
+		apiutil.NewRoute("POST", "/password/reset", router.RequestPasswordReset, rateLimited),
+		apiutil.NewRoute("POST", "/password/reset/confirm", router.CompletePasswordReset, rateLimited),
+		apiutil.NewRoute("POST", "/email/verify", router.VerifyEmail, rateLimited),
+		apiutil.NewRoute("GET", "/{actor}/email", router.GetEmail, identify, Authorized(FirstParty, Owner)),
+		apiutil.NewRoute("PUT", "/{actor}/email", router.PutEmail, identify, Authorized(FirstParty, Owner)),
+
+		// OAUTH
+		apiutil.NewRoute("GET", "/.well-known/oauth-authorization-server", router.GetOAuthMetadata),
+		apiutil.NewRoute("GET", "/.well-known/jwks.json", router.GetJWKS),
+		apiutil.NewRoute("POST", "/oauth/register", router.RegisterClient),
+		apiutil.NewRoute("GET", "/oauth/authorize", router.GetAuthorization, identify, Authorized(FirstParty)),
+		apiutil.NewRoute("POST", "/oauth/authorize", router.PostAuthorization, identify, Authorized(FirstParty)),
+		apiutil.NewRoute("POST", "/oauth/token", router.OAuthToken, rateLimited),
+
+		// ADMIN
+		apiutil.NewRoute("GET", "/admin/users/{username}/roles", router.GetRoles, identify, Authorized(router.Admin)),
+		apiutil.NewRoute("PUT", "/admin/users/{username}/roles", router.PutRoles, identify, Authorized(router.Admin)),
+		apiutil.NewRoute("GET", "/admin/invites", router.GetInvites, identify, Authorized(router.Admin)),
+		apiutil.NewRoute("POST", "/admin/invites", router.PostInvite, identify, Authorized(router.Admin)),
+		apiutil.NewRoute("DELETE", "/admin/invites/{id}", router.DeleteInvite, identify, Authorized(router.Admin)),
+		apiutil.NewRoute("GET", "/admin/registrations", router.GetRegistrations, identify, Authorized(router.Admin)),
+		apiutil.NewRoute("POST", "/admin/registrations/{username}/approve", router.ApproveRegistration, identify, Authorized(router.Admin)),
+		apiutil.NewRoute("POST", "/admin/registrations/{username}/reject", router.RejectRegistration, identify, Authorized(router.Admin)),
+		apiutil.NewRoute("GET", "/admin/ratelimit", router.GetRateLimitMetrics, identify, Authorized(router.Admin)),
+
+		// SEARCH
+		apiutil.NewRoute("GET", "/search", ActivityStreams(router.GetSearch, nil), identify, Authorized(Scoped(ScopeRead))),
+
+		// TAGS
+		apiutil.NewRoute("GET", "/tags/{tag}", ActivityStreams(router.GetTag, router.TagPage), identify),
+
+		// THEMES
+		apiutil.NewRoute("GET", "/themes", router.GetThemes, apiutil.CacheControl(cacheStatic)),
+		apiutil.NewRoute("GET", "/themes/{theme}.css", router.GetThemeStylesheet, apiutil.CacheControl(cacheStatic)),
+		apiutil.NewRoute("GET", "/{actor}/style.css", router.GetCustomStylesheet, apiutil.CacheControl(cachePublic)),
+		apiutil.NewRoute("GET", "/{actor}/appearance", router.GetAppearance, identify, Authorized(router.ActingAs, Scoped(ScopeRead))),
+		apiutil.NewRoute("PUT", "/{actor}/appearance", router.PutAppearance, identify, Authorized(FirstParty, router.ActingAs)),
+	}
+
+	// FEEDS
+	for _, format := range feedFormats {
+		file := "/feed." + format.Extension
+		routes = append(routes,
+			apiutil.NewRoute("GET", "/{actor}"+file, router.ActorFeed(format), apiutil.CacheControl(cachePublic)),
+			apiutil.NewRoute("GET", "/{actor}/streams/{id}"+file, router.StreamFeed(format), apiutil.CacheControl(cachePublic)),
+			apiutil.NewRoute("GET", "/tags/{tag}"+file, router.TagFeed(format), apiutil.CacheControl(cachePublic)),
+		)
+	}
+
+	return append(routes,
+		// OBJECTS
+		apiutil.NewRoute("GET", "/{actor}/{type}/{id}", ActivityStreams(Visible(router, router.GetObject), router.ObjectPage),
+			identify, Authorized(PostingAs, Scoped(ScopeRead))),
+
+		// ACTORS
+		apiutil.NewRoute("GET", "/{actor}", ActivityStreams(Visible(router, router.GetUser), router.ActorPage),
+			identify, Authorized(PostingAs, Scoped(ScopeRead))),
+		apiutil.NewRoute("POST", "/{actor}", router.PostUser, apiutil.CacheControl(noStore), rateLimited),
+
+		// ARCHIVES
+		apiutil.NewRoute("GET", "/{actor}/export", router.Export, identify, Authorized(FirstParty, Owner)),
+		apiutil.NewRoute("POST", "/{actor}/import", router.Import, rateLimited, identify, Authorized(FirstParty, Owner)),
+
+		// TWO-FACTOR AUTHENTICATION
+		apiutil.NewRoute("POST", "/login/2fa", router.LoginSecondFactor, apiutil.CacheControl(noStore), rateLimited),
+		apiutil.NewRoute("POST", "/{actor}/2fa", router.EnrollTwoFactor, apiutil.CacheControl(noStore), identify, Authorized(FirstParty, Owner)),
+		apiutil.NewRoute("DELETE", "/{actor}/2fa", router.DisableTwoFactor, identify, Authorized(FirstParty, Owner)),
+		apiutil.NewRoute("POST", "/{actor}/2fa/confirm", router.ConfirmTwoFactor, apiutil.CacheControl(noStore), identify, Authorized(FirstParty, Owner)),
+		apiutil.NewRoute("POST", "/{actor}/2fa/recovery-codes", router.RegenerateRecoveryCodes, apiutil.CacheControl(noStore), identify, Authorized(FirstParty, Owner)),
+
+		// SESSIONS
+		apiutil.NewRoute("GET", "/{actor}/sessions", router.GetSessions, identify, Authorized(FirstParty, Owner)),
+		apiutil.NewRoute("DELETE", "/{actor}/sessions/{id}", router.DeleteSession, identify, Authorized(FirstParty, Owner)),
+
+		// SIDE BLOGS
+		apiutil.NewRoute("GET", "/{actor}/blogs", router.GetBlogs, identify, Authorized(Owner, Scoped(ScopeRead))),
+		apiutil.NewRoute("POST", "/{actor}/blogs", router.PostBlog, identify, Authorized(FirstParty, Owner)),
+
+		// GROUPS
+		apiutil.NewRoute("GET", "/{actor}/groups", router.GetGroups, identify, Authorized(Owner, Scoped(ScopeRead))),
+		apiutil.NewRoute("POST", "/{actor}/groups", router.PostGroup, identify, Authorized(FirstParty, Owner)),
+		apiutil.NewRoute("GET", "/{actor}/members", router.GetGroupMembers, identify, Authorized(LoggedIn, Scoped(ScopeRead))),
+		apiutil.NewRoute("PUT", "/{actor}/members/{username}", router.PutGroupMember, identify, Authorized(FirstParty)),
+		apiutil.NewRoute("DELETE", "/{actor}/members/{username}", router.DeleteGroupMember, identify, Authorized(FirstParty)),
+
+		// PERSONAL ACCESS TOKENS
+		apiutil.NewRoute("GET", "/{actor}/tokens", router.GetPersonalAccessTokens, identify, Authorized(FirstParty, Owner)),
+		apiutil.NewRoute("POST", "/{actor}/tokens", router.PostPersonalAccessToken, apiutil.CacheControl(noStore), identify, Authorized(FirstParty, Owner)),
+		apiutil.NewRoute("DELETE", "/{actor}/tokens/{id}", router.DeletePersonalAccessToken, identify, Authorized(FirstParty, Owner)),
+
+		// INBOX
+		apiutil.NewRoute("POST", "/{actor}/inbox", Visible(router, router.PostToInbox), identify, Authorized(PostingAs)),
+		apiutil.NewRoute("GET", "/{actor}/inbox", ActivityStreams(Visible(router, router.GetInbox), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+		apiutil.NewRoute("GET", "/{actor}/inbox/{id}", ActivityStreams(Visible(router, router.GetInboxItem), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+
+		// OUTBOX
+		apiutil.NewRoute("POST", "/{actor}/outbox", ActivityStreams(Visible(router, router.PostObject), nil), identify, Authorized(router.PostingThrough, LoggedIn)),
+		apiutil.NewRoute("GET", "/{actor}/outbox", ActivityStreams(Visible(router, router.GetOutbox), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+		apiutil.NewRoute("GET", "/{actor}/outbox/{id}", ActivityStreams(Visible(router, router.GetOutboxActivity), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+
+		// STREAMS
+		apiutil.NewRoute("GET", "/{actor}/streams", ActivityStreams(Visible(router, router.GetStreams), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+		apiutil.NewRoute("GET", "/{actor}/streams/{id}", ActivityStreams(Visible(router, router.GetStream), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+		apiutil.NewRoute("GET", "/{actor}/streams/{id}/page/{page}", ActivityStreams(Visible(router, router.GetStreamPage), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+		apiutil.NewRoute("GET", "/{actor}/streams/{id}/followers", ActivityStreams(Visible(router, router.GetStreamFollowers), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+		apiutil.NewRoute("GET", "/{actor}/streams/{id}/followers/page/{page}", ActivityStreams(Visible(router, router.GetStreamFollowersPage), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+
+		// FOLLOWING
+		apiutil.NewRoute("GET", "/{actor}/following", ActivityStreams(Visible(router, router.GetFollowing), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+		apiutil.NewRoute("GET", "/{actor}/following/page/{page}", ActivityStreams(Visible(router, router.GetFollowingPage), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+
+		// FOLLOWERS
+		apiutil.NewRoute("GET", "/{actor}/followers", ActivityStreams(Visible(router, router.GetFollowers), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+		apiutil.NewRoute("GET", "/{actor}/followers/page/{page}", ActivityStreams(Visible(router, router.GetFollowersPage), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+
+		// LIKED
+		apiutil.NewRoute("GET", "/{actor}/liked", ActivityStreams(Visible(router, router.GetLiked), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+		apiutil.NewRoute("GET", "/{actor}/liked/page/{page}", ActivityStreams(Visible(router, router.GetLikedPage), nil), identify, Authorized(PostingAs, Scoped(ScopeRead))),
+	)
+}
@@ -0,0 +1,127 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+	"github.com/brandonsides/pubblr/server/ratelimit"
+)
+
+var _ = Describe("Routes", func() {
+	var router server.PubblrRouter
+	var alice, bob string
+
+	// scopedToken mints a personal access token for alice with the given scope
+	scopedToken := func(scope string) string {
+		w := do(router, "POST", "/alice/tokens", `{"name":"test","scope":`+jsonString(scope)+`}`, alice)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		var resp struct {
+			Token string `json:"token"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed())
+		return resp.Token
+	}
+
+	BeforeEach(func() {
+		router = newRouter(func(config *server.PubblrRouterConfig) {
+			config.Admins = []string{"alice"}
+		})
+		alice = register(router, "alice")
+		bob = register(router, "bob")
+	})
+
+	Describe("policies", func() {
+		It("should let users post only as actors they may act as", func() {
+			Expect(post(router, "alice", alice, "mine").Code).To(Equal(http.StatusCreated))
+			Expect(post(router, "alice", bob, "theirs").Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("should require that users be logged in", func() {
+			Expect(do(router, "POST", "/logout", "", "").Code).To(Equal(http.StatusUnauthorized))
+			Expect(do(router, "POST", "/logout", "", alice).Code).To(Equal(http.StatusNoContent))
+		})
+
+		It("should refuse personal access tokens where login tokens are required", func() {
+			Expect(do(router, "PUT", "/alice/appearance", `{"css":"body{}"}`, personalAccessToken(router, "alice", alice)).Code).To(Equal(http.StatusForbidden))
+			Expect(do(router, "PUT", "/alice/appearance", `{"css":"body{}"}`, alice).Code).To(Equal(http.StatusOK))
+		})
+
+		It("should let only the owner of an account manage it", func() {
+			Expect(do(router, "GET", "/alice/groups", "", bob).Code).To(Equal(http.StatusForbidden))
+			Expect(do(router, "GET", "/alice/groups", "", alice).Code).To(Equal(http.StatusOK))
+		})
+
+		It("should let only the owner of an account export it or manage its sessions", func() {
+			pat := personalAccessToken(router, "alice", alice)
+			for _, route := range []struct{ method, path string }{
+				{"GET", "/alice/export"},
+				{"GET", "/alice/sessions"},
+				{"DELETE", "/alice/sessions/missing"},
+			} {
+				Expect(do(router, route.method, route.path, "", "").Code).To(Equal(http.StatusUnauthorized), route.path)
+				Expect(do(router, route.method, route.path, "", bob).Code).To(Equal(http.StatusForbidden), route.path)
+				Expect(do(router, route.method, route.path, "", pat).Code).To(Equal(http.StatusForbidden), route.path)
+			}
+			Expect(do(router, "GET", "/alice/export", "", alice).Code).To(Equal(http.StatusOK))
+			Expect(do(router, "GET", "/alice/sessions", "", alice).Code).To(Equal(http.StatusOK))
+			Expect(do(router, "DELETE", "/alice/sessions/missing", "", alice).Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should let only users who may act as an actor manage it", func() {
+			Expect(do(router, "GET", "/alice/appearance", "", "").Code).To(Equal(http.StatusUnauthorized))
+			Expect(do(router, "GET", "/alice/appearance", "", bob).Code).To(Equal(http.StatusForbidden))
+			Expect(do(router, "GET", "/alice/appearance", "", alice).Code).To(Equal(http.StatusOK))
+		})
+
+		It("should require the scope a route reads with", func() {
+			w := do(router, "GET", "/alice/appearance", "", scopedToken("write:posts"))
+			Expect(w.Code).To(Equal(http.StatusForbidden))
+			Expect(w.Body.String()).To(ContainSubstring(`"insufficient_scope"`))
+			Expect(do(router, "GET", "/alice/appearance", "", scopedToken("read")).Code).To(Equal(http.StatusOK))
+		})
+
+		It("should require the scope an activity is posted with", func() {
+			w := post(router, "alice", scopedToken("read"), "read only")
+			Expect(w.Code).To(Equal(http.StatusForbidden))
+			Expect(w.Body.String()).To(ContainSubstring(`"insufficient_scope"`))
+			Expect(post(router, "alice", scopedToken("read write:posts"), "writable").Code).To(Equal(http.StatusCreated))
+		})
+
+		It("should let only administrators administer the server", func() {
+			Expect(do(router, "GET", "/admin/invites", "", "").Code).To(Equal(http.StatusUnauthorized))
+			Expect(do(router, "GET", "/admin/invites", "", bob).Code).To(Equal(http.StatusForbidden))
+			Expect(do(router, "GET", "/admin/invites", "", alice).Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Describe("headers", func() {
+		It("should tell clients how long they may cache responses", func() {
+			Expect(do(router, "GET", "/themes", "", "").Header().Get("Cache-Control")).To(Equal("public, max-age=86400"))
+		})
+
+		It("should keep login responses out of caches and tell limited clients when to retry", func() {
+			router = newRouter(func(config *server.PubblrRouterConfig) {
+				config.RateLimit = ratelimit.Config{
+					PerIP:       ratelimit.BucketConfig{Rate: 0.001, Burst: 2},
+					PerUsername: ratelimit.BucketConfig{Rate: 1000, Burst: 1000},
+				}
+			})
+			register(router, "alice")
+			body := `{"username":"alice","password":` + jsonString(password) + `}`
+
+			w := do(router, "POST", "/login", body, "")
+			Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+			Expect(w.Header().Get("Cache-Control")).To(Equal("no-store"))
+			Expect(w.Header().Get("Retry-After")).To(BeEmpty())
+
+			w = do(router, "POST", "/login", body, "")
+			Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(w.Header().Get("Retry-After")).ToNot(BeEmpty())
+		})
+	})
+})
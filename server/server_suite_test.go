@@ -0,0 +1,132 @@
+package server_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+	"github.com/brandonsides/pubblr/server/auth"
+)
+
+func TestServer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Server Suite")
+}
+
+// the base URL of the routers under test
+const baseUrl = "http://localhost:8080"
+
+// the password of every account created in tests
+const password = "correct-horse-9"
+
+// the key with which the routers under test sign tokens
+var keyLocation string
+
+var _ = BeforeSuite(func() {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	keyLocation = filepath.Join(GinkgoT().TempDir(), "auth.pem")
+	Expect(os.WriteFile(keyLocation, pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: b,
+	}), 0600)).To(Succeed())
+})
+
+// newRouter returns a router serving at baseUrl, configured by the given
+// functions
+func newRouter(configure ...func(*server.PubblrRouterConfig)) server.PubblrRouter {
+	config := server.PubblrRouterConfig{
+		Host: "localhost",
+		Port: 8080,
+		Auth: auth.AuthConfig{AuthKeyLocation: keyLocation},
+	}
+	for _, f := range configure {
+		f(&config)
+	}
+
+	router, err := server.NewPubblrRouter(config, nil)
+	Expect(err).ToNot(HaveOccurred())
+	return router.(server.PubblrRouter)
+}
+
+// do makes a request of a router, authorized by the given access token if it
+// is not empty
+func do(h http.Handler, method, path, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// register creates an account, returning an access token for it
+func register(h http.Handler, username string) string {
+	w := do(h, "POST", "/"+username, `{"password":"`+password+`","actor":{"type":"Person","name":"`+username+`"}}`, "")
+	Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+	var resp struct {
+		JWT string `json:"jwt"`
+	}
+	Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed())
+	return resp.JWT
+}
+
+// post posts a note with the given recipients to the outbox of an actor
+func post(h http.Handler, actor, token, content string, to ...string) *httptest.ResponseRecorder {
+	links := make([]map[string]string, len(to))
+	for i, id := range to {
+		links[i] = map[string]string{"type": "Link", "id": id}
+	}
+	recipients, err := json.Marshal(links)
+	Expect(err).ToNot(HaveOccurred())
+	return do(h, "POST", "/"+actor+"/outbox",
+		`{"@context":"https://www.w3.org/ns/activitystreams","type":"Note","content":`+jsonString(content)+`,"to":`+string(recipients)+`}`, token)
+}
+
+func jsonString(s string) string {
+	b, err := json.Marshal(s)
+	Expect(err).ToNot(HaveOccurred())
+	return string(b)
+}
+
+// totalItems returns the number of items in a collection
+func totalItems(h http.Handler, path, token string) int {
+	w := do(h, "GET", path, "", token)
+	Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+	var collection struct {
+		TotalItems int `json:"totalItems"`
+	}
+	Expect(json.Unmarshal(w.Body.Bytes(), &collection)).To(Succeed())
+	return collection.TotalItems
+}
+
+// personalAccessToken mints a personal access token for an actor, returning
+// the token
+func personalAccessToken(h http.Handler, actor, token string) string {
+	w := do(h, "POST", "/"+actor+"/tokens", `{"name":"test"}`, token)
+	Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed())
+	return resp.Token
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/auth"
+	"github.com/go-chi/chi"
+)
+
+// VerifyToken checks an access token, accepting it only if the session in
+// which it was issued has not since ended, or a personal access token
+func (router *PubblrRouter) VerifyToken(ctx context.Context, token string) (*auth.Claims, error) {
+	if strings.HasPrefix(token, personalAccessTokenPrefix) {
+		return router.verifyPersonalAccessToken(ctx, token)
+	}
+
+	claims, err := router.Auth.VerifyToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = router.Database.GetSession(ctx, claims.Session)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, errors.New("Session has ended")
+	} else if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// startSession begins a new session, for the user, client and scope given by
+// session, and issues its first tokens
+func (router *PubblrRouter) startSession(ctx context.Context, db database.Queries, session database.Session) (*TokenResponse, error) {
+	id, err := auth.NewId()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session.Id = id
+	session.Created = now
+	session.LastUsed = now
+	err = db.CreateSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return router.issueTokens(ctx, db, &session)
+}
+
+// startLoginSession begins a session for a user logging in directly, with
+// every scope the user holds
+func (router *PubblrRouter) startLoginSession(ctx context.Context, db database.Queries, username, userAgent string) (*TokenResponse, error) {
+	roles, err := db.GetRoles(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return router.startSession(ctx, db, database.Session{
+		Username:  username,
+		UserAgent: userAgent,
+		Scope:     userScopes(roles),
+	})
+}
+
+// issueTokens issues an access token and a refresh token in the given session
+func (router *PubblrRouter) issueTokens(ctx context.Context, db database.Queries, session *database.Session) (*TokenResponse, error) {
+	accessToken, err := router.Auth.GenerateToken(auth.Claims{
+		Username: session.Username,
+		Session:  session.Id,
+		ClientId: session.ClientId,
+		Scope:    session.Scope,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, expires, err := router.Auth.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.CreateRefreshToken(ctx, database.RefreshToken{
+		Hash:     auth.HashToken(refreshToken),
+		Username: session.Username,
+		Session:  session.Id,
+		Expires:  expires,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		Scope:        session.Scope,
+	}, nil
+}
+
+// refreshTokens exchanges a refresh token, issued to the given OAuth client
+// or, if clientId is empty, on login, for a new access token and a new
+// refresh token.  Each refresh token may only be exchanged once; if a used
+// one is presented again, it has likely been stolen, so its whole session is
+// ended.
+func (router *PubblrRouter) refreshTokens(ctx context.Context, refreshToken, clientId string) (*TokenResponse, apiutil.Status) {
+	tx, err := router.Database.Begin(ctx)
+	if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	hash := auth.HashToken(refreshToken)
+	token, err := tx.GetRefreshToken(ctx, hash)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, apiutil.NewStatus(http.StatusUnauthorized, "Invalid refresh token")
+	} else if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	session, err := tx.GetSession(ctx, token.Session)
+	if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if session.ClientId != clientId {
+		// Only the client to which a token was issued may use it
+		return nil, apiutil.NewStatus(http.StatusUnauthorized, "Invalid refresh token")
+	}
+
+	if token.Used {
+		err = tx.DeleteSession(ctx, token.Session)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			return nil, apiutil.Statusf(http.StatusInternalServerError, "failed to end session: %w", err)
+		}
+		return nil, apiutil.NewStatus(http.StatusUnauthorized, "Refresh token has already been used")
+	}
+
+	if time.Now().After(token.Expires) {
+		return nil, apiutil.NewStatus(http.StatusUnauthorized, "Refresh token has expired")
+	}
+
+	err = tx.UseRefreshToken(ctx, hash)
+	if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	session.LastUsed = time.Now()
+	err = tx.UpdateSession(ctx, *session)
+	if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	ret, err := router.issueTokens(ctx, tx, session)
+	if err != nil {
+		return nil, apiutil.NewStatus(http.StatusInternalServerError, "Error generating token")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit refresh: %w", err)
+	}
+
+	return ret, nil
+}
+
+// SessionResponse describes one of a user's sessions
+type SessionResponse struct {
+	Id        string `json:"id"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// the OAuth client to which the session was granted, if any
+	ClientId string    `json:"clientId,omitempty"`
+	Scope    string    `json:"scope,omitempty"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"lastUsed"`
+	// whether this is the session in which the request was made
+	Current bool `json:"current"`
+}
+
+// Logout ends the session in which the request was made, and clears the
+// session cookie
+func (router *PubblrRouter) Logout(r *http.Request) (*struct{}, http.Header, apiutil.Status) {
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok || principal.Username == "" {
+		return nil, nil, apiutil.NewStatus(http.StatusUnauthorized, "You are not logged in")
+	}
+	if principal.TokenId != "" {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Personal access tokens are revoked individually, not by logging out")
+	}
+
+	err := router.Database.DeleteSession(r.Context(), principal.Session)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to end session: %w", err)
+	}
+
+	return nil, router.cookieHeader(""), apiutil.StatusFromCode(http.StatusNoContent)
+}
+
+// endSessions ends every session of a user, revoking every token issued in
+// them.  Personal access tokens are issued in no session, so are left alone.
+func endSessions(ctx context.Context, db database.Queries, username string) error {
+	sessions, err := db.GetSessions(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		err = db.DeleteSession(ctx, session.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogoutAll ends every session of the user making the request, including the
+// one in which it was made.  Their personal access tokens, which belong to
+// tools rather than devices, keep working until revoked themselves.
+func (router *PubblrRouter) LogoutAll(r *http.Request) (*struct{}, http.Header, apiutil.Status) {
+	username, ok := currentUser(r)
+	if !ok {
+		return nil, nil, apiutil.NewStatus(http.StatusUnauthorized, "You are not logged in")
+	}
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	err = endSessions(r.Context(), tx, username)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to end sessions: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to end sessions: %w", err)
+	}
+
+	return nil, router.cookieHeader(""), apiutil.StatusFromCode(http.StatusNoContent)
+}
+
+// GetSessions lists the active sessions of the user making the request
+func (router *PubblrRouter) GetSessions(r *http.Request) ([]*SessionResponse, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "actor")
+	var current string
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		current = principal.Session
+	}
+
+	sessions, err := router.Database.GetSessions(r.Context(), username)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	ret := make([]*SessionResponse, len(sessions))
+	for i, session := range sessions {
+		ret[i] = &SessionResponse{
+			Id:        session.Id,
+			UserAgent: session.UserAgent,
+			ClientId:  session.ClientId,
+			Scope:     session.Scope,
+			Created:   session.Created,
+			LastUsed:  session.LastUsed,
+			Current:   session.Id == current,
+		}
+	}
+
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// DeleteSession ends one of the sessions of the user making the request
+func (router *PubblrRouter) DeleteSession(r *http.Request) (*struct{}, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "actor")
+	id := chi.URLParam(r, "id")
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	// Sessions of other users are treated as missing, so that their ids
+	// cannot be discovered
+	session, err := tx.GetSession(r.Context(), id)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && session.Username != username) {
+		return nil, nil, apiutil.NewStatus(http.StatusNotFound, fmt.Sprintf("No session with id %s", id))
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	err = tx.DeleteSession(r.Context(), id)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to end session: %w", err)
+	}
+
+	return nil, nil, apiutil.StatusFromCode(http.StatusNoContent)
+}
@@ -0,0 +1,28 @@
+package server_test
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+)
+
+var _ = Describe("Sessions", func() {
+	var router server.PubblrRouter
+	var alice string
+
+	BeforeEach(func() {
+		router = newRouter()
+		alice = register(router, "alice")
+	})
+
+	It("should end every session on logging out of all of them, but keep personal access tokens", func() {
+		pat := personalAccessToken(router, "alice", alice)
+
+		Expect(do(router, "POST", "/logout/all", "", alice).Code).To(Equal(http.StatusNoContent))
+		Expect(do(router, "GET", "/alice/outbox", "", alice).Code).To(Equal(http.StatusUnauthorized))
+		Expect(do(router, "GET", "/alice/outbox", "", pat).Code).To(Equal(http.StatusOK))
+	})
+})
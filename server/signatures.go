@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// the largest actor or key document fetched to verify a signature
+const maxKeyDocumentSize = 1 << 20
+
+// publicKeyDocument is the public key of an actor, as published in its
+// publicKey property or at its own id
+type publicKeyDocument struct {
+	Id           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// ResolveKey fetches the public key with the given id from the server which
+// published it, along with the actor owning it.  Its id may name either the
+// key itself or, with a fragment, the actor whose publicKey it is.
+func (router *PubblrRouter) ResolveKey(ctx context.Context, keyId string) (crypto.PublicKey, string, error) {
+	keyUrl, err := url.Parse(keyId)
+	if err != nil || keyUrl.Host == "" || (keyUrl.Scheme != "https" && keyUrl.Scheme != "http") {
+		return nil, "", fmt.Errorf("invalid key id %s", keyId)
+	}
+	if router.isLocal(keyId) {
+		return nil, "", fmt.Errorf("no such key %s", keyId)
+	}
+
+	docUrl := *keyUrl
+	docUrl.Fragment = ""
+	req, err := http.NewRequestWithContext(ctx, "GET", docUrl.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/activity+json")
+
+	resp, err := router.HTTPClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch key %s: %w", keyId, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to fetch key %s: %s", keyId, resp.Status)
+	}
+
+	var doc struct {
+		publicKeyDocument
+		PublicKey json.RawMessage `json:"publicKey"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxKeyDocumentSize)).Decode(&doc)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid key document for %s: %w", keyId, err)
+	}
+
+	key := doc.publicKeyDocument
+	if doc.PublicKey != nil {
+		// An actor, publishing one or more keys
+		var keys []publicKeyDocument
+		if json.Unmarshal(doc.PublicKey, &keys) != nil {
+			keys = make([]publicKeyDocument, 1)
+			if err := json.Unmarshal(doc.PublicKey, &keys[0]); err != nil {
+				return nil, "", fmt.Errorf("invalid key document for %s: %w", keyId, err)
+			}
+		}
+
+		key = publicKeyDocument{}
+		for _, k := range keys {
+			if k.Id == keyId && k.Owner == doc.Id {
+				key = k
+			}
+		}
+	}
+	if key.Id != keyId || key.PublicKeyPem == "" {
+		return nil, "", fmt.Errorf("no such key %s", keyId)
+	}
+
+	// A server may only speak for its own actors
+	ownerUrl, err := url.Parse(key.Owner)
+	if err != nil || ownerUrl.Scheme != keyUrl.Scheme || ownerUrl.Host != keyUrl.Host {
+		return nil, "", fmt.Errorf("key %s is not owned by an actor on its server", keyId)
+	}
+
+	publicKey, err := parsePublicKey(key.PublicKeyPem)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid key %s: %w", keyId, err)
+	}
+	return publicKey, key.Owner, nil
+}
+
+func parsePublicKey(pemString string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemString))
+	if block == nil {
+		return nil, errors.New("not PEM encoded")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %s", block.Type)
+	}
+}
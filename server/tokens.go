@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/auth"
+	"github.com/go-chi/chi"
+)
+
+const (
+	// personalAccessTokenPrefix begins every personal access token, telling
+	// them apart from the JWTs issued on login
+	personalAccessTokenPrefix = "pat_"
+	maxTokenNameLength        = 100
+	// how often a token's last use is recorded, so that scripts making many
+	// requests do not each write to the database
+	tokenLastUsedResolution = time.Minute
+	// the longest a token may be given to expire in, in seconds; tokens meant
+	// to last longer may be made never to expire
+	maxTokenExpiresIn = 10 * 365 * 24 * 60 * 60
+)
+
+// PersonalAccessTokenRequest creates a personal access token
+type PersonalAccessTokenRequest struct {
+	Name string `json:"name"`
+	// the space-separated scopes the token carries; defaults to read
+	Scope string `json:"scope"`
+	// the number of seconds for which the token may be used; forever, if
+	// zero
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+// PersonalAccessTokenResponse describes a personal access token.  The token
+// itself is only given when it is created, and it is afterwards known by its
+// id.
+type PersonalAccessTokenResponse struct {
+	Id       string     `json:"id"`
+	Token    string     `json:"token,omitempty"`
+	Name     string     `json:"name"`
+	Scope    string     `json:"scope"`
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	LastUsed *time.Time `json:"lastUsed,omitempty"`
+}
+
+func personalAccessTokenResponse(token *database.PersonalAccessToken) *PersonalAccessTokenResponse {
+	ret := &PersonalAccessTokenResponse{
+		Id:      token.Id,
+		Name:    token.Name,
+		Scope:   token.Scope,
+		Created: token.Created,
+	}
+	if !token.Expires.IsZero() {
+		expires := token.Expires
+		ret.Expires = &expires
+	}
+	if !token.LastUsed.IsZero() {
+		lastUsed := token.LastUsed
+		ret.LastUsed = &lastUsed
+	}
+	return ret
+}
+
+// verifyPersonalAccessToken checks a personal access token, recording its use
+func (router *PubblrRouter) verifyPersonalAccessToken(ctx context.Context, tokenString string) (*auth.Claims, error) {
+	token, err := router.Database.GetPersonalAccessToken(ctx, auth.HashToken(tokenString))
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, errors.New("Invalid personal access token")
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !token.Expires.IsZero() && now.After(token.Expires) {
+		return nil, errors.New("Personal access token has expired")
+	}
+
+	if now.Sub(token.LastUsed) >= tokenLastUsedResolution {
+		token.LastUsed = now
+		err = router.Database.UpdatePersonalAccessToken(ctx, *token)
+		// The token may have been revoked since it was read
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	return &auth.Claims{
+		Username:            token.Username,
+		Scope:               token.Scope,
+		PersonalAccessToken: token.Id,
+	}, nil
+}
+
+// GetPersonalAccessTokens lists the personal access tokens of the user making
+// the request
+func (router *PubblrRouter) GetPersonalAccessTokens(r *http.Request) ([]*PersonalAccessTokenResponse, http.Header, apiutil.Status) {
+	tokens, err := router.Database.GetPersonalAccessTokens(r.Context(), chi.URLParam(r, "actor"))
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	ret := make([]*PersonalAccessTokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		ret = append(ret, personalAccessTokenResponse(token))
+	}
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// PostPersonalAccessToken creates a personal access token for the user making
+// the request, with any of the scopes they hold
+func (router *PubblrRouter) PostPersonalAccessToken(r *http.Request) (*PersonalAccessTokenResponse, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "actor")
+
+	var body PersonalAccessTokenRequest
+	if status := readJSON(r, &body); status != nil {
+		return nil, nil, status
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" || utf8.RuneCountInString(body.Name) > maxTokenNameLength {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest,
+			fmt.Sprintf("Tokens must be given a name of at most %d characters", maxTokenNameLength))
+	}
+	if body.ExpiresIn < 0 {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "expiresIn may not be negative")
+	}
+	if body.ExpiresIn > maxTokenExpiresIn {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest,
+			fmt.Sprintf("expiresIn may be at most %d seconds", maxTokenExpiresIn))
+	}
+
+	roles, err := router.Database.GetRoles(r.Context(), username)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	scope, err := parseScope(body.Scope, userScopes(roles), ScopeRead)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusBadRequest, err)
+	}
+
+	id, err := auth.NewId()
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	secret, err := auth.NewSecret()
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	tokenString := personalAccessTokenPrefix + secret
+
+	now := time.Now()
+	token := database.PersonalAccessToken{
+		Id:       id,
+		Hash:     auth.HashToken(tokenString),
+		Username: username,
+		Name:     body.Name,
+		Scope:    scope,
+		Created:  now,
+	}
+	if body.ExpiresIn > 0 {
+		token.Expires = now.Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+
+	err = router.Database.CreatePersonalAccessToken(r.Context(), token)
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to create token: %w", err)
+	}
+
+	ret := personalAccessTokenResponse(&token)
+	ret.Token = tokenString
+	return ret, nil, apiutil.StatusFromCode(http.StatusCreated)
+}
+
+// DeletePersonalAccessToken revokes one of the personal access tokens of the
+// user making the request
+func (router *PubblrRouter) DeletePersonalAccessToken(r *http.Request) (*struct{}, http.Header, apiutil.Status) {
+	id := chi.URLParam(r, "id")
+
+	err := router.Database.DeletePersonalAccessToken(r.Context(), chi.URLParam(r, "actor"), id)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil, apiutil.NewStatus(http.StatusNotFound, fmt.Sprintf("No token with id %s", id))
+	} else if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to revoke token: %w", err)
+	}
+
+	return nil, nil, apiutil.StatusFromCode(http.StatusNoContent)
+}
+
+// revokePersonalAccessTokens revokes every personal access token of a user
+func revokePersonalAccessTokens(ctx context.Context, db database.Queries, username string) error {
+	tokens, err := db.GetPersonalAccessTokens(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		err = db.DeletePersonalAccessToken(ctx, username, token.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
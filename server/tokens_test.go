@@ -0,0 +1,45 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+)
+
+var _ = Describe("Personal access tokens", func() {
+	var router server.PubblrRouter
+	var alice string
+
+	// create asks for a token expiring in the given number of seconds
+	create := func(expiresIn string) *httptest.ResponseRecorder {
+		return do(router, "POST", "/alice/tokens", `{"name":"test","expiresIn":`+expiresIn+`}`, alice)
+	}
+
+	BeforeEach(func() {
+		router = newRouter()
+		alice = register(router, "alice")
+	})
+
+	It("should expire tokens when asked", func() {
+		w := create("3600")
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+
+		var resp server.PersonalAccessTokenResponse
+		Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed())
+		Expect(resp.Expires).ToNot(BeNil())
+		Expect(*resp.Expires).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+	})
+
+	It("should refuse negative expiries and those too far off", func() {
+		for _, expiresIn := range []int64{-1, 10000000000, 1 << 62} {
+			Expect(create(strconv.FormatInt(expiresIn, 10)).Code).To(Equal(http.StatusBadRequest), strconv.FormatInt(expiresIn, 10))
+		}
+	})
+})
@@ -0,0 +1,413 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/brandonsides/pubblr/database"
+	"github.com/brandonsides/pubblr/server/apiutil"
+	"github.com/brandonsides/pubblr/server/auth"
+	"github.com/go-chi/chi"
+)
+
+const (
+	// how long a user has to give their second factor after their password
+	loginChallengeLifetime = 5 * time.Minute
+	// the number of wrong codes after which a login challenge is abandoned
+	maxChallengeAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+// SecondFactorRequest answers a login challenge with either a code from the
+// user's authenticator or one of their recovery codes.  Its fields are named
+// like those of LoginResponse, whose challenge it answers.
+type SecondFactorRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+	// whether to set a session cookie, as for Login
+	Cookie bool `json:"cookie"`
+}
+
+// TwoFactorRequest confirms a change to a user's two-factor authentication
+// with either a code from their authenticator or one of their recovery codes
+type TwoFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// TwoFactorEnrollment gives the secret with which to provision an
+// authenticator, both as is and as an otpauth URI to show as a QR code
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// beginLoginChallenge records that a user has given their password, and
+// returns the token with which they must give their second factor
+func (router *PubblrRouter) beginLoginChallenge(ctx context.Context, db database.Queries, username, userAgent string) (string, error) {
+	token, err := auth.NewSecret()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.CreateLoginChallenge(ctx, database.LoginChallenge{
+		Hash:      auth.HashToken(token),
+		Username:  username,
+		UserAgent: userAgent,
+		Expires:   time.Now().Add(loginChallengeLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// checkSecondFactor checks a code or recovery code against a user's
+// enrollment, recording its use so that it cannot be used again
+func checkSecondFactor(ctx context.Context, db database.Queries, twoFactor *database.TwoFactor, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := auth.VerifyTOTP(twoFactor.Secret, code, time.Now(), twoFactor.LastStep)
+		if !ok {
+			return false, nil
+		}
+		twoFactor.LastStep = step
+		return true, db.SetTwoFactor(ctx, *twoFactor)
+	}
+
+	if recoveryCode != "" {
+		hash := auth.HashRecoveryCode(recoveryCode)
+		for i, stored := range twoFactor.RecoveryCodes {
+			if stored == hash {
+				twoFactor.RecoveryCodes = append(twoFactor.RecoveryCodes[:i], twoFactor.RecoveryCodes[i+1:]...)
+				return true, db.SetTwoFactor(ctx, *twoFactor)
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// LoginSecondFactor completes a login begun by a user enrolled in two-factor
+// authentication, exchanging the challenge token returned by Login and a valid
+// code for tokens
+func (router *PubblrRouter) LoginSecondFactor(r *http.Request) (*TokenResponse, http.Header, apiutil.Status) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
+	}
+	var body SecondFactorRequest
+	err = json.Unmarshal(reqBody, &body)
+	if err != nil {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
+	}
+
+	if body.ChallengeToken == "" {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Missing challenge token")
+	}
+	if body.Code == "" && body.RecoveryCode == "" {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Missing code")
+	}
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	hash := auth.HashToken(body.ChallengeToken)
+	challenge, err := tx.GetLoginChallenge(r.Context(), hash)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil, apiutil.NewStatus(http.StatusUnauthorized, "Invalid challenge token")
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if time.Now().After(challenge.Expires) {
+		err = tx.DeleteLoginChallenge(r.Context(), hash)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+		return nil, nil, apiutil.NewStatus(http.StatusUnauthorized, "Challenge token has expired")
+	}
+
+	if header, status := router.checkLockout(r, challenge.Username); status != nil {
+		return nil, header, status
+	}
+
+	twoFactor, err := tx.GetTwoFactor(r.Context(), challenge.Username)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	ok, err := checkSecondFactor(r.Context(), tx, twoFactor, body.Code, body.RecoveryCode)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if !ok {
+		// Guessing codes is only worth a few tries before the password must
+		// be given again
+		challenge.Attempts++
+		if challenge.Attempts >= maxChallengeAttempts {
+			err = tx.DeleteLoginChallenge(r.Context(), hash)
+		} else {
+			err = tx.UpdateLoginChallenge(r.Context(), *challenge)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+		router.loginFailed(r, challenge.Username)
+		return nil, nil, apiutil.NewStatus(http.StatusUnauthorized, "Invalid code")
+	}
+
+	err = tx.DeleteLoginChallenge(r.Context(), hash)
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	ret, err := router.startLoginSession(r.Context(), tx, challenge.Username, challenge.UserAgent)
+	if err != nil {
+		return nil, nil, apiutil.NewStatus(http.StatusInternalServerError, "Error generating token")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to commit session: %w", err)
+	}
+	router.loginSucceeded(r, challenge.Username)
+	if body.Cookie {
+		return ret, router.cookieHeader(ret.AccessToken), apiutil.StatusFromCode(http.StatusOK)
+	}
+	return ret, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// readTwoFactorRequest reads the body of a request confirming a change to the
+// user's two-factor authentication
+func readTwoFactorRequest(r *http.Request) (*TwoFactorRequest, apiutil.Status) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
+	}
+	var body TwoFactorRequest
+	err = json.Unmarshal(reqBody, &body)
+	if err != nil {
+		return nil, apiutil.NewStatus(http.StatusBadRequest, "Invalid JSON")
+	}
+	return &body, nil
+}
+
+// EnrollTwoFactor begins enrolling the user in two-factor authentication.  The
+// enrollment takes effect once confirmed with ConfirmTwoFactor.
+func (router *PubblrRouter) EnrollTwoFactor(r *http.Request) (*TwoFactorEnrollment, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "actor")
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	existing, err := tx.GetTwoFactor(r.Context(), username)
+	if err == nil && existing.Enabled {
+		return nil, nil, apiutil.NewStatus(http.StatusConflict, "Two-factor authentication is already enabled")
+	} else if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	err = tx.SetTwoFactor(r.Context(), database.TwoFactor{
+		Username: username,
+		Secret:   secret,
+	})
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to enroll: %w", err)
+	}
+
+	return &TwoFactorEnrollment{
+		Secret: secret,
+		URI:    auth.TOTPURI(router.baseUrl.Host, username, secret),
+	}, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// ConfirmTwoFactor completes enrollment with a code from the newly provisioned
+// authenticator, showing that it was provisioned correctly, and returns the
+// user's recovery codes
+func (router *PubblrRouter) ConfirmTwoFactor(r *http.Request) (*RecoveryCodesResponse, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "actor")
+	body, status := readTwoFactorRequest(r)
+	if status != nil {
+		return nil, nil, status
+	}
+	if body.Code == "" {
+		return nil, nil, apiutil.NewStatus(http.StatusBadRequest, "Missing code")
+	}
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	twoFactor, err := tx.GetTwoFactor(r.Context(), username)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil, apiutil.NewStatus(http.StatusConflict, "Two-factor authentication has not been enrolled in")
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if twoFactor.Enabled {
+		return nil, nil, apiutil.NewStatus(http.StatusConflict, "Two-factor authentication is already enabled")
+	}
+
+	ok, err := checkSecondFactor(r.Context(), tx, twoFactor, body.Code, "")
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if !ok {
+		return nil, nil, apiutil.NewStatus(http.StatusUnauthorized, "Invalid code")
+	}
+
+	twoFactor.Enabled = true
+	codes, status := setRecoveryCodes(r.Context(), tx, twoFactor)
+	if status != nil {
+		return nil, nil, status
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to enable two-factor authentication: %w", err)
+	}
+	return codes, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, for when they
+// have used or lost them
+func (router *PubblrRouter) RegenerateRecoveryCodes(r *http.Request) (*RecoveryCodesResponse, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "actor")
+	body, status := readTwoFactorRequest(r)
+	if status != nil {
+		return nil, nil, status
+	}
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	twoFactor, status := enabledTwoFactor(r.Context(), tx, username)
+	if status != nil {
+		return nil, nil, status
+	}
+
+	ok, err := checkSecondFactor(r.Context(), tx, twoFactor, body.Code, "")
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	if !ok {
+		return nil, nil, apiutil.NewStatus(http.StatusUnauthorized, "Invalid code")
+	}
+
+	codes, status := setRecoveryCodes(r.Context(), tx, twoFactor)
+	if status != nil {
+		return nil, nil, status
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to replace recovery codes: %w", err)
+	}
+	return codes, nil, apiutil.StatusFromCode(http.StatusOK)
+}
+
+// DisableTwoFactor ends the user's enrollment in two-factor authentication.  A
+// confirmed enrollment may only be ended with a valid code or recovery code,
+// so that a stolen session cannot be used to remove the second factor.
+func (router *PubblrRouter) DisableTwoFactor(r *http.Request) (*struct{}, http.Header, apiutil.Status) {
+	username := chi.URLParam(r, "actor")
+	body, status := readTwoFactorRequest(r)
+	if status != nil {
+		return nil, nil, status
+	}
+
+	tx, err := router.Database.Begin(r.Context())
+	if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusServiceUnavailable, err)
+	}
+	defer tx.Rollback()
+
+	twoFactor, err := tx.GetTwoFactor(r.Context(), username)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil, apiutil.NewStatus(http.StatusNotFound, "Two-factor authentication is not enabled")
+	} else if err != nil {
+		return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	if twoFactor.Enabled {
+		ok, err := checkSecondFactor(r.Context(), tx, twoFactor, body.Code, body.RecoveryCode)
+		if err != nil {
+			return nil, nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+		}
+		if !ok {
+			return nil, nil, apiutil.NewStatus(http.StatusUnauthorized, "Invalid code")
+		}
+	}
+
+	err = tx.DeleteTwoFactor(r.Context(), username)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return nil, nil, apiutil.Statusf(http.StatusInternalServerError, "failed to disable two-factor authentication: %w", err)
+	}
+	return nil, nil, apiutil.StatusFromCode(http.StatusNoContent)
+}
+
+// enabledTwoFactor returns the user's confirmed enrollment
+func enabledTwoFactor(ctx context.Context, db database.Queries, username string) (*database.TwoFactor, apiutil.Status) {
+	twoFactor, err := db.GetTwoFactor(ctx, username)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && !twoFactor.Enabled) {
+		return nil, apiutil.NewStatus(http.StatusConflict, "Two-factor authentication is not enabled")
+	} else if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+	return twoFactor, nil
+}
+
+// setRecoveryCodes gives the user new recovery codes, replacing any they had
+func setRecoveryCodes(ctx context.Context, db database.Queries, twoFactor *database.TwoFactor) (*RecoveryCodesResponse, apiutil.Status) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	twoFactor.RecoveryCodes = make([]string, len(codes))
+	for i, code := range codes {
+		twoFactor.RecoveryCodes[i] = auth.HashRecoveryCode(code)
+	}
+	err = db.SetTwoFactor(ctx, *twoFactor)
+	if err != nil {
+		return nil, apiutil.NewStatusFromError(http.StatusInternalServerError, err)
+	}
+
+	return &RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
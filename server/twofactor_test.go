@@ -0,0 +1,59 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+	"github.com/brandonsides/pubblr/server/auth"
+)
+
+var _ = Describe("Two-factor authentication", func() {
+	var router server.PubblrRouter
+	var recoveryCodes []string
+
+	// challenge logs in as alice with her password, returning the challenge
+	// token with which to give her second factor
+	challenge := func() string {
+		w := do(router, "POST", "/login", `{"username":"alice","password":`+jsonString(password)+`}`, "")
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		var resp server.LoginResponse
+		Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed())
+		Expect(resp.TwoFactorRequired).To(BeTrue())
+		return resp.ChallengeToken
+	}
+
+	BeforeEach(func() {
+		router = newRouter()
+		alice := register(router, "alice")
+
+		w := do(router, "POST", "/alice/2fa", "", alice)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var enrollment server.TwoFactorEnrollment
+		Expect(json.Unmarshal(w.Body.Bytes(), &enrollment)).To(Succeed())
+
+		code, err := auth.TOTPCode(enrollment.Secret, time.Now())
+		Expect(err).ToNot(HaveOccurred())
+		w = do(router, "POST", "/alice/2fa/confirm", `{"code":"`+code+`"}`, alice)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var resp server.RecoveryCodesResponse
+		Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed())
+		recoveryCodes = resp.RecoveryCodes
+	})
+
+	It("should answer login challenges with recovery codes named as the challenge is", func() {
+		w := do(router, "POST", "/login/2fa",
+			`{"challenge_token":`+jsonString(challenge())+`,"recovery_code":`+jsonString(recoveryCodes[0])+`}`, "")
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		Expect(w.Body.String()).To(ContainSubstring(`"access_token"`))
+
+		w = do(router, "POST", "/login/2fa",
+			`{"challenge_token":`+jsonString(challenge())+`,"recovery_code":`+jsonString(recoveryCodes[0])+`}`, "")
+		Expect(w.Code).To(Equal(http.StatusUnauthorized), w.Body.String())
+	})
+})
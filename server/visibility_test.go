@@ -0,0 +1,80 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/server"
+)
+
+var _ = Describe("Visibility", func() {
+	var router server.PubblrRouter
+	var alice, bob, carol string
+
+	// finds reports whether a search by the given user turns up the given text
+	finds := func(token, text string) bool {
+		w := do(router, "GET", "/search?q="+text, "", token)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		return strings.Contains(w.Body.String(), `"`+text+`"`)
+	}
+
+	BeforeEach(func() {
+		router = newRouter()
+		alice = register(router, "alice")
+		bob = register(router, "bob")
+		carol = register(router, "carol")
+	})
+
+	It("should show posts to the actors they are addressed to", func() {
+		Expect(post(router, "alice", alice, "directly", baseUrl+"/bob").Code).To(Equal(http.StatusCreated))
+		Expect(finds(alice, "directly")).To(BeTrue())
+		Expect(finds(bob, "directly")).To(BeTrue())
+		Expect(finds(carol, "directly")).To(BeFalse())
+		Expect(finds("", "directly")).To(BeFalse())
+	})
+
+	It("should not mistake actors of other servers for local actors of the same name", func() {
+		Expect(post(router, "alice", alice, "elsewhere", "https://other.example/bob").Code).To(Equal(http.StatusCreated))
+		Expect(finds(alice, "elsewhere")).To(BeTrue())
+		Expect(finds(bob, "elsewhere")).To(BeFalse())
+	})
+
+	It("should show posts addressed to an actor's followers to those followers", func() {
+		Expect(router.Database.AddFollower(context.Background(), "alice", baseUrl+"/bob")).To(Succeed())
+
+		Expect(post(router, "alice", alice, "followers", baseUrl+"/alice/followers").Code).To(Equal(http.StatusCreated))
+		Expect(post(router, "alice", alice, "streamed", baseUrl+"/alice/streams/films/followers").Code).To(Equal(http.StatusCreated))
+		Expect(finds(bob, "followers")).To(BeTrue())
+		Expect(finds(bob, "streamed")).To(BeTrue())
+		Expect(finds(carol, "followers")).To(BeFalse())
+		Expect(finds(carol, "streamed")).To(BeFalse())
+	})
+
+	It("should not show posts addressed to the followers of other servers' actors to local followers", func() {
+		Expect(router.Database.AddFollower(context.Background(), "alice", baseUrl+"/bob")).To(Succeed())
+
+		Expect(post(router, "alice", alice, "theirs", "https://other.example/alice/followers").Code).To(Equal(http.StatusCreated))
+		Expect(finds(bob, "theirs")).To(BeFalse())
+	})
+
+	It("should list only public posts under a tag, in every representation", func() {
+		Expect(post(router, "alice", alice, "#films shown", "https://www.w3.org/ns/activitystreams#Public").Code).To(Equal(http.StatusCreated))
+		Expect(post(router, "alice", alice, "#films hidden", baseUrl+"/bob").Code).To(Equal(http.StatusCreated))
+
+		for _, accept := range []string{"application/activity+json", "text/html"} {
+			req := httptest.NewRequest("GET", "/tags/films?page=true", nil)
+			req.Header.Set("Accept", accept)
+			req.Header.Set("Authorization", "Bearer "+bob)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusOK), accept)
+			Expect(w.Body.String()).To(ContainSubstring("shown"), accept)
+			Expect(w.Body.String()).ToNot(ContainSubstring("hidden"), accept)
+		}
+	})
+})
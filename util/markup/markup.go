@@ -0,0 +1,186 @@
+// Package markup converts the HTML content of posts to plain text and to the
+// markup which may safely be shown in pages.
+package markup
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// blocks are the elements which end a line of text
+var blocks = map[atom.Atom]bool{
+	atom.Br:         true,
+	atom.P:          true,
+	atom.Div:        true,
+	atom.Li:         true,
+	atom.Blockquote: true,
+	atom.Pre:        true,
+	atom.H1:         true,
+	atom.H2:         true,
+	atom.H3:         true,
+	atom.H4:         true,
+	atom.H5:         true,
+	atom.H6:         true,
+}
+
+// hidden are the elements whose content is not text to be read
+var hidden = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Template: true,
+}
+
+// Text converts HTML to plain text, dropping its tags and unescaping its
+// entities.  Line breaks and the ends of paragraphs and other blocks end lines.
+func Text(s string) string {
+	var ret strings.Builder
+	// the hidden element whose content is being skipped, if any
+	var skipping atom.Atom
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return ret.String()
+		}
+
+		name, _ := z.TagName()
+		a := atom.Lookup(name)
+		switch {
+		case skipping != 0:
+			if tt == html.EndTagToken && a == skipping {
+				skipping = 0
+			}
+		case tt == html.TextToken:
+			ret.Write(z.Text())
+		case tt == html.StartTagToken && hidden[a]:
+			skipping = a
+		case a == atom.Br && tt != html.EndTagToken:
+			ret.WriteByte('\n')
+		case a != atom.Br && tt == html.EndTagToken && blocks[a]:
+			ret.WriteByte('\n')
+		}
+	}
+}
+
+// allowed are the elements which may be shown, each with the attributes it
+// may keep
+var allowed = map[atom.Atom][]atom.Atom{
+	atom.P:          nil,
+	atom.Br:         nil,
+	atom.A:          {atom.Href, atom.Class, atom.Rel},
+	atom.Span:       {atom.Class},
+	atom.Em:         nil,
+	atom.Strong:     nil,
+	atom.I:          nil,
+	atom.B:          nil,
+	atom.U:          nil,
+	atom.S:          nil,
+	atom.Del:        nil,
+	atom.Code:       nil,
+	atom.Pre:        nil,
+	atom.Blockquote: nil,
+	atom.Ul:         nil,
+	atom.Ol:         nil,
+	atom.Li:         nil,
+}
+
+// the schemes of the links which may be followed
+var linkSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+// Sanitize returns the markup of HTML which may safely be shown in a page: the
+// allowed elements and attributes, and the text of the rest.  Scripts, styles
+// and links other than to web pages and email addresses are dropped, and every
+// element opened is closed, so that content cannot change the page around it.
+func Sanitize(s string) string {
+	var ret strings.Builder
+	var skipping atom.Atom
+	// the allowed elements left open
+	var open []atom.Atom
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		name, hasAttr := z.TagName()
+		a := atom.Lookup(name)
+		switch {
+		case skipping != 0:
+			if tt == html.EndTagToken && a == skipping {
+				skipping = 0
+			}
+		case tt == html.TextToken:
+			ret.WriteString(html.EscapeString(string(z.Text())))
+		case tt == html.StartTagToken && hidden[a]:
+			skipping = a
+		case tt == html.StartTagToken || tt == html.SelfClosingTagToken:
+			attrs, ok := allowed[a]
+			if !ok {
+				continue
+			}
+			ret.WriteString("<" + a.String())
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				k := atom.Lookup(key)
+				if !in(k, attrs) || (k == atom.Href && !followable(string(val))) {
+					continue
+				}
+				ret.WriteString(" " + k.String() + `="` + html.EscapeString(string(val)) + `"`)
+			}
+			ret.WriteString(">")
+			if a != atom.Br {
+				open = append(open, a)
+			}
+		case tt == html.EndTagToken:
+			// close the element, along with any left open within it
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == a {
+					for _, inner := range reverse(open[i:]) {
+						ret.WriteString("</" + inner.String() + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+		}
+	}
+
+	for _, a := range reverse(open) {
+		ret.WriteString("</" + a.String() + ">")
+	}
+	return ret.String()
+}
+
+// followable reports whether a link may be followed from a page
+func followable(href string) bool {
+	u, err := url.Parse(strings.TrimSpace(href))
+	return err == nil && linkSchemes[strings.ToLower(u.Scheme)]
+}
+
+func in(a atom.Atom, atoms []atom.Atom) bool {
+	for _, b := range atoms {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+func reverse(atoms []atom.Atom) []atom.Atom {
+	ret := make([]atom.Atom, len(atoms))
+	for i, a := range atoms {
+		ret[len(atoms)-1-i] = a
+	}
+	return ret
+}
@@ -0,0 +1,58 @@
+package markup_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/util/markup"
+)
+
+var _ = Describe("Text", func() {
+	It("should drop tags and unescape entities", func() {
+		Expect(markup.Text(`hi <a href="https://example.com/bob" class="mention">@bob</a> &amp; <em>you</em>`)).
+			To(Equal("hi @bob & you"))
+	})
+
+	It("should end lines at line breaks and the ends of blocks", func() {
+		Expect(markup.Text(`<p>first<br>second</p><p>third</p>`)).To(Equal("first\nsecond\nthird\n"))
+	})
+
+	It("should drop the content of scripts and styles", func() {
+		Expect(markup.Text(`before<script>alert("hi")</script><style>p {}</style> after`)).To(Equal("before after"))
+	})
+
+	It("should leave plain text as it is", func() {
+		Expect(markup.Text("just text")).To(Equal("just text"))
+	})
+})
+
+var _ = Describe("Sanitize", func() {
+	It("should keep the allowed elements and attributes", func() {
+		content := `<p>hi <a href="https://example.com/bob" class="mention" rel="nofollow">@bob</a><br>` +
+			`<em>and</em> <strong>you</strong> <span class="h-card">&amp; co</span></p>`
+		Expect(markup.Sanitize(content)).To(Equal(content))
+	})
+
+	It("should drop scripts, event handlers and other elements and attributes", func() {
+		Expect(markup.Sanitize(`<p onclick="steal()">a<script>steal()</script><img src=x onerror="steal()">b</p>`)).
+			To(Equal(`<p>ab</p>`))
+		Expect(markup.Sanitize(`<div style="position:fixed"><iframe src="https://example.com"></iframe>text</div>`)).
+			To(Equal(`text`))
+	})
+
+	It("should only keep links to web pages and email addresses", func() {
+		Expect(markup.Sanitize(`<a href="javascript:steal()">x</a><a href=" JavaScript:steal()">y</a>`)).
+			To(Equal(`<a>x</a><a>y</a>`))
+		Expect(markup.Sanitize(`<a href="mailto:bob@example.com">z</a>`)).
+			To(Equal(`<a href="mailto:bob@example.com">z</a>`))
+	})
+
+	It("should close every element it opens, and no others", func() {
+		Expect(markup.Sanitize(`<p><em>unclosed`)).To(Equal(`<p><em>unclosed</em></p>`))
+		Expect(markup.Sanitize(`</div></p>text<p><strong>a</p>`)).To(Equal(`text<p><strong>a</strong></p>`))
+	})
+
+	It("should escape text", func() {
+		Expect(markup.Sanitize(`1 &lt; 2 & "quoted"`)).To(Equal(`1 &lt; 2 &amp; &#34;quoted&#34;`))
+	})
+})
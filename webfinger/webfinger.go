@@ -0,0 +1,98 @@
+package webfinger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrNotFound = errors.New("account not found")
+
+// the media types under which servers advertise ActivityStreams actors
+var activityStreamsTypes = []string{
+	"application/activity+json",
+	"application/ld+json",
+}
+
+// Resource is a JSON Resource Descriptor, as returned by a WebFinger query
+type Resource struct {
+	Subject string   `json:"subject"`
+	Aliases []string `json:"aliases,omitempty"`
+	Links   []Link   `json:"links,omitempty"`
+}
+
+type Link struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href,omitempty"`
+}
+
+// Lookup queries the server at the given host for the resource describing
+// acct:user@host
+func Lookup(ctx context.Context, client *http.Client, user, host string) (*Resource, error) {
+	query := url.URL{
+		Scheme:   "https",
+		Host:     host,
+		Path:     "/.well-known/webfinger",
+		RawQuery: url.Values{"resource": {"acct:" + user + "@" + host}}.Encode(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", query.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/jrd+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("webfinger query to %s failed: %w", host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s@%s", ErrNotFound, user, host)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("webfinger query to %s failed: %s", host, resp.Status)
+	}
+
+	var resource Resource
+	err = json.NewDecoder(resp.Body).Decode(&resource)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webfinger response from %s: %w", host, err)
+	}
+	return &resource, nil
+}
+
+// LookupActor returns the id of the ActivityStreams actor for user@host
+func LookupActor(ctx context.Context, client *http.Client, user, host string) (string, error) {
+	resource, err := Lookup(ctx, client, user, host)
+	if err != nil {
+		return "", err
+	}
+
+	for _, link := range resource.Links {
+		if link.Rel == "self" && link.Href != "" && isActivityStreamsType(link.Type) {
+			return link.Href, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s@%s has no actor", ErrNotFound, user, host)
+}
+
+func isActivityStreamsType(typ string) bool {
+	mediaType, _, err := mime.ParseMediaType(typ)
+	if err != nil {
+		return false
+	}
+	for _, t := range activityStreamsTypes {
+		if strings.EqualFold(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+package webfinger_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestWebfinger(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Webfinger Suite")
+}
@@ -0,0 +1,65 @@
+package webfinger_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/brandonsides/pubblr/webfinger"
+)
+
+var _ = Describe("LookupActor", func() {
+	var server *httptest.Server
+	var host string
+
+	BeforeEach(func() {
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/.well-known/webfinger"))
+
+			var resource webfinger.Resource
+			switch r.URL.Query().Get("resource") {
+			case "acct:bob@" + host:
+				resource = webfinger.Resource{
+					Subject: "acct:bob@" + host,
+					Links: []webfinger.Link{
+						{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: "https://" + host + "/@bob"},
+						{Rel: "self", Type: `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`, Href: "https://" + host + "/users/bob"},
+					},
+				}
+			case "acct:carol@" + host:
+				resource = webfinger.Resource{Subject: "acct:carol@" + host}
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(resource)
+		}))
+		u, _ := url.Parse(server.URL)
+		host = u.Host
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should return the actor linked from the account", func() {
+		actor, err := webfinger.LookupActor(context.Background(), server.Client(), "bob", host)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actor).To(Equal("https://" + host + "/users/bob"))
+	})
+
+	It("should fail for accounts without an actor", func() {
+		_, err := webfinger.LookupActor(context.Background(), server.Client(), "carol", host)
+		Expect(err).To(MatchError(webfinger.ErrNotFound))
+	})
+
+	It("should fail for unknown accounts", func() {
+		_, err := webfinger.LookupActor(context.Background(), server.Client(), "dave", host)
+		Expect(err).To(MatchError(webfinger.ErrNotFound))
+	})
+})